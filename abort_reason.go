@@ -0,0 +1,73 @@
+package milter
+
+// AbortReason tells [Milter.Abort] why the current message got aborted. Use [Modifier.AbortReason] to get it.
+type AbortReason int
+
+const (
+	// AbortNone is the [AbortReason] of modifiers outside of [Milter.Abort].
+	AbortNone AbortReason = iota
+	// AbortMTA means the MTA aborted the message. The milter protocol does not tell why:
+	// the SMTP client sent RSET or disconnected, the MTA rejected the message, or another milter rejected it.
+	// The MTA might send the next message in the same connection.
+	AbortMTA
+	// AbortRejected means the [Server] rejected the message on its own, e.g. because of [WithMaxBodyBytes] or [WithBodyPolicy].
+	AbortRejected
+	// AbortOverload means the [Server] rejected the message because of [WithMaxConcurrentEndOfMessage].
+	AbortOverload
+	// AbortConnectionClosed means the connection to the MTA ended in the middle of the message
+	// (e.g. the MTA closed it, a read failed, or the session lifetime of [WithServerTimeouts] ran out).
+	// No further messages follow in this connection.
+	AbortConnectionClosed
+	// AbortShutdown means the connection to the MTA ended in the middle of the message after [Server.Shutdown],
+	// [Server.Drain] or [Server.Close] got called.
+	AbortShutdown
+)
+
+func (r AbortReason) String() string {
+	switch r {
+	case AbortNone:
+		return "none"
+	case AbortMTA:
+		return "mta"
+	case AbortRejected:
+		return "rejected"
+	case AbortOverload:
+		return "overload"
+	case AbortConnectionClosed:
+		return "connection closed"
+	case AbortShutdown:
+		return "shutdown"
+	default:
+		return "unknown"
+	}
+}
+
+// AbortReason returns why the current message got aborted when m is the [Modifier] of [Milter.Abort].
+// Use it to decide whether to keep partial results of the message (e.g. the MTA might retry the message
+// after [AbortConnectionClosed] or [AbortShutdown]) or to discard them.
+// AbortReason returns [AbortNone] in all other callbacks.
+func (m *Modifier) AbortReason() AbortReason {
+	return m.abortReason
+}
+
+// abort calls the Abort callback of the backend of m with reason.
+func (m *serverSession) abort(reason AbortReason) error {
+	mod := newModifier(m, true)
+	mod.abortReason = reason
+	return m.backend.Abort(mod)
+}
+
+// abortUnfinished calls the Abort callback of the backend when the connection to the MTA ends in the middle of a message.
+func (m *serverSession) abortUnfinished() {
+	if !m.inMessage || m.backend == nil {
+		return
+	}
+	reason := AbortConnectionClosed
+	if draining, _ := m.draining(); draining || m.server.isClosed() {
+		reason = AbortShutdown
+	}
+	if err := m.abort(reason); err != nil {
+		m.logWarning("Error aborting message: %v", err)
+	}
+	m.setInMessage(false)
+}
@@ -0,0 +1,62 @@
+package milter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestModifier_AbortReason(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		abort func(w *serverClientWrap) error
+		want  AbortReason
+	}{
+		{"mta", func(w *serverClientWrap) error { return w.session.Abort(nil) }, AbortMTA},
+		{"connection closed", func(w *serverClientWrap) error { return w.session.Close() }, AbortConnectionClosed},
+		{"shutdown", func(w *serverClientWrap) error {
+			_ = w.server.Close()
+			return w.session.Close()
+		}, AbortShutdown},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			reasons := make(chan AbortReason, 2)
+			mm := &MockMilter{
+				ConnResp: RespContinue,
+				ConnMod: func(m *Modifier) {
+					reasons <- m.AbortReason()
+				},
+				HeloResp: RespContinue,
+				MailResp: RespContinue,
+				AbortMod: func(m *Modifier) {
+					reasons <- m.AbortReason()
+				},
+			}
+			w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return mm })}, nil)
+			defer w.Cleanup()
+			act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			if got := <-reasons; got != AbortNone {
+				t.Errorf("AbortReason() in Connect = %v, want %v", got, AbortNone)
+			}
+			act, err = w.session.Helo("helo_host")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Mail("from@example.org", "")
+			assertAction(t, act, err, ActionContinue)
+			if err := tt.abort(&w); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-reasons:
+				if got != tt.want {
+					t.Errorf("AbortReason() = %v, want %v", got, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Abort did not get called")
+			}
+		})
+	}
+}
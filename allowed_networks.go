@@ -0,0 +1,30 @@
+package milter
+
+import (
+	"net"
+	"net/netip"
+)
+
+// allowedConn reports whether the MTA at addr may connect to s (see [WithAllowedNetworks]).
+// Connections without an IP address (e.g. unix sockets) are always allowed.
+func (s *Server) allowedConn(addr net.Addr) bool {
+	networks := s.options.allowedNetworks
+	if networks == nil {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package milter
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestServer_allowedConn(t *testing.T) {
+	t.Parallel()
+	s := NewServer(WithMilter(func() Milter { return NoOpMilter{} }), WithAllowedNetworks([]netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}))
+	tests := []struct {
+		name string
+		addr net.Addr
+		want bool
+	}{
+		{"IPv4", &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 1234}, true},
+		{"IPv4-mapped", &net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.10"), Port: 1234}, true},
+		{"IPv6", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, true},
+		{"other IPv4", &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 1234}, false},
+		{"other IPv6", &net.TCPAddr{IP: net.ParseIP("2001:db9::1"), Port: 1234}, false},
+		{"unix", &net.UnixAddr{Name: "/run/milter.sock", Net: "unix"}, true},
+	}
+	for _, tt := range tests {
+		if got := s.allowedConn(tt.addr); got != tt.want {
+			t.Errorf("%s: allowedConn(%v) = %v, want %v", tt.name, tt.addr, got, tt.want)
+		}
+	}
+	if !NewServer(WithMilter(func() Milter { return NoOpMilter{} })).allowedConn(tests[3].addr) {
+		t.Error("server without WithAllowedNetworks denied a connection")
+	}
+	if NewServer(WithMilter(func() Milter { return NoOpMilter{} }), WithAllowedNetworks(nil)).allowedConn(tests[0].addr) {
+		t.Error("server with empty WithAllowedNetworks allowed a connection")
+	}
+}
+
+func TestServer_WithAllowedNetworks(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(WithMilter(func() Milter { return NoOpMilter{} }), WithAllowedNetworks([]netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}))
+	defer s.Close()
+	go func() {
+		_ = s.Serve(ln)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	var buf [1]byte
+	if _, err := conn.Read(buf[:]); err == nil {
+		t.Fatal("server did not close the connection")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("server did not close the connection")
+	}
+	if got := s.ListenerStats(); got.Denied != 1 || got.Accepted != 0 {
+		t.Fatalf("ListenerStats() = %+v", got)
+	}
+}
@@ -0,0 +1,138 @@
+package milter
+
+// AsMilter returns a [Milter] for callbacks, a value that only implements some of the optional interfaces of [Milter]
+// (e.g. [RcptToMilter] and [EndOfMessageMilter]). The callbacks that callbacks does not implement behave like the ones of [NoOpMilter].
+//
+// The returned [Milter] is a [PartialMilter], so the [Server] of [WithMilter] tells the MTA to not send
+// the events that callbacks does not handle.
+// When callbacks already is a [Milter], AsMilter returns it unchanged.
+//
+// Only the [Milter] callbacks get forwarded, the returned [Milter] is e.g. never a [ResettableMilter] or [MacroObserver].
+func AsMilter(callbacks interface{}) Milter {
+	if m, ok := callbacks.(Milter); ok {
+		return m
+	}
+	return &partialMilterAdapter{callbacks: callbacks}
+}
+
+// partialMilterAdapter is the [Milter] of [AsMilter].
+type partialMilterAdapter struct {
+	callbacks interface{}
+}
+
+var _ PartialMilter = (*partialMilterAdapter)(nil)
+
+func (a *partialMilterAdapter) Callbacks() Callbacks {
+	var callbacks Callbacks
+	if _, ok := a.callbacks.(ConnectMilter); ok {
+		callbacks |= CallbackConnect
+	}
+	if _, ok := a.callbacks.(HeloMilter); ok {
+		callbacks |= CallbackHelo
+	}
+	if _, ok := a.callbacks.(MailFromMilter); ok {
+		callbacks |= CallbackMailFrom
+	}
+	if _, ok := a.callbacks.(RcptToMilter); ok {
+		callbacks |= CallbackRcptTo
+	}
+	if _, ok := a.callbacks.(DataMilter); ok {
+		callbacks |= CallbackData
+	}
+	if _, ok := a.callbacks.(HeaderMilter); ok {
+		callbacks |= CallbackHeader
+	}
+	if _, ok := a.callbacks.(HeadersMilter); ok {
+		callbacks |= CallbackHeaders
+	}
+	if _, ok := a.callbacks.(BodyMilter); ok {
+		callbacks |= CallbackBodyChunk
+	}
+	if _, ok := a.callbacks.(UnknownMilter); ok {
+		callbacks |= CallbackUnknown
+	}
+	return callbacks
+}
+
+func (a *partialMilterAdapter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(ConnectMilter); ok {
+		return c.Connect(host, family, port, addr, m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) Helo(name string, m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(HeloMilter); ok {
+		return c.Helo(name, m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(MailFromMilter); ok {
+		return c.MailFrom(from, esmtpArgs, m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(RcptToMilter); ok {
+		return c.RcptTo(rcptTo, esmtpArgs, m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) Data(m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(DataMilter); ok {
+		return c.Data(m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) Header(name string, value string, m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(HeaderMilter); ok {
+		return c.Header(name, value, m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) Headers(m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(HeadersMilter); ok {
+		return c.Headers(m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) BodyChunk(chunk []byte, m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(BodyMilter); ok {
+		return c.BodyChunk(chunk, m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) EndOfMessage(m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(EndOfMessageMilter); ok {
+		return c.EndOfMessage(m)
+	}
+	return RespAccept, nil
+}
+
+func (a *partialMilterAdapter) Abort(m *Modifier) error {
+	if c, ok := a.callbacks.(AbortMilter); ok {
+		return c.Abort(m)
+	}
+	return nil
+}
+
+func (a *partialMilterAdapter) Unknown(cmd string, m *Modifier) (*Response, error) {
+	if c, ok := a.callbacks.(UnknownMilter); ok {
+		return c.Unknown(cmd, m)
+	}
+	return RespContinue, nil
+}
+
+func (a *partialMilterAdapter) Cleanup() {
+	if c, ok := a.callbacks.(CleanupMilter); ok {
+		c.Cleanup()
+	}
+}
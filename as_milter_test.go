@@ -0,0 +1,78 @@
+package milter
+
+import (
+	"testing"
+)
+
+type rcptOnlyFilter struct {
+	rcpts   []string
+	cleaned bool
+}
+
+func (f *rcptOnlyFilter) RcptTo(rcptTo string, _ string, _ *Modifier) (*Response, error) {
+	f.rcpts = append(f.rcpts, rcptTo)
+	if rcptTo == "spammer@example.com" {
+		return RespReject, nil
+	}
+	return RespContinue, nil
+}
+
+func (f *rcptOnlyFilter) Cleanup() {
+	f.cleaned = true
+}
+
+func TestAsMilter(t *testing.T) {
+	if m := AsMilter(NoOpMilter{}); m != (NoOpMilter{}) {
+		t.Errorf("AsMilter() of a Milter got %v", m)
+	}
+	f := &rcptOnlyFilter{}
+	m := AsMilter(f)
+	partial, ok := m.(PartialMilter)
+	if !ok {
+		t.Fatalf("AsMilter() = %T is not a PartialMilter", m)
+	}
+	if got := partial.Callbacks(); got != CallbackRcptTo {
+		t.Errorf("Callbacks() = %b, want %b", got, CallbackRcptTo)
+	}
+	mod := NewTestModifier(NewMacroBag(), nil, nil, 0, 0)
+	if resp, err := m.RcptTo("spammer@example.com", "", mod); resp != RespReject || err != nil {
+		t.Errorf("RcptTo() = %v, %v", resp, err)
+	}
+	if resp, err := m.Helo("helo", mod); resp != RespContinue || err != nil {
+		t.Errorf("Helo() = %v, %v", resp, err)
+	}
+	if resp, err := m.EndOfMessage(mod); resp != RespAccept || err != nil {
+		t.Errorf("EndOfMessage() = %v, %v", resp, err)
+	}
+	if err := m.Abort(mod); err != nil {
+		t.Errorf("Abort() = %v", err)
+	}
+	m.Cleanup()
+	if len(f.rcpts) != 1 || !f.cleaned {
+		t.Errorf("AsMilter() did not forward the callbacks: %+v", f)
+	}
+}
+
+func TestServer_AsMilter(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return AsMilter(&rcptOnlyFilter{})
+	})}, nil)
+	defer w.Cleanup()
+	for _, opt := range []OptProtocol{OptNoConnect, OptNoHelo, OptNoMailReply, OptNoData, OptNoHeaders, OptNoEOH, OptNoBody, OptNoUnknown} {
+		if !w.session.ProtocolOption(opt) {
+			t.Errorf("protocol option %032b was not negotiated", opt)
+		}
+	}
+	if w.session.ProtocolOption(OptNoRcptTo) || w.session.ProtocolOption(OptNoRcptReply) {
+		t.Error("RCPT TO got suppressed")
+	}
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("spammer@example.com", "")
+	assertAction(t, act, err, ActionReject)
+}
@@ -0,0 +1,49 @@
+package milter
+
+import (
+	"sync"
+	"time"
+)
+
+// startAutoProgress sends progress packets to the MTA while the EndOfMessage callback of m runs (see [WithAutoProgress]).
+// The returned function stops sending progress packets. When it returns no more progress packet gets sent.
+func (m *serverSession) startAutoProgress() (stop func()) {
+	after, interval := m.server.options.autoProgressAfter, m.server.options.autoProgressInterval
+	if after <= 0 {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = after
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timer := time.NewTimer(after)
+		defer timer.Stop()
+		wait := timer.C
+		var ticker *time.Ticker
+		for {
+			select {
+			case <-wait:
+			case <-done:
+				return
+			case <-m.ctx.Done():
+				return
+			}
+			if err := m.writePacket(respProgress.Response()); err != nil {
+				return
+			}
+			if ticker == nil {
+				ticker = time.NewTicker(interval)
+				defer ticker.Stop()
+				wait = ticker.C
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
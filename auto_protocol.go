@@ -0,0 +1,99 @@
+package milter
+
+// Callbacks is a set of [Milter] callbacks.
+type Callbacks uint16
+
+// The callbacks of a [Milter] that can be left out.
+const (
+	CallbackConnect   Callbacks = 1 << iota // Milter.Connect
+	CallbackHelo                            // Milter.Helo
+	CallbackMailFrom                        // Milter.MailFrom
+	CallbackRcptTo                          // Milter.RcptTo
+	CallbackData                            // Milter.Data
+	CallbackHeader                          // Milter.Header
+	CallbackHeaders                         // Milter.Headers
+	CallbackBodyChunk                       // Milter.BodyChunk
+	CallbackUnknown                         // Milter.Unknown
+)
+
+// PartialMilter is a [Milter] that only implements some of its callbacks.
+// The other callbacks behave like the ones of [NoOpMilter].
+// [AsMilter] creates a PartialMilter from the optional interfaces that a value implements, [Builder] from functions.
+//
+// When the [Milter] of [WithMilter] is a PartialMilter, the [Server] negotiates the OptNo* and
+// OptNo*Reply protocol options for the callbacks that are not implemented (when the MTA offers them), so the MTA does not send
+// events and does not wait for replies that nobody is interested in. You do not need to tune this with [WithProtocol].
+// The [Server] asks the first [Milter] of a connection, all Milters that [WithMilter] creates should implement the same callbacks.
+// This does not work with [WithDynamicMilter] since its Milters get created after the negotiation.
+// A [MilterMiddleware] that does not implement PartialMilter itself disables this optimization, since it might need all events.
+//
+// The [Server] still gets the events that it needs itself: e.g. Connect for [WithSessionClasses] or Header for [WithMaxHeaderCount].
+// When you negotiate [OptChangeHeader] the MTA sends the header fields (but does not wait for replies),
+// so that [Modifier.ChangeHeaderValue] works without a Header callback.
+// The events of stages that you requested macros for (see [WithMacroRequest]) do not get suppressed either,
+// since the MTA does not send the macros of suppressed events.
+type PartialMilter interface {
+	Milter
+	// Callbacks returns the callbacks that this Milter implements.
+	Callbacks() Callbacks
+}
+
+// callbackProtocol maps the callbacks to the protocol options that suppress their event and their reply.
+var callbackProtocol = []struct {
+	callback   Callbacks
+	event      OptProtocol
+	reply      OptProtocol
+	macroStage MacroStage
+}{
+	{CallbackConnect, OptNoConnect, OptNoConnReply, StageConnect},
+	// the server needs MAIL FROM to know when a message starts
+	{CallbackMailFrom, 0, OptNoMailReply, StageMail},
+	{CallbackHelo, OptNoHelo, OptNoHeloReply, StageHelo},
+	{CallbackRcptTo, OptNoRcptTo, OptNoRcptReply, StageRcpt},
+	{CallbackData, OptNoData, OptNoDataReply, StageData},
+	{CallbackHeader, OptNoHeaders, OptNoHeaderReply, StageEndMarker},
+	{CallbackHeaders, OptNoEOH, OptNoEOHReply, StageEOH},
+	{CallbackBodyChunk, OptNoBody, OptNoBodyReply, StageEndMarker},
+	{CallbackUnknown, OptNoUnknown, OptNoUnknownReply, StageEndMarker},
+}
+
+// autoProtocol returns the protocol options for the callbacks that backend does not implement.
+func (o *options) autoProtocol(backend Milter) OptProtocol {
+	partial, ok := backend.(PartialMilter)
+	if !ok {
+		return 0
+	}
+	implemented := partial.Callbacks()
+	_, observesMacros := backend.(MacroObserver)
+	var protocol OptProtocol
+	for _, c := range callbackProtocol {
+		if implemented&c.callback != 0 || o.needsCallback(c.callback) {
+			continue
+		}
+		protocol |= c.reply
+		if o.needsEvent(c.callback) || observesMacros || o.dynamicMacroRequests != nil || (int(c.macroStage) < len(o.macrosByStage) && len(o.macrosByStage[c.macroStage]) > 0) {
+			continue
+		}
+		protocol |= c.event
+	}
+	return protocol
+}
+
+// needsEvent returns true when the server itself needs the event of callback, but not its reply.
+func (o *options) needsEvent(callback Callbacks) bool {
+	// Modifier.ChangeHeaderValue needs to know the header fields of the message
+	return callback == CallbackHeader && o.actions&OptChangeHeader != 0
+}
+
+// needsCallback returns true when the server itself needs the event and the reply of callback.
+func (o *options) needsCallback(callback Callbacks) bool {
+	switch callback {
+	case CallbackConnect:
+		return o.tarpit != nil || o.classifySession != nil || (o.maxConnections > 0 && o.overflowPolicy == OverflowTempFail)
+	case CallbackHeader, CallbackHeaders:
+		return o.maxHeaderCount > 0 || o.maxHeaderBytes > 0
+	case CallbackBodyChunk:
+		return o.maxBodyBytes > 0 || o.bodyPolicy == BodyReject
+	}
+	return false
+}
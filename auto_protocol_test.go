@@ -0,0 +1,111 @@
+package milter
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+type partialMilter struct {
+	NoOpMilter
+	callbacks Callbacks
+}
+
+func (p partialMilter) Callbacks() Callbacks {
+	return p.callbacks
+}
+
+func TestServer_AutoProtocol(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		backend Milter
+		opts    []Option
+		want    OptProtocol
+	}{
+		{"no partial milter", NoOpMilter{}, nil, 0},
+		{"all callbacks", partialMilter{callbacks: 0xffff}, nil, 0},
+		{"only rcpt", partialMilter{callbacks: CallbackRcptTo}, nil,
+			OptNoConnect | OptNoConnReply | OptNoHelo | OptNoHeloReply | OptNoMailReply | OptNoData | OptNoDataReply | OptNoHeaders | OptNoHeaderReply | OptNoEOH | OptNoEOHReply | OptNoBody | OptNoBodyReply | OptNoUnknown | OptNoUnknownReply},
+		{"macro request", partialMilter{callbacks: CallbackConnect | CallbackMailFrom | CallbackRcptTo | CallbackData | CallbackHeader | CallbackHeaders | CallbackBodyChunk | CallbackUnknown}, []Option{WithMacroRequest(StageHelo, []MacroName{MacroTlsVersion})},
+			OptNoHeloReply},
+		{"change header", partialMilter{callbacks: CallbackRcptTo}, []Option{WithAction(OptChangeHeader)},
+			OptNoConnect | OptNoConnReply | OptNoHelo | OptNoHeloReply | OptNoMailReply | OptNoData | OptNoDataReply | OptNoHeaderReply | OptNoEOH | OptNoEOHReply | OptNoBody | OptNoBodyReply | OptNoUnknown | OptNoUnknownReply},
+		{"server needs events", partialMilter{callbacks: CallbackHelo | CallbackMailFrom | CallbackRcptTo | CallbackData | CallbackUnknown}, []Option{WithSessionClasses(func(Macros) string { return "" }, nil), WithMaxHeaderCount(10), WithBodyPolicy(BodyReject)},
+			0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(append(tt.opts, WithMilter(func() Milter { return tt.backend }))...)
+			defer s.Close()
+			if got := s.options.autoProtocol(tt.backend); got != tt.want {
+				t.Errorf("autoProtocol() = %032b, want %032b", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_AutoProtocolNegotiation(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return partialMilter{callbacks: CallbackRcptTo}
+	})}, []Option{WithProtocols(allClientSupportedProtocolMasks &^ (OptNoHelo | OptNoHeloReply))})
+	defer w.Cleanup()
+	want := OptNoConnect | OptNoConnReply | OptNoMailReply | OptNoBody | OptNoBodyReply | OptNoHeaders | OptNoEOH
+	if got := w.session.Protocols(); got&want != want {
+		t.Errorf("Protocols() = %032b, want %032b", got, want)
+	}
+	if w.session.ProtocolOption(OptNoHelo) || w.session.ProtocolOption(OptNoHeloReply) || w.session.ProtocolOption(OptNoRcptTo) {
+		t.Errorf("Protocols() = %032b has options that the MTA did not offer or that the milter needs", w.session.Protocols())
+	}
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+}
+
+func TestServer_NoBackendOnFailedNegotiation(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	created := 0
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		mu.Lock()
+		defer mu.Unlock()
+		created++
+		return partialMilter{callbacks: CallbackRcptTo}
+	})}, nil)
+	defer w.Cleanup()
+	mu.Lock()
+	createdBefore := created
+	mu.Unlock()
+
+	// the MTA offers an unsupported protocol version
+	conn, err := net.Dial("tcp", w.local.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := wire.WritePacket(conn, &wire.Message{Code: wire.CodeOptNeg, Data: []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && w.server.Stats().NegotiationFailures == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if w.server.Stats().NegotiationFailures != 1 {
+		t.Fatal("negotiation did not fail")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if created != createdBefore {
+		t.Errorf("failed negotiation created %d milters", created-createdBefore)
+	}
+}
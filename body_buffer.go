@@ -0,0 +1,55 @@
+package milter
+
+import (
+	"io"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// bodyPieces passes the rest of a body chunk that is bigger than the limit of [WithMaxBodyBuffer] to the backend.
+// resp is the response of the backend to the first piece of the chunk.
+// Every piece gets read from the connection only after the backend handled the previous piece.
+// When the backend does not want more body chunks the rest of the chunk gets discarded.
+func (m *serverSession) bodyPieces(resp *Response) (*Response, error) {
+	for m.bodyRemaining > 0 {
+		if resp != nil && (!resp.Continue() || resp.code == wire.Code(wire.ActSkip)) {
+			return resp, m.discardBody()
+		}
+		piece, err := m.readBodyPiece()
+		if err != nil {
+			return nil, err
+		}
+		if resp, err = m.bodyChunk(piece); err != nil {
+			return resp, err
+		}
+	}
+	return resp, nil
+}
+
+// readBodyPiece reads the next piece of the body chunk from the connection.
+func (m *serverSession) readBodyPiece() ([]byte, error) {
+	if err := m.setReadDeadline(); err != nil {
+		return nil, err
+	}
+	n := m.bodyRemaining
+	if max := uint32(m.server.options.maxBodyBuffer); n > max {
+		n = max
+	}
+	// the backend may keep the piece, always use a new buffer
+	piece := make([]byte, n)
+	if _, err := io.ReadFull(m.conn, piece); err != nil {
+		return nil, err
+	}
+	m.bodyRemaining -= n
+	return piece, nil
+}
+
+// discardBody reads the rest of the body chunk from the connection without buffering it.
+func (m *serverSession) discardBody() error {
+	if err := m.setReadDeadline(); err != nil {
+		return err
+	}
+	_, err := io.CopyN(io.Discard, m.conn, int64(m.bodyRemaining))
+	m.bodyRemaining = 0
+	return err
+}
@@ -0,0 +1,90 @@
+package milter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BodyPolicy defines how NUL bytes and bare CR or LF characters in the message body get handled.
+// SMTP requires CR LF line endings and does not allow NUL bytes, but MTAs do not always enforce this.
+// Use [WithBodyPolicy] to set the policy of a [Client] or [Server].
+type BodyPolicy int
+
+const (
+	// BodyPassThrough passes body chunks unchanged. This is the default.
+	BodyPassThrough BodyPolicy = iota
+	// BodySanitize removes NUL bytes and converts bare CR and bare LF characters to CR LF.
+	// A CR that is the very last byte of the body cannot be fixed since the body chunk containing it was already sent.
+	BodySanitize
+	// BodyReject rejects bodies that contain NUL bytes or bare CR or LF characters.
+	BodyReject
+)
+
+// ErrInvalidBody gets returned by [ClientSession.BodyChunk] and [ClientSession.End] when the [BodyReject] policy is active
+// and the body contains NUL bytes or bare CR or LF characters.
+var ErrInvalidBody = errors.New("milter: body contains NUL bytes or bare CR or LF characters")
+
+// bodyFilter applies a [BodyPolicy] to the body chunks of one message.
+type bodyFilter struct {
+	policy BodyPolicy
+	// prevCR is true when the last byte of the previous chunk was a CR
+	prevCR bool
+}
+
+// reset prepares f for the next message.
+func (f *bodyFilter) reset() {
+	f.prevCR = false
+}
+
+// apply applies the policy of f to chunk. When chunk needs to be sanitized, apply returns a newly allocated slice.
+func (f *bodyFilter) apply(chunk []byte) ([]byte, error) {
+	if f.policy == BodyPassThrough || len(chunk) == 0 {
+		return chunk, nil
+	}
+	i := invalidBodyByte(chunk, f.prevCR)
+	if i < 0 {
+		f.prevCR = chunk[len(chunk)-1] == '\r'
+		return chunk, nil
+	}
+	if f.policy == BodyReject {
+		return nil, fmt.Errorf("%w (at offset %d of body chunk)", ErrInvalidBody, i)
+	}
+	out := make([]byte, 0, len(chunk)+len(chunk)/16+1)
+	for _, c := range chunk {
+		// drop NUL bytes without changing prevCR, so that CR NUL LF becomes CR LF
+		if c == 0 {
+			continue
+		}
+		if f.prevCR && c != '\n' {
+			out = append(out, '\n')
+		}
+		if c == '\n' && !f.prevCR {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+		f.prevCR = c == '\r'
+	}
+	return out, nil
+}
+
+// finish checks the end of the body. It returns an error when the body ended in a bare CR and the policy is [BodyReject].
+func (f *bodyFilter) finish() error {
+	prevCR := f.prevCR
+	f.reset()
+	if f.policy == BodyReject && prevCR {
+		return fmt.Errorf("%w (at the end of the body)", ErrInvalidBody)
+	}
+	return nil
+}
+
+// invalidBodyByte returns the index of the first NUL byte or bare CR or LF character in chunk or -1.
+// prevCR indicates that the byte before chunk was a CR.
+func invalidBodyByte(chunk []byte, prevCR bool) int {
+	for i, c := range chunk {
+		if c == 0 || (prevCR && c != '\n') || (!prevCR && c == '\n') {
+			return i
+		}
+		prevCR = c == '\r'
+	}
+	return -1
+}
@@ -0,0 +1,58 @@
+package milter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBodyFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  BodyPolicy
+		chunks  []string
+		want    []string
+		wantErr int // index of the chunk that fails or -1, len(chunks) is the finish call
+	}{
+		{"pass-through", BodyPassThrough, []string{"a\nb\x00", "\rc"}, []string{"a\nb\x00", "\rc"}, -1},
+		{"sanitize valid", BodySanitize, []string{"a\r\nb\r", "\nc"}, []string{"a\r\nb\r", "\nc"}, -1},
+		{"sanitize LF", BodySanitize, []string{"a\nb\n"}, []string{"a\r\nb\r\n"}, -1},
+		{"sanitize CR", BodySanitize, []string{"a\rb\r\r\n"}, []string{"a\r\nb\r\n\r\n"}, -1},
+		{"sanitize CR over chunks", BodySanitize, []string{"a\r", "b"}, []string{"a\r", "\nb"}, -1},
+		{"sanitize NUL", BodySanitize, []string{"\x00a\x00\x00b\r\n\x00"}, []string{"ab\r\n"}, -1},
+		{"sanitize CR NUL LF", BodySanitize, []string{"a\r\x00\nb"}, []string{"a\r\nb"}, -1},
+		{"sanitize CR NUL LF over chunks", BodySanitize, []string{"a\r\x00", "\x00\nb"}, []string{"a\r", "\nb"}, -1},
+		{"sanitize CR NUL", BodySanitize, []string{"a\r\x00b"}, []string{"a\r\nb"}, -1},
+		{"reject valid", BodyReject, []string{"a\r\nb\r", "\nc"}, []string{"a\r\nb\r", "\nc"}, -1},
+		{"reject NUL", BodyReject, []string{"a\r\n", "b\x00"}, []string{"a\r\n"}, 1},
+		{"reject LF", BodyReject, []string{"a\n"}, nil, 0},
+		{"reject CR over chunks", BodyReject, []string{"a\r", "b"}, []string{"a\r"}, 1},
+		{"reject CR at end", BodyReject, []string{"a\r"}, []string{"a\r"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := bodyFilter{policy: tt.policy}
+			var got []string
+			failed := false
+			for i, chunk := range tt.chunks {
+				out, err := f.apply([]byte(chunk))
+				if err != nil {
+					if i != tt.wantErr || !errors.Is(err, ErrInvalidBody) {
+						t.Fatalf("apply() chunk %d error = %v, wantErr %d", i, err, tt.wantErr)
+					}
+					failed = true
+					break
+				}
+				got = append(got, string(out))
+			}
+			if !failed {
+				if err := f.finish(); (err != nil) != (tt.wantErr == len(tt.chunks)) {
+					t.Fatalf("finish() error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("apply() got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package milter
+
+// Builder assembles a [Milter] from functions, one per callback:
+//
+//	b := milter.NewBuilder().
+//		OnRcptTo(func(rcptTo string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
+//			if rcptTo == "spam@example.com" {
+//				return milter.RespReject, nil
+//			}
+//			return milter.RespContinue, nil
+//		}).
+//		OnEndOfMessage(func(m *milter.Modifier) (*milter.Response, error) {
+//			return milter.RespAccept, m.AddHeader("X-Filtered", "yes")
+//		})
+//	server := milter.NewServer(milter.WithMilter(b.NewMilter))
+//
+// The callbacks without function behave like the ones of [NoOpMilter]. The [Milter] of a Builder is a [PartialMilter],
+// so the [Server] tells the MTA to not send the events that it does not handle.
+//
+// All Milters of a Builder share its functions, so they get called concurrently for different connections.
+// Use [Modifier.SetMessageValue] to keep state between the callbacks of a message.
+type Builder struct {
+	connect      func(host string, family string, port uint16, addr string, m *Modifier) (*Response, error)
+	helo         func(name string, m *Modifier) (*Response, error)
+	mailFrom     func(from string, esmtpArgs string, m *Modifier) (*Response, error)
+	rcptTo       func(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error)
+	data         func(m *Modifier) (*Response, error)
+	header       func(name string, value string, m *Modifier) (*Response, error)
+	headers      func(m *Modifier) (*Response, error)
+	bodyChunk    func(chunk []byte, m *Modifier) (*Response, error)
+	endOfMessage func(m *Modifier) (*Response, error)
+	abort        func(m *Modifier) error
+	unknown      func(cmd string, m *Modifier) (*Response, error)
+	cleanup      func()
+}
+
+// NewBuilder returns an empty [Builder].
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// OnConnect sets the function of [Milter.Connect].
+func (b *Builder) OnConnect(fn func(host string, family string, port uint16, addr string, m *Modifier) (*Response, error)) *Builder {
+	b.connect = fn
+	return b
+}
+
+// OnHelo sets the function of [Milter.Helo].
+func (b *Builder) OnHelo(fn func(name string, m *Modifier) (*Response, error)) *Builder {
+	b.helo = fn
+	return b
+}
+
+// OnMailFrom sets the function of [Milter.MailFrom].
+func (b *Builder) OnMailFrom(fn func(from string, esmtpArgs string, m *Modifier) (*Response, error)) *Builder {
+	b.mailFrom = fn
+	return b
+}
+
+// OnRcptTo sets the function of [Milter.RcptTo].
+func (b *Builder) OnRcptTo(fn func(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error)) *Builder {
+	b.rcptTo = fn
+	return b
+}
+
+// OnData sets the function of [Milter.Data].
+func (b *Builder) OnData(fn func(m *Modifier) (*Response, error)) *Builder {
+	b.data = fn
+	return b
+}
+
+// OnHeader sets the function of [Milter.Header].
+func (b *Builder) OnHeader(fn func(name string, value string, m *Modifier) (*Response, error)) *Builder {
+	b.header = fn
+	return b
+}
+
+// OnHeaders sets the function of [Milter.Headers].
+func (b *Builder) OnHeaders(fn func(m *Modifier) (*Response, error)) *Builder {
+	b.headers = fn
+	return b
+}
+
+// OnBodyChunk sets the function of [Milter.BodyChunk].
+func (b *Builder) OnBodyChunk(fn func(chunk []byte, m *Modifier) (*Response, error)) *Builder {
+	b.bodyChunk = fn
+	return b
+}
+
+// OnEndOfMessage sets the function of [Milter.EndOfMessage]. Without it the [Milter] accepts every message.
+func (b *Builder) OnEndOfMessage(fn func(m *Modifier) (*Response, error)) *Builder {
+	b.endOfMessage = fn
+	return b
+}
+
+// OnAbort sets the function of [Milter.Abort].
+func (b *Builder) OnAbort(fn func(m *Modifier) error) *Builder {
+	b.abort = fn
+	return b
+}
+
+// OnUnknown sets the function of [Milter.Unknown].
+func (b *Builder) OnUnknown(fn func(cmd string, m *Modifier) (*Response, error)) *Builder {
+	b.unknown = fn
+	return b
+}
+
+// OnCleanup sets the function of [Milter.Cleanup].
+func (b *Builder) OnCleanup(fn func()) *Builder {
+	b.cleanup = fn
+	return b
+}
+
+// NewMilter returns a [Milter] with the functions of b. Pass it to [WithMilter].
+// Later changes of b do not change the returned [Milter].
+func (b *Builder) NewMilter() Milter {
+	built := builtMilter(*b)
+	return &built
+}
+
+// builtMilter is the [Milter] of [Builder.NewMilter].
+type builtMilter Builder
+
+var _ PartialMilter = (*builtMilter)(nil)
+
+func (b *builtMilter) Callbacks() Callbacks {
+	var callbacks Callbacks
+	if b.connect != nil {
+		callbacks |= CallbackConnect
+	}
+	if b.helo != nil {
+		callbacks |= CallbackHelo
+	}
+	if b.mailFrom != nil {
+		callbacks |= CallbackMailFrom
+	}
+	if b.rcptTo != nil {
+		callbacks |= CallbackRcptTo
+	}
+	if b.data != nil {
+		callbacks |= CallbackData
+	}
+	if b.header != nil {
+		callbacks |= CallbackHeader
+	}
+	if b.headers != nil {
+		callbacks |= CallbackHeaders
+	}
+	if b.bodyChunk != nil {
+		callbacks |= CallbackBodyChunk
+	}
+	if b.unknown != nil {
+		callbacks |= CallbackUnknown
+	}
+	return callbacks
+}
+
+func (b *builtMilter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	if b.connect != nil {
+		return b.connect(host, family, port, addr, m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) Helo(name string, m *Modifier) (*Response, error) {
+	if b.helo != nil {
+		return b.helo(name, m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	if b.mailFrom != nil {
+		return b.mailFrom(from, esmtpArgs, m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+	if b.rcptTo != nil {
+		return b.rcptTo(rcptTo, esmtpArgs, m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) Data(m *Modifier) (*Response, error) {
+	if b.data != nil {
+		return b.data(m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) Header(name string, value string, m *Modifier) (*Response, error) {
+	if b.header != nil {
+		return b.header(name, value, m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) Headers(m *Modifier) (*Response, error) {
+	if b.headers != nil {
+		return b.headers(m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) BodyChunk(chunk []byte, m *Modifier) (*Response, error) {
+	if b.bodyChunk != nil {
+		return b.bodyChunk(chunk, m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) EndOfMessage(m *Modifier) (*Response, error) {
+	if b.endOfMessage != nil {
+		return b.endOfMessage(m)
+	}
+	return RespAccept, nil
+}
+
+func (b *builtMilter) Abort(m *Modifier) error {
+	if b.abort != nil {
+		return b.abort(m)
+	}
+	return nil
+}
+
+func (b *builtMilter) Unknown(cmd string, m *Modifier) (*Response, error) {
+	if b.unknown != nil {
+		return b.unknown(cmd, m)
+	}
+	return RespContinue, nil
+}
+
+func (b *builtMilter) Cleanup() {
+	if b.cleanup != nil {
+		b.cleanup()
+	}
+}
@@ -0,0 +1,87 @@
+package milter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	t.Parallel()
+	b := NewBuilder().
+		OnRcptTo(func(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+			if rcptTo == "spam@example.com" {
+				return RespReject, nil
+			}
+			m.SetMessageValue("rcpt", rcptTo)
+			return RespContinue, nil
+		}).
+		OnEndOfMessage(func(m *Modifier) (*Response, error) {
+			return RespAccept, m.AddHeader("X-Rcpt", m.MessageValue("rcpt").(string))
+		})
+	backend := b.NewMilter()
+	// later changes do not change the Milter
+	b.OnHelo(func(name string, m *Modifier) (*Response, error) { return RespReject, nil })
+	if got := backend.(PartialMilter).Callbacks(); got != CallbackRcptTo {
+		t.Fatalf("Callbacks() = %v, want %v", got, CallbackRcptTo)
+	}
+	if got := b.NewMilter().(PartialMilter).Callbacks(); got != CallbackRcptTo|CallbackHelo {
+		t.Fatalf("Callbacks() = %v, want %v", got, CallbackRcptTo|CallbackHelo)
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return backend }), WithAction(OptAddHeader)}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("spam@example.com", "")
+	assertAction(t, act, err, ActionReject)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	modifyActs, act, err := w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	want := []ModifyAction{{Type: ActionAddHeader, HeaderName: "X-Rcpt", HeaderValue: "to@example.org"}}
+	if !reflect.DeepEqual(modifyActs, want) {
+		t.Errorf("got modify actions %+v, want %+v", modifyActs, want)
+	}
+}
+
+func TestBuilder_defaults(t *testing.T) {
+	t.Parallel()
+	backend := NewBuilder().NewMilter()
+	rec := NewRecordingModifier(nil, 0)
+	for _, resp := range []*Response{
+		mustResponse(backend.Connect("host", "tcp4", 25, "127.0.0.1", rec.Modifier)),
+		mustResponse(backend.Helo("host", rec.Modifier)),
+		mustResponse(backend.MailFrom("from@example.org", "", rec.Modifier)),
+		mustResponse(backend.RcptTo("to@example.org", "", rec.Modifier)),
+		mustResponse(backend.Data(rec.Modifier)),
+		mustResponse(backend.Header("Subject", "test", rec.Modifier)),
+		mustResponse(backend.Headers(rec.Modifier)),
+		mustResponse(backend.BodyChunk([]byte("body"), rec.Modifier)),
+		mustResponse(backend.Unknown("CMD", rec.Modifier)),
+	} {
+		if resp != RespContinue {
+			t.Errorf("got %v, want %v", resp, RespContinue)
+		}
+	}
+	if resp := mustResponse(backend.EndOfMessage(rec.Modifier)); resp != RespAccept {
+		t.Errorf("EndOfMessage() = %v, want %v", resp, RespAccept)
+	}
+	if err := backend.Abort(rec.Modifier); err != nil {
+		t.Errorf("Abort() = %v", err)
+	}
+	backend.Cleanup()
+}
+
+func mustResponse(resp *Response, err error) *Response {
+	if err != nil {
+		panic(err)
+	}
+	return resp
+}
@@ -0,0 +1,62 @@
+package milter
+
+// Capabilities summarizes what the MTA and the [Milter] negotiated for the current connection.
+// Use [Modifier.Capabilities] to get it.
+type Capabilities struct {
+	// Version is the negotiated protocol version.
+	Version uint32
+	// Actions are the negotiated actions.
+	Actions OptAction
+	// Protocol are the negotiated protocol options.
+	Protocol OptProtocol
+	// MaxData is the negotiated maximum data size, e.g. of the chunks of [Modifier.ReplaceBodyRawChunk].
+	MaxData DataSize
+}
+
+// actionsFor returns the actions that allow the modification action. Any of them is enough.
+func actionsFor(action ModifyActionType) OptAction {
+	switch action {
+	case ActionAddRcpt:
+		return OptAddRcpt | OptAddRcptWithArgs
+	case ActionDelRcpt:
+		return OptRemoveRcpt
+	case ActionQuarantine:
+		return OptQuarantine
+	case ActionReplaceBody:
+		return OptChangeBody
+	case ActionChangeFrom:
+		return OptChangeFrom
+	case ActionAddHeader:
+		return OptAddHeader
+	case ActionChangeHeader:
+		return OptChangeHeader
+	case ActionInsertHeader:
+		return OptAddHeader | OptChangeHeader
+	default:
+		return 0
+	}
+}
+
+// Can returns true when c allows the modification action.
+// [ActionAddRcpt] with ESMTP arguments additionally needs [OptAddRcptWithArgs].
+func (c Capabilities) Can(action ModifyActionType) bool {
+	return c.Actions&actionsFor(action) != 0
+}
+
+// Capabilities returns what the MTA and the [Milter] negotiated for the current connection.
+// For modifiers created with [NewTestModifier] or [NewRecordingModifier] only Actions and MaxData are set.
+func (m *Modifier) Capabilities() Capabilities {
+	c := Capabilities{Actions: m.actions, MaxData: m.maxDataSize}
+	if m.session != nil {
+		c.Version = m.session.version
+		c.Protocol = m.session.protocol
+	}
+	return c
+}
+
+// Can returns true when m can do the modification action: it got negotiated with the MTA and the current callback
+// is allowed to modify the message (only [Milter.EndOfMessage] is).
+// Use it to pick between alternatives, e.g. quarantine the message when possible and add a header otherwise.
+func (m *Modifier) Can(action ModifyActionType) bool {
+	return !m.readOnly && m.Capabilities().Can(action)
+}
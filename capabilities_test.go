@@ -0,0 +1,88 @@
+package milter
+
+import (
+	"testing"
+)
+
+func TestCapabilities_Can(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		actions OptAction
+		action  ModifyActionType
+		want    bool
+	}{
+		{OptAddRcpt, ActionAddRcpt, true},
+		{OptAddRcptWithArgs, ActionAddRcpt, true},
+		{OptRemoveRcpt, ActionAddRcpt, false},
+		{OptRemoveRcpt, ActionDelRcpt, true},
+		{OptQuarantine, ActionQuarantine, true},
+		{OptChangeBody, ActionReplaceBody, true},
+		{OptChangeFrom, ActionChangeFrom, true},
+		{OptAddHeader, ActionAddHeader, true},
+		{OptAddHeader, ActionChangeHeader, false},
+		{OptChangeHeader, ActionChangeHeader, true},
+		{OptChangeHeader, ActionInsertHeader, true},
+		{OptAddHeader, ActionInsertHeader, true},
+		{OptQuarantine, ActionInsertHeader, false},
+		{^OptAction(0), ModifyActionType(0), false},
+	}
+	for _, tt := range tests {
+		if got := (Capabilities{Actions: tt.actions}).Can(tt.action); got != tt.want {
+			t.Errorf("Capabilities{Actions: %v}.Can(%v) = %v, want %v", tt.actions, tt.action, got, tt.want)
+		}
+	}
+	m := NewTestModifier(nil, nil, nil, OptQuarantine, DataSize64K)
+	if !m.Can(ActionQuarantine) || m.Can(ActionAddHeader) {
+		t.Errorf("Can() of test modifier is wrong")
+	}
+	if got := m.Capabilities(); got != (Capabilities{Actions: OptQuarantine, MaxData: DataSize64K}) {
+		t.Errorf("Capabilities() = %+v", got)
+	}
+}
+
+func TestModifier_Capabilities(t *testing.T) {
+	t.Parallel()
+	var connCan, eomCan bool
+	var eomCapabilities Capabilities
+	mm := &MockMilter{
+		ConnResp:      RespContinue,
+		ConnMod:       func(m *Modifier) { connCan = m.Can(ActionAddHeader) },
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			eomCan = m.Can(ActionAddHeader)
+			eomCapabilities = m.Capabilities()
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return mm }), WithAction(OptAddHeader)}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if connCan {
+		t.Error("Can() in Connect = true, want false")
+	}
+	if !eomCan {
+		t.Error("Can() in EndOfMessage = false, want true")
+	}
+	if eomCapabilities.Version != MaxServerProtocolVersion || eomCapabilities.Actions != OptAddHeader || eomCapabilities.MaxData != DataSize64K {
+		t.Errorf("Capabilities() = %+v", eomCapabilities)
+	}
+}
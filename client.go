@@ -1,11 +1,17 @@
 package milter
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/d--j/go-milter/internal/wire"
@@ -24,6 +30,10 @@ const allClientSupportedProtocolMasksV4 = allClientSupportedProtocolMasksV3 | Op
 const AllClientSupportedActionMasks = OptAddHeader | OptChangeBody | OptAddRcpt | OptRemoveRcpt | OptChangeHeader | OptQuarantine | OptChangeFrom | OptAddRcptWithArgs | OptSetMacros
 const allClientSupportedActionMasksV2 = OptAddHeader | OptChangeBody | OptAddRcpt | OptRemoveRcpt | OptChangeHeader | OptQuarantine
 
+// maxPipelinedBytes is the maximum number of bytes of header fields and body chunks that get buffered in pipelined mode.
+// We write the buffer to the milter when it grows bigger than this.
+const maxPipelinedBytes = 64 * 1024
+
 // Dialer is the interface of the only method we use of a net.Dialer.
 type Dialer interface {
 	Dial(network string, addr string) (net.Conn, error)
@@ -36,6 +46,8 @@ type Client struct {
 	options options
 	network string
 	address string
+	// slots has one element for each open session when WithMaxConcurrentSessions was used
+	slots chan struct{}
 }
 
 // NewClient creates a new Client object connection to a miter at network / address.
@@ -96,9 +108,12 @@ func NewClient(network, address string, opts ...Option) *Client {
 	if options.maxVersion > MaxClientProtocolVersion || options.maxVersion == 1 {
 		panic("milter: this library cannot handle this milter version")
 	}
-	if options.offeredMaxData != DataSize64K && options.offeredMaxData != DataSize256K && options.offeredMaxData != DataSize1M {
+	if options.offeredMaxData < DataSize64K || options.offeredMaxData > dataSizeLimit {
 		panic("milter: wrong data size passed to WithOfferedMaxData")
 	}
+	if options.usedMaxData > dataSizeLimit {
+		panic("milter: wrong data size passed to WithUsedMaxData")
+	}
 	// ensure we only offer protocol options the version can handel
 	if options.protocol != 0 {
 		var all OptProtocol
@@ -135,12 +150,156 @@ func NewClient(network, address string, opts ...Option) *Client {
 	if options.negotiationCallback != nil {
 		panic("milter: WithNegotiationCallback is a server only option")
 	}
+	if options.maxHeaderCount != 0 {
+		panic("milter: WithMaxHeaderCount is a server only option")
+	}
+	if options.maxHeaderBytes != 0 {
+		panic("milter: WithMaxHeaderBytes is a server only option")
+	}
+	if options.maxBodyBytes != 0 {
+		panic("milter: WithMaxBodyBytes is a server only option")
+	}
+	if options.rejectReply != nil || options.tempFailReply != nil {
+		panic("milter: WithDefaultReplies is a server only option")
+	}
+	if options.maxBodyBuffer != 0 {
+		panic("milter: WithMaxBodyBuffer is a server only option")
+	}
+	if options.limitPolicy != LimitTempFail {
+		panic("milter: WithLimitPolicy is a server only option")
+	}
+	if options.classifySession != nil {
+		panic("milter: WithSessionClasses is a server only option")
+	}
+	if options.reconnectAttempts != 0 && options.pipelining {
+		panic("milter: WithReconnect cannot be used together with WithPipelining")
+	}
+	if options.extensionCommandHandler != nil {
+		panic("milter: WithExtensionCommandHandler is a server only option")
+	}
+	if options.panicHandler != nil {
+		panic("milter: WithPanicHandler is a server only option")
+	}
+	if options.unixSocketPermissions != nil {
+		panic("milter: WithUnixSocketPermissions is a server only option")
+	}
+	if options.maxConcurrentEOM != 0 {
+		panic("milter: WithMaxConcurrentEndOfMessage is a server only option")
+	}
+	if options.tarpit != nil {
+		panic("milter: WithTarpit is a server only option")
+	}
+	if options.allowedNetworks != nil {
+		panic("milter: WithAllowedNetworks is a server only option")
+	}
+	if options.autoProgressAfter != 0 {
+		panic("milter: WithAutoProgress is a server only option")
+	}
+	if options.headerFoldLength != 0 {
+		panic("milter: WithHeaderFolding is a server only option")
+	}
+	if options.dynamicMacroRequests != nil {
+		panic("milter: WithDynamicMacroRequests is a server only option")
+	}
+	if len(options.milterMiddlewares) > 0 {
+		panic("milter: WithMilterMiddleware is a server only option")
+	}
+	if options.serverTimeouts != (ServerTimeouts{}) {
+		panic("milter: WithServerTimeouts is a server only option")
+	}
+	if options.maxConnections != 0 {
+		panic("milter: WithMaxConnections is a server only option")
+	}
+	if options.tlsConfig != nil {
+		panic("milter: WithTLSConfig is a server only option, use WithDialer with a tls.Dialer")
+	}
+	if options.keepalive < 0 || options.idleTimeout < 0 {
+		panic("milter: WithKeepalive and WithIdleTimeout cannot be negative")
+	}
+
+	if options.maxSessions < 0 {
+		panic("milter: WithMaxConcurrentSessions needs a positive maximum")
+	}
 
-	return &Client{
+	c := &Client{
 		options: options,
 		network: network,
 		address: address,
 	}
+	if options.maxSessions > 0 {
+		c.slots = make(chan struct{}, options.maxSessions)
+	}
+	return c
+}
+
+// ErrTooManySessions is returned by [Client.Session] and [Client.Ping] when the maximum number of sessions
+// of [WithMaxConcurrentSessions] is reached.
+var ErrTooManySessions = errors.New("milter: too many concurrent sessions")
+
+// acquireSlot reserves a session slot. It returns immediately when WithMaxConcurrentSessions was not used.
+func (c *Client) acquireSlot(ctx context.Context) error {
+	if c.slots == nil {
+		return nil
+	}
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if c.options.sessionQueueTimeout <= 0 {
+		return ErrTooManySessions
+	}
+	timer := time.NewTimer(c.options.sessionQueueTimeout)
+	defer timer.Stop()
+	select {
+	case c.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrTooManySessions
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) releaseSlot() {
+	<-c.slots
+}
+
+// slotConn frees the session slot of its Client when it gets closed.
+type slotConn struct {
+	net.Conn
+	once   sync.Once
+	client *Client
+}
+
+func (c *slotConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.client.releaseSlot)
+	return err
+}
+
+// dial connects to the milter. When WithMaxConcurrentSessions was used the connection occupies a session slot until it gets closed.
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	if err := c.acquireSlot(ctx); err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	var err error
+	if dialer, ok := c.options.dialer.(contextDialer); ok {
+		conn, err = dialer.DialContext(ctx, c.network, c.address)
+	} else {
+		conn, err = c.options.dialer.Dial(c.network, c.address)
+	}
+	if err != nil {
+		if c.slots != nil {
+			c.releaseSlot()
+		}
+		return nil, err
+	}
+	if c.slots != nil {
+		conn = &slotConn{Conn: conn, client: c}
+	}
+	return conn, nil
 }
 
 // String returns the network and address that his Client is configured to connect to.
@@ -159,7 +318,7 @@ func (c *Client) String() string {
 //
 // This method is go-routine save.
 func (c *Client) Session(macros Macros) (*ClientSession, error) {
-	conn, err := c.options.dialer.Dial(c.network, c.address)
+	conn, err := c.dial(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("milter: session create: %w", err)
 	}
@@ -167,29 +326,122 @@ func (c *Client) Session(macros Macros) (*ClientSession, error) {
 	return c.session(conn, macros)
 }
 
-func (c *Client) session(conn net.Conn, macros Macros) (*ClientSession, error) {
-	s := &ClientSession{
-		readTimeout:    c.options.readTimeout,
-		writeTimeout:   c.options.writeTimeout,
-		state:          clientStateClosed,
-		macros:         macros,
-		macrosByStages: make([][]string, StageEndMarker),
-		maxBodySize:    uint32(c.options.usedMaxData),
+// PingResult holds the values that a milter advertised in a [Client.Ping] call.
+type PingResult struct {
+	// Version is the negotiated milter protocol version.
+	Version uint32
+	// Actions are the actions the milter requested.
+	Actions OptAction
+	// Protocol are the protocol options the milter requested.
+	Protocol OptProtocol
+	// MaxData is the maximum data size the milter accepted.
+	MaxData DataSize
+}
+
+// contextDialer is implemented by [net.Dialer].
+type contextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Ping connects to the milter, negotiates the protocol options and then closes the connection.
+// You can use it to check that the milter is reachable before routing traffic to it.
+//
+// The connection gets closed when ctx is done before the milter replied.
+//
+// This method is go-routine save.
+func (c *Client) Ping(ctx context.Context) (*PingResult, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("milter: ping: %w", err)
 	}
-	if c.options.macrosByStage != nil {
-		copy(s.macrosByStages, c.options.macrosByStage)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	s, err := c.session(conn, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("milter: ping: %w", ctx.Err())
+		}
+		return nil, err
+	}
+	result := &PingResult{
+		Version:  s.version,
+		Actions:  s.actionOpts,
+		Protocol: s.protocolOpts,
+		MaxData:  DataSize(s.negotiatedBodySize),
 	}
+	if err := s.Close(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
 
-	s.state = clientStateNegotiated
+func (c *Client) session(conn net.Conn, macros Macros) (*ClientSession, error) {
+	s := &ClientSession{
+		readTimeout:   c.options.readTimeout,
+		stageTimeouts: c.options.stageTimeouts,
+		writeTimeout:  c.options.writeTimeout,
+		state:         clientStateClosed,
+		macros:        macros,
+		pipelining:    c.options.pipelining,
+		macroAutoFill: c.options.macroAutoFill,
+		autoChunking:  c.options.autoChunking || c.options.usedMaxData == 0,
+		body:          bodyFilter{policy: c.options.bodyPolicy},
+		failurePolicy: c.options.failurePolicy,
+		idle:          newIdleKeeper(c.options.keepalive, c.options.idleTimeout),
+
+		maxUnknownReplies: c.options.maxUnknownReplies,
+		extensionHandler:  c.options.extensionReplyHandler,
+		timing:            c.options.timing,
+		logger:            c.options.logger,
+
+		negotiationTolerance: c.options.negotiationTolerance,
+		negotiationWarning:   c.options.negotiationWarning,
+	}
+	if c.options.reconnectAttempts > 0 {
+		s.reconnect = &reconnector{client: c, attempts: c.options.reconnectAttempts}
+	}
 
 	s.conn = conn
-	if err := s.negotiate(c.options.maxVersion, c.options.actions, c.options.protocol, c.options.offeredMaxData); err != nil {
+	if err := c.negotiate(s); err != nil {
 		return nil, err
 	}
+	if s.idle != nil {
+		s.idle.arm(s)
+	}
 
 	return s, nil
 }
 
+// negotiate negotiates the protocol options of s with the milter at the other end of s.conn.
+func (c *Client) negotiate(s *ClientSession) error {
+	s.state = clientStateNegotiated
+	s.macrosByStages = make([][]string, StageEndMarker)
+	if c.options.macrosByStage != nil {
+		copy(s.macrosByStages, c.options.macrosByStage)
+	}
+	s.maxBodySize = uint32(c.options.usedMaxData)
+	if err := s.negotiate(c.options.maxVersion, c.options.actions, c.options.protocol, c.options.offeredMaxData); err != nil {
+		return err
+	}
+	if s.maxBodySize == 0 {
+		// use what we negotiated, but never more than we offered
+		s.maxBodySize = s.negotiatedBodySize
+		if s.maxBodySize > uint32(c.options.offeredMaxData) {
+			s.maxBodySize = uint32(c.options.offeredMaxData)
+		}
+	}
+	return nil
+}
+
 type clientSessionState uint32
 
 const (
@@ -206,8 +458,24 @@ const (
 	clientStateError
 )
 
+// ErrConcurrentUse is returned by the methods of [ClientSession] (except [ClientSession.Close]) when another method of the same [ClientSession]
+// is still running in a different goroutine. A [ClientSession] must only be used by one goroutine at a time.
+// The concurrent call does not change the state of the [ClientSession], the other call is not affected.
+var ErrConcurrentUse = errors.New("milter: concurrent use of ClientSession")
+
 // ClientSession is a connection to one Client for one SMTP connection.
+//
+// A ClientSession is not safe for concurrent use. Methods that communicate with the milter
+// return [ErrConcurrentUse] when they get called while another of these methods is still running.
+// Only [ClientSession.Close] can get called at any time.
 type ClientSession struct {
+	// busy is 1 while a method that communicates with the milter is running
+	busy int32
+	// connMu guards conn against a Close while another method is running
+	connMu sync.Mutex
+	// interrupted is true when Close closed conn while another method was running
+	interrupted bool
+
 	conn net.Conn
 
 	// negotiated version of this session
@@ -227,14 +495,90 @@ type ClientSession struct {
 	skipUnknown bool
 	closedErr   error
 
-	readTimeout  time.Duration
-	writeTimeout time.Duration
+	// msg gets reused for every packet we read from the milter
+	msg wire.Message
+
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	stageTimeouts StageTimeouts
+
+	// pipelining is true when WithPipelining was used.
+	pipelining bool
+	// pipelined holds the encoded header field and body chunk packets that we did not write to the milter yet.
+	pipelined []byte
 
 	macros         Macros
 	macrosByStages [][]MacroName
+
+	// negotiationTolerance is true when WithNegotiationTolerance was used.
+	negotiationTolerance bool
+	negotiationWarning   NegotiationWarningFunc
+
+	// maxUnknownReplies is the value of WithLenientReplies, unknownReplies counts the skipped replies.
+	maxUnknownReplies, unknownReplies int
+
+	// extensionHandler is the handler of WithExtensionReplyHandler.
+	extensionHandler ExtensionReplyHandler
+
+	// logger is the Logger of WithLogger.
+	logger Logger
+
+	// overridden has the commands whose last macro packet contained per-command macros
+	overridden map[wire.Code]bool
+
+	skipStats SkipStats
+
+	// autoChunking is true when WithBodyChunking was used.
+	autoChunking bool
+
+	// body applies the BodyPolicy of WithBodyPolicy.
+	body bodyFilter
+
+	// failurePolicy is the FailurePolicy of WithOnError.
+	failurePolicy FailurePolicy
+	// milterErr is the error that broke the connection to the milter.
+	milterErr error
+
+	// timing is the callback of WithTimingCallback.
+	timing TimingFunc
+
+	// reconnect re-establishes the session for WithReconnect, it is nil when WithReconnect was not used.
+	reconnect *reconnector
+
+	// idle handles WithKeepalive and WithIdleTimeout, it is nil when they were not used.
+	idle *idleKeeper
+
+	// macroAutoFill is true when WithMacroAutoFill was used.
+	macroAutoFill bool
+	// smtpConn is the SMTP connection of the MTA we derive macros from.
+	smtpConn net.Conn
+	// ifNames caches the network interface names of the local addresses of smtpConn.
+	ifNames map[string]string
+}
+
+// enter marks s as busy. It returns [ErrConcurrentUse] when s is already busy.
+func (s *ClientSession) enter() error {
+	if !atomic.CompareAndSwapInt32(&s.busy, 0, 1) {
+		return ErrConcurrentUse
+	}
+	if s.idle != nil {
+		s.idle.stop()
+	}
+	return nil
+}
+
+// leave marks s as not busy.
+func (s *ClientSession) leave() {
+	atomic.StoreInt32(&s.busy, 0)
+	if s.idle != nil {
+		s.idle.arm(s)
+	}
 }
 
 func (s *ClientSession) errorOut(err error) error {
+	if s.milterErr == nil && !errors.Is(err, ErrWrongState) && !errors.Is(err, ErrTooLarge) {
+		s.milterErr = err
+	}
 	s.state = clientStateError
 	// close the connection
 	if s.conn != nil {
@@ -243,6 +587,7 @@ func (s *ClientSession) errorOut(err error) error {
 	// give garbage collector a chance to free space
 	s.macros = nil
 	s.macrosByStages = nil
+	s.smtpConn = nil
 	return err
 }
 
@@ -255,13 +600,7 @@ func (s *ClientSession) negotiate(maximumVersion uint32, actionMask OptAction, p
 	}
 	binary.BigEndian.PutUint32(msg.Data, maximumVersion)
 	binary.BigEndian.PutUint32(msg.Data[4:], uint32(actionMask))
-	if requestedMaxBuffer == DataSize256K {
-		binary.BigEndian.PutUint32(msg.Data[8:], uint32(protoMask)|optMds256K)
-	} else if requestedMaxBuffer == DataSize1M {
-		binary.BigEndian.PutUint32(msg.Data[8:], uint32(protoMask)|optMds1M)
-	} else {
-		binary.BigEndian.PutUint32(msg.Data[8:], uint32(protoMask))
-	}
+	binary.BigEndian.PutUint32(msg.Data[8:], uint32(protoMask)|requestedMaxBuffer.protocolFlag())
 
 	if err := s.writePacket(msg); err != nil {
 		return s.errorOut(fmt.Errorf("milter: negotiate: optneg write: %w", err))
@@ -271,22 +610,26 @@ func (s *ClientSession) negotiate(maximumVersion uint32, actionMask OptAction, p
 		return s.errorOut(fmt.Errorf("milter: negotiate: optneg read: %w", err))
 	}
 	if msg.Code != wire.CodeOptNeg {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unexpected code: %v", rune(msg.Code)))
+		return s.errorOut(&NegotiationError{Reason: fmt.Sprintf("unexpected code: %v", rune(msg.Code))})
 	}
 	if len(msg.Data) < 4*3 /* version + action mask + proto mask */ {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unexpected data size: %v", len(msg.Data)))
+		return s.errorOut(&NegotiationError{Reason: fmt.Sprintf("unexpected data size: %v", len(msg.Data))})
 	}
 	milterVersion := binary.BigEndian.Uint32(msg.Data[0:])
 
 	if milterVersion < 2 || milterVersion > maximumVersion {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unsupported protocol version: %v", milterVersion))
+		return s.errorOut(&NegotiationError{Version: milterVersion, Reason: fmt.Sprintf("unsupported protocol version: %v", milterVersion)})
 	}
 
 	s.version = milterVersion
 
 	milterActionMask := OptAction(binary.BigEndian.Uint32(msg.Data[4:]))
-	if milterActionMask&actionMask != milterActionMask {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unsupported actions requested: MTA %032b filter %032b", actionMask, milterActionMask))
+	unsupportedActions := milterActionMask &^ actionMask
+	if unsupportedActions != 0 {
+		if !s.negotiationTolerance {
+			return s.errorOut(&NegotiationError{Version: milterVersion, UnsupportedActions: unsupportedActions, Reason: fmt.Sprintf("unsupported actions requested: MTA %032b filter %032b", actionMask, milterActionMask)})
+		}
+		milterActionMask = milterActionMask & actionMask
 	}
 	s.actionOpts = milterActionMask
 	milterProtoMask := OptProtocol(binary.BigEndian.Uint32(msg.Data[8:]))
@@ -301,8 +644,19 @@ func (s *ClientSession) negotiate(maximumVersion uint32, actionMask OptAction, p
 
 	// mask out the size flags
 	milterProtoMask = milterProtoMask & (^OptProtocol(optInternal))
-	if milterProtoMask&protoMask != milterProtoMask {
-		return s.errorOut(fmt.Errorf("milter: negotiate: unsupported protocol options requested: MTA %032b filter %032b", protoMask, milterProtoMask))
+	unsupportedProtocol := milterProtoMask &^ protoMask
+	if unsupportedProtocol != 0 {
+		if !s.negotiationTolerance {
+			return s.errorOut(&NegotiationError{Version: milterVersion, UnsupportedActions: unsupportedActions, UnsupportedProtocol: unsupportedProtocol, Reason: fmt.Sprintf("unsupported protocol options requested: MTA %032b filter %032b", protoMask, milterProtoMask)})
+		}
+		milterProtoMask = milterProtoMask & protoMask
+	}
+	if unsupportedActions != 0 || unsupportedProtocol != 0 {
+		if s.negotiationWarning != nil {
+			s.negotiationWarning(unsupportedActions, unsupportedProtocol)
+		} else {
+			s.logWarning("negotiate: ignoring unsupported requests of milter: actions %032b protocol %032b", unsupportedActions, unsupportedProtocol)
+		}
 	}
 
 	// do not send commands that older versions do not understand
@@ -328,16 +682,16 @@ func (s *ClientSession) negotiate(maximumVersion uint32, actionMask OptAction, p
 			requestedMacros := wire.ReadCString(msg.Data[offset:])
 			offset += len(requestedMacros)
 			if l <= offset || msg.Data[offset] != 0 {
-				LogWarning("macros for stage %d are not null-terminated, skipping rest of list: %s", stage, requestedMacros)
+				s.logWarning("macros for stage %d are not null-terminated, skipping rest of list: %s", stage, requestedMacros)
 				break
 			}
 			offset += 1 // skip null byte
 			if stage < uint32(StageConnect) || stage >= uint32(StageEndMarker) {
-				LogWarning("got request for unknown stage %d, ignoring this entry", stage)
+				s.logWarning("got request for unknown stage %d, ignoring this entry", stage)
 				continue
 			}
 			if s.macrosByStages[MacroStage(stage)] != nil {
-				LogWarning("macros for stage %d were send multiple times: %q is overwriting %q", stage, requestedMacros, strings.Join(s.macrosByStages[MacroStage(stage)], " "))
+				s.logWarning("macros for stage %d were send multiple times: %q is overwriting %q", stage, requestedMacros, strings.Join(s.macrosByStages[MacroStage(stage)], " "))
 			}
 			s.macrosByStages[MacroStage(stage)] = parseRequestedMacros(requestedMacros)
 		}
@@ -351,6 +705,26 @@ func (s *ClientSession) negotiate(maximumVersion uint32, actionMask OptAction, p
 	return nil
 }
 
+// Version returns the negotiated milter protocol version.
+func (s *ClientSession) Version() uint32 {
+	return s.version
+}
+
+// Actions returns the negotiated actions the milter is allowed to do.
+func (s *ClientSession) Actions() OptAction {
+	return s.actionOpts
+}
+
+// Protocols returns the negotiated protocol options.
+func (s *ClientSession) Protocols() OptProtocol {
+	return s.protocolOpts
+}
+
+// MaxBodySize returns the maximum size of a body chunk that [ClientSession.BodyChunk] sends to the milter (see [WithUsedMaxData]).
+func (s *ClientSession) MaxBodySize() DataSize {
+	return DataSize(s.maxBodySize)
+}
+
 // ProtocolOption checks whether the option is set in negotiated options.
 func (s *ClientSession) ProtocolOption(opt OptProtocol) bool {
 	return s.protocolOpts&opt != 0
@@ -361,74 +735,214 @@ func (s *ClientSession) ActionOption(opt OptAction) bool {
 	return s.actionOpts&opt != 0
 }
 
-func (s *ClientSession) sendMacros(code wire.Code, names []MacroName) error {
-	if s.macros == nil {
+// MacroRequests returns the names of the macros that this session sends to the milter at stage.
+// These are the macros the milter requested in the protocol negotiation or – when it did not request
+// any macros – the macros that were configured with [WithMacroRequest].
+// You can use this to only compute macro values the milter actually needs.
+//
+// The returned slice is a copy. It is nil when stage is invalid or no macros get sent at stage.
+func (s *ClientSession) MacroRequests(stage MacroStage) []MacroName {
+	if int(stage) >= len(s.macrosByStages) || len(s.macrosByStages[stage]) == 0 {
 		return nil
 	}
-	msg := &wire.Message{
-		Code: wire.CodeMacro,
-		Data: []byte{byte(code)},
+	return append([]MacroName(nil), s.macrosByStages[stage]...)
+}
+
+// SetSMTPConn sets the SMTP connection of the MTA that this session filters.
+// When the [Client] was created with [WithMacroAutoFill] this session derives the connection and TLS related macros from conn.
+// Otherwise, this method does nothing.
+//
+// Call it again with the [crypto/tls.Conn] after a successful STARTTLS so that the TLS related macros get sent to the milter.
+func (s *ClientSession) SetSMTPConn(conn net.Conn) {
+	if !s.macroAutoFill {
+		return
+	}
+	s.smtpConn = conn
+}
+
+// getMacro returns the value of the macro name. Values in s.macros take precedence over the values in derived.
+func (s *ClientSession) getMacro(name MacroName, derived map[MacroName]string) (string, bool) {
+	if s.macros != nil {
+		if val, ok := s.macros.GetEx(name); ok {
+			return val, true
+		}
+	}
+	val, ok := derived[name]
+	return val, ok
+}
+
+// interfaceName returns the name of the network interface that has the address ip or "" if there is none.
+// Looking up the network interfaces is expensive, so s does it only once per address.
+func (s *ClientSession) interfaceName(ip net.IP) string {
+	key := ip.String()
+	if name, ok := s.ifNames[key]; ok {
+		return name
+	}
+	if s.ifNames == nil {
+		s.ifNames = make(map[string]string)
+	}
+	name := interfaceName(ip)
+	s.ifNames[key] = name
+	return name
+}
+
+func (s *ClientSession) sendMacros(code wire.Code, names []MacroName, overrides map[MacroName]string) error {
+	if s.macros == nil && s.smtpConn == nil && len(overrides) == 0 && !s.overridden[code] {
+		return nil
 	}
-	foundMacro := false
+	var derived map[MacroName]string
+	if s.smtpConn != nil {
+		derived = connMacros(s.smtpConn, s.interfaceName)
+	}
+	var kv []string
 	for _, name := range names {
+		val, ok := overrides[name]
+		if !ok {
+			val, ok = s.getMacro(name, derived)
+		}
 		// only send macros we actually defined
-		if val, ok := s.macros.GetEx(name); ok {
-			foundMacro = true
-			msg.Data = wire.AppendCString(msg.Data, name)
-			msg.Data = wire.AppendCString(msg.Data, val)
+		if ok {
+			kv = append(kv, name, val)
 		}
 	}
+	// overrides get sent even when they were not requested
+	extra := make([]MacroName, 0, len(overrides))
+	for name := range overrides {
+		if !containsMacroName(names, name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		kv = append(kv, name, overrides[name])
+	}
 	// no need to send anything when we have not found a single macro
-	if !foundMacro {
+	// but the milter needs to forget the macros of a previous command with overrides
+	if len(kv) == 0 && !s.overridden[code] {
 		return nil
 	}
 
-	if err := s.writePacket(msg); err != nil {
+	if err := s.writePacket(s.macroMessage(code, kv)); err != nil {
 		return fmt.Errorf("milter: sendMacros: %w", err)
 	}
+	if len(overrides) > 0 {
+		if s.overridden == nil {
+			s.overridden = make(map[wire.Code]bool)
+		}
+		s.overridden[code] = true
+	} else {
+		delete(s.overridden, code)
+	}
 
 	return nil
 }
 
+// sendStageMacros sends the requested macros of stage and the per-command macros of overrides.
+func (s *ClientSession) sendStageMacros(code wire.Code, stage MacroStage, overrides map[MacroName]string) error {
+	var names []MacroName
+	if len(s.macrosByStages) > int(stage) {
+		names = s.macrosByStages[stage]
+	}
+	if len(names) == 0 && len(overrides) == 0 && !s.overridden[code] {
+		return nil
+	}
+	return s.sendMacros(code, names, overrides)
+}
+
+func containsMacroName(names []MacroName, name MacroName) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *ClientSession) sendCmdMacros(code wire.Code, macros map[MacroName]string) error {
 	if len(macros) == 0 {
 		return nil
 	}
-	msg := &wire.Message{
-		Code: wire.CodeMacro,
-		Data: []byte{byte(code)},
+	names := make([]MacroName, 0, len(macros))
+	for name := range macros {
+		names = append(names, name)
 	}
-	for name, val := range macros {
-		msg.Data = wire.AppendCString(msg.Data, name)
-		msg.Data = wire.AppendCString(msg.Data, val)
-
+	sort.Strings(names)
+	kv := make([]string, 0, len(macros)*2)
+	for _, name := range names {
+		kv = append(kv, name, macros[name])
 	}
 
-	if err := s.writePacket(msg); err != nil {
+	if err := s.writePacket(s.macroMessage(code, kv)); err != nil {
 		return fmt.Errorf("milter: sendMacros: %w", err)
 	}
 
 	return nil
 }
 
-func (s *ClientSession) readAction(skipOk bool) (*Action, error) {
+// macroMessage encodes the macro name/value pairs kv for the command code.
+// The milter replaces all macros of a stage with each macro packet, so we cannot split kv into multiple packets.
+// When kv does not fit into the negotiated maximum packet size the last macros of kv
+// (the ones with the lowest priority) get dropped and a warning gets logged.
+func (s *ClientSession) macroMessage(code wire.Code, kv []string) *wire.Message {
+	limit := int(s.negotiatedBodySize)
+	if limit == 0 {
+		limit = int(DataSize64K)
+	}
+	msg := &wire.Message{
+		Code: wire.CodeMacro,
+		Data: []byte{byte(code)},
+	}
+	var dropped []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		if len(dropped) > 0 || len(msg.Data)+len(kv[i])+len(kv[i+1])+2 > limit {
+			dropped = append(dropped, kv[i])
+			continue
+		}
+		msg.Data = wire.AppendCString(msg.Data, kv[i])
+		msg.Data = wire.AppendCString(msg.Data, kv[i+1])
+	}
+	if len(dropped) > 0 {
+		s.logWarning("dropping macros %v for command %c: they do not fit into the maximum packet size of %d bytes", dropped, code, limit)
+	}
+	return msg
+}
+
+// readTimeoutOr returns timeout when it is set or the general read timeout of this session.
+func (s *ClientSession) readTimeoutOr(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return s.readTimeout
+}
+
+// readAction reads the reply of the milter. timeout is the read timeout of the current stage,
+// it can be 0 to use the read timeout of this session.
+func (s *ClientSession) readAction(skipOk bool, timeout time.Duration) (*Action, error) {
+	msg := &s.msg
 	for {
-		msg, err := wire.ReadPacket(s.conn, s.readTimeout)
-		if err != nil {
+		if err := wire.ReadPacketInto(s.conn, msg, s.readTimeoutOr(timeout)); err != nil {
 			return nil, s.errorOut(fmt.Errorf("action read: %w", err))
 		}
 		if wire.ActionCode(msg.Code) == wire.ActProgress /* progress */ {
 			continue
 		}
+		if handled, err := s.handleExtensionReply(msg); err != nil {
+			return nil, s.errorOut(err)
+		} else if handled {
+			continue
+		}
+		if s.skipUnknownReply(msg) {
+			continue
+		}
 
 		act, err := parseAction(msg)
 		if err != nil {
-			return nil, err
+			return nil, &ActionParseError{Code: byte(msg.Code), Err: err}
 		}
 		switch act.Type {
 		case ActionSkip:
 			if !skipOk {
-				return nil, fmt.Errorf("action read: unexpected skip message received (can only be received after SMFIC_RCPT, SMFIC_HEADER, SMFIC_BODY when SMFIP_SKIP was negotiated)")
+				return nil, &ActionParseError{Code: byte(msg.Code), Err: errors.New("unexpected skip message received (can only be received after SMFIC_RCPT, SMFIC_HEADER, SMFIC_BODY when SMFIP_SKIP was negotiated)")}
 			}
 		case ActionReject:
 			act.SMTPCode = 550
@@ -442,7 +956,43 @@ func (s *ClientSession) readAction(skipOk bool) (*Action, error) {
 	}
 }
 
+// pipeline buffers msg when the session is in pipelined mode. It returns false when msg needs to get written directly.
+// Only commands that the milter does not reply to get pipelined, see [WithPipelining].
+func (s *ClientSession) pipeline(msg *wire.Message, noReply OptProtocol) (bool, error) {
+	if !s.pipelining || !s.ProtocolOption(noReply) {
+		return false, nil
+	}
+	var err error
+	if s.pipelined, err = wire.AppendPacket(s.pipelined, msg); err != nil {
+		return true, err
+	}
+	if len(s.pipelined) > maxPipelinedBytes {
+		return true, s.flushPipelined()
+	}
+	return true, nil
+}
+
+// flushPipelined writes the buffered packets of pipelined commands to the milter.
+func (s *ClientSession) flushPipelined() error {
+	if len(s.pipelined) == 0 {
+		return nil
+	}
+	if s.writeTimeout != 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		defer func() {
+			_ = s.conn.SetWriteDeadline(time.Time{})
+		}()
+	}
+	_, err := s.conn.Write(s.pipelined)
+	s.pipelined = s.pipelined[:0]
+	return err
+}
+
 func (s *ClientSession) writePacket(msg *wire.Message) error {
+	// keep the order of the commands
+	if err := s.flushPipelined(); err != nil {
+		return err
+	}
 	return wire.WritePacket(s.conn, msg, s.writeTimeout)
 }
 
@@ -451,17 +1001,38 @@ func (s *ClientSession) writePacket(msg *wire.Message) error {
 // It should be called once per milter session (from Session to Close).
 // Exception: After you called Reset you need to call Conn again.
 func (s *ClientSession) Conn(hostname string, family ProtoFamily, port uint16, addr string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeConn, time.Now())(s.failSafe(s.retriable(true, func() (*Action, error) {
+		return s.doConn(hostname, family, port, addr, nil)
+	})))
+}
+
+// ConnWithMacros is like [ClientSession.Conn] but additionally sends macros to the milter.
+// The values of macros take precedence over the values of the [Macros] of s and only get used for this command.
+// Macros in macros that the milter did not request get sent nonetheless.
+func (s *ClientSession) ConnWithMacros(hostname string, family ProtoFamily, port uint16, addr string, macros map[MacroName]string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeConn, time.Now())(s.failSafe(s.retriable(true, func() (*Action, error) {
+		return s.doConn(hostname, family, port, addr, macros)
+	})))
+}
+
+func (s *ClientSession) doConn(hostname string, family ProtoFamily, port uint16, addr string, macros map[MacroName]string) (*Action, error) {
 	if s.state != clientStateNegotiated {
-		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
+		return nil, s.stateError("conn")
 	}
 
 	s.skip = false
 	s.state = clientStateConnectCalled
 
-	if len(s.macrosByStages) > int(StageConnect) && len(s.macrosByStages[StageConnect]) > 0 {
-		if err := s.sendMacros(wire.CodeConn, s.macrosByStages[StageConnect]); err != nil {
-			return nil, err
-		}
+	if err := s.sendStageMacros(wire.CodeConn, StageConnect, macros); err != nil {
+		return nil, err
 	}
 
 	if s.ProtocolOption(OptNoConnect) {
@@ -490,13 +1061,13 @@ func (s *ClientSession) Conn(hostname string, family ProtoFamily, port uint16, a
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(false)
+	act, err := s.readAction(false, s.stageTimeouts.Connect)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: conn: %w", err))
 	}
 
 	if act.Type == ActionDiscard {
-		LogWarning("Connect got a discard action, ignoring it")
+		s.logWarning("Connect got a discard action, ignoring it")
 		act.Type = ActionContinue
 	}
 
@@ -507,17 +1078,38 @@ func (s *ClientSession) Conn(hostname string, family ProtoFamily, port uint16, a
 //
 // It should be called once per milter session (from Client.Session to Close).
 func (s *ClientSession) Helo(helo string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeHelo, time.Now())(s.failSafe(s.retriable(true, func() (*Action, error) {
+		return s.doHelo(helo, nil)
+	})))
+}
+
+// HeloWithMacros is like [ClientSession.Helo] but additionally sends macros to the milter.
+// The values of macros take precedence over the values of the [Macros] of s and only get used for this command.
+// Macros in macros that the milter did not request get sent nonetheless.
+func (s *ClientSession) HeloWithMacros(helo string, macros map[MacroName]string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeHelo, time.Now())(s.failSafe(s.retriable(true, func() (*Action, error) {
+		return s.doHelo(helo, macros)
+	})))
+}
+
+func (s *ClientSession) doHelo(helo string, macros map[MacroName]string) (*Action, error) {
 	if s.state != clientStateConnectCalled && s.state != clientStateHeloCalled {
-		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
+		return nil, s.stateError("helo")
 	}
 
 	s.skip = false
 	s.state = clientStateHeloCalled
 
-	if len(s.macrosByStages) > int(StageHelo) && len(s.macrosByStages[StageHelo]) > 0 {
-		if err := s.sendMacros(wire.CodeHelo, s.macrosByStages[StageHelo]); err != nil {
-			return nil, s.errorOut(err)
-		}
+	if err := s.sendStageMacros(wire.CodeHelo, StageHelo, macros); err != nil {
+		return nil, s.errorOut(err)
 	}
 
 	// Synthesise response as if server replied "go on" while in fact it does
@@ -539,13 +1131,13 @@ func (s *ClientSession) Helo(helo string) (*Action, error) {
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(false)
+	act, err := s.readAction(false, s.stageTimeouts.Helo)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: helo: %w", err))
 	}
 
 	if act.Type == ActionDiscard {
-		LogWarning("Helo got a discard action, ignoring it")
+		s.logWarning("Helo got a discard action, ignoring it")
 		act.Type = ActionContinue
 	}
 
@@ -554,17 +1146,38 @@ func (s *ClientSession) Helo(helo string) (*Action, error) {
 
 // Mail sends the sender (with optional esmtpArgs) to the milter.
 func (s *ClientSession) Mail(sender string, esmtpArgs string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeMail, time.Now())(s.failSafe(s.retriable(false, func() (*Action, error) {
+		return s.doMail(sender, esmtpArgs, nil)
+	})))
+}
+
+// MailWithMacros is like [ClientSession.Mail] but additionally sends macros to the milter.
+// The values of macros take precedence over the values of the [Macros] of s and only get used for this command.
+// Macros in macros that the milter did not request get sent nonetheless.
+func (s *ClientSession) MailWithMacros(sender string, esmtpArgs string, macros map[MacroName]string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeMail, time.Now())(s.failSafe(s.retriable(false, func() (*Action, error) {
+		return s.doMail(sender, esmtpArgs, macros)
+	})))
+}
+
+func (s *ClientSession) doMail(sender string, esmtpArgs string, macros map[MacroName]string) (*Action, error) {
 	if s.state != clientStateHeloCalled {
-		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
+		return nil, s.stateError("mail")
 	}
 
 	s.skip = false
 	s.state = clientStateMailCalled
 
-	if len(s.macrosByStages) > int(StageMail) && len(s.macrosByStages[StageMail]) > 0 {
-		if err := s.sendMacros(wire.CodeMail, s.macrosByStages[StageMail]); err != nil {
-			return nil, s.errorOut(err)
-		}
+	if err := s.sendStageMacros(wire.CodeMail, StageMail, macros); err != nil {
+		return nil, s.errorOut(err)
 	}
 
 	if s.ProtocolOption(OptNoMailFrom) {
@@ -588,7 +1201,7 @@ func (s *ClientSession) Mail(sender string, esmtpArgs string) (*Action, error) {
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(false)
+	act, err := s.readAction(false, s.stageTimeouts.MailFrom)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: mail: %w", err))
 	}
@@ -599,22 +1212,71 @@ func (s *ClientSession) Mail(sender string, esmtpArgs string) (*Action, error) {
 // If s.ProtocolOption(OptRcptRej) is true the milter wants rejected recipients.
 // The default is to only send valid recipients to the milter.
 func (s *ClientSession) Rcpt(rcpt string, esmtpArgs string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeRcpt, time.Now())(s.failSafe(s.retriable(false, func() (*Action, error) {
+		return s.doRcpt(rcpt, esmtpArgs, nil)
+	})))
+}
+
+// RcptWithMacros is like [ClientSession.Rcpt] but additionally sends macros to the milter.
+// The values of macros take precedence over the values of the [Macros] of s and only get used for this command.
+// Macros in macros that the milter did not request get sent nonetheless.
+func (s *ClientSession) RcptWithMacros(rcpt string, esmtpArgs string, macros map[MacroName]string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeRcpt, time.Now())(s.failSafe(s.retriable(false, func() (*Action, error) {
+		return s.doRcpt(rcpt, esmtpArgs, macros)
+	})))
+}
+
+// RcptRejected sends a recipient that the MTA already rejected to the milter.
+// It only sends rcpt when the milter negotiated [OptRcptRej], otherwise it just returns an [ActionContinue] action.
+// The rejection gets signalled to the milter like sendmail does it: the macro [MacroRcptMailer] is "error",
+// [MacroRcptHost] is the SMTP code and [MacroRcptAddr] is the reason of rejected.
+// The milter can use [RejectedRcpt] to detect this.
+//
+// The milter cannot accept a rejected recipient, so you should ignore the returned [Action] unless it ends the SMTP transaction.
+func (s *ClientSession) RcptRejected(rcpt string, esmtpArgs string, rejected RejectedInfo) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeRcpt, time.Now())(s.failSafe(s.doRcptRejected(rcpt, esmtpArgs, rejected)))
+}
+
+func (s *ClientSession) doRcptRejected(rcpt string, esmtpArgs string, rejected RejectedInfo) (*Action, error) {
+	if !s.ProtocolOption(OptRcptRej) {
+		if s.state != clientStateMailCalled && s.state != clientStateRcptCalled {
+			return nil, s.stateError("rcpt")
+		}
+		s.state = clientStateRcptCalled
+		return &Action{Type: ActionContinue}, nil
+	}
+	return s.doRcpt(rcpt, esmtpArgs, rejected.macros())
+}
+
+func (s *ClientSession) doRcpt(rcpt string, esmtpArgs string, macros map[MacroName]string) (*Action, error) {
 	if s.state != clientStateMailCalled && s.state != clientStateRcptCalled {
-		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
+		return nil, s.stateError("rcpt")
 	}
 	if s.skip {
+		s.skipStats.SkippedRcpts++
 		return &Action{Type: ActionContinue}, nil
 	}
 
 	s.state = clientStateRcptCalled
 
-	if len(s.macrosByStages) > int(StageRcpt) && len(s.macrosByStages[StageRcpt]) > 0 {
-		if err := s.sendMacros(wire.CodeRcpt, s.macrosByStages[StageRcpt]); err != nil {
-			return nil, s.errorOut(err)
-		}
+	if err := s.sendStageMacros(wire.CodeRcpt, StageRcpt, macros); err != nil {
+		return nil, s.errorOut(err)
 	}
 
 	if s.ProtocolOption(OptNoRcptTo) {
+		s.skipStats.OptOutRcpts++
 		return &Action{Type: ActionContinue}, nil
 	}
 
@@ -635,7 +1297,7 @@ func (s *ClientSession) Rcpt(rcpt string, esmtpArgs string) (*Action, error) {
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(s.ProtocolOption(OptSkip))
+	act, err := s.readAction(s.ProtocolOption(OptSkip), s.stageTimeouts.RcptTo)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: rcpt: %w", err))
 	}
@@ -653,14 +1315,22 @@ func (s *ClientSession) Rcpt(rcpt string, esmtpArgs string) (*Action, error) {
 // After DataStart you need to call the HeaderField/Header and BodyChunk&End/BodyReadFrom calls for the whole message serially to each milter.
 // The first milter may alter the message and the next milter should receive the altered message, not the original message.
 func (s *ClientSession) DataStart() (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeData, time.Now())(s.failSafe(s.retriable(false, s.doDataStart)))
+}
+
+func (s *ClientSession) doDataStart() (*Action, error) {
 	if s.state != clientStateRcptCalled {
-		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
+		return nil, s.stateError("data")
 	}
 	s.skip = false
 	s.state = clientStateDataCalled
 
 	if s.version > 3 && len(s.macrosByStages) > int(StageData) && len(s.macrosByStages[StageData]) > 0 {
-		if err := s.sendMacros(wire.CodeData, s.macrosByStages[StageData]); err != nil {
+		if err := s.sendMacros(wire.CodeData, s.macrosByStages[StageData], nil); err != nil {
 			return nil, s.errorOut(err)
 		}
 	}
@@ -681,7 +1351,7 @@ func (s *ClientSession) DataStart() (*Action, error) {
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(false)
+	act, err := s.readAction(false, s.stageTimeouts.Data)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: rcpt: %w", err))
 	}
@@ -712,16 +1382,26 @@ func trimLastLineBreak(in string) string {
 // You can send macros to the milter with macros. They only get send to the milter when it wants header values and it did not send a skip response.
 // Thus, the macros you send here should be relevant to this header only.
 func (s *ClientSession) HeaderField(key, value string, macros map[MacroName]string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeHeader, time.Now())(s.failSafe(s.doHeaderField(key, value, macros)))
+}
+
+func (s *ClientSession) doHeaderField(key, value string, macros map[MacroName]string) (*Action, error) {
 	if s.state > clientStateHeaderFieldCalled || s.state < clientStateDataCalled {
-		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
+		return nil, s.stateError("header field")
 	}
 	if s.skip {
+		s.skipStats.SkippedHeaders++
 		return &Action{Type: ActionContinue}, nil
 	}
 
 	s.state = clientStateHeaderFieldCalled
 
 	if s.ProtocolOption(OptNoHeaders) {
+		s.skipStats.OptOutHeaders++
 		return &Action{Type: ActionContinue}, nil
 	}
 
@@ -735,6 +1415,13 @@ func (s *ClientSession) HeaderField(key, value string, macros map[MacroName]stri
 	msg.Data = wire.AppendCString(msg.Data, key)
 	msg.Data = wire.AppendCString(msg.Data, trimLastLineBreak(value))
 
+	if pipelined, err := s.pipeline(msg, OptNoHeaderReply); pipelined || err != nil {
+		if err != nil {
+			return nil, s.errorOut(fmt.Errorf("milter: header field: %w", err))
+		}
+		return &Action{Type: ActionContinue}, nil
+	}
+
 	if err := s.writePacket(msg); err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: header field: %w", err))
 	}
@@ -743,7 +1430,7 @@ func (s *ClientSession) HeaderField(key, value string, macros map[MacroName]stri
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(s.ProtocolOption(OptSkip))
+	act, err := s.readAction(s.ProtocolOption(OptSkip), s.stageTimeouts.Header)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: header field: %w", err))
 	}
@@ -758,14 +1445,25 @@ func (s *ClientSession) HeaderField(key, value string, macros map[MacroName]stri
 //
 // No HeaderField calls are allowed after this point.
 func (s *ClientSession) HeaderEnd() (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeEOH, time.Now())(s.failSafe(s.doHeaderEnd()))
+}
+
+func (s *ClientSession) doHeaderEnd() (*Action, error) {
 	if s.state > clientStateHeaderFieldCalled || s.state < clientStateDataCalled {
-		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
+		return nil, s.stateError("header end")
+	}
+	if err := s.flushPipelined(); err != nil {
+		return nil, s.errorOut(fmt.Errorf("milter: header end: %w", err))
 	}
 	s.skip = false
 	s.state = clientStateHeaderEndCalled
 
 	if len(s.macrosByStages) > int(StageEOH) && len(s.macrosByStages[StageEOH]) > 0 {
-		if err := s.sendMacros(wire.CodeEOH, s.macrosByStages[StageEOH]); err != nil {
+		if err := s.sendMacros(wire.CodeEOH, s.macrosByStages[StageEOH], nil); err != nil {
 			return nil, s.errorOut(err)
 		}
 	}
@@ -784,7 +1482,7 @@ func (s *ClientSession) HeaderEnd() (*Action, error) {
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(false)
+	act, err := s.readAction(false, s.stageTimeouts.EndOfHeader)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: header end: %w", err))
 	}
@@ -797,59 +1495,126 @@ func (s *ClientSession) HeaderEnd() (*Action, error) {
 // You may call HeaderField before calling this method but since it calls HeaderEnd afterwards
 // you should call BodyChunk or BodyReadFrom.
 func (s *ClientSession) Header(hdr textproto.Header) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeHeader, time.Now())(s.failSafe(s.doHeader(hdr)))
+}
+
+func (s *ClientSession) doHeader(hdr textproto.Header) (*Action, error) {
 	if s.state < clientStateRcptCalled || s.state > clientStateHeaderFieldCalled {
-		return nil, s.errorOut(fmt.Errorf("milter: in wrong state %d", s.state))
+		return nil, s.stateError("header")
 	}
 	if s.state == clientStateRcptCalled {
-		act, err := s.DataStart()
+		act, err := s.doDataStart()
 		if err != nil || act.Type != ActionContinue {
 			return act, err
 		}
 	}
 	if !s.ProtocolOption(OptNoHeaders) || s.skip {
 		for f := hdr.Fields(); f.Next(); {
-			act, err := s.HeaderField(f.Key(), f.Value(), nil)
+			act, err := s.doHeaderField(f.Key(), f.Value(), nil)
 			if err != nil || (act.Type != ActionContinue) {
 				return act, err
 			}
 			if s.skip {
+				for f.Next() {
+					s.skipStats.SkippedHeaders++
+				}
 				break
 			}
 		}
+	} else {
+		s.skipStats.OptOutHeaders += hdr.Len()
 	}
 
-	return s.HeaderEnd()
+	return s.doHeaderEnd()
 }
 
 // BodyChunk sends a single body chunk to the milter.
 //
 // It is callers responsibility to ensure every chunk is not bigger than
-// defined in WithUsedMaxData.
+// defined in WithUsedMaxData. When you used [WithBodyChunking] bigger chunks get split automatically.
+//
+// When you used [WithBodyPolicy] the chunk gets sanitized or checked before it gets sent.
+// A chunk that violates the [BodyReject] policy does not get sent and BodyChunk returns an error wrapping [ErrInvalidBody].
+// The session stays usable, you should call [ClientSession.Abort] after this error.
 //
 // BodyChunk can be called even after the milter responded with ActSkip.
 // This method translates a ActSkip milter response into a ActContinue response
 // but after a successful ActSkip response Skip will return true.
 func (s *ClientSession) BodyChunk(chunk []byte) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeBody, time.Now())(s.failSafe(s.doBodyChunk(chunk)))
+}
+
+// splitBodyChunk sends chunk in parts that are not bigger than the negotiated maximum body size.
+// It tries to split chunk after a line ending.
+func (s *ClientSession) splitBodyChunk(chunk []byte) (*Action, error) {
+	max := int(s.maxBodySize)
+	for len(chunk) > 0 {
+		n := len(chunk)
+		if n > max {
+			n = max
+			if i := bytes.LastIndexByte(chunk[:max], '\n'); i >= 0 {
+				n = i + 1
+			}
+		}
+		act, err := s.doBodyChunk(chunk[:n])
+		if err != nil || act.Type != ActionContinue || s.skip {
+			return act, err
+		}
+		chunk = chunk[n:]
+	}
+	return &Action{Type: ActionContinue}, nil
+}
+
+func (s *ClientSession) doBodyChunk(chunk []byte) (*Action, error) {
 	if s.state < clientStateHeaderEndCalled || s.state > clientStateBodyChunkCalled {
-		return nil, s.errorOut(fmt.Errorf("milter: body: in wrong state %d", s.state))
+		return nil, s.stateError("body")
+	}
+	if s.state != clientStateBodyChunkCalled {
+		s.body.reset()
 	}
 	s.state = clientStateBodyChunkCalled
 	if s.skip {
+		s.skipStats.SkippedBodyChunks++
 		return &Action{Type: ActionContinue}, nil
 	}
 
 	if s.ProtocolOption(OptNoBody) {
+		s.skipStats.OptOutBodyChunks++
 		return &Action{Type: ActionContinue}, nil
 	}
 
+	chunk, err := s.body.apply(chunk)
+	if err != nil {
+		return nil, err
+	}
+
 	if len(chunk) > int(s.maxBodySize) {
-		return nil, s.errorOut(fmt.Errorf("milter: body: too big body chunk: %d > %d", len(chunk), s.maxBodySize))
+		if s.autoChunking {
+			return s.splitBodyChunk(chunk)
+		}
+		return nil, s.errorOut(&TooLargeError{Command: "body", Size: len(chunk), Max: int(s.maxBodySize)})
 	}
 
-	if err := s.writePacket(&wire.Message{
+	msg := &wire.Message{
 		Code: wire.CodeBody,
 		Data: chunk,
-	}); err != nil {
+	}
+	if pipelined, err := s.pipeline(msg, OptNoBodyReply); pipelined || err != nil {
+		if err != nil {
+			return nil, s.errorOut(fmt.Errorf("milter: body chunk: %w", err))
+		}
+		return &Action{Type: ActionContinue}, nil
+	}
+
+	if err := s.writePacket(msg); err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: body chunk: %w", err))
 	}
 
@@ -857,7 +1622,7 @@ func (s *ClientSession) BodyChunk(chunk []byte) (*Action, error) {
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(s.ProtocolOption(OptSkip))
+	act, err := s.readAction(s.ProtocolOption(OptSkip), s.stageTimeouts.BodyChunk)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: body chunk: %w", err))
 	}
@@ -876,17 +1641,58 @@ func (s *ClientSession) BodyChunk(chunk []byte) (*Action, error) {
 // You may first call BodyChunk and then call BodyReadFrom but after BodyReadFrom the End method gets
 // called automatically.
 func (s *ClientSession) BodyReadFrom(r io.Reader) ([]ModifyAction, *Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, nil, err
+	}
+	defer s.leave()
+	return s.timedEnd(wire.CodeEOB, time.Now())(s.failSafeEnd(s.doBodyReadFrom(context.Background(), r, nil)))
+}
+
+// BodyProgressFunc is the signature of the progress callback of [ClientSession.BodyReadFromContext].
+// bytesSent and chunksSent are the amount of body data that was sent to the milter so far.
+type BodyProgressFunc func(bytesSent int64, chunksSent int)
+
+// BodyReadFromContext is like [ClientSession.BodyReadFrom] but the transfer of the body can be canceled with ctx,
+// and progress gets called after each body chunk that was sent to the milter. progress can be nil.
+//
+// When ctx gets canceled before the whole body was sent, BodyReadFromContext aborts the current message (see [ClientSession.Abort])
+// and returns the error of ctx. The session can then be used for the next message.
+// ctx does not interrupt a milter that is busy with a body chunk or the end of the message, use [WithReadTimeout] or
+// [WithStageTimeouts] for that.
+func (s *ClientSession) BodyReadFromContext(ctx context.Context, r io.Reader, progress BodyProgressFunc) ([]ModifyAction, *Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, nil, err
+	}
+	defer s.leave()
+	return s.timedEnd(wire.CodeEOB, time.Now())(s.failSafeEnd(s.doBodyReadFrom(ctx, r, progress)))
+}
+
+func (s *ClientSession) doBodyReadFrom(ctx context.Context, r io.Reader, progress BodyProgressFunc) ([]ModifyAction, *Action, error) {
 	if s.state < clientStateHeaderEndCalled || s.state > clientStateBodyChunkCalled {
-		return nil, nil, s.errorOut(fmt.Errorf("milter: body: in wrong state %d", s.state))
+		return nil, nil, s.stateError("body")
 	}
 	if !s.ProtocolOption(OptNoBody) && !s.skip {
 		scanner := milterutil.GetFixedBufferScanner(s.maxBodySize, r)
 		defer scanner.Close()
+		var bytesSent int64
+		var chunksSent int
 		for scanner.Scan() {
-			act, err := s.BodyChunk(scanner.Bytes())
+			if err := ctx.Err(); err != nil {
+				if abortErr := s.doAbort(nil); abortErr != nil {
+					return nil, nil, abortErr
+				}
+				return nil, nil, err
+			}
+			chunk := scanner.Bytes()
+			act, err := s.doBodyChunk(chunk)
 			if err != nil {
 				return nil, nil, err
 			}
+			bytesSent += int64(len(chunk))
+			chunksSent++
+			if progress != nil {
+				progress(bytesSent, chunksSent)
+			}
 			if s.skip {
 				break
 			}
@@ -904,7 +1710,33 @@ func (s *ClientSession) BodyReadFrom(r io.Reader) ([]ModifyAction, *Action, erro
 		s.state = clientStateBodyChunkCalled
 	}
 
-	return s.End()
+	if err := ctx.Err(); err != nil {
+		if abortErr := s.doAbort(nil); abortErr != nil {
+			return nil, nil, abortErr
+		}
+		return nil, nil, err
+	}
+	return s.doEnd()
+}
+
+// SkipStats counts the events that a [ClientSession] did not send to the milter.
+// The counters are the totals of all messages of the session.
+type SkipStats struct {
+	// SkippedRcpts, SkippedHeaders and SkippedBodyChunks count the events that did not get sent
+	// because the milter replied with a skip action.
+	SkippedRcpts, SkippedHeaders, SkippedBodyChunks int
+	// OptOutRcpts, OptOutHeaders and OptOutBodyChunks count the events that did not get sent
+	// because the milter negotiated OptNoRcptTo, OptNoHeaders or OptNoBody.
+	OptOutRcpts, OptOutHeaders, OptOutBodyChunks int
+}
+
+// SkipStats returns how many recipients, header fields and body chunks this session did not send to the milter.
+// You can use this to verify that your negotiation settings actually reduce the traffic to the milter.
+//
+// [ClientSession.BodyReadFrom] stops reading the body when the milter does not want it,
+// the body chunks it did not read are not counted.
+func (s *ClientSession) SkipStats() SkipStats {
+	return s.skipStats
 }
 
 // Skip can be used after a BodyChunk, HeaderField or Rcpt call to check if the milter indicated to not need any more
@@ -914,31 +1746,69 @@ func (s *ClientSession) Skip() bool {
 	return s.skip
 }
 
-func (s *ClientSession) readModifyActs() (modifyActs []ModifyAction, act *Action, err error) {
+// skipUnknownReply returns true when msg has a reply code this library does not know and
+// [WithLenientReplies] allows to skip it.
+func (s *ClientSession) skipUnknownReply(msg *wire.Message) bool {
+	if s.unknownReplies >= s.maxUnknownReplies || isKnownReplyCode(msg.Code) {
+		return false
+	}
+	s.unknownReplies++
+	s.logWarning("skipping milter reply with unknown code %q (%d of %d)", msg.Code, s.unknownReplies, s.maxUnknownReplies)
+	return true
+}
+
+func isKnownReplyCode(code wire.Code) bool {
+	switch wire.ActionCode(code) {
+	case wire.ActAccept, wire.ActContinue, wire.ActDiscard, wire.ActReject, wire.ActTempFail, wire.ActReplyCode, wire.ActSkip, wire.ActProgress:
+		return true
+	}
+	switch wire.ModifyActCode(code) {
+	case wire.ActAddRcpt, wire.ActDelRcpt, wire.ActReplBody, wire.ActAddHeader, wire.ActChangeHeader, wire.ActInsertHeader,
+		wire.ActQuarantine, wire.ActChangeFrom, wire.ActAddRcptPar:
+		return true
+	}
+	return false
+}
+
+func (s *ClientSession) readModifyActs(fn func(ModifyAction) error) (act *Action, err error) {
+	msg := &s.msg
 	for {
-		msg, err := wire.ReadPacket(s.conn, s.readTimeout)
-		if err != nil {
-			return nil, nil, fmt.Errorf("action read: %w", err)
+		if err := wire.ReadPacketInto(s.conn, msg, s.readTimeoutOr(s.stageTimeouts.EndOfMessage)); err != nil {
+			return nil, fmt.Errorf("action read: %w", err)
 		}
 		if msg.Code == wire.Code(wire.ActProgress) /* progress */ {
 			continue
 		}
+		if handled, err := s.handleExtensionReply(msg); err != nil {
+			return nil, s.errorOut(err)
+		} else if handled {
+			continue
+		}
+		if s.skipUnknownReply(msg) {
+			continue
+		}
 
 		switch wire.ModifyActCode(msg.Code) {
 		case wire.ActAddRcpt, wire.ActDelRcpt, wire.ActReplBody, wire.ActChangeHeader, wire.ActInsertHeader,
 			wire.ActAddHeader, wire.ActChangeFrom, wire.ActQuarantine, wire.ActAddRcptPar:
 			modifyAct, err := parseModifyAct(msg)
 			if err != nil {
-				return nil, nil, err
+				return nil, &ActionParseError{Code: byte(msg.Code), Err: err}
+			}
+			if modifyAct.Type == ActionReplaceBody {
+				// modifyAct.Body points into msg.Data, do not overwrite it with the next packet
+				msg.Data = nil
+			}
+			if err := fn(*modifyAct); err != nil {
+				return nil, err
 			}
-			modifyActs = append(modifyActs, *modifyAct)
 		default:
 			act, err = parseAction(msg)
 			if err != nil {
-				return nil, nil, err
+				return nil, &ActionParseError{Code: byte(msg.Code), Err: err}
 			}
 
-			return modifyActs, act, nil
+			return act, nil
 		}
 	}
 }
@@ -946,32 +1816,74 @@ func (s *ClientSession) readModifyActs() (modifyActs []ModifyAction, act *Action
 // End sends the EOB message and resets session back to the state before Mail
 // call. The same ClientSession can be used to check another message arrived
 // within the same SMTP connection (Helo and Conn information is preserved).
+// For a message with an empty body you can call End directly after HeaderEnd.
 //
 // Close should be called to conclude session.
 func (s *ClientSession) End() ([]ModifyAction, *Action, error) {
-	if s.state != clientStateBodyChunkCalled {
-		return nil, nil, s.errorOut(fmt.Errorf("milter: end: in wrong state %d", s.state))
+	if err := s.enter(); err != nil {
+		return nil, nil, err
+	}
+	defer s.leave()
+	return s.timedEnd(wire.CodeEOB, time.Now())(s.failSafeEnd(s.doEnd()))
+}
+
+func (s *ClientSession) doEnd() ([]ModifyAction, *Action, error) {
+	var modifyActs []ModifyAction
+	act, err := s.doEndStream(func(modifyAct ModifyAction) error {
+		modifyActs = append(modifyActs, modifyAct)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return modifyActs, act, nil
+}
+
+// EndStream is like End but it calls fn for each [ModifyAction] as soon as it was received from the milter.
+// Use this instead of End when the milter might send big amounts of modifications (e.g. body replacements)
+// that you do not want to hold in memory all at once.
+//
+// When fn returns an error, EndStream closes the connection to the milter and returns an error wrapping the error of fn.
+func (s *ClientSession) EndStream(fn func(ModifyAction) error) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeEOB, time.Now())(s.failSafe(s.doEndStream(fn)))
+}
+
+func (s *ClientSession) doEndStream(fn func(ModifyAction) error) (*Action, error) {
+	// a message with an empty body has no body chunks
+	if s.state < clientStateHeaderEndCalled || s.state > clientStateBodyChunkCalled {
+		return nil, s.stateError("end")
+	}
+	s.reconnect.forgetMessage()
+	if err := s.body.finish(); err != nil {
+		return nil, err
+	}
+	if err := s.flushPipelined(); err != nil {
+		return nil, s.errorOut(fmt.Errorf("milter: end: %w", err))
 	}
 	s.state = clientStateHeloCalled
 	s.skip = false
 	s.skipUnknown = false
 	if len(s.macrosByStages) > int(StageEOM) && len(s.macrosByStages[StageEOM]) > 0 {
-		if err := s.sendMacros(wire.CodeEOB, s.macrosByStages[StageEOM]); err != nil {
-			return nil, nil, s.errorOut(err)
+		if err := s.sendMacros(wire.CodeEOB, s.macrosByStages[StageEOM], nil); err != nil {
+			return nil, s.errorOut(err)
 		}
 	}
 	if err := s.writePacket(&wire.Message{
 		Code: wire.CodeEOB,
 	}); err != nil {
-		return nil, nil, s.errorOut(fmt.Errorf("milter: end: %w", err))
+		return nil, s.errorOut(fmt.Errorf("milter: end: %w", err))
 	}
 
-	modifyActs, act, err := s.readModifyActs()
+	act, err := s.readModifyActs(fn)
 	if err != nil {
-		return nil, nil, s.errorOut(fmt.Errorf("milter: end: %w", err))
+		return nil, s.errorOut(fmt.Errorf("milter: end: %w", err))
 	}
 
-	return modifyActs, act, nil
+	return act, nil
 }
 
 // Unknown sends an unknown command to the milter. This can happen at any time in the connection.
@@ -979,8 +1891,16 @@ func (s *ClientSession) End() ([]ModifyAction, *Action, error) {
 //
 // You can send macros to the milter with macros. They only get send to the milter when it wants unknown commands.
 func (s *ClientSession) Unknown(cmd string, macros map[MacroName]string) (*Action, error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.leave()
+	return s.timed(wire.CodeUnknown, time.Now())(s.failSafe(s.doUnknown(cmd, macros)))
+}
+
+func (s *ClientSession) doUnknown(cmd string, macros map[MacroName]string) (*Action, error) {
 	if s.state < clientStateNegotiated || s.state == clientStateError {
-		return nil, s.errorOut(fmt.Errorf("milter: unknown: in wrong state %d", s.state))
+		return nil, s.stateError("unknown")
 	}
 
 	if s.ProtocolOption(OptNoUnknown) || s.skipUnknown {
@@ -1004,7 +1924,7 @@ func (s *ClientSession) Unknown(cmd string, macros map[MacroName]string) (*Actio
 		return &Action{Type: ActionContinue}, nil
 	}
 
-	act, err := s.readAction(false)
+	act, err := s.readAction(false, s.stageTimeouts.Unknown)
 	if err != nil {
 		return nil, s.errorOut(fmt.Errorf("milter: unknown: %w", err))
 	}
@@ -1018,9 +1938,18 @@ func (s *ClientSession) Unknown(cmd string, macros map[MacroName]string) (*Actio
 //
 // You can send macros to the milter with macros. They only get send to the milter when it wants unknown commands.
 func (s *ClientSession) Abort(macros map[MacroName]string) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+	return s.timedErr(wire.CodeAbort, time.Now())(s.failSafeErr(s.doAbort(macros)))
+}
+
+func (s *ClientSession) doAbort(macros map[MacroName]string) error {
 	if s.state == clientStateError || s.state < clientStateHeloCalled {
-		return s.errorOut(fmt.Errorf("milter: abort: in wrong state %d", s.state))
+		return s.stateError("abort")
 	}
+	s.reconnect.forgetMessage()
 	s.state = clientStateHeloCalled
 	s.skip = false
 	s.skipUnknown = false
@@ -1041,10 +1970,19 @@ func (s *ClientSession) Abort(macros map[MacroName]string) error {
 // You can use this to do connection pooling - but that could be quite flaky
 // since not all milters can handle CodeQuitNewConn
 // sendmail or postfix do not use CodeQuitNewConn and never re-use a connection.
-// Existing milters might not expect the MTA to use this feature.
+// Existing milters might not expect the MTA to use this feature. A go-milter [Server] supports it (see [ResettableMilter]).
+// Use [WithKeepalive], [WithIdleTimeout] and [ClientSession.Check] to manage pooled sessions.
 func (s *ClientSession) Reset(macros Macros) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+	return s.failSafeErr(s.doReset(macros))
+}
+
+func (s *ClientSession) doReset(macros Macros) error {
 	if s.state == clientStateError || s.state == clientStateClosed {
-		return s.errorOut(fmt.Errorf("milter: reset: in wrong state %d", s.state))
+		return s.stateError("reset")
 	}
 	s.state = clientStateNegotiated
 	s.skip = false
@@ -1055,6 +1993,9 @@ func (s *ClientSession) Reset(macros Macros) error {
 		return s.errorOut(err)
 	}
 	s.macros = macros
+	s.smtpConn = nil
+	s.overridden = nil
+	s.reconnect.forgetConnection()
 	return nil
 }
 
@@ -1063,7 +2004,28 @@ func (s *ClientSession) Reset(macros Macros) error {
 // If there is a milter sequence in progress the CodeQuit command is called to signal closure to the milter.
 //
 // You can call Close at any time in the session, and you can call Close multiple times without harm.
+// When another method of s is still running in a different goroutine (e.g. because the milter hangs),
+// Close closes the connection to the milter without sending CodeQuit and the other method fails.
 func (s *ClientSession) Close() error {
+	if err := s.enter(); err != nil {
+		return s.interrupt()
+	}
+	defer s.leave()
+	return s.doClose()
+}
+
+// interrupt closes the connection of the busy session s, so that the running method fails.
+func (s *ClientSession) interrupt() error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.interrupted {
+		return nil
+	}
+	s.interrupted = true
+	return s.conn.Close()
+}
+
+func (s *ClientSession) doClose() error {
 	if s.state == clientStateClosed || s.state == clientStateError {
 		return s.closedErr
 	}
@@ -0,0 +1,231 @@
+package milter
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrAsyncSessionClosed is returned by the [ActionFuture] of an [AsyncClientSession] call that was made after
+// [AsyncClientSession.Close] was called.
+var ErrAsyncSessionClosed = errors.New("milter: async session closed")
+
+// ActionFuture is the result of a call of an [AsyncClientSession] method.
+// It resolves when the command was sent to the milter and the milter replied.
+type ActionFuture struct {
+	done       chan struct{}
+	modifyActs []ModifyAction
+	act        *Action
+	err        error
+}
+
+func newActionFuture() *ActionFuture {
+	return &ActionFuture{done: make(chan struct{})}
+}
+
+func (f *ActionFuture) resolve(modifyActs []ModifyAction, act *Action, err error) {
+	f.modifyActs, f.act, f.err = modifyActs, act, err
+	close(f.done)
+}
+
+// Done returns a channel that gets closed when f is resolved.
+func (f *ActionFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for f to resolve and returns the result of the [ClientSession] method.
+// Methods like [ClientSession.Abort] that do not return an [Action] resolve to a nil [Action].
+func (f *ActionFuture) Wait() (*Action, error) {
+	<-f.done
+	return f.act, f.err
+}
+
+// ModifyActions waits for f to resolve and returns the modification actions of an [AsyncClientSession.End] call.
+// For all other calls it returns nil.
+func (f *ActionFuture) ModifyActions() []ModifyAction {
+	<-f.done
+	return f.modifyActs
+}
+
+// AsyncClientSession is an asynchronous variant of [ClientSession].
+// Its methods do not wait for the milter. They queue the command and return an [ActionFuture]
+// that resolves when the milter replied. The commands get sent to the milter in the order of the calls.
+// This lets your MTA do its own SMTP processing while the milter is working.
+//
+// The methods of AsyncClientSession are safe for concurrent use, but the order of the commands
+// of concurrent calls is undefined. When a command fails or the milter rejects the SMTP transaction
+// the following commands still get executed and most likely fail with a state error.
+// You should therefore wait for the [ActionFuture] of commands whose result influences what you send next.
+//
+// The commands read the [Macros] of the session when they get executed, not when you call the method.
+// Do not change macro values for commands that are still queued.
+//
+// You need to call [AsyncClientSession.Close] to close the connection to the milter and to free the resources of the AsyncClientSession.
+type AsyncClientSession struct {
+	session *ClientSession
+	mu      sync.Mutex
+	closed  bool
+	// calls are the queued calls, they get executed one after the other by run
+	calls []func()
+	// wake gets signaled when calls got queued or a got closed
+	wake chan struct{}
+}
+
+// Async returns an [AsyncClientSession] for s. After calling Async you must only use the returned
+// [AsyncClientSession] and not s directly.
+func (s *ClientSession) Async() *AsyncClientSession {
+	a := &AsyncClientSession{
+		session: s,
+		wake:    make(chan struct{}, 1),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncClientSession) run() {
+	for {
+		a.mu.Lock()
+		if len(a.calls) == 0 {
+			closed := a.closed
+			a.mu.Unlock()
+			if closed {
+				return
+			}
+			<-a.wake
+			continue
+		}
+		call := a.calls[0]
+		a.calls[0] = nil
+		a.calls = a.calls[1:]
+		a.mu.Unlock()
+		call()
+	}
+}
+
+// signal wakes up run.
+func (a *AsyncClientSession) signal() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue queues fn. It resolves the returned future with the result of fn.
+// The queue has no size limit, so enqueue never blocks.
+func (a *AsyncClientSession) enqueue(fn func() ([]ModifyAction, *Action, error)) *ActionFuture {
+	f := newActionFuture()
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		f.resolve(nil, nil, ErrAsyncSessionClosed)
+		return f
+	}
+	a.calls = append(a.calls, func() {
+		f.resolve(fn())
+	})
+	a.mu.Unlock()
+	a.signal()
+	return f
+}
+
+func (a *AsyncClientSession) enqueueAction(fn func() (*Action, error)) *ActionFuture {
+	return a.enqueue(func() ([]ModifyAction, *Action, error) {
+		act, err := fn()
+		return nil, act, err
+	})
+}
+
+func (a *AsyncClientSession) enqueueError(fn func() error) *ActionFuture {
+	return a.enqueue(func() ([]ModifyAction, *Action, error) {
+		return nil, nil, fn()
+	})
+}
+
+// Conn queues a [ClientSession.Conn] call.
+func (a *AsyncClientSession) Conn(hostname string, family ProtoFamily, port uint16, addr string) *ActionFuture {
+	return a.enqueueAction(func() (*Action, error) {
+		return a.session.Conn(hostname, family, port, addr)
+	})
+}
+
+// Helo queues a [ClientSession.Helo] call.
+func (a *AsyncClientSession) Helo(helo string) *ActionFuture {
+	return a.enqueueAction(func() (*Action, error) {
+		return a.session.Helo(helo)
+	})
+}
+
+// Mail queues a [ClientSession.Mail] call.
+func (a *AsyncClientSession) Mail(sender string, esmtpArgs string) *ActionFuture {
+	return a.enqueueAction(func() (*Action, error) {
+		return a.session.Mail(sender, esmtpArgs)
+	})
+}
+
+// Rcpt queues a [ClientSession.Rcpt] call.
+func (a *AsyncClientSession) Rcpt(rcpt string, esmtpArgs string) *ActionFuture {
+	return a.enqueueAction(func() (*Action, error) {
+		return a.session.Rcpt(rcpt, esmtpArgs)
+	})
+}
+
+// DataStart queues a [ClientSession.DataStart] call.
+func (a *AsyncClientSession) DataStart() *ActionFuture {
+	return a.enqueueAction(a.session.DataStart)
+}
+
+// HeaderField queues a [ClientSession.HeaderField] call.
+func (a *AsyncClientSession) HeaderField(key, value string, macros map[MacroName]string) *ActionFuture {
+	return a.enqueueAction(func() (*Action, error) {
+		return a.session.HeaderField(key, value, macros)
+	})
+}
+
+// HeaderEnd queues a [ClientSession.HeaderEnd] call.
+func (a *AsyncClientSession) HeaderEnd() *ActionFuture {
+	return a.enqueueAction(a.session.HeaderEnd)
+}
+
+// BodyChunk queues a [ClientSession.BodyChunk] call.
+// chunk gets copied, you can re-use it after BodyChunk returned.
+func (a *AsyncClientSession) BodyChunk(chunk []byte) *ActionFuture {
+	chunk = append([]byte(nil), chunk...)
+	return a.enqueueAction(func() (*Action, error) {
+		return a.session.BodyChunk(chunk)
+	})
+}
+
+// End queues a [ClientSession.End] call. Use [ActionFuture.ModifyActions] to get the modification actions.
+func (a *AsyncClientSession) End() *ActionFuture {
+	return a.enqueue(a.session.End)
+}
+
+// Unknown queues a [ClientSession.Unknown] call.
+func (a *AsyncClientSession) Unknown(cmd string, macros map[MacroName]string) *ActionFuture {
+	return a.enqueueAction(func() (*Action, error) {
+		return a.session.Unknown(cmd, macros)
+	})
+}
+
+// Abort queues a [ClientSession.Abort] call.
+func (a *AsyncClientSession) Abort(macros map[MacroName]string) *ActionFuture {
+	return a.enqueueError(func() error {
+		return a.session.Abort(macros)
+	})
+}
+
+// Reset queues a [ClientSession.Reset] call.
+func (a *AsyncClientSession) Reset(macros Macros) *ActionFuture {
+	return a.enqueueError(func() error {
+		return a.session.Reset(macros)
+	})
+}
+
+// Close queues a [ClientSession.Close] call. All calls after Close resolve with [ErrAsyncSessionClosed].
+func (a *AsyncClientSession) Close() *ActionFuture {
+	f := a.enqueueError(a.session.Close)
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+	a.signal()
+	return f
+}
@@ -0,0 +1,131 @@
+package milter
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sentinel errors of [ClientSession]. Use [errors.Is] to check for them.
+// Errors that are none of these (and not [ErrConcurrentUse] or [ErrTooManySessions]) are I/O errors of the connection to the milter.
+var (
+	// ErrWrongState is the sentinel of [StateError].
+	ErrWrongState = errors.New("milter: wrong state")
+	// ErrNegotiationFailed is the sentinel of [NegotiationError].
+	ErrNegotiationFailed = errors.New("milter: negotiation failed")
+	// ErrActionParse is the sentinel of [ActionParseError].
+	ErrActionParse = errors.New("milter: cannot parse milter reply")
+	// ErrTooLarge is the sentinel of [TooLargeError].
+	ErrTooLarge = errors.New("milter: data too large")
+)
+
+// StateError is returned when a [ClientSession] method gets called in a state where it is not allowed.
+// E.g. when you call [ClientSession.Rcpt] before [ClientSession.Mail], or when you use a session after an error.
+type StateError struct {
+	// Command is the command that was called, e.g. "rcpt".
+	Command string
+	// State is the state the session was in, e.g. "negotiated" or "error".
+	State string
+}
+
+func (e *StateError) Error() string {
+	return fmt.Sprintf("milter: %s: in wrong state %q", e.Command, e.State)
+}
+
+func (e *StateError) Is(target error) bool {
+	return target == ErrWrongState
+}
+
+// NegotiationError is returned when the milter requested a protocol version, actions or protocol options that the [Client] does not support.
+type NegotiationError struct {
+	// Version is the protocol version the milter sent.
+	Version uint32
+	// UnsupportedActions are the actions the milter requested but the [Client] did not offer.
+	UnsupportedActions OptAction
+	// UnsupportedProtocol are the protocol options the milter requested but the [Client] did not offer.
+	UnsupportedProtocol OptProtocol
+	// Reason describes the problem.
+	Reason string
+}
+
+func (e *NegotiationError) Error() string {
+	return fmt.Sprintf("milter: negotiate: %s", e.Reason)
+}
+
+func (e *NegotiationError) Is(target error) bool {
+	return target == ErrNegotiationFailed
+}
+
+// ActionParseError is returned when the milter sent a reply that the [ClientSession] cannot understand
+// or that is not allowed at this point of the protocol.
+type ActionParseError struct {
+	// Code is the code of the reply packet.
+	Code byte
+	// Err is the parsing error.
+	Err error
+}
+
+func (e *ActionParseError) Error() string {
+	return fmt.Sprintf("milter: reply %q: %s", e.Code, e.Err)
+}
+
+func (e *ActionParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ActionParseError) Is(target error) bool {
+	return target == ErrActionParse
+}
+
+// TooLargeError is returned when data is bigger than the negotiated maximum data size.
+type TooLargeError struct {
+	// Command is the command that got called, e.g. "body".
+	Command string
+	// Size is the size of the data.
+	Size int
+	// Max is the maximum size.
+	Max int
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("milter: %s: too big: %d > %d", e.Command, e.Size, e.Max)
+}
+
+func (e *TooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// String returns a human-readable name of the state.
+func (s clientSessionState) String() string {
+	switch s {
+	case clientStateClosed:
+		return "closed"
+	case clientStateNegotiated:
+		return "negotiated"
+	case clientStateConnectCalled:
+		return "connect"
+	case clientStateHeloCalled:
+		return "helo"
+	case clientStateMailCalled:
+		return "mail"
+	case clientStateRcptCalled:
+		return "rcpt"
+	case clientStateDataCalled:
+		return "data"
+	case clientStateHeaderFieldCalled:
+		return "header"
+	case clientStateHeaderEndCalled:
+		return "header end"
+	case clientStateBodyChunkCalled:
+		return "body"
+	case clientStateError:
+		return "error"
+	default:
+		return fmt.Sprintf("%d", uint32(s))
+	}
+}
+
+// stateError puts s in the error state and returns a [StateError] for command.
+func (s *ClientSession) stateError(command string) error {
+	err := &StateError{Command: command, State: s.state.String()}
+	return s.errorOut(err)
+}
@@ -0,0 +1,154 @@
+package milter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientGroup holds several [Client] objects for the same logical milter.
+// It distributes [ClientGroup.Session] calls round-robin between its clients.
+// When a client cannot open a session, the next client is tried.
+// After a number of consecutive failures a client is not used for a certain time (circuit breaking).
+//
+// A ClientGroup is safe for concurrent use by multiple goroutines.
+type ClientGroup struct {
+	members   []*groupMember
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu   sync.Mutex
+	next int
+}
+
+type groupMember struct {
+	client    *Client
+	failures  int
+	openUntil time.Time
+}
+
+// ClientGroupOption can be used to configure a [ClientGroup].
+type ClientGroupOption func(*ClientGroup)
+
+// WithFailureThreshold sets the number of consecutive failures after which a [Client] of a [ClientGroup] does not get used.
+// The default is 3.
+func WithFailureThreshold(failures int) ClientGroupOption {
+	return func(g *ClientGroup) {
+		g.threshold = failures
+	}
+}
+
+// WithCooldown sets the duration a [Client] of a [ClientGroup] does not get used after it reached the failure threshold.
+// The default is 30 seconds.
+func WithCooldown(cooldown time.Duration) ClientGroupOption {
+	return func(g *ClientGroup) {
+		g.cooldown = cooldown
+	}
+}
+
+// NewClientGroup creates a new [ClientGroup] for clients.
+//
+// This function will panic when you do not provide any clients.
+func NewClientGroup(clients []*Client, opts ...ClientGroupOption) *ClientGroup {
+	if len(clients) == 0 {
+		panic("milter: you need at least one client in NewClientGroup call")
+	}
+	g := &ClientGroup{
+		threshold: 3,
+		cooldown:  30 * time.Second,
+		now:       time.Now,
+	}
+	for _, c := range clients {
+		g.members = append(g.members, &groupMember{client: c})
+	}
+	for _, o := range opts {
+		if o != nil {
+			o(g)
+		}
+	}
+	if g.threshold < 1 {
+		g.threshold = 1
+	}
+	return g
+}
+
+// String returns the network and address of all clients of this group.
+// This method is go-routine save.
+func (g *ClientGroup) String() string {
+	names := make([]string, len(g.members))
+	for i, m := range g.members {
+		names[i] = m.client.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// pick returns the order in which the clients should be tried. Clients with an open circuit come last.
+func (g *ClientGroup) pick() []*groupMember {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	start := g.next
+	g.next = (g.next + 1) % len(g.members)
+	available := make([]*groupMember, 0, len(g.members))
+	var open []*groupMember
+	for i := range g.members {
+		m := g.members[(start+i)%len(g.members)]
+		if m.openUntil.After(now) {
+			open = append(open, m)
+		} else {
+			available = append(available, m)
+		}
+	}
+	return append(available, open...)
+}
+
+func (g *ClientGroup) record(m *groupMember, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err == nil {
+		m.failures = 0
+		m.openUntil = time.Time{}
+		return
+	}
+	m.failures++
+	if m.failures >= g.threshold {
+		m.openUntil = g.now().Add(g.cooldown)
+	}
+}
+
+// Session opens a new connection to one of the clients of this group. See [Client.Session].
+//
+// The clients get used round-robin. When a client fails to open a session the next client gets tried.
+// Clients that failed too often are only used when all other clients failed.
+//
+// This method is go-routine save.
+func (g *ClientGroup) Session(macros Macros) (*ClientSession, error) {
+	var lastErr error
+	for _, m := range g.pick() {
+		s, err := m.client.Session(macros)
+		g.record(m, err)
+		if err == nil {
+			return s, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("milter: client group: all clients failed: %w", lastErr)
+}
+
+// Available returns the number of clients in this group that are currently not circuit broken.
+//
+// This method is go-routine save.
+func (g *ClientGroup) Available() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	n := 0
+	for _, m := range g.members {
+		if !m.openUntil.After(now) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package milter
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientGroup(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return NoOpMilter{} })}, nil)
+	defer w.Cleanup()
+
+	// get an address where nobody is listening
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().String()
+	_ = dead.Close()
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	g := NewClientGroup([]*Client{
+		NewClient("tcp", deadAddr),
+		NewClient("tcp", w.local.Addr().String()),
+	}, WithFailureThreshold(2), WithCooldown(time.Minute))
+	g.now = func() time.Time { return now }
+
+	if got := g.String(); !strings.Contains(got, deadAddr) || !strings.Contains(got, w.local.Addr().String()) {
+		t.Errorf("String() = %q", got)
+	}
+	for i := 0; i < 4; i++ {
+		s, err := g.Session(nil)
+		if err != nil {
+			t.Fatalf("Session() #%d: %v", i, err)
+		}
+		_ = s.Close()
+	}
+	if got := g.Available(); got != 1 {
+		t.Fatalf("Available() = %d, want 1", got)
+	}
+	if f := g.members[0].failures; f != 2 {
+		t.Fatalf("failures = %d, want 2 (circuit should be open)", f)
+	}
+	now = now.Add(2 * time.Minute)
+	if got := g.Available(); got != 2 {
+		t.Fatalf("Available() = %d, want 2", got)
+	}
+
+	all := NewClientGroup([]*Client{NewClient("tcp", deadAddr)})
+	if _, err := all.Session(nil); err == nil || !strings.Contains(err.Error(), "all clients failed") {
+		t.Fatalf("Session() err = %v", err)
+	}
+}
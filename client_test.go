@@ -2,13 +2,16 @@ package milter
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	nettextproto "net/textproto"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -452,8 +455,12 @@ func TestMilterClient_NoWorking(t *testing.T) {
 	defer w.Cleanup()
 
 	_, err := w.session.Mail("from@example.org", "A=B")
-	if err == nil || err.Error() != "milter: in wrong state 1" {
-		t.Fatal("expected error")
+	var stateErr *StateError
+	if !errors.As(err, &stateErr) || !errors.Is(err, ErrWrongState) {
+		t.Fatalf("expected state error, got %v", err)
+	}
+	if stateErr.Command != "mail" || stateErr.State != "negotiated" {
+		t.Fatalf("unexpected state error %+v", stateErr)
 	}
 	w.local.Close()
 
@@ -495,17 +502,19 @@ func TestMilterClient_BogusServerNegotiation(t *testing.T) {
 		opts        []Option
 		negResponse []byte
 		onlyWarning bool
+		// negotiationErr is true when the error should be a NegotiationError and not an I/O error
+		negotiationErr bool
 	}{
-		{"not even full packet", []Option{WithReadTimeout(time.Second)}, []byte{0}, false},
-		{"wrong response code", nil, []byte{0, 0, 0, 1, 'a'}, false},
-		{"too few bytes", nil, []byte{0, 0, 0, 2, byte(wire.CodeOptNeg), 0}, false},
-		{"milter version 0", nil, []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, false},
-		{"milter version 1", nil, []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}, false},
-		{"wrong actions", nil, []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 2, 255, 255, 255, 255, 0, 0, 0, 0}, false},
-		{"wrong protocol", nil, []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 2, 0, 0, 0, 0, 255, 255, 255, 255}, false},
-		{"wrong milter stage", nil, []byte{0, 0, 0, 18, byte(wire.CodeOptNeg), 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 255, 255, 255, 255, 0}, true},
-		{"wrong milter list", nil, []byte{0, 0, 0, 18, byte(wire.CodeOptNeg), 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'a'}, true},
-		{"repeated milter stage", nil, []byte{0, 0, 0, 25, byte(wire.CodeOptNeg), 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'a', 0, 0, 0, 0, 0, 'a', 0}, true},
+		{"not even full packet", []Option{WithReadTimeout(time.Second)}, []byte{0}, false, false},
+		{"wrong response code", nil, []byte{0, 0, 0, 1, 'a'}, false, true},
+		{"too few bytes", nil, []byte{0, 0, 0, 2, byte(wire.CodeOptNeg), 0}, false, true},
+		{"milter version 0", nil, []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, false, true},
+		{"milter version 1", nil, []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}, false, true},
+		{"wrong actions", nil, []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 2, 255, 255, 255, 255, 0, 0, 0, 0}, false, true},
+		{"wrong protocol", nil, []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 2, 0, 0, 0, 0, 255, 255, 255, 255}, false, true},
+		{"wrong milter stage", nil, []byte{0, 0, 0, 18, byte(wire.CodeOptNeg), 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 255, 255, 255, 255, 0}, true, false},
+		{"wrong milter list", nil, []byte{0, 0, 0, 18, byte(wire.CodeOptNeg), 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'a'}, true, false},
+		{"repeated milter stage", nil, []byte{0, 0, 0, 25, byte(wire.CodeOptNeg), 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'a', 0, 0, 0, 0, 0, 'a', 0}, true, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -558,6 +567,9 @@ func TestMilterClient_BogusServerNegotiation(t *testing.T) {
 					session.Close()
 					t.Fatalf("expected error in negotiation but it succeeded with server response %x", ltt.negResponse)
 				}
+				if errors.Is(err, ErrNegotiationFailed) != ltt.negotiationErr {
+					t.Fatalf("errors.Is(%v, ErrNegotiationFailed) != %v", err, ltt.negotiationErr)
+				}
 			}
 
 			if err := <-sErrChan; err != nil {
@@ -587,6 +599,8 @@ func TestMilterClient_Negotiation(t *testing.T) {
 		{"offered 256K not accepted", []Option{WithActions(AllClientSupportedActionMasks), WithOfferedMaxData(DataSize256K)}, MaxClientProtocolVersion, OptAddHeader, 0, MaxClientProtocolVersion, OptAddHeader, 0, DataSize64K},
 		{"offered 1MB accepted", []Option{WithActions(AllClientSupportedActionMasks), WithOfferedMaxData(DataSize1M)}, MaxClientProtocolVersion, OptAddHeader, OptProtocol(optMds1M), MaxClientProtocolVersion, OptAddHeader, 0, DataSize1M},
 		{"offered 256K accepted", []Option{WithActions(AllClientSupportedActionMasks), WithOfferedMaxData(DataSize256K)}, MaxClientProtocolVersion, OptAddHeader, OptProtocol(optMds256K), MaxClientProtocolVersion, OptAddHeader, 0, DataSize256K},
+		{"offered 512K accepted", []Option{WithActions(AllClientSupportedActionMasks), WithOfferedMaxData(512 * 1024)}, MaxClientProtocolVersion, OptAddHeader, OptProtocol(optMds256K), MaxClientProtocolVersion, OptAddHeader, 0, DataSize256K},
+		{"offered 4MB accepted", []Option{WithActions(AllClientSupportedActionMasks), WithOfferedMaxData(4 * 1024 * 1024)}, MaxClientProtocolVersion, OptAddHeader, OptProtocol(optMds1M), MaxClientProtocolVersion, OptAddHeader, 0, DataSize1M},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1150,11 +1164,19 @@ func TestMilterClient_WithMockServer(t *testing.T) {
 			{s1: sendRcpt, v1: expectContinue, server: responseContinue},
 			{s1: sendData, v1: expectContinue, server: responseContinue},
 			{s1: sendHeaderField, v1: expectContinue, server: responseContinue},
-			{s1: sendHeaderEnd, v1: expectContinue, server: responseContinue},
 			{s3: sendEnd, v3: func(t *testing.T, s *ClientSession, mActs []ModifyAction, act *Action, err error) {
 				expectErr1(t, s, act, err)
 			}, server: []byte{0, 0, 0, 1, byte(wire.ActAccept)}},
 		}},
+		{"End empty body", dC, ops{
+			{s1: sendConnect, v1: expectContinue, server: responseContinue},
+			{s1: sendHelo, v1: expectContinue, server: responseContinue},
+			{s1: sendMail, v1: expectContinue, server: responseContinue},
+			{s1: sendRcpt, v1: expectContinue, server: responseContinue},
+			{s1: sendData, v1: expectContinue, server: responseContinue},
+			{s1: sendHeaderEnd, v1: expectContinue, server: responseContinue},
+			{s3: sendEnd, v3: expectAcceptEmptyMods, server: []byte{0, 0, 0, 1, byte(wire.ActAccept)}},
+		}},
 		{"ActAddRcpt error detection 1", withActC(withProtC(0), OptAddRcpt), ops{
 			{s1: sendConnect, v1: expectContinue, server: responseContinue},
 			{s1: sendHelo, v1: expectContinue, server: responseContinue},
@@ -1677,3 +1699,1728 @@ func TestMilterClient_WithMockServer(t *testing.T) {
 		})
 	}
 }
+
+func TestMilterClient_Pipelining(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithProtocol(OptNoHeaderReply | OptNoBodyReply)}, []Option{WithPipelining()})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	for i := 0; i < 100; i++ {
+		act, err = w.session.HeaderField(fmt.Sprintf("X-Header-%d", i), "value", nil)
+		assertAction(t, act, err, ActionContinue)
+	}
+	if len(w.session.pipelined) == 0 {
+		t.Fatal("header fields did not get buffered")
+	}
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	if len(w.session.pipelined) != 0 {
+		t.Fatalf("%d bytes still buffered", len(w.session.pipelined))
+	}
+	if len(mm.Hdr) != 100 {
+		t.Fatalf("got %d headers", len(mm.Hdr))
+	}
+	_, act, err = w.session.BodyReadFrom(bytes.NewReader(bytes.Repeat([]byte{'A'}, int(3*DataSize64K))))
+	assertAction(t, act, err, ActionAccept)
+	if len(mm.Chunks) != 3 {
+		t.Fatalf("milter got %d body chunks", len(mm.Chunks))
+	}
+
+	// the decision of the milter gets reported by the synchronization point
+	mm.HdrsResp = RespReject
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderField("From", "<>", nil)
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionReject)
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMilterClient_PipeliningWithReplies(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		RcptResp: RespContinue,
+		DataResp: RespContinue,
+		HdrResp:  RespReject,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithPipelining()})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	// the milter wants to reply to header fields, so the session waits for the reply
+	act, err = w.session.HeaderField("From", "<>", nil)
+	assertAction(t, act, err, ActionReject)
+	if len(w.session.pipelined) != 0 {
+		t.Fatalf("%d bytes buffered", len(w.session.pipelined))
+	}
+}
+
+func TestMilterClient_StageTimeouts(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			time.Sleep(200 * time.Millisecond)
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithReadTimeout(50 * time.Millisecond), WithStageTimeouts(StageTimeouts{EndOfMessage: 5 * time.Second})})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.BodyReadFrom(strings.NewReader("body"))
+	assertAction(t, act, err, ActionAccept)
+}
+
+func TestMilterClient_EndStream(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			m.AddHeader("X-Test", "1")
+			m.ReplaceBody(strings.NewReader("new body"))
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithActions(OptAddHeader | OptChangeBody)}, nil)
+	defer w.Cleanup()
+
+	send := func() {
+		t.Helper()
+		act, err := w.session.Mail("from@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Rcpt("to@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Header(textproto.Header{})
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.BodyChunk([]byte("body"))
+		assertAction(t, act, err, ActionContinue)
+	}
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	send()
+	var got []ModifyAction
+	act, err = w.session.EndStream(func(modifyAct ModifyAction) error {
+		got = append(got, modifyAct)
+		return nil
+	})
+	assertAction(t, act, err, ActionAccept)
+	expected := []ModifyAction{
+		{Type: ActionAddHeader, HeaderName: "X-Test", HeaderValue: "1"},
+		{Type: ActionReplaceBody, Body: []byte("new body")},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("EndStream() got %+v, want %+v", got, expected)
+	}
+
+	send()
+	stop := errors.New("stop")
+	_, err = w.session.EndStream(func(modifyAct ModifyAction) error {
+		return stop
+	})
+	if !errors.Is(err, stop) {
+		t.Fatalf("EndStream() err = %v, want %v", err, stop)
+	}
+}
+
+func TestMilterClient_BodyReadFromContext(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, nil)
+	defer w.Cleanup()
+
+	send := func() {
+		t.Helper()
+		act, err := w.session.Mail("from@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Rcpt("to@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Header(textproto.Header{})
+		assertAction(t, act, err, ActionContinue)
+	}
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+
+	send()
+	var gotBytes int64
+	var gotChunks int
+	_, act, err = w.session.BodyReadFromContext(context.Background(), bytes.NewReader(make([]byte, 3*DataSize64K)), func(bytesSent int64, chunksSent int) {
+		gotBytes, gotChunks = bytesSent, chunksSent
+	})
+	assertAction(t, act, err, ActionAccept)
+	if gotBytes != 3*int64(DataSize64K) || gotChunks != 3 {
+		t.Fatalf("progress got %d bytes in %d chunks", gotBytes, gotChunks)
+	}
+
+	send()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, act, err = w.session.BodyReadFromContext(ctx, bytes.NewReader(make([]byte, 3*DataSize64K)), func(_ int64, chunksSent int) {
+		if chunksSent == 1 {
+			cancel()
+		}
+	})
+	if act != nil || err != context.Canceled {
+		t.Fatalf("BodyReadFromContext() got %+v, %v", act, err)
+	}
+
+	// session is still usable
+	send()
+	_, act, err = w.session.BodyReadFrom(bytes.NewReader([]byte("body")))
+	assertAction(t, act, err, ActionAccept)
+}
+
+func TestMilterClient_MacroAutoFill(t *testing.T) {
+	t.Parallel()
+	var clientAddr, tlsVersion string
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			clientAddr = m.Macros.Get(MacroClientAddr)
+			tlsVersion = m.Macros.Get(MacroTlsVersion)
+		},
+	}
+	macros := NewMacroBag()
+	macros.Set(MacroTlsVersion, "set explicitly")
+	w := newServerClient(t, macros, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithMacroAutoFill(), WithMacroRequest(StageConnect, []MacroName{MacroClientAddr, MacroTlsVersion})})
+	defer w.Cleanup()
+
+	smtpConn, err := net.Dial("tcp", w.local.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smtpConn.Close()
+	w.session.SetSMTPConn(smtpConn)
+	act, err := w.session.Conn("host", FamilyInet, 25565, "127.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	if clientAddr != "127.0.0.1" {
+		t.Errorf("got %s = %q, want %q", MacroClientAddr, clientAddr, "127.0.0.1")
+	}
+	if tlsVersion != "set explicitly" {
+		t.Errorf("got %s = %q, want %q", MacroTlsVersion, tlsVersion, "set explicitly")
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return NoOpMilter{}
+	}), WithActions(OptAddHeader | OptChangeHeader), WithProtocols(OptNoMailFrom)}, nil)
+	defer w.Cleanup()
+
+	result, err := w.client.Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &PingResult{Version: MaxServerProtocolVersion, Actions: OptAddHeader | OptChangeHeader, Protocol: OptNoMailFrom, MaxData: DataSize64K}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Ping() = %+v, want %+v", result, expected)
+	}
+
+	// a milter that never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewClient("tcp", ln.Addr().String()).Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Ping() err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClientSession_ConcurrentUse(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			close(started)
+			<-release
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, nil)
+	defer w.Cleanup()
+
+	done := make(chan error)
+	go func() {
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		if err == nil && act.Type != ActionContinue {
+			err = fmt.Errorf("unexpected action %+v", act)
+		}
+		done <- err
+	}()
+	<-started
+	if _, err := w.session.Helo("helo"); err != ErrConcurrentUse {
+		t.Fatalf("Helo() err = %v, want %v", err, ErrConcurrentUse)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	act, err := w.session.Helo("helo")
+	assertAction(t, act, err, ActionContinue)
+}
+
+func TestClientSession_CloseWhileBusy(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			close(started)
+			<-release
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, nil)
+	defer w.server.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		done <- err
+	}()
+	<-started
+	if err := w.session.Close(); err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+	if err := w.session.Close(); err != nil {
+		t.Fatalf("second Close() err = %v", err)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("Conn() did not fail")
+	}
+	if err := w.session.Close(); err != nil {
+		t.Fatalf("Close() after failed Conn() err = %v", err)
+	}
+}
+
+func TestClientSession_MacroRequests(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return NoOpMilter{}
+	}), WithMacroRequest(StageHelo, []MacroName{MacroTlsVersion, MacroCipher})}, nil)
+	defer w.Cleanup()
+
+	if got, want := w.session.MacroRequests(StageHelo), []MacroName{MacroTlsVersion, MacroCipher}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MacroRequests(StageHelo) = %v, want %v", got, want)
+	}
+	if got := w.session.MacroRequests(StageConnect); got != nil {
+		t.Errorf("MacroRequests(StageConnect) = %v, want nil", got)
+	}
+	if got := w.session.MacroRequests(StageEndMarker + 1); got != nil {
+		t.Errorf("MacroRequests(invalid) = %v, want nil", got)
+	}
+	got := w.session.MacroRequests(StageHelo)
+	got[0] = "changed"
+	if w.session.MacroRequests(StageHelo)[0] != MacroTlsVersion {
+		t.Error("MacroRequests did not return a copy")
+	}
+}
+
+func TestMilterClient_NegotiationTolerance(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{}
+	s := NewServer(WithMilter(func() Milter {
+		return &mm
+	}), WithActions(OptAddHeader|OptChangeHeader), WithProtocols(OptNoMailFrom), WithNegotiationCallback(func(mtaVersion, milterVersion uint32, mtaActions, milterActions OptAction, mtaProtocol, milterProtocol OptProtocol, offeredDataSize DataSize) (uint32, OptAction, OptProtocol, DataSize, error) {
+		// a misbehaving milter that requests more than offered
+		return mtaVersion, milterActions, milterProtocol, offeredDataSize, nil
+	}))
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	go s.Serve(local)
+	var gotActions OptAction
+	var gotProtocol OptProtocol
+	client := NewClient("tcp", local.Addr().String(), WithActions(OptAddHeader), WithProtocols(OptNoEOH), WithNegotiationTolerance(func(unsupportedActions OptAction, unsupportedProtocol OptProtocol) {
+		gotActions = unsupportedActions
+		gotProtocol = unsupportedProtocol
+	}))
+	session, err := client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if gotActions != OptChangeHeader || gotProtocol != OptNoMailFrom {
+		t.Fatalf("got warning for actions %032b protocol %032b", gotActions, gotProtocol)
+	}
+	if session.ActionOption(OptChangeHeader) || !session.ActionOption(OptAddHeader) {
+		t.Fatal("unsupported actions did not get masked")
+	}
+	if session.ProtocolOption(OptNoMailFrom) {
+		t.Fatal("unsupported protocol options did not get masked")
+	}
+}
+
+func TestClient_MaxConcurrentSessions(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return NoOpMilter{}
+	})}, []Option{WithMaxConcurrentSessions(2, 0)})
+	defer w.Cleanup()
+
+	// w.session occupies the first slot
+	s2, err := w.client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.client.Session(nil); !errors.Is(err, ErrTooManySessions) {
+		t.Fatalf("Session() error = %v, want ErrTooManySessions", err)
+	}
+	if _, err := w.client.Ping(context.Background()); !errors.Is(err, ErrTooManySessions) {
+		t.Fatalf("Ping() error = %v, want ErrTooManySessions", err)
+	}
+	if err := s2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s3, err := w.client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queue until a session gets closed
+	queued := NewClient(w.local.Addr().Network(), w.local.Addr().String(), WithMaxConcurrentSessions(1, time.Second))
+	s4, err := queued.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = s4.Close()
+	}()
+	s5, err := queued.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s5.Close()
+	_ = s3.Close()
+}
+
+func TestMilterClient_BodyChunking(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithBodyChunking()})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+
+	line := append(bytes.Repeat([]byte{'A'}, 998), '\r', '\n')
+	body := bytes.Repeat(line, 100)
+	body = append(body, bytes.Repeat([]byte{'B'}, int(DataSize64K)+10)...)
+	act, err = w.session.BodyChunk(body)
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+
+	got := bytes.Join(mm.Chunks, nil)
+	if !bytes.Equal(got, body) {
+		t.Fatalf("milter received %d bytes, want %d", len(got), len(body))
+	}
+	// split at line endings when possible
+	lengths := make([]int, len(mm.Chunks))
+	for i, c := range mm.Chunks {
+		lengths[i] = len(c)
+	}
+	if expected := []int{65 * len(line), 35 * len(line), int(DataSize64K), 10}; !reflect.DeepEqual(lengths, expected) {
+		t.Fatalf("milter received chunks of sizes %v, want %v", lengths, expected)
+	}
+}
+
+func TestAsyncClientSession(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			m.AddHeader("X-Test", "1")
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithAction(OptAddHeader)}, nil)
+	defer w.server.Close()
+
+	a := w.session.Async()
+	futures := []*ActionFuture{
+		a.Conn("host", FamilyInet, 25565, "172.0.0.1"),
+		a.Helo("helo_host"),
+		a.Mail("from@example.org", ""),
+		a.Rcpt("to@example.org", ""),
+		a.DataStart(),
+		a.HeaderField("Subject", "test", nil),
+		a.HeaderEnd(),
+		a.BodyChunk([]byte("body")),
+	}
+	end := a.End()
+	closed := a.Close()
+	for i, f := range futures {
+		act, err := f.Wait()
+		if err != nil || act.Type != ActionContinue {
+			t.Fatalf("future %d got %+v, %v", i, act, err)
+		}
+	}
+	act, err := end.Wait()
+	assertAction(t, act, err, ActionAccept)
+	if mods := end.ModifyActions(); len(mods) != 1 || mods[0].HeaderName != "X-Test" {
+		t.Fatalf("End() got modifications %+v", mods)
+	}
+	if _, err := closed.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Helo("late").Wait(); err != ErrAsyncSessionClosed {
+		t.Fatalf("Helo() after Close() got %v", err)
+	}
+}
+
+func TestAsyncClientSession_DoesNotBlock(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			close(started)
+			<-release
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, nil)
+	defer w.server.Close()
+
+	a := w.session.Async()
+	conn := a.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	<-started
+	done := make(chan *ActionFuture)
+	go func() {
+		// the milter hangs, queue more calls than a channel buffer would hold
+		for i := 0; i < 200; i++ {
+			a.Helo("helo_host")
+		}
+		done <- a.Close()
+	}()
+	var closed *ActionFuture
+	select {
+	case closed = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("queueing calls blocked")
+	}
+	close(release)
+	act, err := conn.Wait()
+	assertAction(t, act, err, ActionContinue)
+	if _, err := closed.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientSession_SkipStats(t *testing.T) {
+	t.Parallel()
+	run := func(serverOpts []Option, mm *MockMilter) SkipStats {
+		t.Helper()
+		w := newServerClient(t, nil, append([]Option{WithMilter(func() Milter {
+			return mm
+		})}, serverOpts...), nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Helo("helo_host")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Mail("from@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		for i := 0; i < 3; i++ {
+			act, err = w.session.Rcpt("to@example.org", "")
+			assertAction(t, act, err, ActionContinue)
+		}
+		hdr := textproto.Header{}
+		hdr.Add("A", "1")
+		hdr.Add("B", "2")
+		hdr.Add("C", "3")
+		act, err = w.session.Header(hdr)
+		assertAction(t, act, err, ActionContinue)
+		for i := 0; i < 4; i++ {
+			act, err = w.session.BodyChunk([]byte("body"))
+			assertAction(t, act, err, ActionContinue)
+		}
+		_, act, err = w.session.End()
+		assertAction(t, act, err, ActionAccept)
+		return w.session.SkipStats()
+	}
+
+	got := run([]Option{WithProtocol(OptSkip)}, &MockMilter{
+		ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue, RcptResp: RespSkip, DataResp: RespContinue,
+		HdrResp: RespSkip, HdrsResp: RespContinue, BodyChunkResp: RespSkip, BodyResp: RespAccept,
+	})
+	if expected := (SkipStats{SkippedRcpts: 2, SkippedHeaders: 2, SkippedBodyChunks: 3}); got != expected {
+		t.Errorf("SkipStats() = %+v, want %+v", got, expected)
+	}
+
+	got = run([]Option{WithProtocols(OptNoRcptTo | OptNoHeaders | OptNoBody)}, &MockMilter{
+		ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue, DataResp: RespContinue,
+		HdrsResp: RespContinue, BodyResp: RespAccept,
+	})
+	if expected := (SkipStats{OptOutRcpts: 3, OptOutHeaders: 3, OptOutBodyChunks: 4}); got != expected {
+		t.Errorf("SkipStats() = %+v, want %+v", got, expected)
+	}
+}
+
+func TestClientSession_TooLargeError(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	_, err = w.session.BodyChunk(make([]byte, DataSize64K+1))
+	var tooLarge *TooLargeError
+	if !errors.As(err, &tooLarge) || !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected too large error, got %v", err)
+	}
+	if tooLarge.Size != int(DataSize64K)+1 || tooLarge.Max != int(DataSize64K) {
+		t.Fatalf("unexpected too large error %+v", tooLarge)
+	}
+	if _, err := w.session.BodyChunk(nil); !errors.Is(err, ErrWrongState) {
+		t.Fatalf("expected state error, got %v", err)
+	}
+}
+
+func TestMilterClient_BodyPolicy(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithBodyPolicy(BodySanitize)})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("a\nb\x00\r"))
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("c"))
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if got := string(bytes.Join(mm.Chunks, nil)); got != "a\r\nb\r\nc" {
+		t.Fatalf("milter received %q", got)
+	}
+}
+
+func TestServer_BodyPolicy(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithBodyPolicy(BodyReject)}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("a\r\n"))
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("b\x00\r\n"))
+	assertAction(t, act, err, ActionRejectWithCode)
+	if act.SMTPCode != 554 {
+		t.Fatalf("got SMTP code %d", act.SMTPCode)
+	}
+	if len(mm.Chunks) != 1 {
+		t.Fatalf("milter received %d chunks", len(mm.Chunks))
+	}
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next message is not affected
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("c\r\n"))
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+}
+
+func TestMilterClient_OnError(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		RcptErr:  errors.New("broken"),
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithOnError(FailTempFail)})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	// calling in the wrong state is an error of the caller and is not affected by the policy
+	if _, err := w.session.Rcpt("to@example.org", ""); !errors.Is(err, ErrWrongState) {
+		t.Fatalf("expected state error, got %v", err)
+	}
+	if w.session.Err() != nil {
+		t.Fatalf("Err() = %v", w.session.Err())
+	}
+}
+
+func TestMilterClient_OnErrorBrokenConnection(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		RcptErr:  errors.New("broken"),
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithOnError(FailTempFail)})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	// the milter closes the connection
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionTempFail)
+	if act.SMTPCode != 451 {
+		t.Fatalf("got SMTP code %d", act.SMTPCode)
+	}
+	if w.session.Err() == nil {
+		t.Fatal("Err() should return the connection error")
+	}
+	// all following calls get the same action
+	act, err = w.session.Rcpt("to2@example.org", "")
+	assertAction(t, act, err, ActionTempFail)
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionTempFail)
+}
+
+func TestClientSession_NegotiatedParameters(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return NoOpMilter{}
+	}), WithActions(OptAddHeader), WithProtocols(OptNoHelo)}, []Option{WithUsedMaxData(DataSize256K), WithOfferedMaxData(DataSize256K)})
+	defer w.Cleanup()
+
+	if w.session.Version() != MaxServerProtocolVersion {
+		t.Errorf("Version() = %d", w.session.Version())
+	}
+	if w.session.Actions() != OptAddHeader {
+		t.Errorf("Actions() = %032b", w.session.Actions())
+	}
+	if w.session.Protocols()&OptNoHelo == 0 {
+		t.Errorf("Protocols() = %032b", w.session.Protocols())
+	}
+	if w.session.MaxBodySize() != DataSize256K {
+		t.Errorf("MaxBodySize() = %d", w.session.MaxBodySize())
+	}
+}
+
+func TestClientSession_AutoSplit(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithUsedMaxData(0), WithOfferedMaxData(512 * 1024)})
+	defer w.Cleanup()
+
+	if w.session.MaxBodySize() != DataSize256K {
+		t.Fatalf("MaxBodySize() = %d", w.session.MaxBodySize())
+	}
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk(make([]byte, 600*1024))
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if len(mm.Chunks) != 3 {
+		t.Fatalf("expected 3 chunks, got %d", len(mm.Chunks))
+	}
+	for _, chunk := range mm.Chunks {
+		if len(chunk) > int(DataSize256K) {
+			t.Fatalf("chunk too big: %d", len(chunk))
+		}
+	}
+}
+
+func TestServer_MaxHeaderCount(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithMaxHeaderCount(2), WithMaxHeaderBytes(100)}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	for i := 0; i < 2; i++ {
+		act, err = w.session.HeaderField("X-Test", "1", nil)
+		assertAction(t, act, err, ActionContinue)
+	}
+	act, err = w.session.HeaderField("X-Test", "1", nil)
+	assertAction(t, act, err, ActionRejectWithCode)
+	if act.SMTPCode != 452 {
+		t.Fatalf("got SMTP code %d", act.SMTPCode)
+	}
+	if got := len(mm.Hdr.Values("X-Test")); got != 2 {
+		t.Fatalf("milter received %d header fields", got)
+	}
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the limits apply per message
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderField("X-Test", "1", nil)
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderField("X-Long", strings.Repeat("a", 100), nil)
+	assertAction(t, act, err, ActionRejectWithCode)
+}
+
+func TestServer_MaxBodyBytes(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		policy LimitPolicy
+		code   uint16
+	}{
+		{"tempfail", LimitTempFail, 452},
+		{"reject", LimitReject, 552},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			mm := MockMilter{
+				ConnResp:      RespContinue,
+				HeloResp:      RespContinue,
+				MailResp:      RespContinue,
+				RcptResp:      RespContinue,
+				DataResp:      RespContinue,
+				HdrResp:       RespContinue,
+				HdrsResp:      RespContinue,
+				BodyChunkResp: RespContinue,
+				BodyResp:      RespAccept,
+			}
+			w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+				return &mm
+			}), WithMaxBodyBytes(10), WithLimitPolicy(tt.policy)}, nil)
+			defer w.Cleanup()
+
+			act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo("helo_host")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Mail("from@example.org", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Rcpt("to@example.org", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Header(textproto.Header{})
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.BodyChunk([]byte("12345"))
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.BodyChunk([]byte("123456"))
+			assertAction(t, act, err, ActionRejectWithCode)
+			if act.SMTPCode != tt.code {
+				t.Fatalf("got SMTP code %d", act.SMTPCode)
+			}
+			if len(mm.Chunks) != 1 {
+				t.Fatalf("milter received %d body chunks", len(mm.Chunks))
+			}
+		})
+	}
+}
+
+func TestServer_LimitTruncate(t *testing.T) {
+	t.Parallel()
+	var truncated bool
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			truncated = m.Truncated()
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithMaxHeaderCount(1), WithMaxBodyBytes(8), WithLimitPolicy(LimitTruncate)}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	for i := 0; i < 3; i++ {
+		act, err = w.session.HeaderField("X-Test", "1", nil)
+		assertAction(t, act, err, ActionContinue)
+	}
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("12345"))
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("67890"))
+	if err != nil || (act.Type != ActionContinue && act.Type != ActionSkip) {
+		t.Fatalf("got %+v, %v", act, err)
+	}
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if got := len(mm.Hdr.Values("X-Test")); got != 1 {
+		t.Fatalf("milter received %d header fields", got)
+	}
+	if body := string(bytes.Join(mm.Chunks, nil)); body != "12345678" {
+		t.Fatalf("milter received body %q", body)
+	}
+	if !truncated {
+		t.Fatal("Modifier.Truncated() = false")
+	}
+
+	// the next message is not truncated
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if truncated {
+		t.Fatal("Modifier.Truncated() = true")
+	}
+}
+
+func TestClientSession_WithMacrosNoLeak(t *testing.T) {
+	t.Parallel()
+	var rcptHost string
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		RcptResp: RespContinue,
+		RcptMod: func(m *Modifier) {
+			rcptHost = m.Macros.Get(MacroRcptHost)
+		},
+	}
+	// without a macro bag the second Rcpt has no macros to send on its own
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.RcptWithMacros("to@example.org", "", map[MacroName]string{MacroRcptHost: "example.org"})
+	assertAction(t, act, err, ActionContinue)
+	if rcptHost != "example.org" {
+		t.Fatalf("got %s = %q", MacroRcptHost, rcptHost)
+	}
+	act, err = w.session.Rcpt("to2@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	if rcptHost != "" {
+		t.Fatalf("override leaked into the next command: got %s = %q", MacroRcptHost, rcptHost)
+	}
+}
+
+func TestClientSession_WithMacros(t *testing.T) {
+	t.Parallel()
+	macros := NewMacroBag()
+	macros.Set(MacroRcptMailer, "smtp")
+	var rcptMailer, rcptHost, mailAuthen string
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		MailMod: func(m *Modifier) {
+			mailAuthen = m.Macros.Get(MacroAuthAuthen)
+		},
+		RcptResp: RespContinue,
+		RcptMod: func(m *Modifier) {
+			rcptMailer = m.Macros.Get(MacroRcptMailer)
+			rcptHost = m.Macros.Get(MacroRcptHost)
+		},
+	}
+	w := newServerClient(t, macros, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.ConnWithMacros("host", FamilyInet, 25565, "172.0.0.1", nil)
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeloWithMacros("helo_host", nil)
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.MailWithMacros("from@example.org", "", map[MacroName]string{MacroAuthAuthen: "user"})
+	assertAction(t, act, err, ActionContinue)
+	if mailAuthen != "user" {
+		t.Fatalf("got %s = %q", MacroAuthAuthen, mailAuthen)
+	}
+	act, err = w.session.RcptWithMacros("to@example.org", "", map[MacroName]string{MacroRcptMailer: "local", MacroRcptHost: "example.org"})
+	assertAction(t, act, err, ActionContinue)
+	if rcptMailer != "local" || rcptHost != "example.org" {
+		t.Fatalf("got %s = %q and %s = %q", MacroRcptMailer, rcptMailer, MacroRcptHost, rcptHost)
+	}
+	// the overrides only apply to one command
+	act, err = w.session.Rcpt("to2@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	if rcptMailer != "smtp" || rcptHost != "" {
+		t.Fatalf("got %s = %q and %s = %q", MacroRcptMailer, rcptMailer, MacroRcptHost, rcptHost)
+	}
+	if macros.Get(MacroRcptMailer) != "smtp" {
+		t.Fatal("the override changed the macro bag")
+	}
+}
+
+func TestServer_SessionClasses(t *testing.T) {
+	t.Parallel()
+	inbound := NewMacroBag()
+	inbound.Set(MacroDaemonName, "inbound")
+	outbound := NewMacroBag()
+	outbound.Set(MacroDaemonName, "submission")
+	w := newServerClient(t, inbound, []Option{WithMilter(func() Milter {
+		return NoOpMilter{}
+	}), WithSessionClasses(func(macros Macros) string {
+		return macros.Get(MacroDaemonName)
+	}, map[string]SessionClass{
+		"inbound": {MaxConcurrent: 1},
+	}), WithMacroRequest(StageConnect, []MacroName{MacroDaemonName})}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+
+	// the inbound class is full
+	session2, err := w.client.Session(inbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session2.Close()
+	act, err = session2.Conn("host", FamilyInet, 25565, "172.0.0.2")
+	assertAction(t, act, err, ActionRejectWithCode)
+	if act.SMTPCode != 421 {
+		t.Fatalf("got SMTP code %d", act.SMTPCode)
+	}
+
+	// other classes are not affected
+	session3, err := w.client.Session(outbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session3.Close()
+	act, err = session3.Conn("host", FamilyInet, 25565, "172.0.0.3")
+	assertAction(t, act, err, ActionContinue)
+
+	// Reset releases the slot
+	if err := w.session.Reset(inbound); err != nil {
+		t.Fatal(err)
+	}
+	session4, err := w.client.Session(inbound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session4.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		act, err = session4.Conn("host", FamilyInet, 25565, "172.0.0.4")
+		if err != nil || act.Type == ActionContinue || time.Now().After(deadline) {
+			break
+		}
+		// the server might not have processed the Reset yet
+		if err := session4.Reset(inbound); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertAction(t, act, err, ActionContinue)
+}
+
+func TestClientSession_RcptRejected(t *testing.T) {
+	t.Parallel()
+	var rejected []RejectedInfo
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		RcptResp: RespContinue,
+		RcptMod: func(m *Modifier) {
+			if info, ok := RejectedRcpt(m.Macros); ok {
+				rejected = append(rejected, info)
+			}
+		},
+	}
+	for _, protocol := range []OptProtocol{0, OptRcptRej} {
+		mm.Rcpt = nil
+		rejected = nil
+		w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+			return &mm
+		}), WithProtocols(protocol)}, nil)
+
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Helo("helo_host")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Mail("from@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.RcptRejected("unknown@example.org", "", RejectedInfo{Code: 550, Reason: "5.1.1 User unknown"})
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Rcpt("to@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		w.Cleanup()
+
+		if protocol == 0 {
+			if !reflect.DeepEqual(mm.Rcpt, []string{"to@example.org"}) || len(rejected) != 0 {
+				t.Fatalf("milter got %v, rejected %v", mm.Rcpt, rejected)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(mm.Rcpt, []string{"unknown@example.org", "to@example.org"}) {
+			t.Fatalf("milter got %v", mm.Rcpt)
+		}
+		if !reflect.DeepEqual(rejected, []RejectedInfo{{Code: 550, Reason: "5.1.1 User unknown"}}) {
+			t.Fatalf("milter got rejected %+v", rejected)
+		}
+	}
+}
+
+func TestMilterClient_LenientReplies(t *testing.T) {
+	t.Parallel()
+	vendorReply := func(m *Modifier) {
+		_ = m.writeProgressPacket(&wire.Message{Code: 'Z', Data: []byte("vendor")})
+	}
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		ConnMod:  vendorReply,
+		HeloResp: RespContinue,
+		HeloMod:  vendorReply,
+		MailResp: RespContinue,
+		MailMod:  vendorReply,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithLenientReplies(2)})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	// the limit is reached
+	if _, err = w.session.Mail("from@example.org", ""); !errors.Is(err, ErrActionParse) {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+}
+
+func TestClientSession_Keepalive(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return NoOpMilter{}
+	})}, []Option{WithKeepalive(10 * time.Millisecond)})
+	defer w.Cleanup()
+
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	if !waitFor(func() bool { return len(w.server.Sessions()) == 1 }) {
+		t.Fatal("server did not register the session")
+	}
+	first := w.server.Sessions()[0].LastActivity
+	if !waitFor(func() bool { return w.server.Sessions()[0].LastActivity.After(first) }) {
+		t.Fatal("keepalive did not reach the server")
+	}
+	if err := w.session.Check(); err != nil {
+		t.Fatalf("Check() = %v", err)
+	}
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	if err := w.session.Check(); !errors.Is(err, ErrWrongState) {
+		t.Fatalf("Check() = %v", err)
+	}
+}
+
+func TestClientSession_IdleTimeout(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return NoOpMilter{}
+	})}, []Option{WithIdleTimeout(20 * time.Millisecond)})
+	defer w.Cleanup()
+
+	if err := w.session.Check(); err != nil {
+		t.Fatalf("Check() = %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := w.session.Check(); !errors.Is(err, ErrWrongState) {
+		t.Fatalf("Check() = %v", err)
+	}
+	if _, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1"); !errors.Is(err, ErrWrongState) {
+		t.Fatalf("Conn() = %v", err)
+	}
+}
+
+func TestClientSession_CheckClosedByMilter(t *testing.T) {
+	t.Parallel()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		if _, err := wire.ReadPacket(serverConn, time.Second); err != nil {
+			return
+		}
+		response := []byte{0, 0, 0, 13, byte(wire.CodeOptNeg), 0, 0, 0, 6, 0, 0, 0, 0, 0, 0, 0, 0}
+		_, _ = serverConn.Write(response)
+	}()
+	cl := NewClient("tcp", "127.0.0.1:1")
+	session, err := cl.session(clientConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the milter closed the connection after the negotiation
+	time.Sleep(50 * time.Millisecond)
+	if err := session.Check(); err == nil || errors.Is(err, ErrWrongState) {
+		t.Fatalf("Check() = %v", err)
+	}
+}
+
+func TestExtensionPackets(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			if err := m.SendExtension('V', []byte("eom")); err != nil {
+				panic(err)
+			}
+		},
+	}
+	var replies []string
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithExtensionCommandHandler(func(code byte, data []byte, backend Milter) (*Response, error) {
+		switch code {
+		case 'x':
+			return NewExtensionResponse('Y', append([]byte("echo "), data...)), nil
+		case 'z':
+			return NewExtensionResponse('W', []byte("later")), nil
+		}
+		return nil, errors.New("unexpected code")
+	})}, []Option{WithExtensionReplyHandler(func(code byte, data []byte) error {
+		replies = append(replies, fmt.Sprintf("%c %s", code, data))
+		return nil
+	})})
+	defer w.Cleanup()
+
+	code, data, err := w.session.ExtensionRequest('x', []byte("ping"))
+	if err != nil || code != 'Y' || string(data) != "echo ping" {
+		t.Fatalf("ExtensionRequest() = %c %q %v", code, data, err)
+	}
+	if err := w.session.SendExtension(byte(wire.CodeConn), nil); err == nil {
+		t.Fatal("SendExtension() with a protocol code did not fail")
+	}
+	if err := w.session.SendExtension('z', nil); err != nil {
+		t.Fatal(err)
+	}
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("body\r\n"))
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if !reflect.DeepEqual(replies, []string{"W later", "V eom"}) {
+		t.Fatalf("got replies %q", replies)
+	}
+}
+
+func TestExtensionPackets_HandlerError(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			if err := m.SendExtension('V', []byte("eom")); err != nil {
+				panic(err)
+			}
+		},
+	}
+	errHandler := errors.New("handler error")
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithExtensionReplyHandler(func(code byte, data []byte) error {
+		return errHandler
+	})})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	if _, _, err = w.session.End(); !errors.Is(err, errHandler) {
+		t.Fatalf("End() err = %v, want %v", err, errHandler)
+	}
+	// the session failed
+	if _, err = w.session.Mail("from@example.org", ""); err == nil {
+		t.Fatal("Mail() after failed End() did not fail")
+	}
+}
+
+// recordingDialer remembers the connections it dialed.
+type recordingDialer struct {
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (d *recordingDialer) Dial(network string, addr string) (net.Conn, error) {
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.conns = append(d.conns, conn)
+	d.mu.Unlock()
+	return conn, nil
+}
+
+func TestClientSession_Reconnect(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var milters []*MockMilter
+	dialer := &recordingDialer{}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		mu.Lock()
+		defer mu.Unlock()
+		mm := &MockMilter{
+			ConnResp:      RespContinue,
+			HeloResp:      RespContinue,
+			MailResp:      RespContinue,
+			RcptResp:      RespContinue,
+			DataResp:      RespContinue,
+			HdrResp:       RespContinue,
+			HdrsResp:      RespContinue,
+			BodyChunkResp: RespContinue,
+			BodyResp:      RespAccept,
+		}
+		milters = append(milters, mm)
+		return mm
+	})}, []Option{WithDialer(dialer), WithReconnect(1)})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+
+	// simulate a broken connection
+	_ = dialer.conns[0].Close()
+
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	if len(dialer.conns) != 2 {
+		t.Fatalf("expected a second connection, got %d", len(dialer.conns))
+	}
+	mu.Lock()
+	mm := milters[len(milters)-1]
+	mu.Unlock()
+	if mm.Host != "host" || mm.HeloValue != "helo_host" || mm.From != "from@example.org" || !reflect.DeepEqual(mm.Rcpt, []string{"to@example.org"}) {
+		t.Fatalf("milter did not get the replayed commands: %+v", mm)
+	}
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("body\r\n"))
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+
+	// a lost connection after DataStart cannot be re-established
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	_ = dialer.conns[1].Close()
+	if _, err := w.session.HeaderEnd(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestClientSession_TimingCallback(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespReject,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	var cmds []string
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	})}, []Option{WithTimingCallback(func(cmd string, d time.Duration, act *Action) {
+		if d < 0 {
+			t.Errorf("negative duration %s for %s", d, cmd)
+		}
+		typ := ActionType(0)
+		if act != nil {
+			typ = act.Type
+		}
+		cmds = append(cmds, fmt.Sprintf("%s %d", cmd, typ))
+	})})
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionReject)
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		fmt.Sprintf("connect %d", ActionContinue),
+		fmt.Sprintf("helo %d", ActionContinue),
+		fmt.Sprintf("mail %d", ActionContinue),
+		fmt.Sprintf("rcpt %d", ActionReject),
+		"abort 0",
+	}
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Fatalf("got %q, expected %q", cmds, expected)
+	}
+}
+
+func TestClientSession_MacrosExceedPacketSize(t *testing.T) {
+	t.Parallel()
+	var tlsVersion, cipher string
+	macros := NewMacroBag()
+	macros.Set(MacroTlsVersion, "TLSv1.3")
+	macros.Set(MacroCipher, strings.Repeat("A", int(DataSize64K)))
+	logger := &recordingLogger{}
+	w := newServerClient(t, macros, []Option{WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			HeloResp: RespContinue,
+			HeloMod: func(m *Modifier) {
+				tlsVersion = m.Macros.Get(MacroTlsVersion)
+				cipher = m.Macros.Get(MacroCipher)
+			},
+		}
+	})}, []Option{WithLogger(logger)})
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	if tlsVersion != "TLSv1.3" || cipher != "" {
+		t.Fatalf("got macros %q and %d bytes of %s", tlsVersion, len(cipher), MacroCipher)
+	}
+	if lines := logger.Lines(); len(lines) != 1 || !strings.Contains(lines[0], "dropping macros [{cipher}] for command H") {
+		t.Fatalf("unexpected log lines %q", lines)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"sync"
 
@@ -26,6 +27,7 @@ func randSeq(n int) string {
 func main() {
 	transport := flag.String("transport", "tcp", "Transport to use for milter connection, One of 'tcp', 'unix', 'tcp4' or 'tcp6'")
 	address := flag.String("address", "127.0.0.1:0", "Transport address, path for 'unix', address:port for 'tcp'")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics on this address (e.g. '127.0.0.1:9101'), disabled when empty")
 
 	flag.Parse()
 
@@ -54,9 +56,14 @@ func main() {
 		}(*address)
 	}
 
+	var metrics *Metrics
+	if *metricsAddr != "" {
+		metrics = NewMetrics()
+	}
+
 	server := milter.NewServer(
 		milter.WithMilter(func() milter.Milter {
-			return &LogMilter{logPrefix: randSeq(10)}
+			return &LogMilter{logPrefix: randSeq(10), metrics: metrics}
 		}),
 		milter.WithNegotiationCallback(func(mtaVersion, milterVersion uint32, mtaActions, milterActions milter.OptAction, mtaProtocol, milterProtocol milter.OptProtocol, offeredDataSize milter.DataSize) (version uint32, actions milter.OptAction, protocol milter.OptProtocol, maxDataSize milter.DataSize, err error) {
 			log.Printf("ACCEPT milter version %d, actions %032b, protocol %032b, data size %d", mtaVersion, mtaActions, mtaProtocol, offeredDataSize)
@@ -78,6 +85,16 @@ func main() {
 
 	log.Printf("Started milter on %s:%s", socket.Addr().Network(), socket.Addr().String())
 
+	if metrics != nil {
+		metrics.server = server
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, mux))
+		}()
+		log.Printf("Serving metrics on http://%s/metrics", *metricsAddr)
+	}
+
 	// quit when milter quits
 	wgDone.Wait()
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/d--j/go-milter"
+)
+
+// stages are the milter stages that log-milter records, in the order they get output.
+var stages = []string{"connect", "helo", "mail", "rcpt", "data", "header", "eoh", "body", "eom", "abort", "unknown"}
+
+// delayBuckets are the upper bounds (in seconds) of the buckets of the delay histograms.
+var delayBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range delayBuckets {
+		if v <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Metrics collects per-stage counters and histograms of the delay between two milter commands of a connection.
+// The delay is the time the MTA needed to send the next command and characterizes the MTA-to-milter traffic.
+//
+// Metrics serves them in the Prometheus text format.
+type Metrics struct {
+	server    *milter.Server
+	mu        sync.Mutex
+	calls     map[string]uint64
+	delays    map[string]*histogram
+	bodyBytes uint64
+}
+
+// NewMetrics creates an empty Metrics.
+func NewMetrics() *Metrics {
+	m := &Metrics{calls: make(map[string]uint64), delays: make(map[string]*histogram)}
+	for _, stage := range stages {
+		m.delays[stage] = &histogram{buckets: make([]uint64, len(delayBuckets))}
+	}
+	return m
+}
+
+// observe records a call of stage. last is the time the previous command of the connection got handled (can be zero).
+func (m *Metrics) observe(stage string, last time.Time, bodyBytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[stage]++
+	m.bodyBytes += uint64(bodyBytes)
+	if !last.IsZero() {
+		m.delays[stage].observe(time.Since(last).Seconds())
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _ = fmt.Fprintln(w, "# HELP log_milter_stage_calls_total Number of milter commands per stage.")
+	_, _ = fmt.Fprintln(w, "# TYPE log_milter_stage_calls_total counter")
+	for _, stage := range stages {
+		_, _ = fmt.Fprintf(w, "log_milter_stage_calls_total{stage=%q} %d\n", stage, m.calls[stage])
+	}
+	_, _ = fmt.Fprintln(w, "# HELP log_milter_stage_delay_seconds Time between the previous milter command of the connection and this one.")
+	_, _ = fmt.Fprintln(w, "# TYPE log_milter_stage_delay_seconds histogram")
+	for _, stage := range stages {
+		h := m.delays[stage]
+		for i, le := range delayBuckets {
+			_, _ = fmt.Fprintf(w, "log_milter_stage_delay_seconds_bucket{stage=%q,le=%q} %d\n", stage, strconv.FormatFloat(le, 'g', -1, 64), h.buckets[i])
+		}
+		_, _ = fmt.Fprintf(w, "log_milter_stage_delay_seconds_bucket{stage=%q,le=\"+Inf\"} %d\n", stage, h.count)
+		_, _ = fmt.Fprintf(w, "log_milter_stage_delay_seconds_sum{stage=%q} %s\n", stage, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		_, _ = fmt.Fprintf(w, "log_milter_stage_delay_seconds_count{stage=%q} %d\n", stage, h.count)
+	}
+	_, _ = fmt.Fprintln(w, "# HELP log_milter_body_bytes_total Number of body bytes the MTA sent.")
+	_, _ = fmt.Fprintln(w, "# TYPE log_milter_body_bytes_total counter")
+	_, _ = fmt.Fprintf(w, "log_milter_body_bytes_total %d\n", m.bodyBytes)
+	if m.server == nil {
+		return
+	}
+	stats := m.server.Stats()
+	_, _ = fmt.Fprintln(w, "# HELP log_milter_active_connections Number of MTA connections.")
+	_, _ = fmt.Fprintln(w, "# TYPE log_milter_active_connections gauge")
+	_, _ = fmt.Fprintf(w, "log_milter_active_connections %d\n", stats.ActiveConnections)
+	_, _ = fmt.Fprintln(w, "# HELP log_milter_messages_processed_total Number of messages that reached the end of message stage.")
+	_, _ = fmt.Fprintln(w, "# TYPE log_milter_messages_processed_total counter")
+	_, _ = fmt.Fprintf(w, "log_milter_messages_processed_total %d\n", stats.MessagesProcessed)
+	_, _ = fmt.Fprintln(w, "# HELP log_milter_accepted_connections_total Number of accepted MTA connections.")
+	_, _ = fmt.Fprintln(w, "# TYPE log_milter_accepted_connections_total counter")
+	_, _ = fmt.Fprintf(w, "log_milter_accepted_connections_total %d\n", stats.Listener.Accepted)
+}
@@ -3,13 +3,28 @@ package main
 import (
 	"fmt"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/d--j/go-milter"
 )
 
+var _ milter.MacroObserver = (*LogMilter)(nil)
+
 type LogMilter struct {
 	logPrefix   string
 	macroValues map[milter.MacroName]string
+	metrics     *Metrics
+	last        time.Time
+}
+
+// record updates the metrics of l (when --metrics-addr is used).
+func (l *LogMilter) record(stage string, bodyBytes int) {
+	if l.metrics == nil {
+		return
+	}
+	l.metrics.observe(stage, l.last, bodyBytes)
+	l.last = time.Now()
 }
 
 func (l *LogMilter) log(format string, v ...interface{}) {
@@ -18,70 +33,93 @@ func (l *LogMilter) log(format string, v ...interface{}) {
 
 func (l *LogMilter) Connect(host string, family string, port uint16, addr string, m *milter.Modifier) (*milter.Response, error) {
 	l.log("CONNECT host = %q, family = %q, port = %d, addr = %q", host, family, port, addr)
+	l.record("connect", 0)
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
 func (l *LogMilter) Helo(name string, m *milter.Modifier) (*milter.Response, error) {
 	l.log("HELO %q", name)
+	l.record("helo", 0)
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
 func (l *LogMilter) MailFrom(from string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
 	l.log("MAIL FROM <%s> %s", from, esmtpArgs)
+	l.record("mail", 0)
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
 func (l *LogMilter) RcptTo(rcptTo string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
 	l.log("RCPT TO <%s> %s", rcptTo, esmtpArgs)
+	l.record("rcpt", 0)
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
 func (l *LogMilter) Data(m *milter.Modifier) (*milter.Response, error) {
 	l.log("DATA")
+	l.record("data", 0)
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
 func (l *LogMilter) Header(name string, value string, m *milter.Modifier) (*milter.Response, error) {
 	l.log("HEADER %s: %q", name, value)
+	l.record("header", 0)
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
 func (l *LogMilter) Headers(m *milter.Modifier) (*milter.Response, error) {
 	l.log("EOH")
+	l.record("eoh", 0)
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
 func (l *LogMilter) BodyChunk(chunk []byte, m *milter.Modifier) (*milter.Response, error) {
 	l.log("BODY CHUNK size = %d", len(chunk))
+	l.record("body", len(chunk))
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
 func (l *LogMilter) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
 	l.log("EOM")
+	l.record("eom", 0)
 	l.outputChangedMacros(m)
 	return milter.RespAccept, nil
 }
 
 func (l *LogMilter) Abort(m *milter.Modifier) error {
 	l.log("ABORT")
+	l.record("abort", 0)
 	l.outputChangedMacros(m)
 	return nil
 }
 
 func (l *LogMilter) Unknown(cmd string, m *milter.Modifier) (*milter.Response, error) {
 	l.log("UNKNOWN %q", cmd)
+	l.record("unknown", 0)
 	l.outputChangedMacros(m)
 	return milter.RespContinue, nil
 }
 
+// OnMacros logs every macro packet of the MTA, including the macros that log-milter did not request.
+func (l *LogMilter) OnMacros(stage milter.MacroStage, macros map[milter.MacroName]string) {
+	names := make([]string, 0, len(macros))
+	for name := range macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l.log("  raw macro (stage %d) %s = %q", stage, name, macros[name])
+	}
+}
+
 func (l *LogMilter) Cleanup() {
 	l.log("cleanup")
 	l.macroValues = nil
@@ -3,12 +3,16 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
 
 	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/internal/corpus"
 	"github.com/d--j/go-milter/milterutil"
 	"github.com/emersion/go-message/textproto"
 	"golang.org/x/text/transform"
@@ -54,109 +58,160 @@ func printModifyAction(act milter.ModifyAction) {
 	}
 }
 
-func main() {
-	transport := flag.String("transport", "unix", "Transport to use for milter connection, One of 'tcp', 'unix', 'tcp4' or 'tcp6'")
-	address := flag.String("address", "", "Transport address, path for 'unix', address:port for 'tcp'")
-	hostname := flag.String("hostname", "localhost", "Value to send in CONNECT message")
-	family := flag.String("family", string(milter.FamilyInet), "Protocol family to send in CONNECT message")
-	port := flag.Uint("port", 2525, "Port to send in CONNECT message")
-	connAddr := flag.String("conn-addr", "127.0.0.1", "Connection address to send in CONNECT message")
-	helo := flag.String("helo", "localhost", "Value to send in HELO message")
-	mailFrom := flag.String("from", "foxcpp@example.org", "Value to send in MAIL message")
-	rcptTo := flag.String("rcpt", "foxcpp@example.com", "Comma-separated list of values for RCPT messages")
-	actionMask := flag.Uint("actions",
-		uint(milter.AllClientSupportedActionMasks),
-		"Bitmask value of actions we allow")
-	disabledMsgs := flag.Uint("disabled-msgs", 0, "Bitmask of disabled protocol messages")
-	flag.Parse()
-
-	c := milter.NewClient(*transport, *address, milter.WithActions(milter.OptAction(*actionMask)), milter.WithProtocols(milter.OptProtocol(*disabledMsgs)))
-
-	s, err := c.Session(nil)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	defer func(s *milter.ClientSession) {
-		_ = s.Close()
-	}(s)
+// errStop signals that the milter did not want to continue with the current message.
+var errStop = errors.New("milter stopped processing")
 
-	act, err := s.Conn(*hostname, milter.ProtoFamily((*family)[0]), uint16(*port), *connAddr)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	printAction("CONNECT:", act)
-	if act.StopProcessing() {
-		return
-	}
-
-	act, err = s.Helo(*helo)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	printAction("HELO:", act)
-	if act.StopProcessing() {
-		return
+// sendMessage sends one message (MAIL until EOM) to the milter.
+// When the milter stops the processing before EOM the message gets aborted, so the session can be used for the next message.
+func sendMessage(s *milter.ClientSession, mailFrom string, rcptTo []string, r io.Reader) error {
+	abort := func() error {
+		if err := s.Abort(nil); err != nil {
+			return err
+		}
+		return errStop
 	}
 
-	act, err = s.Mail(*mailFrom, "")
+	act, err := s.Mail(mailFrom, "")
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 	printAction("MAIL:", act)
 	if act.StopProcessing() {
-		return
+		return abort()
 	}
 
-	for _, rcpt := range strings.Split(*rcptTo, ",") {
+	for _, rcpt := range rcptTo {
 		act, err = s.Rcpt(rcpt, "")
 		if err != nil {
-			log.Println(err)
-			return
+			return err
 		}
 		printAction("RCPT:", act)
 		if act.StopProcessing() {
-			return
+			return abort()
 		}
 	}
 
 	act, err = s.DataStart()
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 	printAction("DATA:", act)
 	if act.StopProcessing() {
-		return
+		return abort()
 	}
 
-	bufR := bufio.NewReader(transform.NewReader(os.Stdin, &milterutil.CrLfCanonicalizationTransformer{}))
+	bufR := bufio.NewReader(transform.NewReader(r, &milterutil.CrLfCanonicalizationTransformer{}))
 	hdr, err := textproto.ReadHeader(bufR)
 	if err != nil {
-		log.Println("header parse:", err)
-		return
+		_ = s.Abort(nil)
+		return fmt.Errorf("header parse: %w", err)
 	}
 
 	act, err = s.Header(hdr)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 	printAction("HEADER:", act)
 	if act.StopProcessing() {
-		return
+		return abort()
 	}
 
 	modifyActs, act, err := s.BodyReadFrom(bufR)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 	for _, act := range modifyActs {
 		printModifyAction(act)
 	}
 	printAction("EOB:", act)
+	return nil
+}
+
+func main() {
+	transport := flag.String("transport", "unix", "Transport to use for milter connection, One of 'tcp', 'unix', 'tcp4' or 'tcp6'")
+	address := flag.String("address", "", "Transport address, path for 'unix', address:port for 'tcp'")
+	hostname := flag.String("hostname", "localhost", "Value to send in CONNECT message")
+	family := flag.String("family", string(milter.FamilyInet), "Protocol family to send in CONNECT message")
+	port := flag.Uint("port", 2525, "Port to send in CONNECT message")
+	connAddr := flag.String("conn-addr", "127.0.0.1", "Connection address to send in CONNECT message")
+	helo := flag.String("helo", "localhost", "Value to send in HELO message")
+	mailFrom := flag.String("from", "foxcpp@example.org", "Value to send in MAIL message")
+	rcptTo := flag.String("rcpt", "foxcpp@example.com", "Comma-separated list of values for RCPT messages")
+	actionMask := flag.Uint("actions",
+		uint(milter.AllClientSupportedActionMasks),
+		"Bitmask value of actions we allow")
+	disabledMsgs := flag.Uint("disabled-msgs", 0, "Bitmask of disabled protocol messages")
+	mbox := flag.String("mbox", "", "Read the messages to send from this mbox file instead of reading one message from stdin")
+	maildir := flag.String("maildir", "", "Read the messages to send from this maildir instead of reading one message from stdin")
+	reset := flag.Bool("reset", false, "Reset the session (and send CONNECT and HELO again) between messages instead of re-using the SMTP connection")
+	flag.Parse()
+
+	c := milter.NewClient(*transport, *address, milter.WithActions(milter.OptAction(*actionMask)), milter.WithProtocols(milter.OptProtocol(*disabledMsgs)))
+
+	s, err := c.Session(nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer func(s *milter.ClientSession) {
+		_ = s.Close()
+	}(s)
+
+	connect := func() bool {
+		act, err := s.Conn(*hostname, milter.ProtoFamily((*family)[0]), uint16(*port), *connAddr)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		printAction("CONNECT:", act)
+		if act.StopProcessing() {
+			return false
+		}
+
+		act, err = s.Helo(*helo)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		printAction("HELO:", act)
+		return !act.StopProcessing()
+	}
+
+	if !connect() {
+		return
+	}
+
+	count := 0
+	handleMessage := func(r io.Reader) error {
+		if count > 0 {
+			if *reset {
+				if err := s.Reset(nil); err != nil {
+					return err
+				}
+				if !connect() {
+					return errStop
+				}
+			}
+		}
+		count++
+		log.Printf("message %d", count)
+		if err := sendMessage(s, *mailFrom, strings.Split(*rcptTo, ","), r); err != nil && err != errStop {
+			return err
+		}
+		return nil
+	}
+
+	switch {
+	case *mbox != "":
+		err = corpus.ReadFile(*mbox, func(r io.Reader) error {
+			return corpus.ReadMbox(r, handleMessage)
+		})
+	case *maildir != "":
+		err = corpus.ReadMaildir(*maildir, handleMessage)
+	default:
+		err = handleMessage(os.Stdin)
+	}
+	if err != nil && err != errStop {
+		log.Println(err)
+	}
 }
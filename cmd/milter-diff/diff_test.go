@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_diff(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		a    []string
+		b    []string
+		want []string
+	}{
+		{"both empty", nil, nil, nil},
+		{"equal", []string{"a", "b"}, []string{"a", "b"}, nil},
+		{"only a", []string{"a", "b"}, nil, []string{"- a", "- b"}},
+		{"only b", nil, []string{"a", "b"}, []string{"+ a", "+ b"}},
+		{"added line", []string{"a", "c"}, []string{"a", "b", "c"}, []string{"  a", "+ b", "  c"}},
+		{"removed line", []string{"a", "b", "c"}, []string{"a", "c"}, []string{"  a", "- b", "  c"}},
+		{"changed line", []string{"a", "b", "c"}, []string{"a", "x", "c"}, []string{"  a", "- b", "+ x", "  c"}},
+		{"changed last line", []string{"a", "b"}, []string{"a", "c"}, []string{"  a", "- b", "+ c"}},
+		{"prefix", []string{"a"}, []string{"a", "a"}, []string{"  a", "+ a"}},
+		{"reordered", []string{"a", "b", "c"}, []string{"c", "a", "b"}, []string{"+ c", "  a", "  b", "- c"}},
+		{"nothing in common", []string{"a", "b"}, []string{"c"}, []string{"- a", "- b", "+ c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diff(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
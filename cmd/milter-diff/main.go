@@ -0,0 +1,216 @@
+// Command milter-diff sends the same messages to two milters and reports the differences in their responses.
+//
+// You can use it to check that a new version of your milter behaves like the old one.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/d--j/go-milter"
+	"github.com/d--j/go-milter/internal/corpus"
+	"github.com/d--j/go-milter/milterutil"
+	"github.com/emersion/go-message/textproto"
+	"golang.org/x/text/transform"
+)
+
+type envelope struct {
+	hostname string
+	family   milter.ProtoFamily
+	port     uint16
+	connAddr string
+	helo     string
+	mailFrom string
+	rcptTo   []string
+}
+
+func formatAction(act *milter.Action) string {
+	switch act.Type {
+	case milter.ActionAccept:
+		return "accept"
+	case milter.ActionReject:
+		return "reject"
+	case milter.ActionDiscard:
+		return "discard"
+	case milter.ActionTempFail:
+		return "temp. fail"
+	case milter.ActionRejectWithCode:
+		return fmt.Sprintf("reply code: %d %s", act.SMTPCode, act.SMTPReply)
+	case milter.ActionContinue:
+		return "continue"
+	case milter.ActionSkip:
+		return "skip"
+	default:
+		return fmt.Sprintf("unknown action %c", act.Type)
+	}
+}
+
+func formatModifyAction(act milter.ModifyAction) string {
+	switch act.Type {
+	case milter.ActionAddHeader:
+		return fmt.Sprintf("add header: name %s, value %q", act.HeaderName, act.HeaderValue)
+	case milter.ActionInsertHeader:
+		return fmt.Sprintf("insert header: at %d, name %s, value %q", act.HeaderIndex, act.HeaderName, act.HeaderValue)
+	case milter.ActionChangeFrom:
+		return fmt.Sprintf("change from: %s %s", act.From, act.FromArgs)
+	case milter.ActionChangeHeader:
+		return fmt.Sprintf("change header: at %d, name %s, value %q", act.HeaderIndex, act.HeaderName, act.HeaderValue)
+	case milter.ActionAddRcpt:
+		return fmt.Sprintf("add rcpt: %s %s", act.Rcpt, act.RcptArgs)
+	case milter.ActionDelRcpt:
+		return fmt.Sprintf("del rcpt: %s", act.Rcpt)
+	case milter.ActionQuarantine:
+		return fmt.Sprintf("quarantine: %s", act.Reason)
+	default:
+		return fmt.Sprintf("unknown modify action %c", act.Type)
+	}
+}
+
+// transcript sends msg to the milter c and returns a textual representation of all responses of the milter.
+func transcript(c *milter.Client, env envelope, msg []byte) ([]string, error) {
+	var lines []string
+	s, err := c.Session(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func(s *milter.ClientSession) {
+		_ = s.Close()
+	}(s)
+
+	step := func(name string, act *milter.Action, err error) bool {
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%s: error: %s", name, err.Error()))
+			return false
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", name, formatAction(act)))
+		return !act.StopProcessing()
+	}
+
+	act, err := s.Conn(env.hostname, env.family, env.port, env.connAddr)
+	if !step("CONNECT", act, err) {
+		return lines, nil
+	}
+	act, err = s.Helo(env.helo)
+	if !step("HELO", act, err) {
+		return lines, nil
+	}
+	act, err = s.Mail(env.mailFrom, "")
+	if !step("MAIL", act, err) {
+		return lines, nil
+	}
+	for _, rcpt := range env.rcptTo {
+		act, err = s.Rcpt(rcpt, "")
+		if !step("RCPT", act, err) {
+			return lines, nil
+		}
+	}
+	act, err = s.DataStart()
+	if !step("DATA", act, err) {
+		return lines, nil
+	}
+	bufR := bufio.NewReader(transform.NewReader(bytes.NewReader(msg), &milterutil.CrLfCanonicalizationTransformer{}))
+	hdr, err := textproto.ReadHeader(bufR)
+	if err != nil {
+		return nil, fmt.Errorf("header parse: %w", err)
+	}
+	act, err = s.Header(hdr)
+	if !step("HEADER", act, err) {
+		return lines, nil
+	}
+	modifyActs, act, err := s.BodyReadFrom(bufR)
+	var body []byte
+	replaced := false
+	for _, modifyAct := range modifyActs {
+		if modifyAct.Type == milter.ActionReplaceBody {
+			replaced = true
+			body = append(body, modifyAct.Body...)
+			continue
+		}
+		lines = append(lines, formatModifyAction(modifyAct))
+	}
+	if replaced {
+		lines = append(lines, fmt.Sprintf("replace body: %d bytes, sha256 %x", len(body), sha256.Sum256(body)))
+	}
+	step("EOB", act, err)
+	return lines, nil
+}
+
+func main() {
+	transportA := flag.String("a-transport", "unix", "Transport to use for the connection to milter A, One of 'tcp', 'unix', 'tcp4' or 'tcp6'")
+	addressA := flag.String("a-address", "", "Transport address of milter A, path for 'unix', address:port for 'tcp'")
+	transportB := flag.String("b-transport", "unix", "Transport to use for the connection to milter B, One of 'tcp', 'unix', 'tcp4' or 'tcp6'")
+	addressB := flag.String("b-address", "", "Transport address of milter B, path for 'unix', address:port for 'tcp'")
+	hostname := flag.String("hostname", "localhost", "Value to send in CONNECT message")
+	family := flag.String("family", string(milter.FamilyInet), "Protocol family to send in CONNECT message")
+	port := flag.Uint("port", 2525, "Port to send in CONNECT message")
+	connAddr := flag.String("conn-addr", "127.0.0.1", "Connection address to send in CONNECT message")
+	helo := flag.String("helo", "localhost", "Value to send in HELO message")
+	mailFrom := flag.String("from", "foxcpp@example.org", "Value to send in MAIL message")
+	rcptTo := flag.String("rcpt", "foxcpp@example.com", "Comma-separated list of values for RCPT messages")
+	mbox := flag.String("mbox", "", "Read the messages to send from this mbox file")
+	maildir := flag.String("maildir", "", "Read the messages to send from this maildir")
+	flag.Parse()
+
+	clientA := milter.NewClient(*transportA, *addressA)
+	clientB := milter.NewClient(*transportB, *addressB)
+	env := envelope{
+		hostname: *hostname,
+		family:   milter.ProtoFamily((*family)[0]),
+		port:     uint16(*port),
+		connAddr: *connAddr,
+		helo:     *helo,
+		mailFrom: *mailFrom,
+		rcptTo:   strings.Split(*rcptTo, ","),
+	}
+
+	count, differences := 0, 0
+	handleMessage := func(r io.Reader) error {
+		count++
+		msg, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		a, err := transcript(clientA, env, msg)
+		if err != nil {
+			return fmt.Errorf("message %d: milter A: %w", count, err)
+		}
+		b, err := transcript(clientB, env, msg)
+		if err != nil {
+			return fmt.Errorf("message %d: milter B: %w", count, err)
+		}
+		if d := diff(a, b); d != nil {
+			differences++
+			fmt.Printf("message %d:\n", count)
+			for _, line := range d {
+				fmt.Println(line)
+			}
+		}
+		return nil
+	}
+
+	var err error
+	switch {
+	case *mbox != "":
+		err = corpus.ReadFile(*mbox, func(r io.Reader) error {
+			return corpus.ReadMbox(r, handleMessage)
+		})
+	case *maildir != "":
+		err = corpus.ReadMaildir(*maildir, handleMessage)
+	default:
+		err = handleMessage(os.Stdin)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d messages, %d with differences", count, differences)
+	if differences > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/d--j/go-milter"
+)
+
+type failingHeloMilter struct {
+	milter.NoOpMilter
+}
+
+func (failingHeloMilter) Helo(string, *milter.Modifier) (*milter.Response, error) {
+	return nil, errors.New("helo failed")
+}
+
+func Test_transcript(t *testing.T) {
+	t.Parallel()
+	lb := milter.NewLoopback()
+	server := milter.NewServer(milter.WithMilter(func() milter.Milter {
+		return failingHeloMilter{}
+	}))
+	go func() {
+		_ = server.Serve(lb)
+	}()
+	defer server.Close()
+	client := milter.NewClient("loopback", "", milter.WithDialer(lb))
+	env := envelope{hostname: "localhost", family: milter.FamilyInet, port: 2525, connAddr: "127.0.0.1", helo: "localhost", mailFrom: "from@example.org", rcptTo: []string{"to@example.org"}}
+	lines, err := transcript(client, env, []byte("Subject: test\r\n\r\nbody\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != "CONNECT: continue" || !strings.HasPrefix(lines[1], "HELO: error: ") || len(lines[1]) == len("HELO: error: ") {
+		t.Fatalf("transcript() = %q", lines)
+	}
+}
@@ -0,0 +1,206 @@
+package milter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// maxRecentErrors is the number of errors a [Server] remembers for [Server.DebugHandler].
+const maxRecentErrors = 50
+
+// SessionInfo is a snapshot of one MTA connection of a [Server].
+type SessionInfo struct {
+	// ConnID is the connection ID of the session (see [Modifier.ConnID]).
+	ConnID uint64 `json:"conn_id"`
+	// MessageSeq is the sequence number of the current (or last) message of the session (see [Modifier.MessageSeq]).
+	MessageSeq uint64 `json:"message_seq"`
+	// RemoteAddr is the address of the MTA.
+	RemoteAddr string `json:"remote_addr"`
+	// Started is the time the connection got accepted.
+	Started time.Time `json:"started"`
+	// LastActivity is the time the last command of the MTA got received.
+	LastActivity time.Time `json:"last_activity"`
+	// Stage is the name of the last command of the MTA, e.g. "rcpt" or "body".
+	Stage string `json:"stage"`
+	// Version is the negotiated protocol version.
+	Version uint32 `json:"version"`
+	// Actions are the negotiated actions.
+	Actions OptAction `json:"actions"`
+	// Protocol are the negotiated protocol options.
+	Protocol OptProtocol `json:"protocol"`
+	// MaxData is the negotiated maximum data size.
+	MaxData DataSize `json:"max_data"`
+	// Class is the name of the [SessionClass] of the connection (see [WithSessionClasses]).
+	Class string `json:"class,omitempty"`
+	// Reuses is the number of times the MTA reused the connection for another SMTP connection (see [ResettableMilter]).
+	Reuses uint64 `json:"reuses"`
+	// ModificationBytes is the number of bytes of header and body modifications of the current (or last) message.
+	ModificationBytes int `json:"modification_bytes"`
+}
+
+// ErrorInfo is an error that happened in a session of a [Server].
+type ErrorInfo struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote_addr"`
+	Error      string    `json:"error"`
+}
+
+// DebugInfo is the JSON document that [Server.DebugHandler] serves.
+type DebugInfo struct {
+	State         string        `json:"state"`
+	ListenerStats ListenerStats `json:"listener_stats"`
+	Sessions      []SessionInfo `json:"sessions"`
+	RecentErrors  []ErrorInfo   `json:"recent_errors"`
+}
+
+// recentErrors is a ring buffer of the last errors of a [Server].
+type recentErrors struct {
+	mu     sync.Mutex
+	errors []ErrorInfo
+	next   int
+}
+
+func (r *recentErrors) add(info ErrorInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.errors) < maxRecentErrors {
+		r.errors = append(r.errors, info)
+		return
+	}
+	r.errors[r.next] = info
+	r.next = (r.next + 1) % maxRecentErrors
+}
+
+// list returns the errors, the oldest error first.
+func (r *recentErrors) list() []ErrorInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]ErrorInfo, 0, len(r.errors))
+	list = append(list, r.errors[r.next:]...)
+	list = append(list, r.errors[:r.next]...)
+	return list
+}
+
+// Sessions returns snapshots of the MTA connections that s currently handles, the oldest connection first.
+func (s *Server) Sessions() []SessionInfo {
+	s.mu.Lock()
+	sessions := make([]*serverSession, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, session.snapshot())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// DebugHandler returns a [http.Handler] that serves a [DebugInfo] JSON document with the state of s,
+// its current sessions and its recent errors.
+// You can mount it in the HTTP server of your application to troubleshoot stuck sessions.
+// The handler does not do any authentication, do not expose it to untrusted networks.
+func (s *Server) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := DebugInfo{
+			State:         s.State().String(),
+			ListenerStats: s.ListenerStats(),
+			Sessions:      s.Sessions(),
+			RecentErrors:  s.recentErrors.list(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(info)
+	})
+}
+
+// sessionState is the part of a serverSession that [Server.Sessions] reads concurrently.
+type sessionState struct {
+	mu   sync.Mutex
+	info SessionInfo
+}
+
+// touch records that the MTA sent a command with code.
+func (m *serverSession) touch(code wire.Code) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.state.info.LastActivity = time.Now()
+	if code != wire.CodeMacro {
+		m.state.info.Stage = commandName(code)
+	}
+}
+
+// syncInfo records the negotiated values and the class of m.
+func (m *serverSession) syncInfo() {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.state.info.Version = m.version
+	m.state.info.Actions = m.actions
+	m.state.info.Protocol = m.protocol
+	m.state.info.MaxData = m.maxDataSize
+	m.state.info.Class = m.class
+}
+
+func (m *serverSession) snapshot() SessionInfo {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	return m.state.info
+}
+
+// logError logs err and remembers it for [Server.DebugHandler].
+func (m *serverSession) logError(format string, err error) {
+	m.logWarning(format, err)
+	m.server.recentErrors.add(ErrorInfo{
+		Time:       time.Now(),
+		RemoteAddr: m.state.info.RemoteAddr, // RemoteAddr does not change, no need to lock
+		Error:      fmt.Sprintf(format, err),
+	})
+}
+
+// commandName returns a human-readable name of the command code.
+func commandName(code wire.Code) string {
+	switch code {
+	case wire.CodeOptNeg:
+		return "negotiate"
+	case wire.CodeConn:
+		return "connect"
+	case wire.CodeHelo:
+		return "helo"
+	case wire.CodeMail:
+		return "mail"
+	case wire.CodeRcpt:
+		return "rcpt"
+	case wire.CodeData:
+		return "data"
+	case wire.CodeHeader:
+		return "header"
+	case wire.CodeEOH:
+		return "end of header"
+	case wire.CodeBody:
+		return "body"
+	case wire.CodeEOB:
+		return "end of message"
+	case wire.CodeUnknown:
+		return "unknown"
+	case wire.CodeMacro:
+		return "macro"
+	case wire.CodeAbort:
+		return "abort"
+	case wire.CodeQuitNewConn:
+		return "quit new connection"
+	case wire.CodeQuit:
+		return "quit"
+	default:
+		return fmt.Sprintf("%c", code)
+	}
+}
@@ -0,0 +1,62 @@
+package milter
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_DebugHandler(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithActions(OptAddHeader)}, nil)
+	defer w.Cleanup()
+
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+
+	rec := httptest.NewRecorder()
+	w.server.DebugHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var info DebugInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.State != "serving" || info.ListenerStats.Accepted != 1 {
+		t.Fatalf("got %+v", info)
+	}
+	if len(info.Sessions) != 1 {
+		t.Fatalf("got %d sessions", len(info.Sessions))
+	}
+	session := info.Sessions[0]
+	if session.Stage != "mail" || session.Version != MaxServerProtocolVersion || session.Actions != OptAddHeader || session.RemoteAddr == "" {
+		t.Fatalf("got session %+v", session)
+	}
+}
+
+func TestRecentErrors(t *testing.T) {
+	t.Parallel()
+	r := recentErrors{}
+	for i := 0; i < maxRecentErrors+2; i++ {
+		r.add(ErrorInfo{Error: string(rune('a' + i%26))})
+	}
+	list := r.list()
+	if len(list) != maxRecentErrors {
+		t.Fatalf("got %d errors", len(list))
+	}
+	if list[0].Error != "c" || list[len(list)-1].Error != string(rune('a'+(maxRecentErrors+1)%26)) {
+		t.Fatalf("wrong order: %q … %q", list[0].Error, list[len(list)-1].Error)
+	}
+}
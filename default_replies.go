@@ -0,0 +1,42 @@
+package milter
+
+import "github.com/d--j/go-milter/internal/wire"
+
+// WithDefaultReplies sets the SMTP replies that the [Server] sends instead of [RespReject] and [RespTempFail].
+// Use it to brand or localize the replies of all your handlers in one place. Create the replies with [RejectWithCodeAndReason],
+// reject needs a 5xx code and tempFail a 4xx code. A nil reply keeps the default reply of the MTA.
+//
+// The replacement applies to every [RespReject] and [RespTempFail] that the [Server] sends, including the ones
+// the [Server] itself sends (e.g. when the session lifetime of [WithServerTimeouts] ran out).
+// The replaced responses get counted as ReplyCode in [ServerStats].
+//
+// This is a [Server] only [Option].
+func WithDefaultReplies(reject, tempFail *Response) Option {
+	return func(h *options) {
+		h.rejectReply = reject
+		h.tempFailReply = tempFail
+	}
+}
+
+// validDefaultReply checks that resp is nil or an SMTP reply with a code that starts with class.
+func validDefaultReply(resp *Response, class byte) bool {
+	if resp == nil {
+		return true
+	}
+	return wire.ActionCode(resp.code) == wire.ActReplyCode && len(resp.data) > 0 && resp.data[0] == class
+}
+
+// defaultReply returns the reply of [WithDefaultReplies] for resp, or resp when there is none.
+func (m *serverSession) defaultReply(resp *Response) *Response {
+	switch wire.ActionCode(resp.code) {
+	case wire.ActReject:
+		if reply := m.server.options.rejectReply; reply != nil {
+			return reply
+		}
+	case wire.ActTempFail:
+		if reply := m.server.options.tempFailReply; reply != nil {
+			return reply
+		}
+	}
+	return resp
+}
@@ -0,0 +1,65 @@
+package milter
+
+import (
+	"testing"
+)
+
+func TestWithDefaultReplies(t *testing.T) {
+	reject := mustRejectWithCodeAndReason(550, "5.7.1 Message refused by Example Corp")
+	opt := options{}
+	WithDefaultReplies(reject, nil)(&opt)
+	if opt.rejectReply != reject || opt.tempFailReply != nil {
+		t.Fatalf("unexpected options %+v", opt)
+	}
+	for _, opts := range [][]Option{
+		{WithDefaultReplies(RespReject, nil)},
+		{WithDefaultReplies(nil, reject)},
+		{WithDefaultReplies(mustRejectWithCodeAndReason(451, "4.7.1 Try again"), nil)},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewServer() did not panic")
+				}
+			}()
+			NewServer(append(opts, WithMilter(func() Milter { return NoOpMilter{} }))...)
+		}()
+	}
+}
+
+func TestServer_WithDefaultReplies(t *testing.T) {
+	t.Parallel()
+	mm := &MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		RcptResp: RespReject,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return mm
+	}), WithDefaultReplies(
+		mustRejectWithCodeAndReason(550, "5.7.1 Message refused by Example Corp"),
+		mustRejectWithCodeAndReason(451, "4.7.1 Example Corp is busy, try again later"),
+	)}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionRejectWithCode)
+	if act.SMTPCode != 550 || act.SMTPReply != "550 5.7.1 Message refused by Example Corp" {
+		t.Fatalf("got %+v", act)
+	}
+	mm.RcptResp = RespTempFail
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionRejectWithCode)
+	if act.SMTPCode != 451 || act.SMTPReply != "451 4.7.1 Example Corp is busy, try again later" {
+		t.Fatalf("got %+v", act)
+	}
+	if stats := w.server.Stats(); stats.ReplyCode != 2 || stats.Reject != 0 || stats.TempFail != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,109 @@
+package milter
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDeferredModifierClosed is returned by the methods of [DeferredModifier] after [Milter.EndOfMessage] returned
+// or [DeferredModifier.Close] got called.
+var ErrDeferredModifierClosed = errors.New("milter: deferred modifier used after end of message")
+
+// DeferredModifier is a handle to a [Modifier] that other goroutines can use while [Milter.EndOfMessage] waits for them.
+// See [Modifier.Defer].
+type DeferredModifier struct {
+	mu     sync.Mutex
+	m      *Modifier
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Defer returns a handle to m that is safe to use from other goroutines. Use it when your [Milter.EndOfMessage] callback
+// hands off work to other goroutines (e.g. queries to external services) and those want to modify the message directly.
+//
+// The handle serializes all modifications, so only one goroutine at a time talks to the MTA.
+// When keepAlive is positive the handle sends a progress packet (see [Modifier.Progress]) to the MTA every keepAlive
+// until it gets closed, so the MTA does not time out while you wait.
+//
+// The [Server] closes the handle when [Milter.EndOfMessage] returns: the MTA does not accept modifications after the
+// end-of-message response. Your callback must wait for its goroutines; modifications that arrive later
+// fail with [ErrDeferredModifierClosed]. Do not use m directly while other goroutines use the handle.
+// Handles of modifiers created with [NewTestModifier] or [NewRecordingModifier] need to get closed with [DeferredModifier.Close].
+//
+// Only the [Modifier] of [Milter.EndOfMessage] can modify the message. Defer of the [Modifier] of any other callback
+// returns a closed handle: it does not send progress packets and all its methods return [ErrDeferredModifierClosed].
+func (m *Modifier) Defer(keepAlive time.Duration) *DeferredModifier {
+	d := &DeferredModifier{m: m, done: make(chan struct{})}
+	if m.readOnly {
+		// the server only closes the handles of EndOfMessage, do not start a keep-alive goroutine that nobody stops
+		d.closed = true
+		close(d.done)
+		return d
+	}
+	m.deferred = append(m.deferred, d)
+	if keepAlive > 0 {
+		d.wg.Add(1)
+		go d.keepAlive(keepAlive)
+	}
+	return d
+}
+
+func (d *DeferredModifier) keepAlive(interval time.Duration) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-d.done:
+			return
+		case <-d.m.Context().Done():
+			return
+		}
+		if err := d.Progress(); err != nil {
+			return
+		}
+	}
+}
+
+// Do calls fn with the [Modifier] of d. Calls of Do (and [DeferredModifier.Progress]) of all goroutines run one after the other.
+// When d is closed Do does not call fn and returns [ErrDeferredModifierClosed].
+func (d *DeferredModifier) Do(fn func(m *Modifier) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrDeferredModifierClosed
+	}
+	return fn(d.m)
+}
+
+// Progress tells the MTA that there is progress in a long operation (see [Modifier.Progress]).
+func (d *DeferredModifier) Progress() error {
+	return d.Do(func(m *Modifier) error {
+		return m.Progress()
+	})
+}
+
+// Close closes d. It waits for a running [DeferredModifier.Do] call and stops the keep-alive progress packets.
+// Calling Close more than once is allowed.
+func (d *DeferredModifier) Close() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.done)
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// closeDeferred closes all handles of [Modifier.Defer] of m.
+func (m *Modifier) closeDeferred() {
+	for _, d := range m.deferred {
+		d.Close()
+	}
+	m.deferred = nil
+}
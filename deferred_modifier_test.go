@@ -0,0 +1,108 @@
+package milter
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestModifier_Defer(t *testing.T) {
+	t.Parallel()
+	var handle *DeferredModifier
+	mm := &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			handle = m.Defer(0)
+			var wg sync.WaitGroup
+			for _, name := range []string{"X-A", "X-B"} {
+				name := name
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_ = handle.Do(func(m *Modifier) error {
+						return m.AddHeader(name, "1")
+					})
+				}()
+			}
+			wg.Wait()
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return mm }), WithAction(OptAddHeader)}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	modifyActs, act, err := w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if len(modifyActs) != 2 || modifyActs[0].Type != ActionAddHeader || modifyActs[1].Type != ActionAddHeader {
+		t.Errorf("got modify actions %+v", modifyActs)
+	}
+	if err := handle.Progress(); !errors.Is(err, ErrDeferredModifierClosed) {
+		t.Errorf("Progress() after EndOfMessage = %v, want ErrDeferredModifierClosed", err)
+	}
+}
+
+func TestDeferredModifier_keepAlive(t *testing.T) {
+	t.Parallel()
+	rec := NewRecordingModifier(nil, OptAddHeader)
+	handle := rec.Defer(5 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if err := handle.Do(func(m *Modifier) error { return m.AddHeader("X-A", "1") }); err != nil {
+		t.Fatal(err)
+	}
+	handle.Close()
+	handle.Close()
+	progress := rec.ProgressCalls()
+	if progress == 0 {
+		t.Error("keep-alive did not send progress packets")
+	}
+	time.Sleep(15 * time.Millisecond)
+	if rec.ProgressCalls() != progress {
+		t.Error("keep-alive sent progress packets after Close")
+	}
+	if !reflect.DeepEqual(rec.Actions(), []ModifyAction{{Type: ActionAddHeader, HeaderName: "X-A", HeaderValue: "1"}}) {
+		t.Errorf("Actions() = %+v", rec.Actions())
+	}
+}
+
+func TestModifier_DeferOutsideEndOfMessage(t *testing.T) {
+	t.Parallel()
+	var handle *DeferredModifier
+	mm := &MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		HeloMod: func(m *Modifier) {
+			handle = m.Defer(time.Millisecond)
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return mm }), WithAction(OptAddHeader)}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	if err := handle.Progress(); !errors.Is(err, ErrDeferredModifierClosed) {
+		t.Errorf("Progress() of a handle of Helo = %v, want ErrDeferredModifierClosed", err)
+	}
+	// Close must not block: there is no keep-alive goroutine
+	handle.Close()
+}
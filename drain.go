@@ -0,0 +1,121 @@
+package milter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DrainOptions configures [Server.Drain].
+type DrainOptions struct {
+	// ConnectionDeadline is the time every live connection gets to finish its current message after the drain started.
+	// When it passed, the [Modifier.Context] of the connection gets cancelled and the connection gets closed.
+	// 0 means that the connections have time until the ctx of [Server.Drain] is done.
+	ConnectionDeadline time.Duration
+	// OnShutdown gets called for every connection that ends because of the drain, right before [Milter.Cleanup].
+	// Use it to flush caches or persist the state of backend before the process exits.
+	// It does not get called for connections that did not finish the option negotiation.
+	OnShutdown func(backend Milter)
+}
+
+// errDraining is returned by readPacketInto when the session should end because the server drains.
+var errDraining = errors.New("milter: server is draining")
+
+// sessionDrain is the part of a serverSession that [Server.Drain] changes concurrently.
+type sessionDrain struct {
+	mu         sync.Mutex
+	active     bool
+	deadline   time.Time
+	timer      *time.Timer
+	onShutdown func(backend Milter)
+}
+
+// Drain gracefully shuts down s. It closes all listeners of s and lets the in-flight messages of the active sessions finish.
+// Sessions end as soon as they do not process a message, so the MTA cannot start new messages.
+// With [DrainOptions.ConnectionDeadline] you can limit the time each session gets to finish its message.
+//
+// Drain waits until all sessions ended or ctx is done. If ctx is done before all sessions ended,
+// Drain cancels the context of the [Modifier] objects of the remaining sessions (see [Modifier.Context])
+// and returns the error of ctx.
+// While waiting the [Server.State] of s is [ServerDraining].
+func (s *Server) Drain(ctx context.Context, opts DrainOptions) error {
+	if err := s.Close(); err != nil && err != ErrServerClosed {
+		return err
+	}
+	s.mu.Lock()
+	if s.drain == nil {
+		s.drain = &opts
+		if opts.ConnectionDeadline > 0 {
+			s.drainDeadline = time.Now().Add(opts.ConnectionDeadline)
+		}
+	}
+	sessions := make([]*serverSession, 0, len(s.sessions))
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+	for _, session := range sessions {
+		s.beginDrain(session)
+	}
+	if err := s.waitForSessions(ctx); err != nil {
+		s.cancel()
+		return err
+	}
+	return nil
+}
+
+// beginDrain tells session that s drains. It does nothing when s does not drain.
+func (s *Server) beginDrain(session *serverSession) {
+	s.mu.Lock()
+	drain, deadline := s.drain, s.drainDeadline
+	s.mu.Unlock()
+	if drain != nil {
+		session.beginDrain(deadline, drain.OnShutdown)
+	}
+}
+
+// beginDrain marks m as draining. When m does not process a message its pending read gets interrupted,
+// otherwise the read deadline gets lowered to deadline (when it is set).
+func (m *serverSession) beginDrain(deadline time.Time, onShutdown func(backend Milter)) {
+	m.drain.mu.Lock()
+	defer m.drain.mu.Unlock()
+	if m.drain.active {
+		return
+	}
+	m.drain.active = true
+	m.drain.deadline = deadline
+	m.drain.onShutdown = onShutdown
+	if !deadline.IsZero() {
+		m.drain.timer = time.AfterFunc(time.Until(deadline), m.cancel)
+	}
+	if m.conn == nil {
+		return
+	}
+	if !m.inMessage {
+		_ = m.conn.SetReadDeadline(time.Now())
+	} else if !deadline.IsZero() {
+		_ = m.conn.SetReadDeadline(deadline)
+	}
+}
+
+// draining returns true when m ends because the server drains. deadlineExceeded is true when
+// the session took longer than [DrainOptions.ConnectionDeadline].
+func (m *serverSession) draining() (draining, deadlineExceeded bool) {
+	m.drain.mu.Lock()
+	defer m.drain.mu.Unlock()
+	return m.drain.active, m.drain.active && !m.drain.deadline.IsZero() && !time.Now().Before(m.drain.deadline)
+}
+
+// endDrain calls the OnShutdown callback of a draining session and stops its deadline timer.
+func (m *serverSession) endDrain() {
+	m.drain.mu.Lock()
+	active, onShutdown, timer := m.drain.active, m.drain.onShutdown, m.drain.timer
+	m.drain.mu.Unlock()
+	if timer != nil {
+		timer.Stop()
+	}
+	if active && onShutdown != nil && m.backend != nil {
+		onShutdown(m.backend)
+	}
+}
@@ -0,0 +1,70 @@
+package milter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// eomOverloadResponse is the default response for messages that did not get a slot of [WithMaxConcurrentEndOfMessage].
+func eomOverloadResponse() *Response {
+	return mustRejectWithCodeAndReason(451, "4.3.2 Server busy, try again later")
+}
+
+// acquireEndOfMessage waits for a free slot of [WithMaxConcurrentEndOfMessage].
+// It returns false when no slot got free in time or the connection of m ended while waiting.
+func (m *serverSession) acquireEndOfMessage() bool {
+	slots := m.server.eomSlots
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	timeout := m.server.options.eomQueueTimeout
+	if timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+// releaseEndOfMessage frees a slot of [WithMaxConcurrentEndOfMessage].
+func (m *serverSession) releaseEndOfMessage() {
+	if slots := m.server.eomSlots; slots != nil {
+		<-slots
+	}
+}
+
+// endOfMessage calls [Milter.EndOfMessage] when there is a free slot of [WithMaxConcurrentEndOfMessage].
+// Otherwise, it aborts the message and returns the overload response.
+func (m *serverSession) endOfMessage() (*Response, error) {
+	if !m.acquireEndOfMessage() {
+		atomic.AddUint64(&m.server.counters.eomOverloads, 1)
+		m.logWarning("rejecting message: too many concurrent end-of-message handlers")
+		resp := m.server.options.eomOverload
+		if resp == nil {
+			resp = eomOverloadResponse()
+		}
+		return resp, m.abort(AbortOverload)
+	}
+	defer m.releaseEndOfMessage()
+	stop := m.startAutoProgress()
+	defer stop()
+	mod := newModifier(m, false)
+	defer mod.closeDeferred()
+	resp, err := m.backend.EndOfMessage(mod)
+	if mod.batch != nil {
+		m.logWarning("discarding %d modifications of a batch that did not get committed", len(mod.batch.queue))
+	}
+	return resp, err
+}
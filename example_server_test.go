@@ -54,3 +54,41 @@ func ExampleServer() {
 	// quit when milter quits
 	wgDone.Wait()
 }
+
+// RcptFilter only implements the optional [milter.RcptToMilter] interface.
+type RcptFilter struct{}
+
+func (RcptFilter) RcptTo(rcptTo string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
+	if rcptTo == "other-spammer@example.com" {
+		return milter.RejectWithCodeAndReason(550, "We do not like you")
+	}
+	return milter.RespContinue, nil
+}
+
+func ExampleAsMilter() {
+	// the server tells the MTA to not send the events that RcptFilter does not handle
+	server := milter.NewServer(
+		milter.WithMilter(func() milter.Milter {
+			return milter.AsMilter(RcptFilter{})
+		}),
+	)
+	defer server.Close()
+}
+
+func ExampleBuilder() {
+	filter := milter.NewBuilder().
+		OnRcptTo(func(rcptTo string, esmtpArgs string, m *milter.Modifier) (*milter.Response, error) {
+			if rcptTo == "other-spammer@example.com" {
+				return milter.RejectWithCodeAndReason(550, "We do not like you")
+			}
+			return milter.RespContinue, nil
+		}).
+		OnEndOfMessage(func(m *milter.Modifier) (*milter.Response, error) {
+			return milter.RespAccept, m.AddHeader("X-Filtered", "yes")
+		})
+	server := milter.NewServer(
+		milter.WithMilter(filter.NewMilter),
+		milter.WithAction(milter.OptAddHeader),
+	)
+	defer server.Close()
+}
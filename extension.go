@@ -0,0 +1,107 @@
+package milter
+
+import (
+	"fmt"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// ExtensionReplyHandler gets called by a [ClientSession] for milter replies with codes that this library does not know.
+// Use [WithExtensionReplyHandler] to implement vendor-specific extensions of the milter protocol.
+// data is only valid until the handler returns. When the handler returns an error the session fails with this error.
+type ExtensionReplyHandler func(code byte, data []byte) error
+
+// ExtensionCommandHandler gets called by a [Server] for MTA commands with codes that this library does not know.
+// Use [WithExtensionCommandHandler] to implement vendor-specific extensions of the milter protocol.
+//
+// backend is the [Milter] of the connection, data is only valid until the handler returns.
+// The returned [Response] gets sent to the MTA (use [NewExtensionResponse] for vendor-specific replies).
+// Return nil when the command does not need a reply. When the handler returns an error the connection gets closed.
+type ExtensionCommandHandler func(code byte, data []byte, backend Milter) (*Response, error)
+
+// NewExtensionResponse returns a [Response] with an arbitrary code and data. Use it in an [ExtensionCommandHandler].
+func NewExtensionResponse(code byte, data []byte) *Response {
+	return newResponse(wire.Code(code), data)
+}
+
+// isKnownCommandCode returns true when code is a command of the milter protocol.
+func isKnownCommandCode(code wire.Code) bool {
+	switch code {
+	case wire.CodeOptNeg, wire.CodeMacro, wire.CodeConn, wire.CodeQuit, wire.CodeHelo, wire.CodeMail, wire.CodeRcpt,
+		wire.CodeHeader, wire.CodeEOH, wire.CodeBody, wire.CodeEOB, wire.CodeAbort, wire.CodeData, wire.CodeQuitNewConn, wire.CodeUnknown:
+		return true
+	}
+	return false
+}
+
+// handleExtensionReply passes msg to the [ExtensionReplyHandler] of s. It returns true when the handler handled msg.
+func (s *ClientSession) handleExtensionReply(msg *wire.Message) (bool, error) {
+	if s.extensionHandler == nil || isKnownReplyCode(msg.Code) {
+		return false, nil
+	}
+	if err := s.extensionHandler(byte(msg.Code), msg.Data); err != nil {
+		return false, fmt.Errorf("milter: extension reply %q: %w", msg.Code, err)
+	}
+	return true, nil
+}
+
+// SendExtension sends a vendor-specific command to the milter. It does not wait for a reply.
+// Replies of the milter to it get passed to the [ExtensionReplyHandler] of [WithExtensionReplyHandler]
+// when s reads the reply of the next regular command.
+// Use [ClientSession.ExtensionRequest] when you need the reply right away.
+//
+// code cannot be a code of a command of the milter protocol.
+func (s *ClientSession) SendExtension(code byte, data []byte) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+	return s.doSendExtension("send extension", code, data)
+}
+
+// ExtensionRequest sends a vendor-specific command to the milter and returns the code and data of the reply of the milter.
+// The returned data is only valid until the next method of s gets called.
+//
+// code cannot be a code of a command of the milter protocol.
+func (s *ClientSession) ExtensionRequest(code byte, data []byte) (byte, []byte, error) {
+	if err := s.enter(); err != nil {
+		return 0, nil, err
+	}
+	defer s.leave()
+	if err := s.doSendExtension("extension request", code, data); err != nil {
+		return 0, nil, err
+	}
+	msg := &s.msg
+	for {
+		if err := wire.ReadPacketInto(s.conn, msg, s.readTimeout); err != nil {
+			return 0, nil, s.errorOut(fmt.Errorf("milter: extension request: read: %w", err))
+		}
+		if wire.ActionCode(msg.Code) != wire.ActProgress {
+			return byte(msg.Code), msg.Data, nil
+		}
+	}
+}
+
+func (s *ClientSession) doSendExtension(command string, code byte, data []byte) error {
+	if s.state == clientStateClosed || s.state == clientStateError {
+		return s.stateError(command)
+	}
+	if isKnownCommandCode(wire.Code(code)) {
+		return fmt.Errorf("milter: %s: %q is a code of the milter protocol", command, code)
+	}
+	if err := s.writePacket(&wire.Message{Code: wire.Code(code), Data: data}); err != nil {
+		return s.errorOut(fmt.Errorf("milter: %s: %w", command, err))
+	}
+	return nil
+}
+
+// SendExtension sends a vendor-specific packet to the MTA.
+// You can use it in [Milter.EndOfMessage] to send modification actions this library does not know.
+//
+// code cannot be a code of a reply of the milter protocol.
+func (m *Modifier) SendExtension(code byte, data []byte) error {
+	if isKnownReplyCode(wire.Code(code)) {
+		return fmt.Errorf("milter: send extension: %q is a code of the milter protocol", code)
+	}
+	return m.writePacket(&wire.Message{Code: wire.Code(code), Data: data})
+}
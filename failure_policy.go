@@ -0,0 +1,67 @@
+package milter
+
+// FailurePolicy defines what a [ClientSession] does when the milter fails.
+// Use [WithOnError] to set the policy of a [Client].
+//
+// The policies mirror the F= flags of sendmail milter definitions.
+type FailurePolicy int
+
+const (
+	// FailError returns the error to the caller. This is the default.
+	FailError FailurePolicy = iota
+	// FailAccept acts as if the milter accepted the message. This is the sendmail default when no F= flag is set.
+	FailAccept
+	// FailTempFail acts as if the milter temporarily rejected the message (sendmail F=T).
+	FailTempFail
+	// FailReject acts as if the milter rejected the message (sendmail F=R).
+	FailReject
+)
+
+// action returns the synthesized [Action] of p.
+func (p FailurePolicy) action() *Action {
+	switch p {
+	case FailAccept:
+		return &Action{Type: ActionAccept}
+	case FailTempFail:
+		return &Action{Type: ActionTempFail, SMTPCode: 451, SMTPReply: "451 4.7.1 Service unavailable - try again later"}
+	case FailReject:
+		return &Action{Type: ActionReject, SMTPCode: 550, SMTPReply: "550 5.7.1 Command rejected"}
+	default:
+		return nil
+	}
+}
+
+// failed returns true when err should be replaced by the [Action] of the [FailurePolicy] of s.
+func (s *ClientSession) failed(err error) bool {
+	return err != nil && s.milterErr != nil && s.failurePolicy != FailError
+}
+
+// failSafe applies the [FailurePolicy] of s to the result of a command.
+func (s *ClientSession) failSafe(act *Action, err error) (*Action, error) {
+	if s.failed(err) {
+		return s.failurePolicy.action(), nil
+	}
+	return act, err
+}
+
+// failSafeEnd is failSafe for commands that also return modification actions.
+func (s *ClientSession) failSafeEnd(modifyActs []ModifyAction, act *Action, err error) ([]ModifyAction, *Action, error) {
+	if s.failed(err) {
+		return nil, s.failurePolicy.action(), nil
+	}
+	return modifyActs, act, err
+}
+
+// failSafeErr is failSafe for commands that only return an error.
+func (s *ClientSession) failSafeErr(err error) error {
+	if s.failed(err) {
+		return nil
+	}
+	return err
+}
+
+// Err returns the error that broke the connection to the milter or nil if the connection did not break.
+// When you use [WithOnError] the methods of s do not return this error, so you can use Err to log it.
+func (s *ClientSession) Err() error {
+	return s.milterErr
+}
@@ -0,0 +1,96 @@
+package milter
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrHeaderNotFound is returned by [Modifier.ChangeHeaderValue] when the message does not have the requested header field.
+var ErrHeaderNotFound = errors.New("milter: header field not found")
+
+// headerOccurrences counts the header fields of the current message per canonical name (see Modifier.ChangeHeaderValue).
+type headerOccurrences struct {
+	// seen is the number of header fields that the MTA sent per lower-case name
+	seen map[string]int
+	// deleted are the occurrences per lower-case name that got deleted with ChangeHeaderValue
+	deleted map[string][]int
+}
+
+// add records the header field name.
+func (h *headerOccurrences) add(name string) {
+	if h.seen == nil {
+		h.seen = make(map[string]int)
+	}
+	h.seen[strings.ToLower(name)]++
+}
+
+// reset prepares h for the next message.
+func (h *headerOccurrences) reset() {
+	h.seen = nil
+	h.deleted = nil
+}
+
+// index returns the header index for ChangeHeader of occurrence of the header field name.
+// A negative occurrence counts from the last header field of this name.
+// When shift is true, deletions of earlier occurrences shift the index.
+func (h *headerOccurrences) index(name string, occurrence int, shift bool) (int, error) {
+	key := strings.ToLower(name)
+	count := h.seen[key]
+	if occurrence < 0 {
+		occurrence = count + 1 + occurrence
+	}
+	if occurrence < 1 || occurrence > count {
+		return 0, ErrHeaderNotFound
+	}
+	index := occurrence
+	for _, deleted := range h.deleted[key] {
+		if deleted == occurrence {
+			return 0, ErrHeaderNotFound
+		}
+		if shift && deleted < occurrence {
+			index--
+		}
+	}
+	return index, nil
+}
+
+// markDeleted records that occurrence of the header field name got deleted.
+func (h *headerOccurrences) markDeleted(name string, occurrence int) {
+	key := strings.ToLower(name)
+	if occurrence < 0 {
+		occurrence = h.seen[key] + 1 + occurrence
+	}
+	if h.deleted == nil {
+		h.deleted = make(map[string][]int)
+	}
+	h.deleted[key] = append(h.deleted[key], occurrence)
+}
+
+// ChangeHeaderValue changes the occurrence-th header field with the name name (1-based, case-insensitive) to value.
+// A negative occurrence counts from the end: -1 is the last header field with this name.
+// To delete the header field pass an empty value.
+//
+// Unlike [Modifier.ChangeHeader] you do not need to count the header fields yourself:
+// the [Server] remembers the header fields that the MTA sent for the current message (even when [Milter.Header] does not see them).
+// ChangeHeaderValue also takes care of the different index semantics of MTAs after a deletion (see [MTAInfo.DeleteShiftsHeaderIndexes]),
+// as long as you delete header fields only with ChangeHeaderValue.
+// It returns [ErrHeaderNotFound] when there is no such header field (or it got deleted already), instead of adding a new header field.
+//
+// For modifiers created with [NewTestModifier] ChangeHeaderValue is the same as [Modifier.ChangeHeader].
+func (m *Modifier) ChangeHeaderValue(name string, occurrence int, value string) error {
+	if m.session == nil {
+		return m.ChangeHeader(occurrence, name, value)
+	}
+	occurrences := &m.session.headerOccurrences
+	index, err := occurrences.index(name, occurrence, m.MTAInfo().DeleteShiftsHeaderIndexes())
+	if err != nil {
+		return err
+	}
+	if err = m.ChangeHeader(index, name, value); err != nil {
+		return err
+	}
+	if value == "" {
+		occurrences.markDeleted(name, occurrence)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package milter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestModifier_ChangeHeaderValue(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		version string
+		want    []ModifyAction
+	}{
+		{"sendmail", "8.17.1", []ModifyAction{
+			{Type: ActionChangeHeader, HeaderIndex: 1, HeaderName: "X-A"},
+			{Type: ActionChangeHeader, HeaderIndex: 3, HeaderName: "x-a", HeaderValue: "last"},
+			{Type: ActionChangeHeader, HeaderIndex: 1, HeaderName: "Subject", HeaderValue: "changed"},
+		}},
+		{"postfix", "Postfix 3.7.2", []ModifyAction{
+			{Type: ActionChangeHeader, HeaderIndex: 1, HeaderName: "X-A"},
+			{Type: ActionChangeHeader, HeaderIndex: 2, HeaderName: "x-a", HeaderValue: "last"},
+			{Type: ActionChangeHeader, HeaderIndex: 1, HeaderName: "Subject", HeaderValue: "changed"},
+		}},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var errs []error
+			mm := &MockMilter{
+				ConnResp:      RespContinue,
+				HeloResp:      RespContinue,
+				MailResp:      RespContinue,
+				RcptResp:      RespContinue,
+				DataResp:      RespContinue,
+				HdrResp:       RespContinue,
+				HdrsResp:      RespContinue,
+				BodyChunkResp: RespContinue,
+				BodyResp:      RespAccept,
+				BodyMod: func(m *Modifier) {
+					errs = append(errs,
+						m.ChangeHeaderValue("X-A", 1, ""),
+						m.ChangeHeaderValue("x-a", -1, "last"),
+						m.ChangeHeaderValue("Subject", 1, "changed"),
+						m.ChangeHeaderValue("X-A", 1, "deleted"),
+						m.ChangeHeaderValue("X-A", 4, "missing"),
+						m.ChangeHeaderValue("X-Missing", -1, "missing"),
+					)
+				},
+			}
+			macros := NewMacroBag()
+			macros.Set(MacroMTAVersion, tt.version)
+			w := newServerClient(t, macros, []Option{WithMilter(func() Milter { return mm }), WithAction(OptChangeHeader), WithMacroRequest(StageConnect, []MacroName{MacroMTAVersion})}, nil)
+			defer w.Cleanup()
+			act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo("helo_host")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Mail("from@example.org", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Rcpt("to@example.org", "")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.DataStart()
+			assertAction(t, act, err, ActionContinue)
+			for _, h := range [][2]string{{"X-A", "1"}, {"Subject", "test"}, {"x-a", "2"}, {"X-a", "3"}} {
+				act, err = w.session.HeaderField(h[0], h[1], nil)
+				assertAction(t, act, err, ActionContinue)
+			}
+			act, err = w.session.HeaderEnd()
+			assertAction(t, act, err, ActionContinue)
+			modifyActs, act, err := w.session.End()
+			assertAction(t, act, err, ActionAccept)
+			if !reflect.DeepEqual(modifyActs, tt.want) {
+				t.Errorf("got modify actions %+v, want %+v", modifyActs, tt.want)
+			}
+			for i, err := range errs {
+				if wantErr := i >= 3; (err != nil) != wantErr || (wantErr && !errors.Is(err, ErrHeaderNotFound)) {
+					t.Errorf("ChangeHeaderValue() #%d error = %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestModifier_ChangeHeaderValueWithoutHeaderCallback(t *testing.T) {
+	t.Parallel()
+	var changeErr error
+	newMilter := NewBuilder().OnEndOfMessage(func(m *Modifier) (*Response, error) {
+		changeErr = m.ChangeHeaderValue("Subject", 1, "changed")
+		return RespAccept, nil
+	}).NewMilter
+	w := newServerClient(t, nil, []Option{WithMilter(newMilter), WithAction(OptChangeHeader)}, nil)
+	defer w.Cleanup()
+	if w.session.ProtocolOption(OptNoHeaders) {
+		t.Fatal("server negotiated OptNoHeaders")
+	}
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderField("Subject", "test", nil)
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	modifyActs, act, err := w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if changeErr != nil {
+		t.Fatalf("ChangeHeaderValue() = %v", changeErr)
+	}
+	if want := []ModifyAction{{Type: ActionChangeHeader, HeaderIndex: 1, HeaderName: "Subject", HeaderValue: "changed"}}; !reflect.DeepEqual(modifyActs, want) {
+		t.Errorf("got modify actions %+v, want %+v", modifyActs, want)
+	}
+}
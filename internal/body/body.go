@@ -12,7 +12,12 @@ import (
 //
 // If maxMem is less than 1 a temporary file gets always used.
 func New(maxMem int) *Body {
-	return &Body{maxMem: maxMem}
+	return &Body{maxMem: maxMem, pattern: "body-*"}
+}
+
+// NewIn creates a new Body like [New] that creates its temporary file in dir with [os.CreateTemp] and pattern.
+func NewIn(maxMem int, dir, pattern string) *Body {
+	return &Body{maxMem: maxMem, dir: dir, pattern: pattern}
 }
 
 // Body is an [io.ReadSeekCloser] and [io.Writer] that starts buffering all data written to it in memory
@@ -22,6 +27,8 @@ func New(maxMem int) *Body {
 // Body is an [io.Seeker] so you can read it multiple times or get the size of the Body.
 type Body struct {
 	maxMem  int
+	dir     string
+	pattern string
 	buf     bytes.Buffer
 	mem     *bytes.Reader
 	file    *os.File
@@ -39,7 +46,7 @@ func (b *Body) Write(p []byte) (n int, err error) {
 	}
 	n, _ = b.buf.Write(p)
 	if b.buf.Len() > b.maxMem {
-		b.file, err = os.CreateTemp("", "body-*")
+		b.file, err = os.CreateTemp(b.dir, b.pattern)
 		if err != nil {
 			return
 		}
@@ -106,3 +113,15 @@ func (b *Body) Seek(offset int64, whence int) (int64, error) {
 	}
 	return b.mem.Seek(offset, whence)
 }
+
+// ReadAt implements the io.ReaderAt interface. It does not change the read position of b.
+// After calling ReadAt you cannot call Write anymore.
+func (b *Body) ReadAt(p []byte, off int64) (n int, err error) {
+	if err := b.switchToReading(); err != nil {
+		return 0, err
+	}
+	if b.file != nil {
+		return b.file.ReadAt(p, off)
+	}
+	return b.mem.ReadAt(p, off)
+}
@@ -156,3 +156,26 @@ func TestBody(t *testing.T) {
 		}
 	})
 }
+
+func TestBody_ReadAt(t *testing.T) {
+	for _, maxMem := range []int{100, 2} {
+		b := getBody(maxMem, []byte("0123456789"))
+		var buf [4]byte
+		if _, err := b.Read(buf[:2]); err != nil {
+			t.Fatal("b.Read got error", err)
+		}
+		n, err := b.ReadAt(buf[:], 4)
+		if err != nil || string(buf[:n]) != "4567" {
+			t.Fatalf("maxMem %d: b.ReadAt got %q, %v", maxMem, buf[:n], err)
+		}
+		n, err = b.ReadAt(buf[:], 8)
+		if err != io.EOF || string(buf[:n]) != "89" {
+			t.Fatalf("maxMem %d: b.ReadAt got %q, %v", maxMem, buf[:n], err)
+		}
+		n, err = b.Read(buf[:])
+		if err != nil || string(buf[:n]) != "2345" {
+			t.Fatalf("maxMem %d: ReadAt changed the read position, b.Read got %q, %v", maxMem, buf[:n], err)
+		}
+		_ = b.Close()
+	}
+}
@@ -0,0 +1,86 @@
+// Package corpus reads collections of e-mail messages (mbox files and maildirs).
+package corpus
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ReadMbox calls fn for each message in the mbox r.
+// Lines starting with ">From " (with any number of '>') get unescaped (mboxrd format).
+func ReadMbox(r io.Reader, fn func(msg io.Reader) error) error {
+	br := bufio.NewReader(r)
+	var msg bytes.Buffer
+	started := false
+	flush := func() error {
+		if !started {
+			return nil
+		}
+		err := fn(bytes.NewReader(msg.Bytes()))
+		msg.Reset()
+		return err
+	}
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			switch {
+			case bytes.HasPrefix(line, []byte("From ")):
+				if err := flush(); err != nil {
+					return err
+				}
+				started = true
+			case started:
+				if unquoted := bytes.TrimLeft(line, ">"); len(unquoted) < len(line) && bytes.HasPrefix(unquoted, []byte("From ")) {
+					line = line[1:]
+				}
+				msg.Write(line)
+			}
+		}
+		if err == io.EOF {
+			return flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReadMaildir calls fn for each message in the new and cur subdirectories of the maildir dir.
+// The messages are sorted by their file name.
+func ReadMaildir(dir string, fn func(msg io.Reader) error) error {
+	var files []string
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, filepath.Join(dir, sub, e.Name()))
+			}
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.Base(files[i]) < filepath.Base(files[j])
+	})
+	for _, name := range files {
+		if err := ReadFile(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadFile calls fn with the content of the file name.
+func ReadFile(name string, fn func(msg io.Reader) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
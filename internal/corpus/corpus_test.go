@@ -0,0 +1,72 @@
+package corpus
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func collect(t *testing.T, got *[]string) func(msg io.Reader) error {
+	return func(msg io.Reader) error {
+		b, err := io.ReadAll(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		*got = append(*got, string(b))
+		return nil
+	}
+}
+
+func TestReadMbox(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		mbox string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"garbage before first From", "garbage\n", nil},
+		{"one", "From a@b Mon Jan  1 00:00:00 2024\nSubject: one\n\nbody\n", []string{"Subject: one\n\nbody\n"}},
+		{"two", "From a@b Mon Jan  1 00:00:00 2024\nSubject: one\n\nbody\nFrom a@b Mon Jan  1 00:00:00 2024\nSubject: two\n\nbody", []string{"Subject: one\n\nbody\n", "Subject: two\n\nbody"}},
+		{"unescape", "From a@b Mon Jan  1 00:00:00 2024\n\n>From me\n>>From you\n>Fromage\n", []string{"\nFrom me\n>From you\n>Fromage\n"}},
+	}
+	for _, tt_ := range tests {
+		tt := tt_
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var got []string
+			if err := ReadMbox(strings.NewReader(tt.mbox), collect(t, &got)); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadMbox() got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMaildir(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	for name, content := range map[string]string{"new/2": "two", "cur/1:2,S": "one", "tmp/3": "three"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	if err := ReadMaildir(dir, collect(t, &got)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"one", "two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadMaildir() got %q, want %q", got, want)
+	}
+	if err := ReadMaildir(filepath.Join(dir, "missing"), collect(t, &got)); err == nil {
+		t.Error("ReadMaildir() expected error for missing maildir")
+	}
+}
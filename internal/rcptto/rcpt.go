@@ -18,14 +18,17 @@ func Has(rcptTos []*addr.RcptTo, rcptTo string) bool {
 }
 
 // Add adds rcptTo with esmtpArgs to the slice rcptTos and returns the new slice.
-// If rcptTo is already in rcptTos, it is not added a second time. In this case the exiting ESMTP argument gets updated.
+// If rcptTo is already in rcptTos, it is not added a second time. In this case the exiting ESMTP argument gets updated
+// when esmtpArgs is not empty. An empty esmtpArgs keeps the ESMTP arguments (e.g. NOTIFY or ORCPT) of the existing recipient.
 func Add(rcptTos []*addr.RcptTo, rcptTo string, esmtpArgs string) (out []*addr.RcptTo) {
 	out = rcptTos
 	addR := addr.NewRcptTo(rcptTo, esmtpArgs, "new")
 	findLocal, findDomain := addR.Local(), addR.AsciiDomain()
 	for i, r := range out {
 		if r.Local() == findLocal && r.AsciiDomain() == findDomain {
-			out[i].Args = esmtpArgs
+			if esmtpArgs != "" {
+				out[i].Args = esmtpArgs
+			}
 			return
 		}
 	}
@@ -111,6 +111,7 @@ func TestAdd(t *testing.T) {
 		{"add1", args{nil, "root", "A=B"}, []*addr.RcptTo{addr.NewRcptTo("root", "A=B", "new")}},
 		{"add2", args{[]*addr.RcptTo{addr.NewRcptTo("root", "", "smtp")}, "toor", "A=B"}, []*addr.RcptTo{addr.NewRcptTo("root", "", "smtp"), addr.NewRcptTo("toor", "A=B", "new")}},
 		{"change", args{[]*addr.RcptTo{addr.NewRcptTo("root", "", "smtp")}, "root", "A=B"}, []*addr.RcptTo{addr.NewRcptTo("root", "A=B", "smtp")}},
+		{"keep args", args{[]*addr.RcptTo{addr.NewRcptTo("root", "NOTIFY=NEVER", "smtp")}, "root", ""}, []*addr.RcptTo{addr.NewRcptTo("root", "NOTIFY=NEVER", "smtp")}},
 	}
 	for _, tt := range tests {
 		tt := tt
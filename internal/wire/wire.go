@@ -66,7 +66,45 @@ const (
 // We reject reading/writing messages larger than 512 MB outright.
 const maxPacketSize = 512 * 1024 * 1024
 
+// MessageConn is a connection that passes [Message] values without encoding them in the wire format.
+// The functions of this package use the methods of a MessageConn instead of the wire format.
+// Read and Write of a MessageConn still need to work with the wire format.
+type MessageConn interface {
+	net.Conn
+	// ReadMessage reads the next packet into msg. bodyLimit and remaining work like in [ReadPacketIntoLimit].
+	// The remaining data bytes of the packet need to get read with Read.
+	ReadMessage(msg *Message, bodyLimit uint32) (remaining uint32, err error)
+	// WriteMessage writes msg. It must not keep a reference to msg.Data.
+	WriteMessage(msg *Message) error
+}
+
+// Reset clears m so that it can be reused with [ReadPacketInto]. The memory of m.Data is kept.
+func (m *Message) Reset() {
+	m.Code = 0
+	m.Data = m.Data[:0]
+}
+
 func ReadPacket(conn net.Conn, timeout time.Duration) (*Message, error) {
+	msg := &Message{}
+	if err := ReadPacketInto(conn, msg, timeout); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ReadPacketInto reads the next packet from conn into msg.
+// The memory of msg.Data gets reused when it is big enough, so after this call
+// all slices that pointed into the old msg.Data contain the data of the new packet.
+// Set msg.Data to nil before calling ReadPacketInto when you handed the old data to someone else.
+func ReadPacketInto(conn net.Conn, msg *Message, timeout time.Duration) error {
+	_, err := ReadPacketIntoLimit(conn, msg, timeout, 0)
+	return err
+}
+
+// ReadPacketIntoLimit is like [ReadPacketInto] but only reads the first bodyLimit bytes of the data of a [CodeBody] packet.
+// 0 means no limit. It returns the number of data bytes of the packet that it did not read.
+// The caller needs to read them from conn before it reads the next packet.
+func ReadPacketIntoLimit(conn net.Conn, msg *Message, timeout time.Duration, bodyLimit uint32) (remaining uint32, err error) {
 	if timeout != 0 {
 		_ = conn.SetReadDeadline(time.Now().Add(timeout))
 		defer func(conn net.Conn) {
@@ -74,29 +112,51 @@ func ReadPacket(conn net.Conn, timeout time.Duration) (*Message, error) {
 		}(conn)
 	}
 
+	if mc, ok := conn.(MessageConn); ok {
+		return mc.ReadMessage(msg, bodyLimit)
+	}
+
+	buf := msg.Data[:cap(msg.Data)]
+	if len(buf) < 4 {
+		buf = make([]byte, 64)
+	}
+
 	// read packet length
-	var length uint32
-	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
-		return nil, err
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return 0, err
 	}
+	length := binary.BigEndian.Uint32(buf)
 
 	if length > maxPacketSize {
-		return nil, fmt.Errorf("milter: reject to read %d bytes in one message", length)
+		return 0, fmt.Errorf("milter: reject to read %d bytes in one message", length)
+	}
+	if length == 0 {
+		return 0, errors.New("milter: empty message")
 	}
 
-	// read packet data
-	data := make([]byte, length)
-	if _, err := io.ReadFull(conn, data); err != nil {
-		return nil, err
+	// read packet code
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return 0, err
+	}
+	code := Code(buf[0])
+	length--
+	if code == CodeBody && bodyLimit > 0 && length > bodyLimit {
+		remaining = length - bodyLimit
+		length = bodyLimit
 	}
 
-	// prepare response data
-	message := Message{
-		Code: Code(data[0]),
-		Data: data[1:],
+	// read packet data
+	if uint32(len(buf)) < length {
+		buf = make([]byte, length)
+	}
+	if _, err := io.ReadFull(conn, buf[:length]); err != nil {
+		return 0, err
 	}
 
-	return &message, nil
+	msg.Code = code
+	msg.Data = buf[:length]
+
+	return remaining, nil
 }
 
 func WritePacket(conn net.Conn, msg *Message, timeout time.Duration) error {
@@ -115,6 +175,10 @@ func WritePacket(conn net.Conn, msg *Message, timeout time.Duration) error {
 		return fmt.Errorf("milter: cannot write %d bytes in one message", length)
 	}
 
+	if mc, ok := conn.(MessageConn); ok {
+		return mc.WriteMessage(msg)
+	}
+
 	_, err := conn.Write([]byte{byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length), byte(msg.Code)})
 	if err != nil {
 		return err
@@ -128,6 +192,39 @@ func WritePacket(conn net.Conn, msg *Message, timeout time.Duration) error {
 	return err
 }
 
+// AppendPacket appends the wire encoding of msg to dest. It returns the new dest like append does.
+func AppendPacket(dest []byte, msg *Message) ([]byte, error) {
+	length := len(msg.Data) + 1
+	if length > maxPacketSize {
+		return dest, fmt.Errorf("milter: cannot write %d bytes in one message", length)
+	}
+	dest = append(dest, byte(length>>24), byte(length>>16), byte(length>>8), byte(length), byte(msg.Code))
+	return append(dest, msg.Data...), nil
+}
+
+// ParsePacket parses the first packet in the wire format of buf. It returns the number n of bytes the packet used.
+// n is 0 when buf does not contain a complete packet yet. The returned msg does not reference buf.
+func ParsePacket(buf []byte) (msg *Message, n int, err error) {
+	if len(buf) < 4 {
+		return nil, 0, nil
+	}
+	length := binary.BigEndian.Uint32(buf)
+	if length > maxPacketSize {
+		return nil, 0, fmt.Errorf("milter: reject to read %d bytes in one message", length)
+	}
+	if length == 0 {
+		return nil, 0, errors.New("milter: empty message")
+	}
+	if uint64(len(buf)) < 4+uint64(length) {
+		return nil, 0, nil
+	}
+	msg = &Message{Code: Code(buf[4])}
+	if length > 1 {
+		msg.Data = append([]byte(nil), buf[5:4+length]...)
+	}
+	return msg, 4 + int(length), nil
+}
+
 // AppendUint16 appends the big endian encoding of val to dest. It returns the new dest like append does.
 func AppendUint16(dest []byte, val uint16) []byte {
 	return append(dest, byte(val>>8), byte(val))
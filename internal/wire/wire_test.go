@@ -175,3 +175,93 @@ func TestWritePacket(t *testing.T) {
 		})
 	}
 }
+
+func TestReadPacketInto(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _ = server.Write([]byte{0, 0, 0, 5, 'b', 'o', 'd', 'y', '1'})
+		_, _ = server.Write([]byte{0, 0, 0, 3, 'h', 'i', '2'})
+		_, _ = server.Write([]byte{0, 0, 0, 0})
+		_ = server.Close()
+	}()
+	msg := Message{}
+	if err := ReadPacketInto(client, &msg, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != 'b' || string(msg.Data) != "ody1" {
+		t.Fatalf("ReadPacketInto() got = %+v", msg)
+	}
+	first := &msg.Data[:1][0]
+	msg.Reset()
+	if msg.Code != 0 || len(msg.Data) != 0 {
+		t.Fatalf("Reset() got = %+v", msg)
+	}
+	if err := ReadPacketInto(client, &msg, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != 'h' || string(msg.Data) != "i2" {
+		t.Fatalf("ReadPacketInto() got = %+v", msg)
+	}
+	if &msg.Data[0] != first {
+		t.Errorf("ReadPacketInto() did not reuse the buffer")
+	}
+	if err := ReadPacketInto(client, &msg, time.Second); err == nil {
+		t.Errorf("ReadPacketInto() expected error for empty packet")
+	}
+}
+
+func TestReadPacketIntoLimit(t *testing.T) {
+	t.Parallel()
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _ = server.Write([]byte{0, 0, 0, 7, 'B', '1', '2', '3', '4', '5', '6'})
+		_, _ = server.Write([]byte{0, 0, 0, 7, 'L', '1', '2', '3', '4', '5', '6'})
+		_ = server.Close()
+	}()
+	msg := Message{}
+	remaining, err := ReadPacketIntoLimit(client, &msg, time.Second, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != CodeBody || string(msg.Data) != "1234" || remaining != 2 {
+		t.Fatalf("ReadPacketIntoLimit() got = %+v, %d", msg, remaining)
+	}
+	rest := make([]byte, remaining)
+	if _, err := io.ReadFull(client, rest); err != nil || string(rest) != "56" {
+		t.Fatalf("rest got = %q, %v", rest, err)
+	}
+	// the limit only applies to body packets
+	remaining, err = ReadPacketIntoLimit(client, &msg, time.Second, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != CodeHeader || string(msg.Data) != "123456" || remaining != 0 {
+		t.Fatalf("ReadPacketIntoLimit() got = %+v, %d", msg, remaining)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	t.Parallel()
+	buf, err := AppendPacket(nil, &Message{Code: CodeHelo, Data: []byte("host")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, _ = AppendPacket(buf, &Message{Code: CodeQuit})
+	msg, n, err := ParsePacket(buf)
+	if err != nil || n != 9 || msg.Code != CodeHelo || string(msg.Data) != "host" {
+		t.Fatalf("ParsePacket() got = %+v, %d, %v", msg, n, err)
+	}
+	msg, n, err = ParsePacket(buf[n:])
+	if err != nil || n != 5 || msg.Code != CodeQuit || msg.Data != nil {
+		t.Fatalf("ParsePacket() got = %+v, %d, %v", msg, n, err)
+	}
+	if msg, n, err = ParsePacket(buf[:8]); err != nil || n != 0 || msg != nil {
+		t.Fatalf("ParsePacket() of incomplete packet got = %+v, %d, %v", msg, n, err)
+	}
+	if _, _, err = ParsePacket([]byte{0, 0, 0, 0}); err == nil {
+		t.Fatal("ParsePacket() of empty packet did not fail")
+	}
+}
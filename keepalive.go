@@ -0,0 +1,129 @@
+package milter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// idleKeeper sends keepalive packets and closes idle sessions for [WithKeepalive] and [WithIdleTimeout].
+//
+// A session is idle when it is in the negotiated state (it was just created or [ClientSession.Reset] was called)
+// and no method of it is running.
+type idleKeeper struct {
+	// mu serializes the timer callback with the methods of the session
+	mu    sync.Mutex
+	timer *time.Timer
+	// generation identifies the current timer, callbacks of stopped timers do nothing
+	generation  uint64
+	idleSince   time.Time
+	keepalive   time.Duration
+	idleTimeout time.Duration
+}
+
+func newIdleKeeper(keepalive, idleTimeout time.Duration) *idleKeeper {
+	if keepalive <= 0 && idleTimeout <= 0 {
+		return nil
+	}
+	return &idleKeeper{keepalive: keepalive, idleTimeout: idleTimeout}
+}
+
+// stop stops the timer of k. When the timer callback is running, stop waits for it to finish.
+func (k *idleKeeper) stop() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.timer != nil {
+		k.timer.Stop()
+		k.timer = nil
+	}
+}
+
+// arm starts the idle period of s.
+func (k *idleKeeper) arm(s *ClientSession) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.timer != nil {
+		k.timer.Stop()
+		k.timer = nil
+	}
+	// another method of s got called in the meantime, it will arm the timer when it is done
+	if atomic.LoadInt32(&s.busy) != 0 || s.state != clientStateNegotiated {
+		return
+	}
+	k.idleSince = time.Now()
+	k.schedule(s)
+}
+
+// schedule starts the timer for the next keepalive packet or the idle timeout. k.mu needs to be locked.
+func (k *idleKeeper) schedule(s *ClientSession) {
+	delay := k.keepalive
+	if k.idleTimeout > 0 {
+		remaining := k.idleTimeout - time.Since(k.idleSince)
+		if delay <= 0 || remaining < delay {
+			delay = remaining
+		}
+	}
+	k.generation++
+	generation := k.generation
+	k.timer = time.AfterFunc(delay, func() {
+		k.fire(s, generation)
+	})
+}
+
+func (k *idleKeeper) fire(s *ClientSession, generation uint64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.timer == nil || k.generation != generation || atomic.LoadInt32(&s.busy) != 0 || s.state != clientStateNegotiated {
+		return
+	}
+	k.timer = nil
+	if k.idleTimeout > 0 && time.Since(k.idleSince) >= k.idleTimeout {
+		_ = s.doClose()
+		return
+	}
+	// an empty macro packet does not need a reply and the next Conn call overwrites it
+	if err := s.writePacket(&wire.Message{Code: wire.CodeMacro, Data: []byte{byte(wire.CodeConn)}}); err != nil {
+		_ = s.errorOut(fmt.Errorf("milter: keepalive: %w", err))
+		return
+	}
+	k.schedule(s)
+}
+
+// Check verifies that the milter did not close the connection of s.
+// Use it to re-validate a pooled session (see [ClientSession.Reset]) before you use it for a new SMTP connection.
+// Check can only be called when s is not in an SMTP connection.
+//
+// Check does not communicate with the milter, it only detects connections that the milter (or the network) already closed.
+// Use [WithKeepalive] to keep connections alive that might otherwise silently be dropped by firewalls.
+func (s *ClientSession) Check() error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.leave()
+	return s.doCheck()
+}
+
+func (s *ClientSession) doCheck() error {
+	if s.state != clientStateNegotiated {
+		return s.stateError("check")
+	}
+	if err := s.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		return s.errorOut(fmt.Errorf("milter: check: %w", err))
+	}
+	var buf [1]byte
+	n, err := s.conn.Read(buf[:])
+	_ = s.conn.SetReadDeadline(time.Time{})
+	if n > 0 {
+		return s.errorOut(fmt.Errorf("milter: check: unexpected data from milter"))
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	return s.errorOut(fmt.Errorf("milter: check: %w", err))
+}
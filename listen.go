@@ -0,0 +1,128 @@
+package milter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ListenAndServe listens on network and address (see [net.Listen]) and serves the MTA connections with s (see [Server.Serve]).
+//
+// For unix sockets a stale socket file at address gets removed before s listens, and the permissions of
+// [WithUnixSocketPermissions] get applied to the new socket file. The socket file gets removed when s stops serving.
+func (s *Server) ListenAndServe(network, address string) error {
+	ln, err := s.listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// listen creates the listener of [Server.ListenAndServe].
+func (s *Server) listen(network, address string) (net.Listener, error) {
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+	// only remove sockets, do not delete a regular file because of a wrong address
+	if fi, err := os.Lstat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(address); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if perms := s.options.unixSocketPermissions; perms != nil {
+		if err := perms.apply(address); err != nil {
+			_ = ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// unixSocketPermissions are the settings of [WithUnixSocketPermissions].
+type unixSocketPermissions struct {
+	mode     os.FileMode
+	uid, gid int
+}
+
+func (p *unixSocketPermissions) apply(name string) error {
+	if err := os.Chmod(name, p.mode); err != nil {
+		return err
+	}
+	if p.uid != -1 || p.gid != -1 {
+		return os.Chown(name, p.uid, p.gid)
+	}
+	return nil
+}
+
+// systemdFirstFD is the first file descriptor that systemd passes with socket activation (SD_LISTEN_FDS_START).
+const systemdFirstFD = 3
+
+// listenFDs returns the number of sockets that systemd passed to the process with the ID pid.
+func listenFDs(pid int, getenv func(string) string) (int, error) {
+	listenPid, listenFds := getenv("LISTEN_PID"), getenv("LISTEN_FDS")
+	if listenPid == "" || listenFds == "" {
+		return 0, nil
+	}
+	if p, err := strconv.Atoi(listenPid); err != nil || p != pid {
+		// the sockets are meant for another process
+		return 0, nil
+	}
+	n, err := strconv.Atoi(listenFds)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("milter: invalid LISTEN_FDS %q", listenFds)
+	}
+	return n, nil
+}
+
+// SystemdListeners returns the sockets that systemd passed to this process with socket activation (see systemd.socket(5)).
+// It returns no listeners and no error when the process was not socket activated.
+// The environment variables LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES get unset, so that child processes do not inherit them.
+func SystemdListeners() ([]net.Listener, error) {
+	n, err := listenFDs(os.Getpid(), os.Getenv)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(key)
+	}
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(systemdFirstFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(systemdFirstFD+i), name)
+		// FileListener duplicates the file descriptor, we do not need f anymore
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("milter: systemd socket %s: %w", name, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// ServeSystemd serves the MTA connections of the sockets that systemd passed to this process (see [SystemdListeners]
+// and [Server.ServeListeners]). It returns an error when the process was not socket activated.
+func (s *Server) ServeSystemd() error {
+	listeners, err := SystemdListeners()
+	if err != nil {
+		return err
+	}
+	if len(listeners) == 0 {
+		return errors.New("milter: systemd did not pass any sockets to this process")
+	}
+	return s.ServeListeners(listeners...)
+}
@@ -0,0 +1,107 @@
+package milter
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestServer_ListenAndServe(t *testing.T) {
+	t.Parallel()
+	address := filepath.Join(t.TempDir(), "milter.sock")
+	// leave a stale socket file behind
+	stale, err := net.Listen("unix", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	s := NewServer(WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	}), WithUnixSocketPermissions(0600, -1, -1))
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ListenAndServe("unix", address)
+	}()
+	client := NewClient("unix", address)
+	var session *ClientSession
+	for i := 0; i < 100; i++ {
+		if session, err = client.Session(nil); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	act, err := session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	_ = session.Close()
+	fi, err := os.Stat(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("socket mode = %o, want 600", mode)
+	}
+	_ = s.Close()
+	if err := <-errs; err != ErrServerClosed {
+		t.Errorf("ListenAndServe() = %v, want ErrServerClosed", err)
+	}
+	if _, err := os.Stat(address); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file did not get removed: %v", err)
+	}
+}
+
+func TestServer_ListenAndServeKeepsFiles(t *testing.T) {
+	t.Parallel()
+	address := filepath.Join(t.TempDir(), "milter.sock")
+	if err := os.WriteFile(address, []byte("not a socket"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(WithMilter(func() Milter { return NoOpMilter{} }))
+	if err := s.ListenAndServe("unix", address); err == nil {
+		t.Fatal("ListenAndServe() did not fail")
+	}
+	if _, err := os.Stat(address); err != nil {
+		t.Fatalf("regular file got removed: %v", err)
+	}
+}
+
+func Test_listenFDs(t *testing.T) {
+	t.Parallel()
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    int
+		wantErr bool
+	}{
+		{"not activated", nil, 0, false},
+		{"other process", map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "2"}, 0, false},
+		{"activated", map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "2"}, 2, false},
+		{"invalid", map[string]string{"LISTEN_PID": pid, "LISTEN_FDS": "two"}, 0, true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := listenFDs(os.Getpid(), func(key string) string { return tt.env[key] })
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("listenFDs() = %d, %v, want %d (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_ServeSystemdNotActivated(t *testing.T) {
+	t.Parallel()
+	s := NewServer(WithMilter(func() Milter { return NoOpMilter{} }))
+	if err := s.ServeSystemd(); err == nil {
+		t.Fatal("ServeSystemd() did not fail")
+	}
+}
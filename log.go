@@ -14,4 +14,27 @@ func logWarning(format string, v ...interface{}) {
 //
 // The default implementation uses [log.Print] to output the warning.
 // You can re-assign LogWarning to something more suitable for your application. But do not assign nil to it.
+// Use [WithLogger] to set the logger of a single [Client] or [Server] instead.
 var LogWarning = logWarning
+
+// Logger receives the warnings of a [Client] or [Server] that uses [WithLogger]. [log.Logger] implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// warn outputs a warning to logger. It uses [LogWarning] when logger is nil.
+func warn(logger Logger, format string, v ...interface{}) {
+	if logger == nil {
+		LogWarning(format, v...)
+		return
+	}
+	logger.Printf(fmt.Sprintf("milter: warning: %s", format), v...)
+}
+
+func (s *ClientSession) logWarning(format string, v ...interface{}) {
+	warn(s.logger, format, v...)
+}
+
+func (m *serverSession) logWarning(format string, v ...interface{}) {
+	warn(m.server.options.logger, format, v...)
+}
@@ -0,0 +1,358 @@
+package milter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// Loopback connects a [Client] directly to a [Server] in the same process without using sockets.
+//
+// Loopback is a [net.Listener] that you pass to [Server.Serve] and a [Dialer] that you pass to [NewClient] with [WithDialer]:
+//
+//	lb := milter.NewLoopback()
+//	server := milter.NewServer(milter.WithMilter(newMyMilter))
+//	go server.Serve(lb)
+//	client := milter.NewClient("loopback", "", milter.WithDialer(lb))
+//
+// The network and address arguments of the [Client] are ignored.
+// The milter packets get passed in memory as they are, they do not get encoded in the milter wire format.
+// Writes to the connections never block.
+type Loopback struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewLoopback creates a new [Loopback].
+func NewLoopback() *Loopback {
+	return &Loopback{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept waits for and returns the next connection of a [Client].
+func (l *Loopback) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener side of l. Already established connections are not closed.
+func (l *Loopback) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr returns the address of l.
+func (l *Loopback) Addr() net.Addr {
+	return loopbackAddr{}
+}
+
+// Dial connects to the [Server] that serves l. network and addr are ignored.
+func (l *Loopback) Dial(network string, addr string) (net.Conn, error) {
+	return l.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the [Server] that serves l. network and addr are ignored.
+func (l *Loopback) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	client, server := newLoopbackPipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "dial", Net: "loopback", Addr: loopbackAddr{}, Err: net.ErrClosed}
+	case <-ctx.Done():
+		return nil, &net.OpError{Op: "dial", Net: "loopback", Addr: loopbackAddr{}, Err: ctx.Err()}
+	}
+}
+
+var _ net.Listener = (*Loopback)(nil)
+var _ Dialer = (*Loopback)(nil)
+var _ wire.MessageConn = (*loopbackConn)(nil)
+
+type loopbackAddr struct{}
+
+func (loopbackAddr) Network() string { return "loopback" }
+func (loopbackAddr) String() string  { return "loopback" }
+
+// loopbackBuffer is one direction of a loopbackConn pair.
+// The byte stream of the direction is partial followed by the wire format of msgs.
+type loopbackBuffer struct {
+	mu sync.Mutex
+	// msgs are the packets that were not read yet
+	msgs []*wire.Message
+	// partial are the bytes of a packet that did only get read partially
+	partial []byte
+	// raw are the bytes that got written with Write but do not form a complete packet yet
+	raw    []byte
+	closed bool
+	// notify gets signaled when data was written or when it got closed
+	notify chan struct{}
+}
+
+func (b *loopbackBuffer) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// loopbackDeadline is a deadline that can be waited on with a channel.
+type loopbackDeadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // closed when the deadline is exceeded
+}
+
+func newLoopbackDeadline() *loopbackDeadline {
+	return &loopbackDeadline{cancel: make(chan struct{})}
+}
+
+func (d *loopbackDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // the timer fired, wait for it to close cancel
+	}
+	d.timer = nil
+	exceeded := d.exceeded()
+	if t.IsZero() {
+		if exceeded {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if exceeded {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+	if !exceeded {
+		close(d.cancel)
+	}
+}
+
+func (d *loopbackDeadline) exceeded() bool {
+	select {
+	case <-d.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *loopbackDeadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// loopbackConn is one end of an in-memory connection. In contrast to [net.Pipe] writes never block.
+// It implements [wire.MessageConn], so the milter packets do not get encoded when both ends use the functions of package wire.
+type loopbackConn struct {
+	rx, tx        *loopbackBuffer
+	readDeadline  *loopbackDeadline
+	writeDeadline *loopbackDeadline
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+func newLoopbackPipe() (net.Conn, net.Conn) {
+	a := &loopbackBuffer{notify: make(chan struct{}, 1)}
+	b := &loopbackBuffer{notify: make(chan struct{}, 1)}
+	return &loopbackConn{rx: a, tx: b, readDeadline: newLoopbackDeadline(), writeDeadline: newLoopbackDeadline(), done: make(chan struct{})},
+		&loopbackConn{rx: b, tx: a, readDeadline: newLoopbackDeadline(), writeDeadline: newLoopbackDeadline(), done: make(chan struct{})}
+}
+
+func (c *loopbackConn) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// errLoopbackPartialPacket is returned by ReadMessage when the current packet was already partially read with Read.
+var errLoopbackPartialPacket = errors.New("milter: loopback: packet got partially read")
+
+// waitRead waits until the rx buffer has data or is closed and calls fn with the locked rx buffer.
+func (c *loopbackConn) waitRead(fn func(b *loopbackBuffer) error) error {
+	for {
+		if c.isClosed() {
+			return io.ErrClosedPipe
+		}
+		if c.readDeadline.exceeded() {
+			return os.ErrDeadlineExceeded
+		}
+		c.rx.mu.Lock()
+		if len(c.rx.partial) > 0 || len(c.rx.msgs) > 0 {
+			err := fn(c.rx)
+			c.rx.mu.Unlock()
+			return err
+		}
+		closed := c.rx.closed
+		c.rx.mu.Unlock()
+		if closed {
+			return io.EOF
+		}
+		select {
+		case <-c.rx.notify:
+		case <-c.readDeadline.wait():
+		case <-c.done:
+		}
+	}
+}
+
+// next removes the next packet from b.
+func (b *loopbackBuffer) next() *wire.Message {
+	msg := b.msgs[0]
+	b.msgs[0] = nil
+	b.msgs = b.msgs[1:]
+	return msg
+}
+
+func (c *loopbackConn) Read(p []byte) (n int, err error) {
+	err = c.waitRead(func(b *loopbackBuffer) error {
+		if len(b.partial) == 0 {
+			// the reader does not use ReadMessage, encode the packet
+			b.partial, _ = wire.AppendPacket(nil, b.next())
+		}
+		n = copy(p, b.partial)
+		b.partial = b.partial[n:]
+		return nil
+	})
+	return n, err
+}
+
+func (c *loopbackConn) ReadMessage(msg *wire.Message, bodyLimit uint32) (remaining uint32, err error) {
+	err = c.waitRead(func(b *loopbackBuffer) error {
+		if len(b.partial) > 0 {
+			return errLoopbackPartialPacket
+		}
+		next := b.next()
+		msg.Code = next.Code
+		msg.Data = next.Data
+		if next.Code == wire.CodeBody && bodyLimit > 0 && uint32(len(next.Data)) > bodyLimit {
+			msg.Data = next.Data[:bodyLimit:bodyLimit]
+			b.partial = next.Data[bodyLimit:]
+			remaining = uint32(len(b.partial))
+		}
+		return nil
+	})
+	return remaining, err
+}
+
+// write calls fn with the locked tx buffer.
+func (c *loopbackConn) write(fn func(b *loopbackBuffer) error) error {
+	if c.isClosed() {
+		return io.ErrClosedPipe
+	}
+	if c.writeDeadline.exceeded() {
+		return os.ErrDeadlineExceeded
+	}
+	c.tx.mu.Lock()
+	if c.tx.closed {
+		c.tx.mu.Unlock()
+		return io.ErrClosedPipe
+	}
+	err := fn(c.tx)
+	c.tx.mu.Unlock()
+	c.tx.signal()
+	return err
+}
+
+// parseRaw moves the complete packets of b.raw to b.msgs.
+func (b *loopbackBuffer) parseRaw() error {
+	for {
+		msg, n, err := wire.ParsePacket(b.raw)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		b.msgs = append(b.msgs, msg)
+		b.raw = b.raw[n:]
+	}
+}
+
+func (c *loopbackConn) Write(p []byte) (int, error) {
+	err := c.write(func(b *loopbackBuffer) error {
+		// the writer does not use WriteMessage, decode the packets
+		b.raw = append(b.raw, p...)
+		return b.parseRaw()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *loopbackConn) WriteMessage(msg *wire.Message) error {
+	return c.write(func(b *loopbackBuffer) error {
+		if len(b.raw) > 0 {
+			// keep the order of the packets
+			b.raw, _ = wire.AppendPacket(b.raw, msg)
+			return b.parseRaw()
+		}
+		// the writer might re-use msg.Data
+		b.msgs = append(b.msgs, &wire.Message{Code: msg.Code, Data: append([]byte(nil), msg.Data...)})
+		return nil
+	})
+}
+
+func (c *loopbackConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		for _, b := range []*loopbackBuffer{c.rx, c.tx} {
+			b.mu.Lock()
+			b.closed = true
+			b.mu.Unlock()
+			b.signal()
+		}
+	})
+	return nil
+}
+
+func (c *loopbackConn) LocalAddr() net.Addr {
+	return loopbackAddr{}
+}
+
+func (c *loopbackConn) RemoteAddr() net.Addr {
+	return loopbackAddr{}
+}
+
+func (c *loopbackConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *loopbackConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *loopbackConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
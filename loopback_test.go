@@ -0,0 +1,140 @@
+package milter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+	"github.com/emersion/go-message/textproto"
+)
+
+func TestLoopback(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			m.AddHeader("X-Test", "1")
+		},
+	}
+	lb := NewLoopback()
+	server := NewServer(WithMilter(func() Milter {
+		return &mm
+	}), WithAction(OptAddHeader))
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(lb)
+	}()
+	client := NewClient("loopback", "", WithDialer(lb), WithPipelining())
+	session, err := client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	act, err := session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	hdr := textproto.Header{}
+	for i := 0; i < 100; i++ {
+		hdr.Add("X-Header", "value")
+	}
+	act, err = session.Header(hdr)
+	assertAction(t, act, err, ActionContinue)
+	modifyActs, act, err := session.BodyReadFrom(bytes.NewReader(make([]byte, 3*DataSize64K)))
+	assertAction(t, act, err, ActionAccept)
+	if len(modifyActs) != 1 || modifyActs[0].HeaderName != "X-Test" {
+		t.Fatalf("got modifications %+v", modifyActs)
+	}
+	if len(mm.Chunks) != 3 {
+		t.Fatalf("milter got %d body chunks", len(mm.Chunks))
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serveErr; err != ErrServerClosed {
+		t.Fatalf("Serve() got %v", err)
+	}
+	if _, err := lb.Dial("", ""); err == nil {
+		t.Fatal("Dial() after Close() did not fail")
+	}
+}
+
+func TestLoopbackConn(t *testing.T) {
+	t.Parallel()
+	a, b := newLoopbackPipe()
+	data := []byte("one")
+	if err := wire.WritePacket(a, &wire.Message{Code: wire.CodeHelo, Data: data}, 0); err != nil {
+		t.Fatal(err)
+	}
+	// the connection must not keep a reference to the data
+	data[0] = 'X'
+	// raw writes get decoded
+	if _, err := a.Write([]byte{0, 0, 0, 4, byte(wire.CodeMail)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Write([]byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := wire.ReadPacket(b, 0)
+	if err != nil || !reflect.DeepEqual(msg, &wire.Message{Code: wire.CodeHelo, Data: []byte("one")}) {
+		t.Fatalf("ReadPacket() got %+v, %v", msg, err)
+	}
+	// raw reads get encoded
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(b, buf); err != nil || string(buf) != "\x00\x00\x00\x04Mtwo" {
+		t.Fatalf("Read() got %q, %v", buf, err)
+	}
+
+	// the rest of a body chunk gets read with Read
+	if err := wire.WritePacket(a, &wire.Message{Code: wire.CodeBody, Data: []byte("0123456789")}, 0); err != nil {
+		t.Fatal(err)
+	}
+	msg = &wire.Message{}
+	remaining, err := wire.ReadPacketIntoLimit(b, msg, 0, 4)
+	if err != nil || remaining != 6 || string(msg.Data) != "0123" {
+		t.Fatalf("ReadPacketIntoLimit() got %+v, %d, %v", msg, remaining, err)
+	}
+	if _, err := wire.ReadPacket(b, 0); !errors.Is(err, errLoopbackPartialPacket) {
+		t.Fatalf("ReadPacket() got %v, want %v", err, errLoopbackPartialPacket)
+	}
+	buf = make([]byte, remaining)
+	if _, err := io.ReadFull(b, buf); err != nil || string(buf) != "456789" {
+		t.Fatalf("Read() got %q, %v", buf, err)
+	}
+
+	_ = b.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := b.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read() got %v, want deadline error", err)
+	}
+	_ = b.SetReadDeadline(time.Time{})
+
+	_ = wire.WritePacket(a, &wire.Message{Code: wire.CodeQuit}, 0)
+	_ = a.Close()
+	if data, err := io.ReadAll(b); err != nil || string(data) != "\x00\x00\x00\x01Q" {
+		t.Fatalf("ReadAll() got %q, %v", data, err)
+	}
+	if _, err := b.Write([]byte{0, 0, 0, 1, 'Q'}); err == nil {
+		t.Fatal("Write() to closed connection did not fail")
+	}
+}
@@ -67,8 +67,55 @@ const (
 	MacroDateRFC822Current  MacroName = "b"
 	MacroDateANSICCurrent   MacroName = "d"
 	MacroDateSecondsCurrent MacroName = "t"
+	MacroSMTPGreeting       MacroName = "e"                // SMTP greeting message of sendmail
+	MacroClientResolve      MacroName = "{client_resolve}" // result of the reverse lookup of the client IP address (OK, FAIL, FORGED, TEMP)
+	MacroClientFlags        MacroName = "{client_flags}"   // flags of the ClientPortOptions of the daemon
+	MacroServerAddr         MacroName = "{server_addr}"    // IP address of the server the MTA delivers to
+	MacroServerName         MacroName = "{server_name}"    // host name of the server the MTA delivers to
+	MacroVerify             MacroName = "{verify}"         // result of the client certificate verification (OK, NO, FAIL, …)
+	MacroCNSubject          MacroName = "{cn_subject}"     // common name of the client certificate's subject
+	MacroCNIssuer           MacroName = "{cn_issuer}"      // common name of the client certificate's issuer
+	MacroCertMD5            MacroName = "{cert_md5}"       // MD5 fingerprint of the client certificate
+	MacroMsgId              MacroName = "{msg_id}"         // value of the Message-Id header field
+	MacroMsgSize            MacroName = "{msg_size}"       // size of the message in bytes
+	MacroNRcpts             MacroName = "{nrcpts}"         // number of validated recipients
+	MacroNBadRcpts          MacroName = "{nbadrcpts}"      // number of rejected recipients
+	MacroNTries             MacroName = "{ntries}"         // number of delivery attempts
+	MacroQuarantine         MacroName = "{quarantine}"     // quarantine reason of the message
 )
 
+// customMacroPrefix is the prefix of site-specific macros.
+const customMacroPrefix = "{x-"
+
+// CustomMacro returns the [MacroName] of the site-specific macro {x-name}.
+// sendmail and Postfix can be configured to send arbitrary macros to milters.
+// Prefixing your own macros with x- ensures that they will not clash with macros of the MTA.
+// A [Client] sends custom macros like any other macro when you include them in [WithMacroRequest],
+// mailfilter based milters can receive them with its WithCustomMacros option.
+//
+// It panics when the resulting name is not valid (see [ValidateCustomMacro]).
+func CustomMacro(name string) MacroName {
+	macro := customMacroPrefix + name + "}"
+	if err := ValidateCustomMacro(macro); err != nil {
+		panic(err)
+	}
+	return macro
+}
+
+// ValidateCustomMacro checks that name is a valid site-specific macro name like {x-my_macro}.
+// The part after {x- must not be empty and must only consist of ASCII letters, digits, '-' and '_'.
+func ValidateCustomMacro(name MacroName) error {
+	if !strings.HasPrefix(name, customMacroPrefix) || !strings.HasSuffix(name, "}") || len(name) == len(customMacroPrefix)+1 {
+		return fmt.Errorf("milter: invalid custom macro name %q", name)
+	}
+	for _, r := range name[len(customMacroPrefix) : len(name)-1] {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("milter: invalid character %q in custom macro name %q", r, name)
+		}
+	}
+	return nil
+}
+
 type macroRequests [][]MacroName
 
 type Macros interface {
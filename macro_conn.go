@@ -0,0 +1,114 @@
+package milter
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// tlsConnectionStater is implemented by [tls.Conn] and wrappers of it.
+type tlsConnectionStater interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// connMacros derives the macros [MacroDaemonAddr], [MacroDaemonPort], [MacroIfAddr], [MacroIfName],
+// [MacroClientAddr], [MacroClientPort] and – when conn is a TLS connection with a completed handshake –
+// [MacroTlsVersion], [MacroCipher], [MacroCipherBits], [MacroCertSubject] and [MacroCertIssuer] from the SMTP connection conn.
+// Macros that cannot be derived are not part of the result. ifName returns the name of the network interface of an IP address.
+func connMacros(conn net.Conn, ifName func(ip net.IP) string) map[MacroName]string {
+	macros := make(map[MacroName]string)
+	if conn == nil {
+		return macros
+	}
+	if ip, port, ok := splitAddr(conn.LocalAddr()); ok {
+		macros[MacroDaemonAddr] = ip.String()
+		macros[MacroDaemonPort] = strconv.Itoa(port)
+		macros[MacroIfAddr] = ip.String()
+		if name := ifName(ip); name != "" {
+			macros[MacroIfName] = name
+		}
+	}
+	if ip, port, ok := splitAddr(conn.RemoteAddr()); ok {
+		macros[MacroClientAddr] = ip.String()
+		macros[MacroClientPort] = strconv.Itoa(port)
+	}
+	if stater, ok := conn.(tlsConnectionStater); ok {
+		state := stater.ConnectionState()
+		if state.HandshakeComplete {
+			macros[MacroTlsVersion] = tlsVersionName(state.Version)
+			cipher := tls.CipherSuiteName(state.CipherSuite)
+			macros[MacroCipher] = cipher
+			if bits := cipherBits(cipher); bits > 0 {
+				macros[MacroCipherBits] = strconv.Itoa(bits)
+			}
+			if len(state.VerifiedChains) > 0 && len(state.PeerCertificates) > 0 {
+				macros[MacroCertSubject] = state.PeerCertificates[0].Subject.String()
+				macros[MacroCertIssuer] = state.PeerCertificates[0].Issuer.String()
+			}
+		}
+	}
+	return macros
+}
+
+func splitAddr(addr net.Addr) (net.IP, int, bool) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP, a.Port, a.IP != nil
+	case *net.UDPAddr:
+		return a.IP, a.Port, a.IP != nil
+	default:
+		return nil, 0, false
+	}
+}
+
+// interfaceName returns the name of the network interface that has the address ip or "" if there is none.
+func interfaceName(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+	return ""
+}
+
+// tlsVersionName returns the TLS version in the format sendmail and Postfix use (e.g. TLSv1.3).
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
+	}
+}
+
+// cipherBits returns the strength of the cipher suite cipher in bits or 0 if it is unknown.
+func cipherBits(cipher string) int {
+	switch {
+	case strings.Contains(cipher, "AES_256"), strings.Contains(cipher, "CHACHA20"):
+		return 256
+	case strings.Contains(cipher, "AES_128"), strings.Contains(cipher, "RC4_128"):
+		return 128
+	case strings.Contains(cipher, "3DES"):
+		return 168
+	default:
+		return 0
+	}
+}
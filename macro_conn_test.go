@@ -0,0 +1,96 @@
+package milter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		// make the certificate usable as its own CA and as client certificate
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func Test_connMacros(t *testing.T) {
+	t.Parallel()
+	if got := connMacros(nil, interfaceName); len(got) != 0 {
+		t.Errorf("connMacros(nil) = %v", got)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_ = conn.(*tls.Conn).Handshake()
+		_ = conn.Close()
+	}()
+	client, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	got := connMacros(client, interfaceName)
+	local := client.LocalAddr().(*net.TCPAddr)
+	remote := client.RemoteAddr().(*net.TCPAddr)
+	for name, want := range map[MacroName]string{
+		MacroDaemonAddr: local.IP.String(),
+		MacroIfAddr:     local.IP.String(),
+		MacroClientAddr: remote.IP.String(),
+		MacroTlsVersion: "TLSv1.3",
+		MacroCipherBits: "128",
+		MacroCipher:     "TLS_AES_128_GCM_SHA256",
+	} {
+		if got[name] != want {
+			t.Errorf("connMacros()[%s] = %q, want %q", name, got[name], want)
+		}
+	}
+	if _, ok := got[MacroCertSubject]; ok {
+		t.Errorf("connMacros() has %s without verified chain", MacroCertSubject)
+	}
+}
+
+func TestClientSession_interfaceName(t *testing.T) {
+	t.Parallel()
+	s := &ClientSession{}
+	ip := net.IPv4(127, 0, 0, 1)
+	name := s.interfaceName(ip)
+	if cached, ok := s.ifNames[ip.String()]; !ok || cached != name {
+		t.Fatalf("interface name of %s did not get cached: %v", ip, s.ifNames)
+	}
+	s.ifNames[ip.String()] = "cached"
+	if got := s.interfaceName(ip); got != "cached" {
+		t.Fatalf("interfaceName() = %q, want cached value", got)
+	}
+}
@@ -0,0 +1,28 @@
+package milter
+
+// MacroObserver is a [Milter] that wants to see the raw macro packets of the MTA.
+//
+// The [Server] calls OnMacros for every macro packet that the MTA sends, before it calls the callback of the command
+// that the macros belong to. macros are all macros of the packet, not only the ones your milter requested
+// (see [WithMacroRequest]). This is useful to debug which macros a particular MTA actually provides.
+// stage is [StageEndMarker] for the macros of the header, body, abort and unknown commands.
+// Some MTAs split big macro sets into multiple packets, OnMacros gets called for each of them.
+//
+// A [MilterMiddleware] hides this interface when it does not implement it itself.
+type MacroObserver interface {
+	Milter
+	OnMacros(stage MacroStage, macros map[MacroName]string)
+}
+
+// observeMacros passes the decoded macro packet data (name, value pairs) to the backend when it is a [MacroObserver].
+func (m *serverSession) observeMacros(stage MacroStage, data []string) {
+	observer, ok := m.backend.(MacroObserver)
+	if !ok {
+		return
+	}
+	macros := make(map[MacroName]string, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		macros[data[i]] = data[i+1]
+	}
+	observer.OnMacros(stage, macros)
+}
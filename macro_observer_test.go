@@ -0,0 +1,55 @@
+package milter
+
+import (
+	"sync"
+	"testing"
+)
+
+type macroObserverMilter struct {
+	MockMilter
+	mu      sync.Mutex
+	packets []map[MacroName]string
+	stages  []MacroStage
+}
+
+func (o *macroObserverMilter) OnMacros(stage MacroStage, macros map[MacroName]string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.stages = append(o.stages, stage)
+	o.packets = append(o.packets, macros)
+}
+
+func TestServer_MacroObserver(t *testing.T) {
+	t.Parallel()
+	macros := NewMacroBag()
+	macros.Set(MacroMTAFQDN, "mx.example.org")
+	macros.Set(MacroDaemonName, "smtpd")
+	macros.Set(MacroMailAddr, "from@example.org")
+	o := &macroObserverMilter{MockMilter: MockMilter{
+		ConnResp: RespContinue,
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+	}}
+	w := newServerClient(t, macros, []Option{WithMilter(func() Milter {
+		return o
+	})}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	found := map[MacroStage]map[MacroName]string{}
+	for i, stage := range o.stages {
+		found[stage] = o.packets[i]
+	}
+	if got := found[StageConnect]; got[MacroMTAFQDN] != "mx.example.org" || got[MacroDaemonName] != "smtpd" {
+		t.Fatalf("got connect macros %v", got)
+	}
+	if got := found[StageMail]; got[MacroMailAddr] != "from@example.org" {
+		t.Fatalf("got mail macros %v", got)
+	}
+}
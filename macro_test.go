@@ -558,3 +558,36 @@ func Test_removeEmpty(t *testing.T) {
 		})
 	}
 }
+
+func TestCustomMacro(t *testing.T) {
+	if got := CustomMacro("site_id"); got != "{x-site_id}" {
+		t.Errorf("CustomMacro() = %q", got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("CustomMacro() did not panic on invalid name")
+		}
+	}()
+	CustomMacro("in valid")
+}
+
+func TestValidateCustomMacro(t *testing.T) {
+	tests := []struct {
+		name    MacroName
+		wantErr bool
+	}{
+		{"{x-site}", false},
+		{"{x-Site-ID_2}", false},
+		{"{x-}", true},
+		{"{site}", true},
+		{"{x-site", true},
+		{"x-site", true},
+		{"{x-sité}", true},
+		{"{x-si}te}", true},
+	}
+	for _, tt := range tests {
+		if err := ValidateCustomMacro(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateCustomMacro(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
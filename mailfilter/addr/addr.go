@@ -93,6 +93,22 @@ func (a *addr) UnicodeDomain() string {
 	return unicodeDomain
 }
 
+// Arg returns the value of the ESMTP argument keyword (e.g. "NOTIFY" or "SIZE") in Args.
+// keyword is compared case-insensitive. ok is false when Args does not contain keyword.
+// Arguments without a value (e.g. "SMTPUTF8") have an empty value.
+func (a *addr) Arg(keyword string) (value string, ok bool) {
+	for _, arg := range strings.Fields(a.Args) {
+		k, v := arg, ""
+		if i := strings.IndexByte(arg, '='); i >= 0 {
+			k, v = arg[:i], arg[i+1:]
+		}
+		if strings.EqualFold(k, keyword) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
 // MailFrom is the sender address and the sender info (used transport, authenticated user).
 type MailFrom struct {
 	addr
@@ -158,6 +174,23 @@ func (r *RcptTo) Transport() string {
 	return r.transport
 }
 
+// Notify returns the values of the NOTIFY ESMTP argument (RFC 3461) of this recipient, e.g. ["SUCCESS", "FAILURE"].
+// It returns nil when there is no NOTIFY argument.
+func (r *RcptTo) Notify() []string {
+	value, ok := r.Arg("NOTIFY")
+	if !ok || value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// ORcpt returns the value of the ORCPT ESMTP argument (RFC 3461) of this recipient, e.g. "rfc822;user@example.com".
+// The value is returned as-is (xtext encoded). It returns an empty string when there is no ORCPT argument.
+func (r *RcptTo) ORcpt() string {
+	value, _ := r.Arg("ORCPT")
+	return value
+}
+
 // Copy returns an independent copy of r.
 func (r *RcptTo) Copy() *RcptTo {
 	if r == nil {
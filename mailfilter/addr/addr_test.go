@@ -251,3 +251,41 @@ func TestRcptTo_Copy(t *testing.T) {
 		t.Errorf("Copy() did not create an independent copy")
 	}
 }
+
+func Test_addr_Arg(t *testing.T) {
+	t.Parallel()
+	a := addr{Addr: "root@localhost", Args: "notify=SUCCESS,FAILURE ORCPT=rfc822;root@localhost SMTPUTF8"}
+	tests := []struct {
+		keyword string
+		value   string
+		ok      bool
+	}{
+		{"NOTIFY", "SUCCESS,FAILURE", true},
+		{"orcpt", "rfc822;root@localhost", true},
+		{"SMTPUTF8", "", true},
+		{"SIZE", "", false},
+	}
+	for _, tt := range tests {
+		if value, ok := a.Arg(tt.keyword); value != tt.value || ok != tt.ok {
+			t.Errorf("Arg(%q) = %q, %v, want %q, %v", tt.keyword, value, ok, tt.value, tt.ok)
+		}
+	}
+}
+
+func TestRcptTo_Notify_ORcpt(t *testing.T) {
+	t.Parallel()
+	r := NewRcptTo("root@localhost", "NOTIFY=SUCCESS,DELAY ORCPT=rfc822;root@example.com", "smtp")
+	if got := r.Notify(); !reflect.DeepEqual(got, []string{"SUCCESS", "DELAY"}) {
+		t.Errorf("Notify() = %v", got)
+	}
+	if got := r.ORcpt(); got != "rfc822;root@example.com" {
+		t.Errorf("ORcpt() = %q", got)
+	}
+	r = NewRcptTo("root@localhost", "", "smtp")
+	if got := r.Notify(); got != nil {
+		t.Errorf("Notify() = %v", got)
+	}
+	if got := r.ORcpt(); got != "" {
+		t.Errorf("ORcpt() = %q", got)
+	}
+}
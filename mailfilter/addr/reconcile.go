@@ -0,0 +1,42 @@
+package addr
+
+import "strings"
+
+// Normalize returns address without surrounding whitespace and angle brackets and with its domain
+// in the ASCII representation of [IDNAProfile] (that also lower-cases the domain). The local part does not get changed.
+// The null sender "<>" normalizes to the empty string.
+func Normalize(address string) string {
+	address = strings.TrimSpace(address)
+	if len(address) > 1 && address[0] == '<' && address[len(address)-1] == '>' {
+		address = strings.TrimSpace(address[1 : len(address)-1])
+	}
+	a := addr{Addr: address}
+	if a.Domain() == "" {
+		return address
+	}
+	return a.Local() + "@" + a.AsciiDomain()
+}
+
+// Equal returns true when a and b are the same envelope address.
+// Differences in angle brackets, surrounding whitespace and the case or IDNA representation of the domain get ignored.
+// Local parts get compared case-sensitive like RFC 5321 demands.
+func Equal(a, b string) bool {
+	return Normalize(a) == Normalize(b)
+}
+
+// Reconcile compares the address protocolAddr of a MAIL FROM or RCPT TO command with the value macroAddr of the
+// {mail_addr} or {rcpt_addr} macro the MTA sent for this command. It returns protocolAddr without angle brackets and
+// mismatch == true when macroAddr is not empty and is not the same address (see [Equal]).
+//
+// MTAs can rewrite the address in the macro (e.g. Sendmail adds its own domain to addresses without domain),
+// so filters that use both sources should check for a mismatch and decide which one to trust.
+func Reconcile(protocolAddr, macroAddr string) (address string, mismatch bool) {
+	address = strings.TrimSpace(protocolAddr)
+	if len(address) > 1 && address[0] == '<' && address[len(address)-1] == '>' {
+		address = address[1 : len(address)-1]
+	}
+	if strings.TrimSpace(macroAddr) == "" {
+		return address, false
+	}
+	return address, !Equal(protocolAddr, macroAddr)
+}
@@ -0,0 +1,47 @@
+package addr
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		address, want string
+	}{
+		{"", ""},
+		{"<>", ""},
+		{" <root@Example.COM> ", "root@example.com"},
+		{"Root@localhost", "Root@localhost"},
+		{"root", "root"},
+		{"root@スパム.example.com", "root@xn--zck5b2b.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if got := Normalize(tt.address); got != tt.want {
+				t.Errorf("Normalize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name, protocol, macro string
+		want                  string
+		wantMismatch          bool
+	}{
+		{"same", "<root@example.com>", "root@example.com", "root@example.com", false},
+		{"no-macro", "<root@example.com>", "", "root@example.com", false},
+		{"null-sender", "<>", "<>", "", false},
+		{"domain-case", "<root@EXAMPLE.com>", "root@example.com", "root@EXAMPLE.com", false},
+		{"idna", "<root@スパム.example.com>", "root@xn--zck5b2b.example.com", "root@スパム.example.com", false},
+		{"local-case", "<Root@example.com>", "root@example.com", "Root@example.com", true},
+		{"rewritten", "<root>", "root@mta.example.com", "root", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, mismatch := Reconcile(tt.protocol, tt.macro)
+			if got != tt.want || mismatch != tt.wantMismatch {
+				t.Errorf("Reconcile() = %q, %v, want %q, %v", got, mismatch, tt.want, tt.wantMismatch)
+			}
+		})
+	}
+}
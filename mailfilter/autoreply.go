@@ -0,0 +1,103 @@
+package mailfilter
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"github.com/d--j/go-milter/mailfilter/header"
+)
+
+// IsAutoGenerated returns true when the message of trx was generated automatically and not by a human.
+// This is the case for bounces (empty envelope sender), messages with an Auto-Submitted header field (RFC 3834)
+// with a value other than "no" and for messages where [IsAutoReply] or [IsBulk] returns true.
+//
+// Use it to exempt these messages from challenges, rate limits or auto-replies of your own.
+func IsAutoGenerated(trx Trx) bool {
+	if trx.MailFrom().Addr == "" {
+		return true
+	}
+	if v := headerToken(trx.Headers(), "Auto-Submitted"); v != "" && v != "no" {
+		return true
+	}
+	return IsAutoReply(trx) || IsBulk(trx)
+}
+
+// IsAutoReply returns true when the message of trx is an automatic reply (e.g. a vacation notice).
+// It checks the header fields Auto-Submitted (auto-replied), Precedence (auto_reply), X-Autoreply and X-Autorespond.
+func IsAutoReply(trx Trx) bool {
+	h := trx.Headers()
+	return headerToken(h, "Auto-Submitted") == "auto-replied" ||
+		headerToken(h, "Precedence") == "auto_reply" ||
+		headerToken(h, "X-Autoreply") != "" ||
+		headerToken(h, "X-Autorespond") != ""
+}
+
+// IsBulk returns true when the message of trx is a bulk or mailing list message.
+// It checks the header fields Precedence (bulk, junk or list), List-Id and List-Unsubscribe.
+func IsBulk(trx Trx) bool {
+	h := trx.Headers()
+	switch headerToken(h, "Precedence") {
+	case "bulk", "junk", "list":
+		return true
+	}
+	return headerToken(h, "List-Id") != "" || headerToken(h, "List-Unsubscribe") != ""
+}
+
+// IsCalendar returns true when the message of trx is a calendar message (e.g. a meeting invitation or its reply).
+// These messages have a text/calendar or application/ics MIME part.
+// Nested MIME parts only get checked when the body of trx is available (see [Trx.Body]),
+// otherwise only the Content-Type of the message itself gets checked.
+func IsCalendar(trx Trx) bool {
+	mediaType, params, err := mime.ParseMediaType(trx.Headers().UnfoldedValue("Content-Type"))
+	if err != nil {
+		return false
+	}
+	if isCalendarType(mediaType) {
+		return true
+	}
+	if body := trx.Body(); body != nil && strings.HasPrefix(mediaType, "multipart/") {
+		return hasCalendarPart(body, params["boundary"], 0)
+	}
+	return false
+}
+
+// maxMIMEDepth limits the nesting of multipart parts that IsCalendar checks.
+const maxMIMEDepth = 10
+
+func hasCalendarPart(r io.Reader, boundary string, depth int) bool {
+	if boundary == "" || depth > maxMIMEDepth {
+		return false
+	}
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextRawPart()
+		if err != nil {
+			return false
+		}
+		mediaType, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			continue
+		}
+		if isCalendarType(mediaType) {
+			return true
+		}
+		if strings.HasPrefix(mediaType, "multipart/") && hasCalendarPart(part, params["boundary"], depth+1) {
+			return true
+		}
+	}
+}
+
+func isCalendarType(mediaType string) bool {
+	return mediaType == "text/calendar" || mediaType == "application/ics"
+}
+
+// headerToken returns the lower-cased value of the first field key in h without comments and parameters.
+func headerToken(h header.Header, key string) string {
+	v := h.UnfoldedValue(key)
+	if i := strings.IndexAny(v, ";("); i >= 0 {
+		v = v[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(v))
+}
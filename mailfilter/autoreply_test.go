@@ -0,0 +1,94 @@
+package mailfilter
+
+import (
+	"context"
+	"testing"
+)
+
+func newAutoReplyTrx(t *testing.T, from string, headers [][2]string, body string) Trx {
+	t.Helper()
+	b, s := newMockBackend()
+	t.Cleanup(b.transaction.cleanup)
+	_, _ = b.MailFrom(from, "", s.newModifier())
+	_, _ = b.RcptTo("root@localhost", "", s.newModifier())
+	for _, h := range headers {
+		_, _ = b.Header(h[0], h[1], s.newModifier())
+	}
+	if body != "" {
+		if _, err := b.BodyChunk([]byte(body), s.newModifier()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.transaction.makeDecision(context.Background(), func(_ context.Context, _ Trx) (Decision, error) {
+		return Accept, nil
+	})
+	return b.transaction
+}
+
+func TestAutoReplyDetection(t *testing.T) {
+	tests := []struct {
+		name                string
+		from                string
+		headers             [][2]string
+		wantAuto, wantReply bool
+		wantBulk            bool
+	}{
+		{"human", "root@localhost", [][2]string{{"Subject", " hi"}}, false, false, false},
+		{"bounce", "", nil, true, false, false},
+		{"auto-submitted-no", "root@localhost", [][2]string{{"Auto-Submitted", " no"}}, false, false, false},
+		{"auto-generated", "root@localhost", [][2]string{{"Auto-Submitted", " auto-generated (cron)"}}, true, false, false},
+		{"auto-replied", "root@localhost", [][2]string{{"auto-submitted", " Auto-Replied; owner-email=\"a@b\""}}, true, true, false},
+		{"x-autoreply", "root@localhost", [][2]string{{"X-Autoreply", " yes"}}, true, true, false},
+		{"precedence-auto-reply", "root@localhost", [][2]string{{"Precedence", " auto_reply"}}, true, true, false},
+		{"precedence-bulk", "root@localhost", [][2]string{{"Precedence", " bulk"}}, true, false, true},
+		{"list-id", "root@localhost", [][2]string{{"List-Id", " <list.example.com>"}}, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trx := newAutoReplyTrx(t, tt.from, tt.headers, "")
+			if got := IsAutoGenerated(trx); got != tt.wantAuto {
+				t.Errorf("IsAutoGenerated() = %v, want %v", got, tt.wantAuto)
+			}
+			if got := IsAutoReply(trx); got != tt.wantReply {
+				t.Errorf("IsAutoReply() = %v, want %v", got, tt.wantReply)
+			}
+			if got := IsBulk(trx); got != tt.wantBulk {
+				t.Errorf("IsBulk() = %v, want %v", got, tt.wantBulk)
+			}
+		})
+	}
+}
+
+func TestIsCalendar(t *testing.T) {
+	invite := "--outer\r\nContent-Type: multipart/alternative; boundary=\"inner\"\r\n\r\n" +
+		"--inner\r\nContent-Type: text/plain\r\n\r\nmeeting\r\n" +
+		"--inner\r\nContent-Type: text/calendar; method=REQUEST\r\n\r\nBEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n" +
+		"--inner--\r\n" +
+		"--outer--\r\n"
+	plain := "--outer\r\nContent-Type: text/plain\r\n\r\nhello\r\n--outer--\r\n"
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        bool
+	}{
+		{"none", "", "", false},
+		{"top-level", " text/calendar; method=REPLY", "", true},
+		{"ics", " application/ics", "", true},
+		{"nested", " multipart/mixed;\r\n boundary=\"outer\"", invite, true},
+		{"no-calendar-part", " multipart/mixed; boundary=\"outer\"", plain, false},
+		{"no-body", " multipart/mixed; boundary=\"outer\"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers [][2]string
+			if tt.contentType != "" {
+				headers = append(headers, [2]string{"Content-Type", tt.contentType})
+			}
+			trx := newAutoReplyTrx(t, "root@localhost", headers, tt.body)
+			if got := IsCalendar(trx); got != tt.want {
+				t.Errorf("IsCalendar() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -25,12 +25,23 @@ func (b *backend) decideOrContinue(stage DecisionAt, m *milter.Modifier) (*milte
 			if b.transaction.decisionErr != nil {
 				return b.error(b.transaction.decisionErr)
 			}
-			return b.transaction.response(), nil
+			return b.response(m), nil
 		}
 	}
 	return milter.RespContinue, nil
 }
 
+// response returns the [milter.Response] of the decision and passes the decision to the audit log of [WithAuditLog].
+// m is the [milter.Modifier] that sent the modifications of the decision.
+func (b *backend) response(m *milter.Modifier) *milter.Response {
+	if b.opts.auditLog != nil {
+		entry := b.transaction.auditEntry()
+		entry.HeaderModificationBytes, entry.BodyModificationBytes = m.ModificationBytes()
+		b.opts.auditLog(entry)
+	}
+	return b.transaction.response()
+}
+
 func (b *backend) error(err error) (*milter.Response, error) {
 	b.Cleanup()
 	switch b.opts.errorHandling {
@@ -51,9 +62,10 @@ func (b *backend) error(err error) (*milter.Response, error) {
 }
 
 func (b *backend) makeDecision(m *milter.Modifier) {
+	b.readCustomMacros(m)
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(m.Context())
 	done := make(chan struct{})
 	go func() {
 		b.transaction.makeDecision(ctx, b.decision)
@@ -82,6 +94,19 @@ func (b *backend) makeDecision(m *milter.Modifier) {
 	}
 }
 
+// readCustomMacros copies the current values of the requested custom macros into the transaction
+func (b *backend) readCustomMacros(m *milter.Modifier) {
+	if len(b.opts.customMacros) == 0 {
+		return
+	}
+	b.transaction.customMacros = make(map[milter.MacroName]string, len(b.opts.customMacros))
+	for _, name := range b.opts.customMacros {
+		if value, ok := m.Macros.GetEx(name); ok {
+			b.transaction.customMacros[name] = value
+		}
+	}
+}
+
 func (b *backend) Connect(host string, family string, port uint16, addr string, m *milter.Modifier) (*milter.Response, error) {
 	b.Cleanup()
 	b.transaction.mta = MTA{
@@ -170,6 +195,11 @@ func (b *backend) Headers(m *milter.Modifier) (*milter.Response, error) {
 	if b.transaction.hasDecision {
 		return milter.RespContinue, nil
 	}
+	if b.opts.trxStore != nil {
+		if err := b.saveSnapshot(); err != nil {
+			return b.error(fmt.Errorf("milter: save transaction snapshot: %w", err))
+		}
+	}
 	return b.decideOrContinue(DecisionAtEndOfHeaders, m)
 }
 
@@ -199,6 +229,9 @@ func (b *backend) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
 		b.transaction.queueId = m.Macros.Get(milter.MacroQueueId)
 	}
 	if !b.transaction.hasDecision {
+		if !b.opts.skipBody {
+			b.transaction.initBody()
+		}
 		b.makeDecision(m)
 	}
 
@@ -206,11 +239,15 @@ func (b *backend) EndOfMessage(m *milter.Modifier) (*milter.Response, error) {
 		return b.error(b.transaction.decisionErr)
 	}
 
-	if err := b.transaction.sendModifications(m); err != nil {
+	if err := b.transaction.applyBodyTransform(b.opts.bodyTransform); err != nil {
+		return b.error(err)
+	}
+
+	if err := b.transaction.sendModifications(m, b.opts.modOrder); err != nil {
 		return b.error(err)
 	}
 
-	response := b.transaction.response()
+	response := b.response(m)
 
 	b.readyForNewMessage()
 
@@ -225,6 +262,7 @@ func (b *backend) Abort(_ *milter.Modifier) error {
 func (b *backend) Cleanup() {
 	if b.transaction != nil {
 		b.transaction.cleanup()
+		b.deleteSnapshot()
 	}
 	b.transaction = &transaction{}
 }
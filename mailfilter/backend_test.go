@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -336,6 +337,78 @@ func Test_backend_decideOrContinue(t *testing.T) {
 	}
 }
 
+func Test_backend_auditLog(t *testing.T) {
+	t.Parallel()
+	b, s := newMockBackend()
+	var entries []AuditEntry
+	b.opts.decisionAt = DecisionAtData
+	b.opts.auditLog = func(entry AuditEntry) {
+		entries = append(entries, entry)
+	}
+	b.decision = func(_ context.Context, _ Trx) (Decision, error) {
+		return TempFailRetryAfter(5*time.Minute, "Greylisted"), nil
+	}
+	_, _ = b.MailFrom("root@localhost", "", s.newModifier())
+	_, _ = b.RcptTo("one@localhost", "", s.newModifier())
+	resp, err := b.Data(s.newModifier())
+	if err != nil {
+		t.Fatalf("got err %s", err)
+	}
+	if resp.Continue() {
+		t.Fatalf("got resp %v expected temp fail", resp)
+	}
+	want := []AuditEntry{{
+		QueueId:    "Q123",
+		MailFrom:   "root@localhost",
+		RcptTos:    []string{"one@localhost"},
+		Code:       451,
+		Reason:     "4.7.1 Greylisted (retry after 300 seconds)",
+		RetryAfter: 5 * time.Minute,
+	}}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("audit log got %+v, want %+v", entries, want)
+	}
+}
+
+func Test_backend_auditLogModificationBytes(t *testing.T) {
+	t.Parallel()
+	b, s := newMockBackend()
+	var entries []AuditEntry
+	b.opts.auditLog = func(entry AuditEntry) {
+		entries = append(entries, entry)
+	}
+	b.decision = func(_ context.Context, trx Trx) (Decision, error) {
+		trx.Headers().Add("X-Test", "1")
+		trx.ReplaceBody(strings.NewReader("new body"))
+		return Accept, nil
+	}
+	_, _ = b.MailFrom("root@localhost", "", s.newModifier())
+	_, _ = b.RcptTo("one@localhost", "", s.newModifier())
+	resp, err := b.BodyChunk([]byte("body"), s.newModifier())
+	assertContinue(t, resp, err)
+	if resp, err := b.EndOfMessage(s.newModifier()); resp != milter.RespAccept || err != nil {
+		t.Fatalf("wrong return %v, %v", resp, err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("audit log got %d entries, want 1", len(entries))
+	}
+	var headerBytes, bodyBytes int
+	for _, msg := range s.modifications {
+		switch wire.ModifyActCode(msg.Code) {
+		case wire.ActReplBody:
+			bodyBytes += len(msg.Data)
+		default:
+			headerBytes += len(msg.Data)
+		}
+	}
+	if bodyBytes != len("new body") || headerBytes == 0 {
+		t.Fatalf("unexpected modifications %v", s.modifications)
+	}
+	if got := entries[0]; got.HeaderModificationBytes != headerBytes || got.BodyModificationBytes != bodyBytes {
+		t.Fatalf("audit log got %d header and %d body bytes, want %d and %d", got.HeaderModificationBytes, got.BodyModificationBytes, headerBytes, bodyBytes)
+	}
+}
+
 func Test_backend_error(t *testing.T) {
 	savedWarning := milter.LogWarning
 	defer func() {
@@ -420,3 +493,54 @@ func Test_backend_makeDecision(t *testing.T) {
 		t.Fatal("values not set")
 	}
 }
+
+func Test_backend_customMacros(t *testing.T) {
+	t.Parallel()
+	b, s := newMockBackend()
+	b.opts.customMacros = []milter.MacroName{"{x-site}", "{x-missing}"}
+	var got map[milter.MacroName]string
+	b.decision = func(_ context.Context, trx Trx) (Decision, error) {
+		got = trx.CustomMacros()
+		return Accept, nil
+	}
+	m := s.newModifier()
+	s.macros.Set("{x-site}", "site-1")
+	b.makeDecision(m)
+	if !reflect.DeepEqual(got, map[milter.MacroName]string{"{x-site}": "site-1"}) {
+		t.Fatalf("CustomMacros() = %v", got)
+	}
+}
+
+func Test_backend_EndOfMessageEmptyBody(t *testing.T) {
+	t.Parallel()
+	b, s := newMockBackend()
+	b.decision = func(_ context.Context, trx Trx) (Decision, error) {
+		if trx.Body() == nil || !trx.IsEmptyBody() {
+			t.Fatalf("Body() = %v, IsEmptyBody() = %v, want an empty body", trx.Body(), trx.IsEmptyBody())
+		}
+		if n := trx.Headers().Fields().Len(); n != 0 {
+			t.Fatalf("Headers().Fields().Len() = %d, want 0", n)
+		}
+		trx.Headers().Add("X-Test", "1")
+		return Accept, nil
+	}
+	resp, err := b.EndOfMessage(s.newModifier())
+	if resp != milter.RespAccept || err != nil {
+		t.Fatalf("wrong return %v, %v", resp, err)
+	}
+	if len(s.modifications) != 1 || s.modifications[0].Code != wire.Code(wire.ActInsertHeader) {
+		t.Fatalf("unexpected modifications %v", s.modifications)
+	}
+
+	b.decision = func(_ context.Context, trx Trx) (Decision, error) {
+		if trx.IsEmptyBody() {
+			t.Fatal("IsEmptyBody() = true for a message with a body")
+		}
+		return Accept, nil
+	}
+	resp, err = b.BodyChunk([]byte("body"), s.newModifier())
+	assertContinue(t, resp, err)
+	if resp, err := b.EndOfMessage(s.newModifier()); resp != milter.RespAccept || err != nil {
+		t.Fatalf("wrong return %v, %v", resp, err)
+	}
+}
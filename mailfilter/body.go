@@ -0,0 +1,63 @@
+package mailfilter
+
+import (
+	"io"
+)
+
+// Body is the spooled body of the current message (see [Trx.Body]).
+//
+// Besides reading it sequentially you can re-examine specific regions of the body with ReadAt or Slice
+// (e.g. the bytes around a matched signature) without re-reading the body from the start.
+type Body interface {
+	io.ReadSeeker
+	io.ReaderAt
+	// Slice returns a reader of the bytes start up to (excluding) end of the body.
+	// Reading the returned reader does not change the read position of the Body.
+	// The reader returns fewer bytes when end is after the end of the body.
+	Slice(start, end int64) *io.SectionReader
+}
+
+// NewBody wraps rs as a [Body]. This is useful when you implement [Trx] yourself.
+//
+// When rs does not implement [io.ReaderAt] the ReadAt method of the returned Body seeks rs
+// and restores the read position of rs afterwards. This is not safe for concurrent use.
+func NewBody(rs io.ReadSeeker) Body {
+	if b, ok := rs.(Body); ok {
+		return b
+	}
+	b := &readSeekerBody{ReadSeeker: rs}
+	b.at, _ = rs.(io.ReaderAt)
+	return b
+}
+
+type readSeekerBody struct {
+	io.ReadSeeker
+	at io.ReaderAt
+}
+
+func (b *readSeekerBody) ReadAt(p []byte, off int64) (n int, err error) {
+	if b.at != nil {
+		return b.at.ReadAt(p, off)
+	}
+	pos, err := b.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = b.Seek(off, io.SeekStart); err == nil {
+		n, err = io.ReadFull(b.ReadSeeker, p)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+	}
+	if _, seekErr := b.Seek(pos, io.SeekStart); seekErr != nil && (err == nil || err == io.EOF) {
+		err = seekErr
+	}
+	return n, err
+}
+
+func (b *readSeekerBody) Slice(start, end int64) *io.SectionReader {
+	if end < start {
+		end = start
+	}
+	return io.NewSectionReader(b, start, end-start)
+}
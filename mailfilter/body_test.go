@@ -0,0 +1,63 @@
+package mailfilter
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// onlyReadSeeker hides the io.ReaderAt implementation of the wrapped reader.
+type onlyReadSeeker struct {
+	io.ReadSeeker
+}
+
+func TestNewBody(t *testing.T) {
+	trx := &transaction{}
+	if err := trx.addBodyChunk([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	defer trx.cleanup()
+	tests := []struct {
+		name string
+		body Body
+	}{
+		{"spooled", trx.Body()},
+		{"ReaderAt", NewBody(bytes.NewReader([]byte("0123456789")))},
+		{"ReadSeeker", NewBody(onlyReadSeeker{bytes.NewReader([]byte("0123456789"))})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf [4]byte
+			if _, err := io.ReadFull(tt.body, buf[:2]); err != nil {
+				t.Fatal(err)
+			}
+			n, err := tt.body.ReadAt(buf[:], 3)
+			if err != nil || string(buf[:n]) != "3456" {
+				t.Fatalf("ReadAt() = %q, %v", buf[:n], err)
+			}
+			n, err = tt.body.ReadAt(buf[:], 7)
+			if err != io.EOF || string(buf[:n]) != "789" {
+				t.Fatalf("ReadAt() = %q, %v", buf[:n], err)
+			}
+			got, err := io.ReadAll(tt.body.Slice(8, 20))
+			if err != nil || string(got) != "89" {
+				t.Fatalf("Slice(8, 20) = %q, %v", got, err)
+			}
+			got, err = io.ReadAll(tt.body.Slice(5, 1))
+			if err != nil || len(got) != 0 {
+				t.Fatalf("Slice(5, 1) = %q, %v", got, err)
+			}
+			got, err = io.ReadAll(tt.body)
+			if err != nil || string(got) != "23456789" {
+				t.Fatalf("read position changed: ReadAll() = %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestNewBody_keepsBody(t *testing.T) {
+	b := NewBody(bytes.NewReader(nil))
+	if NewBody(b) != b {
+		t.Fatal("NewBody wrapped a Body")
+	}
+}
@@ -0,0 +1,66 @@
+package mailfilter
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/d--j/go-milter/internal/body"
+)
+
+// BodyTransformFunc wraps the body of the message of trx. See [WithBodyTransform].
+type BodyTransformFunc func(trx Trx, body io.Reader) io.Reader
+
+// applyBodyTransform runs the body of t through transform and replaces the body with the result,
+// but only when the transform changed the body.
+func (t *transaction) applyBodyTransform(transform BodyTransformFunc) error {
+	if transform == nil || t.body == nil || t.replacementBody != nil || t.decision != Accept {
+		return nil
+	}
+	var transformed *body.Body
+	if t.snapshotID != "" {
+		transformed = body.NewIn(200*1024, t.spoolDir, t.snapshotID+"-*")
+	} else {
+		transformed = body.New(200 * 1024)
+	}
+	if _, err := io.Copy(transformed, transform(t, t.Body())); err != nil {
+		_ = transformed.Close()
+		return err
+	}
+	if _, err := transformed.Seek(0, io.SeekStart); err != nil {
+		_ = transformed.Close()
+		return err
+	}
+	same, err := sameContent(t.Body(), transformed)
+	if err != nil || same {
+		_ = transformed.Close()
+		return err
+	}
+	if _, err := transformed.Seek(0, io.SeekStart); err != nil {
+		_ = transformed.Close()
+		return err
+	}
+	t.replacementBody = transformed
+	return nil
+}
+
+// sameContent returns true when a and b produce the same bytes.
+func sameContent(a, b io.Reader) (bool, error) {
+	bufA, bufB := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		if errA != nil && errA != io.EOF && errA != io.ErrUnexpectedEOF {
+			return false, errA
+		}
+		nB, errB := io.ReadFull(b, bufB)
+		if errB != nil && errB != io.EOF && errB != io.ErrUnexpectedEOF {
+			return false, errB
+		}
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA != nil || errB != nil {
+			// one reader ended, the other one needs to end at the same time
+			return errA != nil && errB != nil, nil
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package mailfilter
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/d--j/go-milter/internal/wire"
+	"golang.org/x/text/transform"
+)
+
+func Test_sameContent(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"abc", "abcd", false},
+		{"abcd", "abc", false},
+		{strings.Repeat("a", 100000), strings.Repeat("a", 100000), true},
+		{strings.Repeat("a", 100000), strings.Repeat("a", 99999) + "b", false},
+	}
+	for _, tt := range tests {
+		got, err := sameContent(strings.NewReader(tt.a), strings.NewReader(tt.b))
+		if err != nil || got != tt.want {
+			t.Errorf("sameContent(%.10q, %.10q) = %v, %v, want %v", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+}
+
+type replaceTransformer struct {
+	transform.NopResetter
+	old, new string
+}
+
+func (r replaceTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	// good enough for the test: src is always complete
+	if !atEOF {
+		return 0, 0, transform.ErrShortSrc
+	}
+	out := strings.ReplaceAll(string(src), r.old, r.new)
+	if len(dst) < len(out) {
+		return 0, 0, transform.ErrShortDst
+	}
+	return copy(dst, out), len(src), nil
+}
+
+func Test_backend_bodyTransform(t *testing.T) {
+	replace := func(old, new string) BodyTransformFunc {
+		return func(_ Trx, body io.Reader) io.Reader {
+			return transform.NewReader(body, replaceTransformer{old: old, new: new})
+		}
+	}
+	tests := []struct {
+		name        string
+		decision    Decision
+		transform   BodyTransformFunc
+		wantReplace string
+	}{
+		{"unchanged", Accept, replace("pixel", "pixel"), ""},
+		{"changed", Accept, replace("pixel", ""), "body with  tracking"},
+		{"rejected", Reject, replace("pixel", ""), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, s := newMockBackend()
+			b.opts.bodyTransform = tt.transform
+			b.decision = func(_ context.Context, _ Trx) (Decision, error) {
+				return tt.decision, nil
+			}
+			_, _ = b.MailFrom("root@localhost", "", s.newModifier())
+			_, _ = b.RcptTo("root@localhost", "", s.newModifier())
+			_, _ = b.Header("Subject", " test", s.newModifier())
+			_, _ = b.BodyChunk([]byte("body with pixel tracking"), s.newModifier())
+			if _, err := b.EndOfMessage(s.newModifier()); err != nil {
+				t.Fatal(err)
+			}
+			var replaced string
+			for _, msg := range s.modifications {
+				if wire.ModifyActCode(msg.Code) == wire.ActReplBody {
+					replaced += string(msg.Data)
+				}
+			}
+			if replaced != tt.wantReplace {
+				t.Errorf("replaced body = %q, want %q (%v)", replaced, tt.wantReplace, outputMessages(s.modifications))
+			}
+		})
+	}
+}
+
+func TestNew_bodyTransformOptions(t *testing.T) {
+	transform := func(_ Trx, body io.Reader) io.Reader { return body }
+	for _, opts := range [][]Option{
+		{WithBodyTransform(transform), WithoutBody()},
+		{WithBodyTransform(transform), WithDecisionAt(DecisionAtEndOfHeaders)},
+	} {
+		if _, err := New("tcp", "127.0.0.1:0", nil, opts...); err == nil {
+			t.Errorf("New() did not return an error")
+		}
+	}
+}
@@ -3,6 +3,8 @@ package mailfilter
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Decision interface {
@@ -65,3 +67,69 @@ func QuarantineResponse(reason string) Decision {
 		reason: reason,
 	}
 }
+
+type tempFailResponse struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (c tempFailResponse) getCode() uint16 {
+	return 451
+}
+
+func (c tempFailResponse) getReason() string {
+	reason := c.reason
+	if reason == "" {
+		reason = TempFail.getReason()
+	}
+	enhanced, text := splitEnhancedCode(reason)
+	if enhanced == "" {
+		enhanced = "4.7.1"
+	}
+	return fmt.Sprintf("%s %s (retry after %d seconds)", enhanced, text, retryAfterSeconds(c.retryAfter))
+}
+
+// splitEnhancedCode splits the RFC 3463 enhanced status code (e.g. "4.7.1") at the start of reason from the text of reason.
+// enhanced is empty when reason does not start with an enhanced status code.
+func splitEnhancedCode(reason string) (enhanced, text string) {
+	first, rest, _ := strings.Cut(reason, " ")
+	parts := strings.Split(first, ".")
+	if len(parts) != 3 {
+		return "", reason
+	}
+	for _, part := range parts {
+		if part == "" || strings.Trim(part, "0123456789") != "" {
+			return "", reason
+		}
+	}
+	return first, rest
+}
+
+// retryAfterSeconds rounds d up to full seconds (at least one second).
+func retryAfterSeconds(d time.Duration) int64 {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		return 1
+	}
+	return secs
+}
+
+// TempFailRetryAfter temporarily rejects the transaction like [TempFail] but suggests the MTA to retry after retryAfter.
+// The suggestion gets appended to the reply text as "(retry after N seconds)". When reason is empty the reason of [TempFail] is used.
+// reason can start with an enhanced status code (e.g. "4.2.1 Mailbox busy"), the default is 4.7.1.
+// The suggested interval is part of the [AuditEntry] of [WithAuditLog]. Use [RetryAfter] to get it from a [Decision].
+func TempFailRetryAfter(retryAfter time.Duration, reason string) Decision {
+	return &tempFailResponse{
+		reason:     reason,
+		retryAfter: retryAfter,
+	}
+}
+
+// RetryAfter returns the suggested retry interval of decision (rounded up to full seconds).
+// ok is false when decision was not created with [TempFailRetryAfter].
+func RetryAfter(decision Decision) (retryAfter time.Duration, ok bool) {
+	if t, isTempFail := decision.(*tempFailResponse); isTempFail {
+		return time.Duration(retryAfterSeconds(t.retryAfter)) * time.Second, true
+	}
+	return 0, false
+}
@@ -3,6 +3,7 @@ package mailfilter
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestCustomErrorResponse(t *testing.T) {
@@ -137,3 +138,63 @@ func TestQuarantineResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestTempFailRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter time.Duration
+		reason     string
+		wantReason string
+		wantAfter  time.Duration
+	}{
+		{"works", 5 * time.Minute, "Greylisted", "4.7.1 Greylisted (retry after 300 seconds)", 5 * time.Minute},
+		{"default-reason", time.Minute, "", "4.7.1 Service unavailable - try again later (retry after 60 seconds)", time.Minute},
+		{"round-up", 1500 * time.Millisecond, "x", "4.7.1 x (retry after 2 seconds)", 2 * time.Second},
+		{"minimum", 0, "x", "4.7.1 x (retry after 1 seconds)", time.Second},
+		{"enhanced-code", time.Minute, "4.2.1 Mailbox busy", "4.2.1 Mailbox busy (retry after 60 seconds)", time.Minute},
+		{"not-an-enhanced-code", time.Minute, "4.2 x", "4.7.1 4.2 x (retry after 60 seconds)", time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TempFailRetryAfter(tt.retryAfter, tt.reason)
+			if got.getCode() != 451 {
+				t.Errorf("getCode() = %v, want 451", got.getCode())
+			}
+			if got.getReason() != tt.wantReason {
+				t.Errorf("getReason() = %q, want %q", got.getReason(), tt.wantReason)
+			}
+			after, ok := RetryAfter(got)
+			if !ok || after != tt.wantAfter {
+				t.Errorf("RetryAfter() = %v, %v, want %v, true", after, ok, tt.wantAfter)
+			}
+		})
+	}
+	if _, ok := RetryAfter(TempFail); ok {
+		t.Errorf("RetryAfter(TempFail) = _, true, want _, false")
+	}
+}
+
+func Test_splitEnhancedCode(t *testing.T) {
+	tests := []struct {
+		reason       string
+		wantEnhanced string
+		wantText     string
+	}{
+		{"4.7.1 Service unavailable", "4.7.1", "Service unavailable"},
+		{"5.1.10 x", "5.1.10", "x"},
+		{"4.7.1", "4.7.1", ""},
+		{"Service unavailable", "", "Service unavailable"},
+		{"4.7 x", "", "4.7 x"},
+		{"4..1 x", "", "4..1 x"},
+		{"4.a.1 x", "", "4.a.1 x"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason, func(t *testing.T) {
+			enhanced, text := splitEnhancedCode(tt.reason)
+			if enhanced != tt.wantEnhanced || text != tt.wantText {
+				t.Errorf("splitEnhancedCode() = %q, %q, want %q, %q", enhanced, text, tt.wantEnhanced, tt.wantText)
+			}
+		})
+	}
+}
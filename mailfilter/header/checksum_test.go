@@ -0,0 +1,84 @@
+package header_test
+
+import (
+	"errors"
+	"testing"
+
+	internal "github.com/d--j/go-milter/internal/header"
+	"github.com/d--j/go-milter/mailfilter/header"
+)
+
+func newHeader(t *testing.T, raw string) *internal.Header {
+	t.Helper()
+	h, err := internal.New([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestChecksum(t *testing.T) {
+	oldKey := header.ChecksumKey{ID: "old", Secret: []byte("old secret")}
+	newKey := header.ChecksumKey{ID: "new", Secret: []byte("new secret")}
+	c := header.NewChecksum("X-Trust-Sum", []header.ChecksumKey{oldKey}, "x-trust", "From", "X-Trust-Sum")
+	raw := "From: <root@localhost>\r\nX-Trust: spf=pass\r\nSubject: test\r\n\r\n"
+
+	h := newHeader(t, raw)
+	if err := c.Verify(h); !errors.Is(err, header.ErrChecksumMissing) {
+		t.Fatalf("Verify() = %v, want ErrChecksumMissing", err)
+	}
+	c.Sign(h)
+	if err := c.Verify(h); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	// not covered fields can change
+	h.SetSubject("changed")
+	if err := c.Verify(h); err != nil {
+		t.Fatalf("Verify() after subject change = %v", err)
+	}
+
+	// rotation: new key signs, old checksums still verify
+	rotated := header.NewChecksum("X-Trust-Sum", []header.ChecksumKey{newKey, oldKey}, "X-Trust", "From")
+	if err := rotated.Verify(h); err != nil {
+		t.Fatalf("Verify() with rotated keys = %v", err)
+	}
+	rotated.Sign(h)
+	if err := rotated.Verify(h); err != nil {
+		t.Fatalf("Verify() with rotated keys = %v", err)
+	}
+	if err := c.Verify(h); !errors.Is(err, header.ErrChecksumMismatch) {
+		t.Fatalf("Verify() with unknown key = %v, want ErrChecksumMismatch", err)
+	}
+
+	// tampering
+	tampered := newHeader(t, raw)
+	c.Sign(tampered)
+	tampered.Add("X-Trust", "dkim=pass")
+	if err := c.Verify(tampered); !errors.Is(err, header.ErrChecksumMismatch) {
+		t.Fatalf("Verify() of added field = %v, want ErrChecksumMismatch", err)
+	}
+	tampered = newHeader(t, raw)
+	c.Sign(tampered)
+	tampered.Set("X-Trust", "spf=fail")
+	if err := c.Verify(tampered); !errors.Is(err, header.ErrChecksumMismatch) {
+		t.Fatalf("Verify() of changed field = %v, want ErrChecksumMismatch", err)
+	}
+	tampered.Set("X-Trust-Sum", "k=old; s=!!!")
+	if err := c.Verify(tampered); !errors.Is(err, header.ErrChecksumMismatch) {
+		t.Fatalf("Verify() of invalid checksum = %v, want ErrChecksumMismatch", err)
+	}
+}
+
+func TestNewChecksum_panics(t *testing.T) {
+	for _, keys := range [][]header.ChecksumKey{nil, {{ID: "", Secret: []byte("x")}}, {{ID: "a;b", Secret: []byte("x")}}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewChecksum(%v) did not panic", keys)
+				}
+			}()
+			header.NewChecksum("X-Sum", keys, "From")
+		}()
+	}
+}
@@ -0,0 +1,51 @@
+package mailfilter
+
+import (
+	"context"
+	"strings"
+)
+
+// LoopDetected is the default [Decision] of [DetectLoops] for messages that are in a mail loop.
+var LoopDetected = CustomErrorResponse(554, "5.4.6 Mail loop detected")
+
+// DetectLoops wraps decide and stops messages that are in a mail loop before decide gets called.
+//
+// A message is in a loop when it has more than maxReceived Received header fields (0 disables this check)
+// or when it already has a header field markerKey with the value markerValue (the comparison is case-insensitive).
+// loop is the [Decision] for these messages (e.g. [Discard]); when it is nil [LoopDetected] is used.
+//
+// When decide accepts the message, the header field markerKey: markerValue gets added to it,
+// so that the next time this message passes the filter, the loop gets detected. Leave markerKey empty
+// to only count the Received header fields.
+// Use this with [DecisionAtEndOfHeaders] or [DecisionAtEndOfMessage], otherwise there are no header fields to check.
+func DetectLoops(decide DecisionModificationFunc, loop Decision, maxReceived int, markerKey, markerValue string) DecisionModificationFunc {
+	if loop == nil {
+		loop = LoopDetected
+	}
+	return func(ctx context.Context, trx Trx) (Decision, error) {
+		received := 0
+		fields := trx.Headers().Fields()
+		for fields.Next() {
+			switch {
+			case fields.IsDeleted():
+			case fields.CanonicalKey() == "Received":
+				received++
+			case markerKey != "" && strings.EqualFold(fields.Key(), markerKey) && strings.EqualFold(strings.TrimSpace(fields.UnfoldedValue()), markerValue):
+				return loop, nil
+			}
+		}
+		if maxReceived > 0 && received > maxReceived {
+			return loop, nil
+		}
+		decision, err := decide(ctx, trx)
+		if err == nil && markerKey != "" && isAccepted(decision) {
+			trx.Headers().Add(markerKey, markerValue)
+		}
+		return decision, err
+	}
+}
+
+// isAccepted returns true when decision lets the message through.
+func isAccepted(decision Decision) bool {
+	return decision != nil && decision != Discard && decision.getCode() < 300
+}
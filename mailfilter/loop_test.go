@@ -0,0 +1,63 @@
+package mailfilter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+func TestDetectLoops(t *testing.T) {
+	decide := func(d Decision) DecisionModificationFunc {
+		return func(_ context.Context, _ Trx) (Decision, error) {
+			return d, nil
+		}
+	}
+	tests := []struct {
+		name      string
+		headers   [][2]string
+		decide    Decision
+		loop      Decision
+		want      Decision
+		wantAdded bool
+	}{
+		{"accept", [][2]string{{"Received", " a"}}, Accept, nil, Accept, true},
+		{"quarantine", nil, QuarantineResponse("test"), nil, Accept, true},
+		{"reject", nil, Reject, nil, Reject, false},
+		{"discard", nil, Discard, nil, Discard, false},
+		{"too-many-received", [][2]string{{"Received", " a"}, {"Received", " b"}, {"Received", " c"}}, Accept, nil, LoopDetected, false},
+		{"marker", [][2]string{{"x-loop", " FILTER.example.com"}}, Accept, Discard, Discard, false},
+		{"other-marker", [][2]string{{"X-Loop", " other.example.com"}}, Accept, nil, Accept, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, s := newMockBackend()
+			t.Cleanup(b.transaction.cleanup)
+			_, _ = b.MailFrom("", "", s.newModifier())
+			_, _ = b.RcptTo("root@localhost", "", s.newModifier())
+			_, _ = b.Header("From", " <>", s.newModifier())
+			for _, h := range tt.headers {
+				_, _ = b.Header(h[0], h[1], s.newModifier())
+			}
+			b.transaction.makeDecision(context.Background(), DetectLoops(decide(tt.decide), tt.loop, 2, "X-Loop", "filter.example.com"))
+			if b.transaction.decisionErr != nil {
+				t.Fatal(b.transaction.decisionErr)
+			}
+			if b.transaction.decision != tt.want {
+				t.Errorf("decision = %v, want %v", b.transaction.decision, tt.want)
+			}
+			if err := b.transaction.sendModifications(s.newModifier(), nil); err != nil {
+				t.Fatal(err)
+			}
+			added := false
+			for _, msg := range s.modifications {
+				if wire.ModifyActCode(msg.Code) == wire.ActInsertHeader {
+					added = true
+				}
+			}
+			if added != tt.wantAdded {
+				t.Errorf("marker added = %v, want %v (%v)", added, tt.wantAdded, outputMessages(s.modifications))
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package mailfilter
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
 	"sync"
 
@@ -11,14 +13,15 @@ import (
 
 // DecisionModificationFunc is the callback function that you need to implement to create a mail filter.
 //
-// ctx is a [context.Context] that might get canceled when the connection to the MTA fails while your callback is running.
+// ctx is a [context.Context] that gets canceled when the connection to the MTA fails while your callback is running
+// or the [MailFilter] gets shut down.
 // If your decision function is running longer than one second the [MailFilter] automatically sends progress notifications
 // every second so that MTA does not time out the milter connection.
 //
 // trx is the [Trx] object that you can inspect to see what the [MailFilter] got as information about the current SMTP transaction.
 // You can also use trx to modify the transaction (e.g. change recipients, alter headers).
 //
-// decision is your [Decision] about this SMTP transaction. Use [Accept], [TempFail], [Reject], [Discard] or [CustomErrorResponse].
+// decision is your [Decision] about this SMTP transaction. Use [Accept], [TempFail], [TempFailRetryAfter], [Reject], [Discard] or [CustomErrorResponse].
 //
 // If you return a non-nil error [WithErrorHandling] will determine what happens with the current SMTP transaction.
 type DecisionModificationFunc func(ctx context.Context, trx Trx) (decision Decision, err error)
@@ -41,6 +44,26 @@ func New(network, address string, decision DecisionModificationFunc, opts ...Opt
 	for _, o := range opts {
 		o(&resolvedOptions)
 	}
+	for _, name := range resolvedOptions.customMacros {
+		if err := milter.ValidateCustomMacro(name); err != nil {
+			return nil, err
+		}
+	}
+	if resolvedOptions.bodyTransform != nil && (resolvedOptions.decisionAt != DecisionAtEndOfMessage || resolvedOptions.skipBody) {
+		return nil, errors.New("mailfilter: WithBodyTransform needs DecisionAtEndOfMessage and cannot be used with WithoutBody")
+	}
+	if resolvedOptions.modOrder != nil {
+		if err := validateModificationOrder(resolvedOptions.modOrder); err != nil {
+			return nil, err
+		}
+	}
+	if resolvedOptions.trxStore != nil && resolvedOptions.orphanCleanup {
+		orphans, err := reconcileOrphans(resolvedOptions.trxStore, resolvedOptions.orphanMinAge)
+		if err != nil {
+			return nil, err
+		}
+		logOrphans(orphans)
+	}
 
 	actions := milter.AllClientSupportedActionMasks
 	protocols := milter.OptHeaderLeadingSpace | milter.OptNoUnknown
@@ -80,6 +103,10 @@ func New(network, address string, decision DecisionModificationFunc, opts ...Opt
 		macroStages = append(macroStages, []milter.MacroName{})                    //StageEOH
 	}
 
+	for i := range macroStages {
+		macroStages[i] = append(macroStages[i], resolvedOptions.customMacros...)
+	}
+
 	milterOptions := []milter.Option{
 		milter.WithDynamicMilter(func(version uint32, action milter.OptAction, protocol milter.OptProtocol, maxData milter.DataSize) milter.Milter {
 			return &backend{
@@ -91,6 +118,8 @@ func New(network, address string, decision DecisionModificationFunc, opts ...Opt
 		}),
 		milter.WithActions(actions),
 		milter.WithProtocols(protocols),
+		milter.WithNegotiationCallback(negotiate),
+		milter.WithBodyPolicy(resolvedOptions.bodyPolicy),
 	}
 	for i, macros := range macroStages {
 		milterOptions = append(milterOptions, milter.WithMacroRequest(milter.MacroStage(i), macros))
@@ -122,6 +151,25 @@ func New(network, address string, decision DecisionModificationFunc, opts ...Opt
 	return f, nil
 }
 
+// optionalActions are the actions that we only use when the MTA offers them
+const optionalActions = milter.OptAddRcptWithArgs
+
+// negotiate does the default milter protocol negotiation but does not fail when the MTA does not offer
+// one of the optionalActions. [Trx.AddRcptTo] with ESMTP arguments will fail in this case.
+func negotiate(mtaVersion, _ uint32, mtaActions, milterActions milter.OptAction, mtaProtocol, milterProtocol milter.OptProtocol, offeredDataSize milter.DataSize) (version uint32, actions milter.OptAction, protocol milter.OptProtocol, maxDataSize milter.DataSize, err error) {
+	if mtaVersion < 2 || mtaVersion > milter.MaxServerProtocolVersion {
+		return 0, 0, 0, 0, fmt.Errorf("milter: negotiate: unsupported protocol version: %d", mtaVersion)
+	}
+	milterActions = milterActions &^ (optionalActions &^ mtaActions)
+	if milterActions&mtaActions != milterActions {
+		return 0, 0, 0, 0, fmt.Errorf("milter: negotiate: MTA does not offer required actions. offered: %032b requested: %032b", mtaActions, milterActions)
+	}
+	if milterProtocol&mtaProtocol != milterProtocol {
+		return 0, 0, 0, 0, fmt.Errorf("milter: negotiate: MTA does not offer required protocol options. offered: %032b requested: %032b", mtaProtocol, milterProtocol)
+	}
+	return mtaVersion, milterActions, milterProtocol, offeredDataSize, nil
+}
+
 // Addr returns the [net.Addr] of the listening socket of this [MailFilter].
 // This method returns nil when the socket is not set.
 func (f *MailFilter) Addr() net.Addr {
@@ -0,0 +1,36 @@
+package mailfilter
+
+import (
+	"testing"
+
+	"github.com/d--j/go-milter"
+)
+
+func Test_negotiate(t *testing.T) {
+	t.Parallel()
+	const all = milter.AllClientSupportedActionMasks
+	tests := []struct {
+		name        string
+		mtaVersion  uint32
+		mtaActions  milter.OptAction
+		mtaProtocol milter.OptProtocol
+		wantActions milter.OptAction
+		wantErr     bool
+	}{
+		{"all", 6, all, milter.OptNoUnknown, all, false},
+		{"without add-rcpt-par", 6, all &^ milter.OptAddRcptWithArgs, milter.OptNoUnknown, all &^ milter.OptAddRcptWithArgs, false},
+		{"without quarantine", 6, all &^ milter.OptQuarantine, milter.OptNoUnknown, 0, true},
+		{"missing protocol", 6, all, 0, 0, true},
+		{"version", 1, all, milter.OptNoUnknown, 0, true},
+	}
+	for _, tt := range tests {
+		version, actions, protocol, dataSize, err := negotiate(tt.mtaVersion, milter.MaxServerProtocolVersion, tt.mtaActions, all, tt.mtaProtocol, milter.OptNoUnknown, milter.DataSize256K)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: negotiate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (version != tt.mtaVersion || actions != tt.wantActions || protocol != milter.OptNoUnknown || dataSize != milter.DataSize256K) {
+			t.Errorf("%s: negotiate() = %d, %032b, %032b, %d", tt.name, version, actions, protocol, dataSize)
+		}
+	}
+}
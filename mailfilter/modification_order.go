@@ -0,0 +1,41 @@
+package mailfilter
+
+import "errors"
+
+// ModificationKind is a group of modifications that the [MailFilter] sends to the MTA. See [WithModificationOrder].
+type ModificationKind int
+
+const (
+	// ModifyMailFrom changes the envelope sender.
+	ModifyMailFrom ModificationKind = iota
+	// ModifyRecipients deletes and adds envelope recipients (deletions first).
+	ModifyRecipients
+	// ModifyHeaders changes, deletes and inserts existing header fields.
+	ModifyHeaders
+	// ModifyAddHeaders adds header fields at the end of the header.
+	ModifyAddHeaders
+	// ModifyBody replaces the body.
+	ModifyBody
+	// ModifyQuarantine quarantines the message.
+	ModifyQuarantine
+	// modificationKindCount is the number of modification kinds.
+	modificationKindCount
+)
+
+// defaultModificationOrder is the order the [MailFilter] uses when [WithModificationOrder] was not used.
+var defaultModificationOrder = []ModificationKind{ModifyMailFrom, ModifyRecipients, ModifyHeaders, ModifyAddHeaders, ModifyBody, ModifyQuarantine}
+
+// validateModificationOrder checks that order contains every [ModificationKind] exactly once.
+func validateModificationOrder(order []ModificationKind) error {
+	var seen [modificationKindCount]bool
+	for _, kind := range order {
+		if kind < 0 || kind >= modificationKindCount || seen[kind] {
+			return errors.New("mailfilter: WithModificationOrder needs every ModificationKind exactly once")
+		}
+		seen[kind] = true
+	}
+	if len(order) != int(modificationKindCount) {
+		return errors.New("mailfilter: WithModificationOrder needs every ModificationKind exactly once")
+	}
+	return nil
+}
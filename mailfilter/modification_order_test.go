@@ -0,0 +1,70 @@
+package mailfilter
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+func TestTransaction_sendModifications_order(t *testing.T) {
+	decide := func(_ context.Context, trx Trx) (Decision, error) {
+		trx.ChangeMailFrom("root@localhost", "")
+		trx.AddRcptTo("someone@localhost", "")
+		trx.Headers().Set("Subject", "changed")
+		trx.Headers().Add("X-New", "1")
+		trx.ReplaceBody(strings.NewReader("new body"))
+		return QuarantineResponse("test"), nil
+	}
+	tests := []struct {
+		name  string
+		order []ModificationKind
+		want  []wire.ModifyActCode
+	}{
+		{"default", nil, []wire.ModifyActCode{wire.ActChangeFrom, wire.ActAddRcpt, wire.ActChangeHeader, wire.ActInsertHeader, wire.ActReplBody, wire.ActQuarantine}},
+		{"body-first", []ModificationKind{ModifyBody, ModifyQuarantine, ModifyAddHeaders, ModifyHeaders, ModifyRecipients, ModifyMailFrom},
+			[]wire.ModifyActCode{wire.ActReplBody, wire.ActQuarantine, wire.ActInsertHeader, wire.ActChangeHeader, wire.ActAddRcpt, wire.ActChangeFrom}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, s := newMockBackend()
+			t.Cleanup(b.transaction.cleanup)
+			_, _ = b.MailFrom("", "", s.newModifier())
+			_, _ = b.RcptTo("root@localhost", "", s.newModifier())
+			_, _ = b.Header("Subject", " test", s.newModifier())
+			_, _ = b.BodyChunk([]byte("body"), s.newModifier())
+			b.transaction.makeDecision(context.Background(), decide)
+			if b.transaction.decisionErr != nil {
+				t.Fatal(b.transaction.decisionErr)
+			}
+			if err := b.transaction.sendModifications(s.newModifier(), tt.order); err != nil {
+				t.Fatal(err)
+			}
+			var got []wire.ModifyActCode
+			for _, msg := range s.modifications {
+				got = append(got, wire.ModifyActCode(msg.Code))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sendModifications() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_modificationOrder(t *testing.T) {
+	for _, order := range [][]ModificationKind{
+		{},
+		{ModifyBody},
+		{ModifyMailFrom, ModifyRecipients, ModifyHeaders, ModifyAddHeaders, ModifyBody, ModifyBody},
+		{ModifyMailFrom, ModifyRecipients, ModifyHeaders, ModifyAddHeaders, ModifyBody, ModifyQuarantine, ModificationKind(99)},
+	} {
+		if _, err := New("tcp", "127.0.0.1:0", nil, WithModificationOrder(order...)); err == nil {
+			t.Errorf("New() with order %v did not return an error", order)
+		}
+	}
+	if err := validateModificationOrder(defaultModificationOrder); err != nil {
+		t.Errorf("default order is invalid: %v", err)
+	}
+}
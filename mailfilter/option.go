@@ -1,5 +1,11 @@
 package mailfilter
 
+import (
+	"time"
+
+	"github.com/d--j/go-milter"
+)
+
 // DecisionAt defines when the filter decision is made.
 type DecisionAt int
 
@@ -41,6 +47,15 @@ type options struct {
 	decisionAt    DecisionAt
 	errorHandling ErrorHandling
 	skipBody      bool
+	customMacros  []milter.MacroName
+	bodyPolicy    milter.BodyPolicy
+	trxStore      TrxStore
+	spoolDir      string
+	orphanCleanup bool
+	orphanMinAge  time.Duration
+	bodyTransform BodyTransformFunc
+	modOrder      []ModificationKind
+	auditLog      AuditLogFunc
 }
 
 type Option func(opt *options)
@@ -67,3 +82,106 @@ func WithoutBody() Option {
 		opt.skipBody = true
 	}
 }
+
+// WithCustomMacros requests the site-specific macros names (see [milter.CustomMacro]) from the MTA at every stage
+// and makes their values available in [Trx.CustomMacros].
+// [New] returns an error when one of the names is not a valid custom macro name.
+func WithCustomMacros(names ...milter.MacroName) Option {
+	return func(opt *options) {
+		opt.customMacros = append(opt.customMacros, names...)
+	}
+}
+
+// WithBodyPolicy sets the [milter.BodyPolicy] for NUL bytes and bare CR or LF characters in the mail body.
+// The policy gets applied before the body gets spooled, so [Trx.Body] only contains sanitized data with [milter.BodySanitize].
+// With [milter.BodyReject] messages with such a body get rejected before the decision function gets called.
+// The default is [milter.BodyPassThrough].
+func WithBodyPolicy(policy milter.BodyPolicy) Option {
+	return func(opt *options) {
+		opt.bodyPolicy = policy
+	}
+}
+
+// WithTrxStore makes the [MailFilter] save a [TrxSnapshot] of every transaction in store at the end of the headers
+// and spool the body of the transaction to files in spoolDir (an empty spoolDir means [os.TempDir]).
+// The snapshot gets deleted when the transaction ends.
+// When saving the snapshot fails, [WithErrorHandling] determines what happens with the transaction (the default is to temporarily reject it).
+//
+// When the filter process crashes, the snapshots of the running transactions stay in store.
+// Use [WithOrphanCleanup] to remove them.
+//
+// Snapshots only get taken when [WithDecisionAt] is [DecisionAtEndOfHeaders] or [DecisionAtEndOfMessage].
+func WithTrxStore(store TrxStore, spoolDir string) Option {
+	return func(opt *options) {
+		opt.trxStore = store
+		opt.spoolDir = spoolDir
+	}
+}
+
+// WithOrphanCleanup makes [New] remove the snapshots of the [WithTrxStore] store that were taken at least minAge ago,
+// together with their spool files, and log them with [milter.LogWarning].
+// These are the transactions of a filter process that crashed.
+//
+// New cannot tell the snapshots of a crashed process apart from the snapshots of another running process.
+// When store is only used by this [MailFilter], minAge can be 0. When other filter processes share store, choose a minAge
+// that is longer than your longest transaction, otherwise New removes the snapshots of their running transactions.
+func WithOrphanCleanup(minAge time.Duration) Option {
+	return func(opt *options) {
+		opt.orphanCleanup = true
+		opt.orphanMinAge = minAge
+	}
+}
+
+// WithBodyTransform makes the [MailFilter] run the body of every accepted message through transform
+// (e.g. to remove tracking pixels or to add a disclaimer). transform gets called after the decision function
+// when the decision function did not call [Trx.ReplaceBody] itself.
+// The body only gets replaced at the MTA when the transformed body differs from the original body,
+// so you do not pay for a full body replacement when transform did not change anything.
+//
+// This option only works with [DecisionAtEndOfMessage] and not together with [WithoutBody], [New] returns an error otherwise.
+func WithBodyTransform(transform BodyTransformFunc) Option {
+	return func(opt *options) {
+		opt.bodyTransform = transform
+	}
+}
+
+// WithModificationOrder sets the order in which the [MailFilter] sends the modifications of a message to the MTA.
+// order needs to contain every [ModificationKind] exactly once, [New] returns an error otherwise.
+//
+// The default order is [ModifyMailFrom], [ModifyRecipients], [ModifyHeaders], [ModifyAddHeaders], [ModifyBody], [ModifyQuarantine].
+// Within one kind the order is always the same: recipient deletions come before additions and
+// header changes and insertions get sent from the last header field to the first, so that the indexes stay valid.
+// Use this option for MTAs that are picky about the order, e.g. to send the body replacement before all header modifications.
+func WithModificationOrder(order ...ModificationKind) Option {
+	return func(opt *options) {
+		opt.modOrder = order
+	}
+}
+
+// AuditEntry describes the decision of the [MailFilter] about one transaction.
+type AuditEntry struct {
+	QueueId  string
+	MailFrom string
+	RcptTos  []string
+	// Code and Reason are the SMTP code and reply text of the decision (e.g. 250 and "accept" for [Accept]).
+	Code   uint16
+	Reason string
+	// RetryAfter is the suggested retry interval of a [TempFailRetryAfter] decision, it is 0 for all other decisions.
+	RetryAfter time.Duration
+	// HeaderModificationBytes and BodyModificationBytes are the number of bytes of the header and body modifications
+	// that the [MailFilter] sent to the MTA for this transaction (see [milter.Modifier.ModificationBytes]).
+	HeaderModificationBytes int
+	BodyModificationBytes   int
+}
+
+// AuditLogFunc gets called by the [MailFilter] with the decision of every transaction.
+type AuditLogFunc func(entry AuditEntry)
+
+// WithAuditLog makes the [MailFilter] call log with the decision of every transaction,
+// e.g. to tell postmasters why and for how long a message got deferred.
+// Transactions that fail with an error (see [WithErrorHandling]) do not get logged.
+func WithAuditLog(log AuditLogFunc) Option {
+	return func(opt *options) {
+		opt.auditLog = log
+	}
+}
@@ -0,0 +1,135 @@
+package mailfilter
+
+import (
+	"context"
+	"strings"
+
+	"github.com/d--j/go-milter/mailfilter/addr"
+)
+
+// Router dispatches transactions to different decision functions based on the domains of the recipients or the sender.
+// Use [Router.Decide] as the [DecisionModificationFunc] of your [MailFilter].
+//
+// A domain pattern is either a domain name (e.g. "example.com") that only matches this domain,
+// a wildcard pattern (e.g. "*.example.com") that matches all subdomains of a domain (but not the domain itself)
+// or "*" that matches all domains. When more than one pattern matches a domain, the longest pattern wins.
+// Domains and patterns get compared case-insensitive in their ASCII (punycode) form (see [addr.IDNAProfile]),
+// so "*.bücher.example" and "*.xn--bcher-kva.example" are the same pattern.
+//
+// Recipient routes take precedence over sender routes. Recipients that do not match a recipient route
+// get handled by the matching sender route or – when there is none – by the fallback.
+// When the recipients of a message belong to different routes, each of these decision functions gets called
+// in the order of the recipients until one of them does not return [Accept]. Its decision is the decision of the transaction.
+type Router struct {
+	recipients []route
+	senders    []route
+	fallback   DecisionModificationFunc
+}
+
+type route struct {
+	pattern  string
+	decision DecisionModificationFunc
+}
+
+// NewRouter creates a new [Router]. fallback gets called when no route matches. It can be nil, then the [Router] accepts these transactions.
+func NewRouter(fallback DecisionModificationFunc) *Router {
+	return &Router{fallback: fallback}
+}
+
+// RecipientDomain routes transactions with recipients in domains matching pattern to decision.
+func (r *Router) RecipientDomain(pattern string, decision DecisionModificationFunc) *Router {
+	r.recipients = append(r.recipients, route{pattern: asciiPattern(pattern), decision: decision})
+	return r
+}
+
+// SenderDomain routes transactions with a sender in a domain matching pattern to decision.
+func (r *Router) SenderDomain(pattern string, decision DecisionModificationFunc) *Router {
+	r.senders = append(r.senders, route{pattern: asciiPattern(pattern), decision: decision})
+	return r
+}
+
+// asciiPattern converts the domain of pattern to lower-case ASCII (punycode).
+// Patterns that are not valid domain names only get converted to lower-case.
+func asciiPattern(pattern string) string {
+	prefix, domain := "", pattern
+	if strings.HasPrefix(pattern, "*.") {
+		prefix, domain = "*.", pattern[2:]
+	}
+	if domain != "*" {
+		if ascii, err := addr.IDNAProfile.ToASCII(domain); err == nil {
+			domain = ascii
+		}
+	}
+	return prefix + strings.ToLower(domain)
+}
+
+// Decide is the [DecisionModificationFunc] of r.
+func (r *Router) Decide(ctx context.Context, trx Trx) (Decision, error) {
+	var decisions []DecisionModificationFunc
+	var routes []*route
+	unrouted := false
+	// unroutedDecision adds the decision function for the recipients without a recipient route (only once)
+	unroutedDecision := func() {
+		if unrouted {
+			return
+		}
+		unrouted = true
+		if rt := matchRoute(r.senders, trx.MailFrom().AsciiDomain()); rt != nil {
+			decisions = append(decisions, rt.decision)
+		} else if r.fallback != nil {
+			decisions = append(decisions, r.fallback)
+		}
+	}
+	for _, rcptTo := range trx.RcptTos() {
+		rt := matchRoute(r.recipients, rcptTo.AsciiDomain())
+		if rt == nil {
+			unroutedDecision()
+		} else if !containsRoute(routes, rt) {
+			routes = append(routes, rt)
+			decisions = append(decisions, rt.decision)
+		}
+	}
+	if len(routes) == 0 {
+		unroutedDecision()
+	}
+	for _, decide := range decisions {
+		decision, err := decide(ctx, trx)
+		if err != nil || decision != Accept {
+			return decision, err
+		}
+	}
+	return Accept, nil
+}
+
+// matchRoute returns the route of routes with the longest pattern that matches domain or nil.
+func matchRoute(routes []route, domain string) *route {
+	domain = strings.ToLower(domain)
+	var best *route
+	for i := range routes {
+		rt := &routes[i]
+		if matchDomain(rt.pattern, domain) && (best == nil || len(rt.pattern) > len(best.pattern)) {
+			best = rt
+		}
+	}
+	return best
+}
+
+func matchDomain(pattern, domain string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "*."):
+		return len(domain) > len(pattern)-1 && strings.HasSuffix(domain, pattern[1:])
+	default:
+		return pattern == domain
+	}
+}
+
+func containsRoute(routes []*route, rt *route) bool {
+	for _, r := range routes {
+		if r == rt {
+			return true
+		}
+	}
+	return false
+}
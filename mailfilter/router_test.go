@@ -0,0 +1,115 @@
+package mailfilter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/d--j/go-milter/mailfilter/addr"
+)
+
+func Test_matchDomain(t *testing.T) {
+	tests := []struct {
+		pattern, domain string
+		want            bool
+	}{
+		{"*", "example.com", true},
+		{"*", "", true},
+		{"example.com", "example.com", true},
+		{"example.com", "sub.example.com", false},
+		{"*.example.com", "sub.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+		{"*.example.com", ".example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.domain, func(t *testing.T) {
+			if got := matchDomain(tt.pattern, tt.domain); got != tt.want {
+				t.Errorf("matchDomain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_asciiPattern(t *testing.T) {
+	tests := []struct {
+		pattern, want string
+	}{
+		{"*", "*"},
+		{"Example.COM", "example.com"},
+		{"*.Example.com", "*.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"*.Bücher.example", "*.xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if got := asciiPattern(tt.pattern); got != tt.want {
+				t.Errorf("asciiPattern() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouter_Decide(t *testing.T) {
+	var called []string
+	decide := func(name string, decision Decision) DecisionModificationFunc {
+		return func(ctx context.Context, trx Trx) (Decision, error) {
+			called = append(called, name)
+			return decision, nil
+		}
+	}
+	router := NewRouter(decide("fallback", Accept)).
+		RecipientDomain("*", decide("rcpt-any", Accept)).
+		RecipientDomain("*.Example.com", decide("rcpt-sub", Accept)).
+		RecipientDomain("spam.example.com", decide("rcpt-spam", Reject)).
+		RecipientDomain("example.net", decide("rcpt-net", TempFail)).
+		SenderDomain("example.org", decide("sender-org", Discard))
+	noRcpt := NewRouter(decide("fallback", Accept)).
+		SenderDomain("example.org", decide("sender-org", Discard))
+	partial := NewRouter(decide("fallback", Reject)).
+		RecipientDomain("example.net", decide("rcpt-net", Accept)).
+		SenderDomain("example.org", decide("sender-org", Discard))
+	idn := NewRouter(decide("fallback", Reject)).
+		RecipientDomain("BÜCHER.example", decide("rcpt-idn", Accept)).
+		RecipientDomain("*.bücher.example", decide("rcpt-idn-sub", Accept))
+	tests := []struct {
+		name     string
+		router   *Router
+		from     string
+		rcptTos  []a
+		want     Decision
+		wantCall []string
+	}{
+		{"any", router, "root@example.org", []a{{Addr: "root@localhost"}}, Accept, []string{"rcpt-any"}},
+		{"longest", router, "", []a{{Addr: "root@a.example.com"}, {Addr: "root@SPAM.example.com"}}, Reject, []string{"rcpt-sub", "rcpt-spam"}},
+		{"once", router, "", []a{{Addr: "root@a.example.com"}, {Addr: "root@b.example.com"}}, Accept, []string{"rcpt-sub"}},
+		{"first-non-accept", router, "", []a{{Addr: "root@example.net"}, {Addr: "root@spam.example.com"}}, TempFail, []string{"rcpt-net"}},
+		{"sender", noRcpt, "root@example.org", []a{{Addr: "root@example.com"}}, Discard, []string{"sender-org"}},
+		{"fallback", noRcpt, "root@example.com", []a{{Addr: "root@example.com"}}, Accept, []string{"fallback"}},
+		{"nil-fallback", NewRouter(nil), "root@example.com", []a{{Addr: "root@example.com"}}, Accept, nil},
+		{"unrouted-fallback", partial, "root@example.com", []a{{Addr: "root@example.net"}, {Addr: "root@example.com"}, {Addr: "root@example.de"}}, Reject, []string{"rcpt-net", "fallback"}},
+		{"unrouted-sender", partial, "root@example.org", []a{{Addr: "root@example.com"}, {Addr: "root@example.net"}}, Discard, []string{"sender-org"}},
+		{"idn-pattern", idn, "", []a{{Addr: "root@xn--bcher-kva.example"}, {Addr: "root@shop.Bücher.example"}}, Accept, []string{"rcpt-idn", "rcpt-idn-sub"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called = nil
+			trx := &transaction{
+				mailFrom: addr.NewMailFrom(tt.from, "", "", "", ""),
+				rcptTos:  rcptFromAddr(tt.rcptTos),
+			}
+			got, err := tt.router.Decide(context.Background(), trx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Decide() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(called, tt.wantCall) {
+				t.Errorf("called %v, want %v", called, tt.wantCall)
+			}
+		})
+	}
+}
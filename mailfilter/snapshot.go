@@ -0,0 +1,197 @@
+package mailfilter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/d--j/go-milter"
+)
+
+// TrxSnapshot is the state of a transaction that a [MailFilter] saves in a [TrxStore] at the end of the headers.
+type TrxSnapshot struct {
+	// ID identifies the transaction.
+	ID string
+	// Started is the time the snapshot got taken.
+	Started time.Time
+	MTA     MTA
+	Connect Connect
+	Helo    Helo
+	QueueId string
+	// MailFrom and MailFromArgs are the envelope sender and its ESMTP arguments.
+	MailFrom, MailFromArgs string
+	// RcptTos are the envelope recipients.
+	RcptTos []string
+	// Headers is the header of the message as the MTA sent it (including the empty line at the end).
+	Headers []byte
+	// SpoolPattern is a [filepath.Glob] pattern that matches the body spool files of the transaction.
+	SpoolPattern string
+}
+
+// TrxStore persists [TrxSnapshot] values so that a [MailFilter] can clean up after a crash.
+// Use [WithTrxStore] to configure it. You can use [NewFileTrxStore] or your own implementation.
+type TrxStore interface {
+	// Save stores snapshot.
+	Save(snapshot *TrxSnapshot) error
+	// Delete removes the snapshot with the ID id. Deleting a non-existing snapshot is not an error.
+	Delete(id string) error
+	// List returns all stored snapshots.
+	List() ([]*TrxSnapshot, error)
+}
+
+// fileTrxStore is a [TrxStore] that stores each snapshot as a JSON file.
+type fileTrxStore struct {
+	dir string
+}
+
+// NewFileTrxStore returns a [TrxStore] that stores each [TrxSnapshot] as a JSON file in dir.
+// dir needs to exist and should not be used for anything else.
+func NewFileTrxStore(dir string) TrxStore {
+	return &fileTrxStore{dir: dir}
+}
+
+func (s *fileTrxStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *fileTrxStore) Save(snapshot *TrxSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so we never leave a half-written snapshot behind
+	tmp := s.path(snapshot.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(snapshot.ID))
+}
+
+func (s *fileTrxStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileTrxStore) List() ([]*TrxSnapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*TrxSnapshot, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		snapshot := &TrxSnapshot{}
+		if err := json.Unmarshal(data, snapshot); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// reconcileOrphans removes the snapshots and spool files of transactions that did not end because the filter process died.
+// Snapshots that are younger than minAge might belong to a running transaction and stay in store.
+// It returns the removed snapshots.
+func reconcileOrphans(store TrxStore, minAge time.Duration) ([]*TrxSnapshot, error) {
+	snapshots, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	removed := snapshots[:0]
+	for _, snapshot := range snapshots {
+		if time.Since(snapshot.Started) < minAge {
+			continue
+		}
+		if snapshot.SpoolPattern != "" {
+			files, _ := filepath.Glob(snapshot.SpoolPattern)
+			for _, file := range files {
+				if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+					return nil, err
+				}
+			}
+		}
+		if err := store.Delete(snapshot.ID); err != nil {
+			return nil, err
+		}
+		removed = append(removed, snapshot)
+	}
+	return removed, nil
+}
+
+func newTrxID() (string, error) {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// saveSnapshot saves the current state of b.transaction in the [TrxStore].
+func (b *backend) saveSnapshot() error {
+	id, err := newTrxID()
+	if err != nil {
+		return err
+	}
+	t := b.transaction
+	snapshot := &TrxSnapshot{
+		ID:           id,
+		Started:      time.Now(),
+		MTA:          t.mta,
+		Connect:      t.connect,
+		Helo:         t.helo,
+		QueueId:      t.queueId,
+		MailFrom:     t.origMailFrom.Addr,
+		MailFromArgs: t.origMailFrom.Args,
+		SpoolPattern: filepath.Join(b.spoolDir(), id+"-*"),
+	}
+	for _, rcptTo := range t.origRcptTos {
+		snapshot.RcptTos = append(snapshot.RcptTos, rcptTo.Addr)
+	}
+	if t.origHeaders != nil {
+		if snapshot.Headers, err = io.ReadAll(t.origHeaders.Reader()); err != nil {
+			return err
+		}
+	}
+	if err := b.opts.trxStore.Save(snapshot); err != nil {
+		return err
+	}
+	t.snapshotID = id
+	t.spoolDir = b.spoolDir()
+	return nil
+}
+
+// deleteSnapshot removes the snapshot of b.transaction from the [TrxStore].
+func (b *backend) deleteSnapshot() {
+	if b.transaction == nil || b.transaction.snapshotID == "" {
+		return
+	}
+	if err := b.opts.trxStore.Delete(b.transaction.snapshotID); err != nil {
+		milter.LogWarning("could not delete transaction snapshot %s: %s", b.transaction.snapshotID, err)
+	}
+	b.transaction.snapshotID = ""
+}
+
+func (b *backend) spoolDir() string {
+	if b.opts.spoolDir == "" {
+		return os.TempDir()
+	}
+	return b.opts.spoolDir
+}
+
+// logOrphans logs the transactions of snapshots.
+func logOrphans(snapshots []*TrxSnapshot) {
+	for _, snapshot := range snapshots {
+		milter.LogWarning("removed orphaned transaction %s (queue ID %q, from %q to %q, started %s)", snapshot.ID, snapshot.QueueId, snapshot.MailFrom, strings.Join(snapshot.RcptTos, ","), snapshot.Started.Format(time.RFC3339))
+	}
+}
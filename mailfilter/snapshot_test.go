@@ -0,0 +1,133 @@
+package mailfilter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileTrxStore(t *testing.T) {
+	t.Parallel()
+	store := NewFileTrxStore(t.TempDir())
+	snapshot := &TrxSnapshot{ID: "abc", QueueId: "Q123", RcptTos: []string{"root@localhost"}, Headers: []byte("Subject: test\r\n\r\n")}
+	if err := store.Save(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	list, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !reflect.DeepEqual(list[0], snapshot) {
+		t.Fatalf("List() = %+v", list)
+	}
+	if err := store.Delete("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("abc"); err != nil {
+		t.Fatalf("deleting a missing snapshot: %s", err)
+	}
+	if list, err := store.List(); err != nil || len(list) != 0 {
+		t.Fatalf("List() = %+v, %v", list, err)
+	}
+}
+
+func Test_reconcileOrphans(t *testing.T) {
+	t.Parallel()
+	store := NewFileTrxStore(t.TempDir())
+	spoolDir := t.TempDir()
+	spoolFile := filepath.Join(spoolDir, "abc-123")
+	if err := os.WriteFile(spoolFile, []byte("body"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(&TrxSnapshot{ID: "abc", Started: time.Now().Add(-time.Hour), SpoolPattern: filepath.Join(spoolDir, "abc-*")}); err != nil {
+		t.Fatal(err)
+	}
+	// a running transaction of another filter process
+	running := &TrxSnapshot{ID: "def", Started: time.Now(), SpoolPattern: filepath.Join(spoolDir, "def-*")}
+	if err := store.Save(running); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := reconcileOrphans(store, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != "abc" {
+		t.Fatalf("reconcileOrphans() = %+v", orphans)
+	}
+	if _, err := os.Stat(spoolFile); !os.IsNotExist(err) {
+		t.Fatalf("spool file still exists: %v", err)
+	}
+	if list, err := store.List(); err != nil || len(list) != 1 || list[0].ID != "def" {
+		t.Fatalf("List() = %+v, %v", list, err)
+	}
+	orphans, err = reconcileOrphans(store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != "def" {
+		t.Fatalf("reconcileOrphans() = %+v", orphans)
+	}
+}
+
+func TestNew_orphanCleanup(t *testing.T) {
+	store := NewFileTrxStore(t.TempDir())
+	if err := store.Save(&TrxSnapshot{ID: "abc", Started: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := New("tcp", "127.0.0.1:0", nil, WithTrxStore(store, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if list, err := store.List(); err != nil || len(list) != 1 {
+		t.Fatalf("New() without WithOrphanCleanup changed the store: %+v, %v", list, err)
+	}
+	f, err = New("tcp", "127.0.0.1:0", nil, WithTrxStore(store, ""), WithOrphanCleanup(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if list, err := store.List(); err != nil || len(list) != 0 {
+		t.Fatalf("List() = %+v, %v", list, err)
+	}
+}
+
+func Test_backend_trxStore(t *testing.T) {
+	t.Parallel()
+	b, s := newMockBackend()
+	store := NewFileTrxStore(t.TempDir())
+	b.opts.trxStore = store
+	b.opts.spoolDir = t.TempDir()
+	b.decision = func(_ context.Context, trx Trx) (Decision, error) {
+		return Accept, nil
+	}
+	_, _ = b.MailFrom("root@localhost", "", s.newModifier())
+	_, _ = b.RcptTo("postmaster@localhost", "", s.newModifier())
+	_, _ = b.Header("Subject", "test", s.newModifier())
+	resp, err := b.Headers(s.newModifier())
+	assertContinue(t, resp, err)
+	list, err := store.List()
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List() = %+v, %v", list, err)
+	}
+	if list[0].MailFrom != "root@localhost" || !reflect.DeepEqual(list[0].RcptTos, []string{"postmaster@localhost"}) || string(list[0].Headers) != "Subject: test\r\n\r\n" {
+		t.Fatalf("unexpected snapshot %+v", list[0])
+	}
+	resp, err = b.BodyChunk(make([]byte, 300*1024), s.newModifier())
+	assertContinue(t, resp, err)
+	if files, _ := filepath.Glob(list[0].SpoolPattern); len(files) != 1 {
+		t.Fatalf("expected one spool file, got %v", files)
+	}
+	if _, err := b.EndOfMessage(s.newModifier()); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := store.List(); err != nil || len(list) != 0 {
+		t.Fatalf("List() = %+v, %v", list, err)
+	}
+	if files, _ := filepath.Glob(list[0].SpoolPattern); len(files) != 0 {
+		t.Fatalf("expected no spool files, got %v", files)
+	}
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryPurgeInterval is how often a [Memory] store removes its expired entries.
+const memoryPurgeInterval = time.Minute
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// Memory is a [Store] that keeps its entries in memory. Its state is lost when the process exits
+// and it is not shared between multiple filter instances.
+// Expired entries get removed periodically while the store is in use.
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+// NewMemory creates an empty [Memory] store.
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]memoryEntry), now: time.Now}
+}
+
+var _ Store = (*Memory)(nil)
+
+// Get implements [Store.Get].
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if !ok || entry.expired(m.now()) {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), entry.value...), nil
+}
+
+// Set implements [Store.Set].
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.purge(now)
+	m.entries[key] = memoryEntry{value: append([]byte(nil), value...), expires: expiry(now, ttl)}
+	return nil
+}
+
+// Delete implements [Store.Delete].
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// Increment implements [Store.Increment].
+func (m *Memory) Increment(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.purge(now)
+	entry, ok := m.entries[key]
+	if !ok || entry.expired(now) {
+		entry = memoryEntry{value: []byte("0"), expires: expiry(now, ttl)}
+	}
+	n, err := parseCounter(key, entry.value)
+	if err != nil {
+		return 0, err
+	}
+	n += delta
+	entry.value = []byte(strconv.FormatInt(n, 10))
+	m.entries[key] = entry
+	return n, nil
+}
+
+// Len returns the number of entries in m, including expired entries that did not get removed yet.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// purge removes the expired entries when the last purge was at least memoryPurgeInterval ago.
+func (m *Memory) purge(now time.Time) {
+	if now.Sub(m.lastPurge) < memoryPurgeInterval {
+		return
+	}
+	m.lastPurge = now
+	for key, entry := range m.entries {
+		if entry.expired(now) {
+			delete(m.entries, key)
+		}
+	}
+}
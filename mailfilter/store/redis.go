@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RedisClient executes a Redis command and returns its reply.
+// A nil reply (e.g. GET of a missing key) needs to be returned as a nil interface value and no error.
+//
+// This package does not depend on a specific Redis library. For github.com/redis/go-redis this is
+//
+//	store.RedisFunc(func(ctx context.Context, args ...interface{}) (interface{}, error) {
+//		reply, err := client.Do(ctx, args...).Result()
+//		if err == redis.Nil {
+//			return nil, nil
+//		}
+//		return reply, err
+//	})
+type RedisClient interface {
+	Do(ctx context.Context, args ...interface{}) (interface{}, error)
+}
+
+// RedisFunc is an adapter to use a function as a [RedisClient].
+type RedisFunc func(ctx context.Context, args ...interface{}) (interface{}, error)
+
+// Do calls f(ctx, args...).
+func (f RedisFunc) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	return f(ctx, args...)
+}
+
+// Redis is a [Store] that keeps its entries in a Redis server (or a compatible server like Valkey or KeyDB).
+// Multiple filter instances can share it. The entries expire with the native key expiry of Redis.
+type Redis struct {
+	client RedisClient
+	prefix string
+}
+
+// NewRedis creates a [Redis] store that uses client. prefix gets prepended to all keys (e.g. "milter:"),
+// so that you can share the Redis database with other applications.
+func NewRedis(client RedisClient, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+var _ Store = (*Redis)(nil)
+
+// Get implements [Store.Get].
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := r.client.Do(ctx, "GET", r.prefix+key)
+	if err != nil {
+		return nil, err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return nil, ErrNotFound
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	default:
+		return nil, fmt.Errorf("store: unexpected Redis reply %T", reply)
+	}
+}
+
+// Set implements [Store.Set].
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []interface{}{"SET", r.prefix + key, value}
+	if ttl > 0 {
+		args = append(args, "PX", redisMilliseconds(ttl))
+	}
+	_, err := r.client.Do(ctx, args...)
+	return err
+}
+
+// Delete implements [Store.Delete].
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	_, err := r.client.Do(ctx, "DEL", r.prefix+key)
+	return err
+}
+
+// redisIncrement increments a counter and sets its expiry when the counter did not exist (it has no expiry yet).
+const redisIncrement = `local n = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 and n == tonumber(ARGV[1]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return n`
+
+// Increment implements [Store.Increment]. It uses a Lua script to create the counter and set its expiry atomically.
+func (r *Redis) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	var ms int64
+	if ttl > 0 {
+		ms = redisMilliseconds(ttl)
+	}
+	reply, err := r.client.Do(ctx, "EVAL", redisIncrement, 1, r.prefix+key, delta, ms)
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, fmt.Errorf("store: unexpected Redis reply %T", reply)
+	}
+}
+
+// redisMilliseconds converts ttl to the milliseconds that Redis expects. It rounds up to at least one millisecond.
+func redisMilliseconds(ttl time.Duration) int64 {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		return 1
+	}
+	return ms
+}
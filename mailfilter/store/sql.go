@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLPlaceholder is the placeholder style of the SQL driver of a [SQL] store.
+type SQLPlaceholder int
+
+const (
+	// QuestionMark placeholders (?) are used by MySQL, MariaDB and SQLite.
+	QuestionMark SQLPlaceholder = iota
+	// Dollar placeholders ($1, $2, …) are used by PostgreSQL.
+	Dollar
+)
+
+// SQL is a [Store] that keeps its entries in a table of an SQL database. Multiple filter instances can share it.
+// The table needs to have this layout (adjust the types to your database):
+//
+//	CREATE TABLE milter_store (
+//		k       VARCHAR(255) NOT NULL PRIMARY KEY,
+//		v       BLOB NOT NULL,     -- BYTEA for PostgreSQL
+//		expires BIGINT NOT NULL    -- Unix time in milliseconds, 0 = never
+//	);
+//
+// Expired entries are not returned but stay in the table until you call [SQL.Purge].
+type SQL struct {
+	db          *sql.DB
+	placeholder SQLPlaceholder
+	queries     sqlQueries
+	now         func() time.Time
+}
+
+type sqlQueries struct {
+	get, insert, swap, delete, purge string
+}
+
+// maxIncrementAttempts is the number of times [SQL.Increment] tries to update a counter that concurrently got changed.
+const maxIncrementAttempts = 10
+
+// NewSQL creates a [SQL] store that uses the table table of db. placeholder needs to match the driver of db.
+// table gets used verbatim in the queries, do not use untrusted input for it.
+func NewSQL(db *sql.DB, table string, placeholder SQLPlaceholder) *SQL {
+	s := &SQL{db: db, placeholder: placeholder, now: time.Now}
+	s.queries = sqlQueries{
+		get:    s.rebind("SELECT v, expires FROM " + table + " WHERE k = ?"),
+		insert: s.rebind("INSERT INTO " + table + " (k, v, expires) VALUES (?, ?, ?)"),
+		swap:   s.rebind("UPDATE " + table + " SET v = ?, expires = ? WHERE k = ? AND v = ? AND expires = ?"),
+		delete: s.rebind("DELETE FROM " + table + " WHERE k = ?"),
+		purge:  s.rebind("DELETE FROM " + table + " WHERE expires <> 0 AND expires <= ?"),
+	}
+	return s
+}
+
+var _ Store = (*SQL)(nil)
+
+// rebind replaces the ? placeholders of query with the placeholder style of s.
+func (s *SQL) rebind(query string) string {
+	if s.placeholder != Dollar {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// unixMilli returns t as Unix time in milliseconds, 0 for the zero time.
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// queryer is the part of [sql.DB] and [sql.Tx] that get needs.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// get returns the value and expiry of key. It returns ErrNotFound when key does not exist or is expired.
+func (s *SQL) get(ctx context.Context, q queryer, key string) ([]byte, int64, error) {
+	value, expires, err := s.getRow(ctx, q, key)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && s.expired(expires)) {
+		return nil, 0, ErrNotFound
+	}
+	return value, expires, err
+}
+
+// getRow returns the value and expiry of key, even when it is expired. It returns sql.ErrNoRows when key does not exist.
+func (s *SQL) getRow(ctx context.Context, q queryer, key string) ([]byte, int64, error) {
+	var value []byte
+	var expires int64
+	if err := q.QueryRowContext(ctx, s.queries.get, key).Scan(&value, &expires); err != nil {
+		return nil, 0, err
+	}
+	return value, expires, nil
+}
+
+// expired returns true when the expiry expires is in the past.
+func (s *SQL) expired(expires int64) bool {
+	return expires != 0 && expires <= unixMilli(s.now())
+}
+
+// put replaces key in tx. It deletes and inserts the row because the number of affected rows of an UPDATE
+// is not portable (MySQL does not count rows that did not change).
+func (s *SQL) put(ctx context.Context, tx *sql.Tx, key string, value []byte, expires int64) error {
+	if _, err := tx.ExecContext(ctx, s.queries.delete, key); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, s.queries.insert, key, value, expires)
+	return err
+}
+
+// inTx runs fn in a transaction.
+func (s *SQL) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get implements [Store.Get].
+func (s *SQL) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := s.get(ctx, s.db, key)
+	return value, err
+}
+
+// Set implements [Store.Set].
+func (s *SQL) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	expires := unixMilli(expiry(s.now(), ttl))
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.put(ctx, tx, key, value, expires)
+	})
+}
+
+// Delete implements [Store.Delete].
+func (s *SQL) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.queries.delete, key)
+	return err
+}
+
+// Increment implements [Store.Increment]. It changes the counter with an UPDATE that only succeeds
+// when the counter did not change since it got read (compare-and-swap) and tries again otherwise.
+// A new counter gets created with an INSERT, the primary key makes sure that only one concurrent INSERT succeeds.
+// So concurrent increments do not get lost, regardless of the transaction isolation level of your database.
+func (s *SQL) Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	lastErr := errors.New("counter changed concurrently")
+	for attempt := 0; attempt < maxIncrementAttempts; attempt++ {
+		value, expires, err := s.getRow(ctx, s.db, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			// another Increment might create the counter at the same time, then our INSERT fails and we try again
+			if _, err := s.db.ExecContext(ctx, s.queries.insert, key, []byte(strconv.FormatInt(delta, 10)), unixMilli(expiry(s.now(), ttl))); err != nil {
+				lastErr = err
+				continue
+			}
+			return delta, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		n, swapped, err := s.swapCounter(ctx, key, value, expires, delta, ttl)
+		if err != nil || swapped {
+			return n, err
+		}
+	}
+	return 0, fmt.Errorf("store: increment %q: %w", key, lastErr)
+}
+
+// swapCounter adds delta to the counter key that had the value value and the expiry expires when it got read.
+// swapped is false when the counter got changed concurrently.
+func (s *SQL) swapCounter(ctx context.Context, key string, value []byte, expires int64, delta int64, ttl time.Duration) (n int64, swapped bool, err error) {
+	newExpires := expires
+	if s.expired(expires) {
+		newExpires = unixMilli(expiry(s.now(), ttl))
+	} else if n, err = parseCounter(key, value); err != nil {
+		return 0, false, err
+	}
+	n += delta
+	newValue := []byte(strconv.FormatInt(n, 10))
+	if newExpires == expires && string(newValue) == string(value) {
+		// nothing to change (MySQL would report 0 affected rows)
+		return n, true, nil
+	}
+	res, err := s.db.ExecContext(ctx, s.queries.swap, newValue, newExpires, key, value, expires)
+	if err != nil {
+		return 0, false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	return n, affected == 1, nil
+}
+
+// Purge deletes all expired entries from the table. Call it periodically.
+func (s *SQL) Purge(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.queries.purge, unixMilli(s.now()))
+	return err
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeTable is an in-memory SQL table that understands the queries of [SQL]. Every statement runs atomically,
+// transactions do not isolate anything – like a database with the lowest isolation level.
+type fakeTable struct {
+	mu   sync.Mutex
+	rows map[string]fakeRow
+}
+
+type fakeRow struct {
+	v       []byte
+	expires int64
+}
+
+func (f *fakeTable) Connect(context.Context) (driver.Conn, error) { return &fakeConn{table: f}, nil }
+func (f *fakeTable) Driver() driver.Driver                        { return nil }
+
+func (f *fakeTable) exec(query string, args []driver.Value) (driver.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := func(i int) string { return args[i].(string) }
+	switch {
+	case strings.HasPrefix(query, "INSERT"):
+		if _, ok := f.rows[key(0)]; ok {
+			return nil, errors.New("duplicate key")
+		}
+		f.rows[key(0)] = fakeRow{v: append([]byte(nil), args[1].([]byte)...), expires: args[2].(int64)}
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "UPDATE"):
+		row, ok := f.rows[key(2)]
+		if !ok || string(row.v) != string(args[3].([]byte)) || row.expires != args[4].(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		f.rows[key(2)] = fakeRow{v: append([]byte(nil), args[0].([]byte)...), expires: args[1].(int64)}
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "DELETE") && strings.Contains(query, "k = ?"):
+		delete(f.rows, key(0))
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "DELETE"):
+		var n int64
+		for k, row := range f.rows {
+			if row.expires != 0 && row.expires <= args[0].(int64) {
+				delete(f.rows, k)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, errors.New("unexpected query " + query)
+}
+
+func (f *fakeTable) query(query string, args []driver.Value) (driver.Rows, error) {
+	if !strings.HasPrefix(query, "SELECT") {
+		return nil, errors.New("unexpected query " + query)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	row, ok := f.rows[args[0].(string)]
+	if !ok {
+		return &fakeRows{}, nil
+	}
+	return &fakeRows{row: []driver.Value{append([]byte(nil), row.v...), row.expires}}, nil
+}
+
+type fakeConn struct {
+	table *fakeTable
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{table: c.table, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+type fakeStmt struct {
+	table *fakeTable
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.table.exec(s.query, args)
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.table.query(s.query, args)
+}
+
+type fakeRows struct {
+	row []driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"v", "expires"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.row == nil {
+		return io.EOF
+	}
+	copy(dest, r.row)
+	r.row = nil
+	return nil
+}
+
+func newFakeSQL() *SQL {
+	return NewSQL(sql.OpenDB(&fakeTable{rows: make(map[string]fakeRow)}), "store", QuestionMark)
+}
+
+func TestSQL(t *testing.T) {
+	s := newFakeSQL()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	testStore(t, s, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestSQL_IncrementConcurrently(t *testing.T) {
+	s := newFakeSQL()
+	const goroutines, increments = 8, 50
+	var wg sync.WaitGroup
+	var succeeded int64
+	errs := make(chan error, goroutines*increments)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				// Increment may give up after too many concurrent changes, but it must not lose an increment
+				if _, err := s.Increment(context.Background(), "c", 1, time.Hour); err != nil {
+					errs <- err
+					continue
+				}
+				atomic.AddInt64(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if !strings.Contains(err.Error(), "concurrently") {
+			t.Fatal(err)
+		}
+	}
+	n, err := s.Increment(context.Background(), "c", 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != succeeded || n == 0 {
+		t.Fatalf("counter = %d, want %d", n, succeeded)
+	}
+}
@@ -0,0 +1,47 @@
+// Package store defines the key-value storage that stateful mail filter helpers (rate limits, greylisting, reputation)
+// use to keep their state, and implementations of it for memory, Redis and SQL databases.
+//
+// All helpers take a [Store], so a deployment can choose one backing store for all of them.
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrNotFound is returned by [Store.Get] when the key does not exist or is expired.
+var ErrNotFound = errors.New("store: not found")
+
+// Store is a key-value store with expiring entries. Implementations need to be safe for concurrent use.
+type Store interface {
+	// Get returns the value of key. It returns [ErrNotFound] when key does not exist or is expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set sets key to value. The entry expires after ttl, a ttl of 0 means that the entry does not expire.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key. It is not an error when key does not exist.
+	Delete(ctx context.Context, key string) error
+	// Increment atomically adds delta to the counter key and returns its new value.
+	// A counter that does not exist starts at 0 and expires after ttl (0 means never).
+	// Incrementing an existing counter does not change its expiry.
+	// Counters are stored as decimal strings, so [Store.Get] returns e.g. "42".
+	Increment(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// parseCounter parses the counter value of key.
+func parseCounter(key string, value []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, errors.New("store: value of " + strconv.Quote(key) + " is not a counter")
+	}
+	return n, nil
+}
+
+// expiry returns the expiry time of an entry that gets written at now with ttl. It is zero when ttl is 0.
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testStore checks the behaviour of s that all [Store] implementations share.
+// advance moves the clock of s forward.
+func testStore(t *testing.T, s Store, advance func(d time.Duration)) {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := s.Set(ctx, "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(ctx, "b", []byte("2"), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get(ctx, "a"); err != nil || string(v) != "1" {
+		t.Fatalf("Get(a) = %q, %v", v, err)
+	}
+	for i, want := range []int64{3, 6} {
+		n, err := s.Increment(ctx, "c", 3, time.Second)
+		if err != nil || n != want {
+			t.Fatalf("Increment(c) #%d = %d, %v, want %d", i, n, err, want)
+		}
+	}
+	if _, err := s.Increment(ctx, "a", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get(ctx, "a"); err != nil || string(v) != "2" {
+		t.Fatalf("Get(a) = %q, %v", v, err)
+	}
+	if err := s.Set(ctx, "text", []byte("text"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Increment(ctx, "text", 1, 0); err == nil {
+		t.Fatal("Increment(text) did not fail")
+	}
+	advance(2 * time.Second)
+	if _, err := s.Get(ctx, "b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(b) error = %v, want ErrNotFound", err)
+	}
+	if n, err := s.Increment(ctx, "c", 1, time.Second); err != nil || n != 1 {
+		t.Fatalf("Increment(c) = %d, %v, want 1", n, err)
+	}
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(a) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	testStore(t, m, func(d time.Duration) { now = now.Add(d) })
+
+	now = now.Add(memoryPurgeInterval)
+	_ = m.Set(context.Background(), "d", nil, 0)
+	if n := m.Len(); n != 2 {
+		t.Fatalf("Len() = %d, want 2 after the purge", n)
+	}
+}
+
+// fakeRedis is a [RedisClient] that implements the commands that [Redis] uses on top of a [Memory] store.
+func fakeRedis(m *Memory, calls *[]string) RedisClient {
+	return RedisFunc(func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		*calls = append(*calls, fmt.Sprint(args[0]))
+		switch args[0] {
+		case "GET":
+			v, err := m.Get(ctx, args[1].(string))
+			if errors.Is(err, ErrNotFound) {
+				return nil, nil
+			}
+			return string(v), err
+		case "SET":
+			var ttl time.Duration
+			if len(args) == 5 && args[3] == "PX" {
+				ttl = time.Duration(args[4].(int64)) * time.Millisecond
+			}
+			return "OK", m.Set(ctx, args[1].(string), args[2].([]byte), ttl)
+		case "DEL":
+			return int64(1), m.Delete(ctx, args[1].(string))
+		case "EVAL":
+			if args[1] != redisIncrement || args[2] != 1 {
+				return nil, errors.New("unexpected script")
+			}
+			return m.Increment(ctx, args[3].(string), args[4].(int64), time.Duration(args[5].(int64))*time.Millisecond)
+		}
+		return nil, fmt.Errorf("unknown command %v", args[0])
+	})
+}
+
+func TestRedis(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	var calls []string
+	r := NewRedis(fakeRedis(m, &calls), "milter:")
+	testStore(t, r, func(d time.Duration) { now = now.Add(d) })
+	if _, err := m.Get(context.Background(), "milter:c"); err != nil {
+		t.Fatalf("key did not get prefixed: %v", err)
+	}
+	if len(calls) == 0 {
+		t.Fatal("client did not get called")
+	}
+}
+
+func TestSQL_rebind(t *testing.T) {
+	s := NewSQL(nil, "store", Dollar)
+	if got, want := s.queries.insert, "INSERT INTO store (k, v, expires) VALUES ($1, $2, $3)"; got != want {
+		t.Fatalf("insert query = %q, want %q", got, want)
+	}
+	s = NewSQL(nil, "store", QuestionMark)
+	if got, want := s.queries.get, "SELECT v, expires FROM store WHERE k = ?"; got != want {
+		t.Fatalf("get query = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package mailfilter
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// TrustFunc reports whether the sender of trx is trusted to add internal header fields.
+type TrustFunc func(trx Trx) bool
+
+// TrustAuthenticated trusts transactions of authenticated (SMTP AUTH) senders.
+func TrustAuthenticated(trx Trx) bool {
+	return trx.MailFrom().AuthenticatedUser() != ""
+}
+
+// TrustNetworks returns a [TrustFunc] that trusts transactions of SMTP clients with an IP address in one of networks.
+// networks are CIDR strings like "10.0.0.0/8" or "::1/128". TrustNetworks panics when a network cannot be parsed.
+func TrustNetworks(networks ...string) TrustFunc {
+	nets := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return func(trx Trx) bool {
+		ip := net.ParseIP(trx.Connect().Addr)
+		if ip == nil {
+			return false
+		}
+		for _, ipNet := range nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// StripUntrustedHeaders wraps decide and removes all header fields with one of keys from messages that trusted does not trust
+// before decide gets called. This prevents senders from spoofing internal header fields (e.g. spam scores or the authenticated user)
+// that later filters or the delivery agent rely on.
+//
+// keys are compared case-insensitive. A key ending in "*" matches all fields with this prefix, e.g. "X-Spam-*".
+// trusted can be nil, then no message is trusted.
+// The removed fields get deleted at the MTA when decide accepts the message (you do not need to compute header indexes yourself).
+// Use this with [DecisionAtEndOfHeaders] or [DecisionAtEndOfMessage], otherwise there are no header fields to remove.
+func StripUntrustedHeaders(decide DecisionModificationFunc, trusted TrustFunc, keys ...string) DecisionModificationFunc {
+	lowerKeys := make([]string, len(keys))
+	for i, key := range keys {
+		lowerKeys[i] = strings.ToLower(key)
+	}
+	return func(ctx context.Context, trx Trx) (Decision, error) {
+		if trusted == nil || !trusted(trx) {
+			fields := trx.Headers().Fields()
+			for fields.Next() {
+				if !fields.IsDeleted() && matchHeaderKey(lowerKeys, strings.ToLower(fields.Key())) {
+					fields.Del()
+				}
+			}
+		}
+		return decide(ctx, trx)
+	}
+}
+
+func matchHeaderKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if strings.HasSuffix(k, "*") {
+			if strings.HasPrefix(key, k[:len(k)-1]) {
+				return true
+			}
+		} else if k == key {
+			return true
+		}
+	}
+	return false
+}
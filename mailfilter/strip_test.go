@@ -0,0 +1,69 @@
+package mailfilter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+func TestStripUntrustedHeaders(t *testing.T) {
+	accept := func(_ context.Context, _ Trx) (Decision, error) {
+		return Accept, nil
+	}
+	mod := func(data string) *wire.Message {
+		return &wire.Message{Code: wire.Code(wire.ActChangeHeader), Data: []byte(data)}
+	}
+	tests := []struct {
+		name    string
+		trusted TrustFunc
+		want    []*wire.Message
+	}{
+		{"untrusted", nil, []*wire.Message{
+			mod("\u0000\u0000\u0000\u0001X-Auth-User\u0000\u0000"),
+			mod("\u0000\u0000\u0000\u0001X-Spam-Flag\u0000\u0000"),
+			mod("\u0000\u0000\u0000\u0001x-spam-score\u0000\u0000"),
+		}},
+		{"untrusted-network", TrustNetworks("10.0.0.0/8"), []*wire.Message{
+			mod("\u0000\u0000\u0000\u0001X-Auth-User\u0000\u0000"),
+			mod("\u0000\u0000\u0000\u0001X-Spam-Flag\u0000\u0000"),
+			mod("\u0000\u0000\u0000\u0001x-spam-score\u0000\u0000"),
+		}},
+		{"trusted-network", TrustNetworks("127.0.0.0/8", "::1/128"), nil},
+		{"trusted-auth", TrustAuthenticated, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, s := newMockBackend()
+			t.Cleanup(b.transaction.cleanup)
+			_, _ = b.Connect("localhost", "tcp4", 25, "127.0.0.1", s.newModifier())
+			_, _ = b.MailFrom("", "", s.newModifier())
+			_, _ = b.RcptTo("root@localhost", "", s.newModifier())
+			_, _ = b.Header("x-spam-score", " 100", s.newModifier())
+			_, _ = b.Header("From", " <>", s.newModifier())
+			_, _ = b.Header("X-Spam-Flag", " NO", s.newModifier())
+			_, _ = b.Header("X-Auth-User", " root", s.newModifier())
+			_, _ = b.Header("X-Spammy", " not stripped", s.newModifier())
+			b.transaction.makeDecision(context.Background(), StripUntrustedHeaders(accept, tt.trusted, "X-Spam-*", "x-auth-user"))
+			if b.transaction.decisionErr != nil {
+				t.Fatal(b.transaction.decisionErr)
+			}
+			if err := b.transaction.sendModifications(s.newModifier(), nil); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s.modifications, tt.want) {
+				t.Errorf("sendModifications() sent %v, want %v", outputMessages(s.modifications), outputMessages(tt.want))
+			}
+		})
+	}
+}
+
+func TestTrustNetworks_panics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("TrustNetworks did not panic")
+		}
+	}()
+	TrustNetworks("10.0.0.0")
+}
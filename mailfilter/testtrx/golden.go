@@ -0,0 +1,81 @@
+package testtrx
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// UpdateGoldenEnv is the name of the environment variable that instructs [AssertGolden] to
+// write the golden files instead of comparing against them.
+// E.g. run
+//
+//	TESTTRX_UPDATE_GOLDEN=1 go test ./...
+//
+// to update all golden files and then review the changes with your version control system.
+const UpdateGoldenEnv = "TESTTRX_UPDATE_GOLDEN"
+
+// FormatModifications serializes mods into a canonical line based text format that is easy to read in diffs.
+//
+// Every modification is one line. Header values are quoted with Go syntax.
+// A body replacement is followed by the lines of the new body, each prefixed with "| ".
+func FormatModifications(mods []Modification) string {
+	var b strings.Builder
+	for _, m := range mods {
+		switch m.Kind {
+		case ChangeFrom:
+			_, _ = fmt.Fprintf(&b, "change from: <%s> %q\n", m.Addr, m.Args)
+		case AddRcptTo:
+			_, _ = fmt.Fprintf(&b, "add rcpt: <%s> %q\n", m.Addr, m.Args)
+		case DelRcptTo:
+			_, _ = fmt.Fprintf(&b, "del rcpt: <%s>\n", m.Addr)
+		case InsertHeader:
+			_, _ = fmt.Fprintf(&b, "insert header: index %d, %s: %q\n", m.Index, m.Name, m.Value)
+		case ChangeHeader:
+			_, _ = fmt.Fprintf(&b, "change header: index %d, %s: %q\n", m.Index, m.Name, m.Value)
+		case ReplaceBody:
+			_, _ = fmt.Fprintf(&b, "replace body: %d bytes\n", len(m.Body))
+			if len(m.Body) > 0 {
+				for _, line := range strings.SplitAfter(string(m.Body), "\n") {
+					if line == "" {
+						continue
+					}
+					_, _ = fmt.Fprintf(&b, "| %q\n", line)
+				}
+			}
+		default:
+			_, _ = fmt.Fprintf(&b, "unknown modification %d\n", m.Kind)
+		}
+	}
+	return b.String()
+}
+
+// AssertGolden compares the modifications of trx (serialized with [FormatModifications]) with the content of the file goldenFile.
+// It fails the test when they differ.
+//
+// When the environment variable [UpdateGoldenEnv] is set, AssertGolden writes the modifications to goldenFile instead.
+func AssertGolden(t testing.TB, trx *Trx, goldenFile string) {
+	t.Helper()
+	got := []byte(FormatModifications(trx.Modifications()))
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(goldenFile), 0o755); err != nil {
+			t.Fatalf("testtrx: create directory for golden file: %v", err)
+		}
+		if err := os.WriteFile(goldenFile, got, 0o644); err != nil {
+			t.Fatalf("testtrx: write golden file: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("testtrx: read golden file (set %s=1 to create it): %v", UpdateGoldenEnv, err)
+	}
+	// golden files might get checked out with CR LF line endings
+	want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+	if !bytes.Equal(got, want) {
+		t.Errorf("testtrx: modifications differ from golden file %s\ngot:\n%s\nwant:\n%s", goldenFile, got, want)
+	}
+}
@@ -0,0 +1,51 @@
+package testtrx
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/d--j/go-milter/mailfilter/addr"
+)
+
+func TestFormatModifications(t *testing.T) {
+	t.Parallel()
+	mods := []Modification{
+		{Kind: ChangeFrom, Addr: "", Args: "A=B"},
+		{Kind: DelRcptTo, Addr: "root@localhost"},
+		{Kind: AddRcptTo, Addr: "postmaster@example.com", Args: ""},
+		{Kind: ChangeHeader, Index: 1, Name: "Subject", Value: " test"},
+		{Kind: InsertHeader, Index: 101, Name: "X-Add", Value: " 1"},
+		{Kind: ReplaceBody, Body: []byte("line 1\r\nline 2")},
+		{Kind: ReplaceBody},
+	}
+	want := `change from: <> "A=B"
+del rcpt: <root@localhost>
+add rcpt: <postmaster@example.com> ""
+change header: index 1, Subject: " test"
+insert header: index 101, X-Add: " 1"
+replace body: 14 bytes
+| "line 1\r\n"
+| "line 2"
+replace body: 0 bytes
+`
+	if got := FormatModifications(mods); got != want {
+		t.Errorf("FormatModifications() got\n%s\nwant\n%s", got, want)
+	}
+	if got := FormatModifications(nil); got != "" {
+		t.Errorf("FormatModifications(nil) = %q, want empty string", got)
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	t.Parallel()
+	trx := (&Trx{}).
+		SetMailFrom(addr.NewMailFrom("root@localhost", "", "local", "", "")).
+		SetRcptTosList("root@localhost").
+		SetHeadersRaw([]byte("Subject: test\n\n")).
+		SetBodyBytes([]byte("test body"))
+	trx.AddRcptTo("postmaster@example.com", "")
+	trx.Headers().SetSubject("[SPAM] test")
+	trx.ReplaceBody(bytes.NewReader([]byte("new body\n")))
+	AssertGolden(t, trx, filepath.Join("testdata", "golden.txt"))
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"io"
 
+	"github.com/d--j/go-milter"
 	"github.com/d--j/go-milter/internal/header"
 	"github.com/d--j/go-milter/internal/rcptto"
 	"github.com/d--j/go-milter/mailfilter"
@@ -55,6 +56,7 @@ type Trx struct {
 	enforceHeaderOrder bool
 	body               io.ReadSeeker
 	bodyReplacement    io.Reader
+	customMacros       map[milter.MacroName]string
 }
 
 func (t *Trx) MTA() *mailfilter.MTA {
@@ -162,11 +164,21 @@ func (t *Trx) SetHeadersRaw(raw []byte) *Trx {
 	return t
 }
 
-func (t *Trx) Body() io.ReadSeeker {
-	if t.body != nil {
-		_, _ = t.body.Seek(0, io.SeekStart)
+func (t *Trx) Body() mailfilter.Body {
+	if t.body == nil {
+		return nil
 	}
-	return t.body
+	_, _ = t.body.Seek(0, io.SeekStart)
+	return mailfilter.NewBody(t.body)
+}
+
+func (t *Trx) IsEmptyBody() bool {
+	if t.body == nil {
+		return false
+	}
+	size, err := t.body.Seek(0, io.SeekEnd)
+	_, _ = t.body.Seek(0, io.SeekStart)
+	return err == nil && size == 0
 }
 
 func (t *Trx) SetBody(body io.ReadSeeker) *Trx {
@@ -192,6 +204,15 @@ func (t *Trx) SetQueueId(value string) *Trx {
 	return t
 }
 
+func (t *Trx) CustomMacros() map[milter.MacroName]string {
+	return t.customMacros
+}
+
+func (t *Trx) SetCustomMacros(macros map[milter.MacroName]string) *Trx {
+	t.customMacros = macros
+	return t
+}
+
 func (t *Trx) Modifications() []Modification {
 	var mods []Modification
 	if t.origMailFrom.Addr != t.mailFrom.Addr || t.origMailFrom.Args != t.mailFrom.Args {
@@ -2,6 +2,7 @@ package testtrx
 
 import (
 	"bytes"
+	"io"
 	"reflect"
 	"testing"
 
@@ -64,3 +65,20 @@ func TestTestTrx(t *testing.T) {
 		t.Fatalf("trx.Modifications() = %+v, want %+v", m, expected)
 	}
 }
+
+func TestTrx_IsEmptyBody(t *testing.T) {
+	t.Parallel()
+	if (&Trx{}).IsEmptyBody() {
+		t.Error("IsEmptyBody() = true without a body")
+	}
+	if !(&Trx{}).SetBodyBytes(nil).IsEmptyBody() {
+		t.Error("IsEmptyBody() = false for an empty body")
+	}
+	trx := (&Trx{}).SetBodyBytes([]byte("body"))
+	if trx.IsEmptyBody() {
+		t.Error("IsEmptyBody() = true for a body")
+	}
+	if b, err := io.ReadAll(trx.Body()); err != nil || string(b) != "body" {
+		t.Errorf("Body() = %q, %v", b, err)
+	}
+}
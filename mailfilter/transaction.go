@@ -3,6 +3,7 @@ package mailfilter
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"regexp"
 
@@ -59,12 +60,17 @@ type transaction struct {
 	origHeaders        *header.Header
 	enforceHeaderOrder bool
 	body               *body.Body
+	bodySize           int64
 	replacementBody    io.Reader
 	queueId            string
 	hasDecision        bool
 	decision           Decision
 	decisionErr        error
 	quarantineReason   *string
+	customMacros       map[milter.MacroName]string
+	// snapshotID is the ID of the TrxSnapshot of this transaction, spoolDir is where we spool the body
+	snapshotID string
+	spoolDir   string
 }
 
 func (t *transaction) MTA() *MTA {
@@ -79,6 +85,10 @@ func (t *transaction) Helo() *Helo {
 	return &t.helo
 }
 
+func (t *transaction) CustomMacros() map[milter.MacroName]string {
+	return t.customMacros
+}
+
 func (t *transaction) QueueId() string {
 	return t.queueId
 }
@@ -94,6 +104,7 @@ func (t *transaction) cleanup() {
 		_ = t.body.Close()
 		t.body = nil
 	}
+	t.bodySize = 0
 }
 
 func (t *transaction) response() *milter.Response {
@@ -116,6 +127,21 @@ func (t *transaction) response() *milter.Response {
 	}
 }
 
+// auditEntry returns the [AuditEntry] of the decision of t.
+func (t *transaction) auditEntry() AuditEntry {
+	entry := AuditEntry{
+		QueueId:  t.queueId,
+		MailFrom: t.mailFrom.Addr,
+		Code:     t.decision.getCode(),
+		Reason:   t.decision.getReason(),
+	}
+	for _, r := range t.rcptTos {
+		entry.RcptTos = append(entry.RcptTos, r.Addr)
+	}
+	entry.RetryAfter, _ = RetryAfter(t.decision)
+	return entry
+}
+
 func (t *transaction) makeDecision(ctx context.Context, decide DecisionModificationFunc) {
 	if t.hasDecision {
 		panic("calling makeDecision on a transaction that already has made a decision")
@@ -181,12 +207,58 @@ func (t *transaction) hasModifications() bool {
 	return false
 }
 
-func (t *transaction) sendModifications(m *milter.Modifier) error {
-	if t.origMailFrom.Addr != t.mailFrom.Addr || t.origMailFrom.Args != t.mailFrom.Args {
-		if err := m.ChangeFrom(t.mailFrom.Addr, t.mailFrom.Args); err != nil {
+// sendModifications sends all modifications of t to the MTA in the order order (nil means the default order,
+// see [WithModificationOrder]).
+func (t *transaction) sendModifications(m *milter.Modifier, order []ModificationKind) error {
+	if t.replacementBody != nil {
+		defer func() {
+			t.closeReplacementBody()
+		}()
+	}
+	if order == nil {
+		order = defaultModificationOrder
+	}
+	var changeInsertOps, addOps []header.Op
+	diffed := false
+	for _, kind := range order {
+		if !diffed && (kind == ModifyHeaders || kind == ModifyAddHeaders) {
+			changeInsertOps, addOps = header.DiffOrRecreate(t.enforceHeaderOrder, t.origHeaders, t.headers)
+			diffed = true
+		}
+		var err error
+		switch kind {
+		case ModifyMailFrom:
+			err = t.sendMailFrom(m)
+		case ModifyRecipients:
+			err = t.sendRecipients(m)
+		case ModifyHeaders:
+			err = sendChangeInsertHeaders(m, changeInsertOps)
+		case ModifyAddHeaders:
+			err = sendAddHeaders(m, addOps, len(changeInsertOps))
+		case ModifyBody:
+			if t.replacementBody != nil {
+				err = m.ReplaceBody(t.replacementBody)
+			}
+		case ModifyQuarantine:
+			if t.quarantineReason != nil {
+				err = m.Quarantine(*t.quarantineReason)
+			}
+		}
+		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+func (t *transaction) sendMailFrom(m *milter.Modifier) error {
+	if t.origMailFrom.Addr != t.mailFrom.Addr || t.origMailFrom.Args != t.mailFrom.Args {
+		return m.ChangeFrom(t.mailFrom.Addr, t.mailFrom.Args)
+	}
+	return nil
+}
+
+func (t *transaction) sendRecipients(m *milter.Modifier) error {
 	deletions, additions := rcptto.Diff(t.origRcptTos, t.rcptTos)
 	for _, r := range deletions {
 		if err := m.DeleteRecipient(r.Addr); err != nil {
@@ -195,10 +267,16 @@ func (t *transaction) sendModifications(m *milter.Modifier) error {
 	}
 	for _, r := range additions {
 		if err := m.AddRecipient(r.Addr, r.Args); err != nil {
+			if err == milter.ErrModificationNotAllowed && r.Args != "" {
+				return fmt.Errorf("mailfilter: cannot add recipient %q with ESMTP arguments %q, the MTA does not support it: %w", r.Addr, r.Args, err)
+			}
 			return err
 		}
 	}
-	changeInsertOps, addOps := header.DiffOrRecreate(t.enforceHeaderOrder, t.origHeaders, t.headers)
+	return nil
+}
+
+func sendChangeInsertHeaders(m *milter.Modifier, changeInsertOps []header.Op) error {
 	// apply change/insert operations in reverse for the indexes to be correct
 	for i := len(changeInsertOps) - 1; i > -1; i-- {
 		op := changeInsertOps[i]
@@ -212,6 +290,10 @@ func (t *transaction) sendModifications(m *milter.Modifier) error {
 			}
 		}
 	}
+	return nil
+}
+
+func sendAddHeaders(m *milter.Modifier, addOps []header.Op, changeInsertCount int) error {
 	for _, op := range addOps {
 		// Sendmail has headers in its envelop headers list that it does not send to the milter.
 		// But the *do* count to the insert index?! So for sendmail we cannot really add a header at a specific position.
@@ -219,20 +301,7 @@ func (t *transaction) sendModifications(m *milter.Modifier) error {
 		// We add the arbitrary number 100 to the index so that we skip any and all "hidden" sendmail headers when we
 		// want to insert at the end of the header list.
 		// We do not use m.AddHeader since that also is not guaranteed to add the header at the end…
-		if err := m.InsertHeader(op.Index+len(changeInsertOps)+100, op.Name, op.Value); err != nil {
-			return err
-		}
-	}
-	if t.replacementBody != nil {
-		defer func() {
-			t.closeReplacementBody()
-		}()
-		if err := m.ReplaceBody(t.replacementBody); err != nil {
-			return err
-		}
-	}
-	if t.quarantineReason != nil {
-		if err := m.Quarantine(*t.quarantineReason); err != nil {
+		if err := m.InsertHeader(op.Index+changeInsertCount+100, op.Name, op.Value); err != nil {
 			return err
 		}
 	}
@@ -246,10 +315,22 @@ func (t *transaction) addHeader(key string, raw []byte) {
 	t.origHeaders.AddRaw(key, raw)
 }
 
-func (t *transaction) addBodyChunk(chunk []byte) (err error) {
+// initBody creates the spooled body of t. The MTA does not send body chunks for an empty body,
+// so EndOfMessage calls this to make [Trx.Body] return an empty body instead of nil.
+func (t *transaction) initBody() {
 	if t.body == nil {
-		t.body = body.New(200 * 1024)
+		if t.snapshotID != "" {
+			// name the spool file, so that we can find it when we crash
+			t.body = body.NewIn(200*1024, t.spoolDir, t.snapshotID+"-*")
+		} else {
+			t.body = body.New(200 * 1024)
+		}
 	}
+}
+
+func (t *transaction) addBodyChunk(chunk []byte) (err error) {
+	t.initBody()
+	t.bodySize += int64(len(chunk))
 	_, err = t.body.Write(chunk)
 	return
 }
@@ -289,12 +370,16 @@ func (t *transaction) HeadersEnforceOrder() {
 	}
 }
 
-func (t *transaction) Body() io.ReadSeeker {
+func (t *transaction) Body() Body {
 	if t.body == nil {
 		return nil
 	}
 	_, _ = t.body.Seek(0, io.SeekStart)
-	return t.body
+	return NewBody(t.body)
+}
+
+func (t *transaction) IsEmptyBody() bool {
+	return t.body != nil && t.bodySize == 0
 }
 
 func (t *transaction) ReplaceBody(r io.Reader) {
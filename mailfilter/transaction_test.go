@@ -9,6 +9,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/d--j/go-milter"
 	"github.com/d--j/go-milter/internal/wire"
 	"github.com/d--j/go-milter/mailfilter/addr"
 	"github.com/emersion/go-message/mail"
@@ -56,6 +57,8 @@ func TestTransaction_AddRcptTo(t1 *testing.T) {
 		{"add", []a{{}}, args{"root@localhost", "A=B"}, []a{{}, {Addr: "root@localhost", Args: "A=B"}}},
 		{"idna-utf8", []a{{Addr: "root@スパム.example.com"}}, args{"root@xn--zck5b2b.example.com", "A=B"}, []a{{Addr: "root@スパム.example.com", Args: "A=B"}}},
 		{"idna-ascii", []a{{Addr: "root@xn--zck5b2b.example.com"}}, args{"root@スパム.example.com", "A=B"}, []a{{Addr: "root@xn--zck5b2b.example.com", Args: "A=B"}}},
+		{"keep-esmtp-args", []a{{Addr: "root@localhost", Args: "NOTIFY=NEVER"}}, args{"root@localhost", ""}, []a{{Addr: "root@localhost", Args: "NOTIFY=NEVER"}}},
+		{"change-esmtp-args", []a{{Addr: "root@localhost", Args: "NOTIFY=NEVER"}}, args{"root@localhost", "NOTIFY=SUCCESS"}, []a{{Addr: "root@localhost", Args: "NOTIFY=SUCCESS"}}},
 	}
 	for _, tt := range tests {
 		t1.Run(tt.name, func(t1 *testing.T) {
@@ -72,6 +75,21 @@ func TestTransaction_AddRcptTo(t1 *testing.T) {
 	}
 }
 
+func TestTransaction_AddRcptTo_removeArgs(t *testing.T) {
+	// an empty esmtpArgs keeps the ESMTP arguments, the Args field removes them
+	trx := &transaction{
+		rcptTos: rcptFromAddr([]a{{Addr: "root@localhost", Args: "NOTIFY=NEVER"}}),
+	}
+	trx.AddRcptTo("root@localhost", "")
+	if got, want := addrFromRcp(trx.RcptTos()), []a{{Addr: "root@localhost", Args: "NOTIFY=NEVER"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("RcptTos = %+v, want %+v", got, want)
+	}
+	trx.RcptTos()[0].Args = ""
+	if got, want := addrFromRcp(trx.RcptTos()), []a{{Addr: "root@localhost"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("RcptTos = %+v, want %+v", got, want)
+	}
+}
+
 func TestTransaction_DelRcptTo(t1 *testing.T) {
 	type args struct {
 		rcptTo string
@@ -286,7 +304,7 @@ func TestTransaction_sendModifications(t1 *testing.T) {
 					t1.Errorf("hasModifications() = %v, want %v", gotHas, expectHas)
 				}
 			}
-			if err := b.transaction.sendModifications(s.newModifier()); (err != nil) != tt.wantErr {
+			if err := b.transaction.sendModifications(s.newModifier(), nil); (err != nil) != tt.wantErr {
 				t1.Errorf("sendModifications() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			got := s.modifications
@@ -347,3 +365,15 @@ func Test_transaction_HeadersEnforceOrder(t1 *testing.T) {
 		})
 	}
 }
+
+func TestTransaction_sendModifications_addRcptWithArgsNotAllowed(t *testing.T) {
+	b, s := newMockBackend()
+	t.Cleanup(b.transaction.cleanup)
+	_, _ = b.RcptTo("root@localhost", "", s.newModifier())
+	b.transaction.AddRcptTo("someone@localhost", "NOTIFY=NEVER")
+	m := milter.NewTestModifier(s.macros, s.writePacket, s.writeProgress, milter.AllClientSupportedActionMasks&^milter.OptAddRcptWithArgs, milter.DataSize64K)
+	err := b.transaction.sendModifications(m, nil)
+	if !errors.Is(err, milter.ErrModificationNotAllowed) || !strings.Contains(err.Error(), "NOTIFY=NEVER") {
+		t.Fatalf("sendModifications() error = %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package mailfilter
 import (
 	"io"
 
+	"github.com/d--j/go-milter"
 	"github.com/d--j/go-milter/mailfilter/addr"
 	"github.com/d--j/go-milter/mailfilter/header"
 )
@@ -35,6 +36,9 @@ type Trx interface {
 	// Your changes to Addr and/or Args values of the elements of this slice get send back to the MTA.
 	// But you should use DelRcptTo and AddRcptTo
 	//
+	// The Args of the recipients are the ESMTP arguments the client sent (e.g. NOTIFY and ORCPT),
+	// use [addr.RcptTo.Notify], [addr.RcptTo.ORcpt] or [addr.RcptTo.Arg] to inspect them.
+	//
 	// Only populated if [WithDecisionAt] is bigger than [DecisionAtMailFrom].
 	RcptTos() []*addr.RcptTo
 	// HasRcptTo returns true when rcptTo is in the list of recipients.
@@ -43,11 +47,16 @@ type Trx interface {
 	HasRcptTo(rcptTo string) bool
 	// AddRcptTo adds the rcptTo (without angles) to the list of recipients with the ESMTP arguments esmtpArgs.
 	// If rcptTo is already in the list of recipients only the esmtpArgs of this recipient get updated.
+	// An empty esmtpArgs keeps the ESMTP arguments of the existing recipient.
+	// Use the Args field of the [addr.RcptTo] in RcptTos to remove the ESMTP arguments of a recipient.
 	//
 	// rcptTo gets compared to the existing recipients IDNA address aware.
 	//
 	// When your filter should work with Sendmail you should set esmtpArgs to the empty string
 	// since Sendmail validates the provided esmtpArgs and also rejects valid values like `BODY=8BITMIME`.
+	//
+	// Recipients with esmtpArgs get added with the SMFIR_ADDRCPT_PAR milter command. When the MTA does not support
+	// this command, sending the modifications at the end of the transaction fails with an error wrapping [milter.ErrModificationNotAllowed].
 	AddRcptTo(rcptTo string, esmtpArgs string)
 	// DelRcptTo deletes the rcptTo (without angles) from the list of recipients.
 	//
@@ -69,12 +78,16 @@ type Trx interface {
 	// For other MTAs this method does not do anything (since there we can ensure correct header ordering without this workaround).
 	HeadersEnforceOrder()
 
-	// Body gets you a [io.ReadSeeker] of the body.
+	// Body gets you the [Body] of the message. You can read it sequentially or access byte ranges of it with
+	// [io.ReaderAt] or [Body.Slice].
 	// The reader gets seeked to the start of the body whenever you call this method.
 	//
 	// This method returns nil when you used [WithDecisionAt] with anything other than [DecisionAtEndOfMessage]
 	// or you used [WithoutBody].
-	Body() io.ReadSeeker
+	Body() Body
+	// IsEmptyBody returns true when the message has a body of zero bytes (e.g. a message that only consists of a header).
+	// It returns false when the body is not available (see [Trx.Body]).
+	IsEmptyBody() bool
 	// ReplaceBody replaces the body of the current message with the contents
 	// of the [io.Reader] r.
 	ReplaceBody(r io.Reader)
@@ -84,4 +97,8 @@ type Trx interface {
 	//
 	// Only populated if [WithDecisionAt] is bigger than [DecisionAtMailFrom].
 	QueueId() string
+
+	// CustomMacros holds the values of the custom macros that you requested with [WithCustomMacros].
+	// Macros that the MTA did not send are missing in the map.
+	CustomMacros() map[milter.MacroName]string
 }
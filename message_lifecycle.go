@@ -0,0 +1,37 @@
+package milter
+
+// MessageLifecycleMilter is a [Milter] that manages per-message resources (e.g. temporary files or scanner handles).
+//
+// The [Server] calls NewMessage when the MTA starts a message (at MAIL FROM, before [Milter.MailFrom]) and
+// EndMessageCleanup exactly once when this message is over: after [Milter.EndOfMessage] or [Milter.Abort],
+// when the MTA starts the next message without ending the current one, or when the connection ends in the middle of the message.
+// EndMessageCleanup always gets called before the connection-level [Milter.Cleanup].
+//
+// When NewMessage returns an error the error gets logged and the connection gets closed. EndMessageCleanup still gets called.
+//
+// A [MilterMiddleware] hides this interface when it does not implement it itself.
+type MessageLifecycleMilter interface {
+	Milter
+	NewMessage(m *Modifier) error
+	EndMessageCleanup()
+}
+
+// startMessage calls NewMessage of the backend when it is a [MessageLifecycleMilter].
+func (m *serverSession) startMessage() error {
+	lifecycle, ok := m.backend.(MessageLifecycleMilter)
+	if !ok {
+		return nil
+	}
+	m.messageLifecycle = lifecycle
+	return lifecycle.NewMessage(newModifier(m, true))
+}
+
+// endMessage calls EndMessageCleanup of the backend that started the current message.
+func (m *serverSession) endMessage() {
+	lifecycle := m.messageLifecycle
+	if lifecycle == nil {
+		return
+	}
+	m.messageLifecycle = nil
+	lifecycle.EndMessageCleanup()
+}
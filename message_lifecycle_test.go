@@ -0,0 +1,110 @@
+package milter
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lifecycleEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (e *lifecycleEvents) get() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.events...)
+}
+
+type lifecycleMilter struct {
+	*MockMilter
+	log *lifecycleEvents
+}
+
+func (l *lifecycleMilter) record(event string) {
+	l.log.mu.Lock()
+	defer l.log.mu.Unlock()
+	l.log.events = append(l.log.events, event)
+}
+
+func (l *lifecycleMilter) NewMessage(m *Modifier) error {
+	l.record("new " + m.Macros.Get(MacroQueueId))
+	return nil
+}
+
+func (l *lifecycleMilter) EndMessageCleanup() {
+	l.record("end")
+}
+
+func (l *lifecycleMilter) Abort(m *Modifier) error {
+	l.record("abort")
+	return nil
+}
+
+func (l *lifecycleMilter) EndOfMessage(m *Modifier) (*Response, error) {
+	l.record("eom")
+	return RespAccept, nil
+}
+
+func (l *lifecycleMilter) Cleanup() {
+	l.record("cleanup")
+}
+
+func TestServer_MessageLifecycleMilter(t *testing.T) {
+	t.Parallel()
+	log := &lifecycleEvents{}
+	newMilter := func() Milter {
+		return &lifecycleMilter{MockMilter: &MockMilter{
+			ConnResp:      RespContinue,
+			HeloResp:      RespContinue,
+			MailResp:      RespContinue,
+			RcptResp:      RespContinue,
+			DataResp:      RespContinue,
+			HdrResp:       RespContinue,
+			HdrsResp:      RespContinue,
+			BodyChunkResp: RespContinue,
+		}, log: log}
+	}
+	macros := NewMacroBag()
+	w := newServerClient(t, macros, []Option{WithMilter(newMilter), WithMacroRequest(StageMail, []MacroName{MacroQueueId})}, nil)
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	// first message ends with EndOfMessage
+	macros.Set(MacroQueueId, "1")
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	// second message gets aborted
+	macros.Set(MacroQueueId, "2")
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+	// third message is in progress when the connection ends
+	macros.Set(MacroQueueId, "3")
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	w.Cleanup()
+	// the Server ends the first Milter after the final response of the first message
+	want := []string{"new 1", "eom", "end", "cleanup", "new 2", "abort", "end", "new 3", "abort", "end", "cleanup"}
+	deadline := time.Now().Add(time.Second)
+	for len(log.get()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// the Server might call Cleanup twice on QUIT, only look at the first one
+	if got := log.get(); len(got) < len(want) || !reflect.DeepEqual(got[:len(want)], want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package milter
+
+import "fmt"
+
+// LimitPolicy defines what a [Server] does with a message that exceeds the limits of
+// [WithMaxHeaderCount], [WithMaxHeaderBytes] or [WithMaxBodyBytes]. Use [WithLimitPolicy] to set it.
+type LimitPolicy int
+
+const (
+	// LimitTempFail temporarily rejects the message (452 4.3.4). The [Milter] does not get the rest of the message
+	// ([Milter.Abort] gets called instead of [Milter.EndOfMessage]). This is the default.
+	LimitTempFail LimitPolicy = iota
+	// LimitReject permanently rejects the message (552 5.3.4). The [Milter] does not get the rest of the message
+	// ([Milter.Abort] gets called instead of [Milter.EndOfMessage]).
+	LimitReject
+	// LimitTruncate accepts the message but only passes the part of the message to the [Milter] that is within the limits:
+	// header fields over the limit get dropped and the body gets cut off at the limit.
+	// [Milter.EndOfMessage] gets called as usual, [Modifier.Truncated] tells it that it did not see the whole message.
+	LimitTruncate
+)
+
+// limitResponse returns the response for a message whose part (e.g. "header") exceeded a limit.
+func limitResponse(policy LimitPolicy, part string) func() *Response {
+	if policy == LimitReject {
+		return func() *Response {
+			return mustRejectWithCodeAndReason(552, fmt.Sprintf("5.3.4 Message %s too large", part))
+		}
+	}
+	return func() *Response {
+		return mustRejectWithCodeAndReason(452, fmt.Sprintf("4.3.4 Message %s too large", part))
+	}
+}
+
+// countHeader counts the header field name: value and applies the [LimitPolicy] when the message exceeds
+// the limits of [WithMaxHeaderCount] or [WithMaxHeaderBytes].
+// It returns false when the header field should not be passed to the backend.
+func (m *serverSession) countHeader(name, value string) bool {
+	if m.headerTruncated {
+		return false
+	}
+	m.headerCount++
+	m.headerBytes += len(name) + len(value)
+	if max := m.server.options.maxHeaderCount; max > 0 && m.headerCount > max {
+		m.exceedLimit("header", fmt.Errorf("more than %d header fields", max))
+		return false
+	} else if max := m.server.options.maxHeaderBytes; max > 0 && m.headerBytes > max {
+		m.exceedLimit("header", fmt.Errorf("header is bigger than %d bytes", max))
+		return false
+	}
+	return true
+}
+
+// countBody counts the body chunk and applies the [LimitPolicy] when the message exceeds the limit of [WithMaxBodyBytes].
+// It returns the part of chunk that should be passed to the backend.
+func (m *serverSession) countBody(chunk []byte) []byte {
+	if m.bodyTruncated {
+		return nil
+	}
+	max := m.server.options.maxBodyBytes
+	if max <= 0 || m.bodyBytes+int64(len(chunk)) <= max {
+		m.bodyBytes += int64(len(chunk))
+		return chunk
+	}
+	chunk = chunk[:max-m.bodyBytes]
+	m.bodyBytes = max
+	m.exceedLimit("body", fmt.Errorf("body is bigger than %d bytes", max))
+	if m.rejected != nil {
+		return nil
+	}
+	return chunk
+}
+
+// exceedLimit applies the [LimitPolicy] for a message whose part exceeded a limit.
+// err is the reason that gets logged.
+func (m *serverSession) exceedLimit(part string, err error) {
+	policy := m.server.options.limitPolicy
+	if policy != LimitTruncate {
+		m.reject(limitResponse(policy, part), err)
+		return
+	}
+	m.logWarning("truncating message: %v", err)
+	if part == "header" {
+		m.headerTruncated = true
+	} else {
+		m.bodyTruncated = true
+	}
+}
+
+// truncatedBodyResponse is the response to body chunks that the server dropped because of [LimitTruncate].
+func (m *serverSession) truncatedBodyResponse() *Response {
+	if m.protocolOption(OptSkip) {
+		return RespSkip
+	}
+	return RespContinue
+}
@@ -0,0 +1,46 @@
+package milter
+
+import (
+	"reflect"
+)
+
+// SetMessageValue stores value under key for the current message. Use it in stateless [Milter] implementations
+// (e.g. handler functions) to pass intermediate results of one callback to the callbacks of later stages,
+// without putting mutable fields on your [Milter].
+//
+// The values belong to the current message: the [Server] discards them when the message ends
+// (after [Milter.EndOfMessage] or [Milter.Abort]) and when the next MAIL FROM starts a new message.
+// Setting a nil value deletes key.
+//
+// Like with [context.WithValue] key must be comparable and should be of an unexported type to avoid collisions.
+// The values of modifiers created with [NewTestModifier] or [NewRecordingModifier] belong to the modifier.
+func (m *Modifier) SetMessageValue(key, value interface{}) {
+	if key == nil {
+		panic("milter: nil message value key")
+	}
+	if !reflect.TypeOf(key).Comparable() {
+		panic("milter: message value key is not comparable")
+	}
+	values := m.messageValues()
+	if value == nil {
+		delete(*values, key)
+		return
+	}
+	if *values == nil {
+		*values = make(map[interface{}]interface{})
+	}
+	(*values)[key] = value
+}
+
+// MessageValue returns the value that [Modifier.SetMessageValue] stored under key for the current message, or nil.
+func (m *Modifier) MessageValue(key interface{}) interface{} {
+	return (*m.messageValues())[key]
+}
+
+// messageValues returns the storage of SetMessageValue of m.
+func (m *Modifier) messageValues() *map[interface{}]interface{} {
+	if m.session != nil {
+		return &m.session.messageValues
+	}
+	return &m.values
+}
@@ -0,0 +1,84 @@
+package milter
+
+import (
+	"testing"
+)
+
+type messageValueKey struct{}
+
+func TestModifier_MessageValue(t *testing.T) {
+	t.Parallel()
+	var got []interface{}
+	mm := &MockMilter{
+		ConnResp: RespContinue,
+		ConnMod: func(m *Modifier) {
+			m.SetMessageValue(messageValueKey{}, "connect")
+		},
+		HeloResp: RespContinue,
+		MailResp: RespContinue,
+		MailMod: func(m *Modifier) {
+			got = append(got, m.MessageValue(messageValueKey{}))
+			m.SetMessageValue(messageValueKey{}, m.MessageSeq())
+		},
+		RcptResp: RespContinue,
+		RcptMod: func(m *Modifier) {
+			got = append(got, m.MessageValue(messageValueKey{}))
+		},
+		AbortMod: func(m *Modifier) {
+			got = append(got, m.MessageValue(messageValueKey{}))
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return mm })}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	want := []interface{}{nil, uint64(1), uint64(1), nil, uint64(2)}
+	if len(got) != len(want) {
+		t.Fatalf("got values %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got values %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestModifier_SetMessageValue(t *testing.T) {
+	t.Parallel()
+	m := NewTestModifier(nil, nil, nil, 0, 0)
+	if m.MessageValue("key") != nil {
+		t.Error("MessageValue() of empty modifier != nil")
+	}
+	m.SetMessageValue("key", 1)
+	if m.MessageValue("key") != 1 {
+		t.Errorf("MessageValue() = %v, want 1", m.MessageValue("key"))
+	}
+	m.SetMessageValue("key", nil)
+	if m.MessageValue("key") != nil {
+		t.Error("SetMessageValue(nil) did not delete the value")
+	}
+	for _, key := range []interface{}{nil, []string{"not comparable"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("SetMessageValue(%v) did not panic", key)
+				}
+			}()
+			m.SetMessageValue(key, 1)
+		}()
+	}
+}
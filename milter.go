@@ -15,7 +15,9 @@ const (
 	OptQuarantine      OptAction = 1 << 5 // SMFIF_QUARANTINE
 	OptChangeFrom      OptAction = 1 << 6 // SMFIF_CHGFROM [v6]
 	OptAddRcptWithArgs OptAction = 1 << 7 // SMFIF_ADDRCPT_PAR [v6]
-	OptSetMacros       OptAction = 1 << 8 // SMFIF_SETSYMLIST [v6]
+	// OptSetMacros lets the milter change the list of macros that the MTA sends (see [WithMacroRequest]).
+	// The milter protocol has no command to set macro values in the MTA.
+	OptSetMacros OptAction = 1 << 8 // SMFIF_SETSYMLIST [v6]
 )
 
 // OptProtocol masks out unwanted parts of the SMTP transaction.
@@ -79,7 +81,7 @@ const (
 // DataSize defines the maximum data size for milter or MTA to use.
 //
 // The DataSize does not include the one byte for the command byte.
-// Only three sizes are defined in the milter protocol.
+// Only three sizes are defined in the milter protocol, but you can use other sizes with [WithOfferedMaxData] and [WithUsedMaxData].
 type DataSize uint32
 
 const (
@@ -89,8 +91,22 @@ const (
 	DataSize256K DataSize = 1024*256 - 1
 	// DataSize1M is 1MB - 1 byte (command-byte)
 	DataSize1M DataSize = 1024*1024 - 1
+	// dataSizeLimit is the hard maximum packet size of this library (512 MB) - 1 byte (command-byte)
+	dataSizeLimit DataSize = 512*1024*1024 - 1
 )
 
+// protocolFlag returns the protocol negotiation flag of the biggest standard data size that fits into size.
+func (size DataSize) protocolFlag() uint32 {
+	switch {
+	case size >= DataSize1M:
+		return optMds1M
+	case size >= DataSize256K:
+		return optMds256K
+	default:
+		return 0
+	}
+}
+
 type ProtoFamily byte
 
 const (
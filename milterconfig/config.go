@@ -0,0 +1,313 @@
+// Package milterconfig binds the configuration of a milter daemon to a struct.
+//
+// You tag the fields of your configuration struct with `config:"name"` (and optionally `usage:"help text"`)
+// and [Load] fills them from command line flags, environment variables and a configuration file.
+// The [Daemon] struct has the settings that most milter daemons need, embed it into your configuration struct:
+//
+//	type Config struct {
+//		milterconfig.Daemon
+//		Threshold float64 `config:"threshold" usage:"spam score threshold"`
+//	}
+//
+//	cfg := Config{Daemon: milterconfig.Daemon{Socket: milterconfig.MustParseSocket("inet:10025@127.0.0.1")}}
+//	if err := milterconfig.Load(&cfg, milterconfig.Options{EnvPrefix: "MY_MILTER_"}); err != nil {
+//		log.Fatal(err)
+//	}
+package milterconfig
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configure [Load].
+type Options struct {
+	// Name is the name of the program in the usage message of the flags. The default is os.Args[0].
+	Name string
+	// Args are the command line arguments without the program name. The default is os.Args[1:].
+	Args []string
+	// EnvPrefix gets prepended to the names of the environment variables, e.g. "MY_MILTER_".
+	EnvPrefix string
+	// ConfigFlag is the name of the flag (and setting of the environment) that names the configuration file.
+	// The default is "config", use "-" to disable configuration files.
+	ConfigFlag string
+	// LookupEnv looks up environment variables. The default is [os.LookupEnv].
+	LookupEnv func(key string) (string, bool)
+	// Output receives the usage and the errors of the flags. The default is os.Stderr.
+	Output io.Writer
+}
+
+// field is a configurable field of a configuration struct.
+type field struct {
+	name  string
+	usage string
+	value reflect.Value
+}
+
+// Load fills the tagged fields of cfg, which needs to be a pointer to a struct.
+//
+// The values get applied in this order, a later source overrides an earlier one:
+// the values that are already in cfg (your defaults), the configuration file, environment variables and command line flags.
+//
+// The setting name of a field is the value of its config tag, e.g. `config:"read-timeout"`.
+// The command line flag has the same name (-read-timeout), the environment variable is the upper-cased name with
+// underscores and the EnvPrefix of opts (MY_MILTER_READ_TIMEOUT). Fields of embedded structs are settings of cfg.
+//
+// Load supports fields of the types string, bool, the integer and float types, [time.Duration], []string (comma-separated)
+// and types that implement [encoding.TextUnmarshaler]. Integers can use the prefixes 0x, 0o (or 0) and 0b.
+//
+// The configuration file has one "name: value" setting per line, the values use the same syntax as the flags.
+// A value can be in double quotes (with the escapes of Go strings) or in single quotes (write a single quote twice).
+// A # at the start of a line or after a space starts a comment. Empty lines are ignored, indented lines are an error.
+// This is a flat subset of YAML, so a simple YAML file works, but nested values and YAML sequences do not:
+//
+//	# settings of my milter
+//	socket: inet:10025@127.0.0.1
+//	read-timeout: 10s
+//	actions: add-header, change-body
+//	name: 'it''s #1'
+//
+// When the flags contain -h or -help Load prints the usage and returns [flag.ErrHelp].
+func Load(cfg interface{}, opts Options) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("milterconfig: cfg needs to be a pointer to a struct")
+	}
+	fields, err := structFields(v.Elem(), nil)
+	if err != nil {
+		return err
+	}
+	if opts.Name == "" {
+		opts.Name = os.Args[0]
+	}
+	if opts.Args == nil {
+		opts.Args = os.Args[1:]
+	}
+	if opts.ConfigFlag == "" {
+		opts.ConfigFlag = "config"
+	}
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+
+	fs := flag.NewFlagSet(opts.Name, flag.ContinueOnError)
+	if opts.Output != nil {
+		fs.SetOutput(opts.Output)
+	}
+	flags := make([]*rawFlag, len(fields))
+	for i, f := range fields {
+		flags[i] = &rawFlag{isBool: f.value.Kind() == reflect.Bool}
+		if !f.value.IsZero() {
+			flags[i].def = formatValue(f.value)
+		}
+		fs.Var(flags[i], f.name, f.usage)
+	}
+	var configFile string
+	if opts.ConfigFlag != "-" {
+		fs.StringVar(&configFile, opts.ConfigFlag, "", "path of the configuration file")
+	}
+	if err := fs.Parse(opts.Args); err != nil {
+		return err
+	}
+
+	if opts.ConfigFlag != "-" && configFile == "" {
+		configFile, _ = opts.LookupEnv(envName(opts.EnvPrefix, opts.ConfigFlag))
+	}
+	if configFile != "" {
+		if err := loadFile(configFile, fields); err != nil {
+			return err
+		}
+	}
+	for _, f := range fields {
+		name := envName(opts.EnvPrefix, f.name)
+		if s, ok := opts.LookupEnv(name); ok {
+			if err := setValue(f.value, s); err != nil {
+				return fmt.Errorf("milterconfig: environment variable %s: %w", name, err)
+			}
+		}
+	}
+	for i, f := range fields {
+		if flags[i].set {
+			if err := setValue(f.value, flags[i].value); err != nil {
+				return fmt.Errorf("milterconfig: flag -%s: %w", f.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envName returns the environment variable name of the setting name.
+func envName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// structFields appends the tagged fields of the struct v to fields.
+func structFields(v reflect.Value, fields []field) ([]field, error) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Tag.Get("config")
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if name == "" {
+			if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+				var err error
+				if fields, err = structFields(fv, fields); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if !supported(fv) {
+			return nil, fmt.Errorf("milterconfig: field %s has the unsupported type %s", sf.Name, sf.Type)
+		}
+		for _, f := range fields {
+			if f.name == name {
+				return nil, fmt.Errorf("milterconfig: duplicate setting %q", name)
+			}
+		}
+		fields = append(fields, field{name: name, usage: sf.Tag.Get("usage"), value: fv})
+	}
+	return fields, nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func supported(v reflect.Value) bool {
+	if _, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String
+	default:
+		return false
+	}
+}
+
+// setValue parses s and stores it in v.
+func setValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		setList(v, list)
+	}
+	return nil
+}
+
+// setList stores list in the []string v.
+func setList(v reflect.Value, list []string) {
+	l := reflect.MakeSlice(v.Type(), len(list), len(list))
+	for i, s := range list {
+		l.Index(i).SetString(s)
+	}
+	v.Set(l)
+}
+
+// formatValue returns the current value of v as string (for the defaults in the usage message of the flags).
+func formatValue(v reflect.Value) string {
+	if m, ok := v.Addr().Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		if err != nil {
+			return ""
+		}
+		return string(text)
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		list := make([]string, v.Len())
+		for i := range list {
+			list[i] = v.Index(i).String()
+		}
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// rawFlag is a [flag.Value] that records the raw value of a flag. Load applies it after the other sources.
+type rawFlag struct {
+	def    string
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *rawFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	if f.set {
+		return f.value
+	}
+	return f.def
+}
+
+func (f *rawFlag) Set(s string) error {
+	f.value, f.set = s, true
+	return nil
+}
+
+func (f *rawFlag) IsBoolFlag() bool {
+	return f.isBool
+}
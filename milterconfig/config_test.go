@@ -0,0 +1,156 @@
+package milterconfig
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d--j/go-milter"
+)
+
+type testConfig struct {
+	Daemon
+	Threshold float64  `config:"threshold" usage:"spam score threshold"`
+	Verbose   bool     `config:"verbose"`
+	Domains   []string `config:"domains"`
+	Name      string   `config:"name"`
+	Ignored   string
+	Skipped   string `config:"-"`
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func env(m map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := writeFile(t, `# comment
+socket: "inet:10025@127.0.0.1"
+read-timeout: 10s
+threshold: 4.5 # inline comment
+name: 'it''s #1'
+actions: add-header, change-body
+domains: example.com, example.org
+`)
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want testConfig
+	}{
+		{"defaults", []string{}, nil, testConfig{Daemon: Daemon{Socket: Socket{"unix", "/run/milter.sock"}, WriteTimeout: time.Minute}, Threshold: 5}},
+		{"file", []string{"-config", file}, nil, testConfig{
+			Daemon:    Daemon{Socket: Socket{"tcp4", "127.0.0.1:10025"}, ReadTimeout: 10 * time.Second, WriteTimeout: time.Minute, Actions: Actions(milter.OptAddHeader | milter.OptChangeBody)},
+			Threshold: 4.5, Name: "it's #1", Domains: []string{"example.com", "example.org"},
+		}},
+		{"file from env", []string{}, map[string]string{"TEST_CONFIG": file}, testConfig{
+			Daemon:    Daemon{Socket: Socket{"tcp4", "127.0.0.1:10025"}, ReadTimeout: 10 * time.Second, WriteTimeout: time.Minute, Actions: Actions(milter.OptAddHeader | milter.OptChangeBody)},
+			Threshold: 4.5, Name: "it's #1", Domains: []string{"example.com", "example.org"},
+		}},
+		{"env overrides file", []string{"-config", file}, map[string]string{"TEST_THRESHOLD": "3", "TEST_SOCKET_MODE": "0660", "TEST_DOMAINS": "a.example, b.example", "TEST_VERBOSE": "true"}, testConfig{
+			Daemon:    Daemon{Socket: Socket{"tcp4", "127.0.0.1:10025"}, SocketMode: 0660, ReadTimeout: 10 * time.Second, WriteTimeout: time.Minute, Actions: Actions(milter.OptAddHeader | milter.OptChangeBody)},
+			Threshold: 3, Verbose: true, Name: "it's #1", Domains: []string{"a.example", "b.example"},
+		}},
+		{"flags override env", []string{"-config", file, "-threshold", "2", "-verbose", "-socket", "/tmp/m.sock", "-actions", "quarantine"}, map[string]string{"TEST_THRESHOLD": "3", "TEST_SOCKET": "inet6:10025@::1"}, testConfig{
+			Daemon:    Daemon{Socket: Socket{"unix", "/tmp/m.sock"}, ReadTimeout: 10 * time.Second, WriteTimeout: time.Minute, Actions: Actions(milter.OptQuarantine)},
+			Threshold: 2, Verbose: true, Name: "it's #1", Domains: []string{"example.com", "example.org"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig{Daemon: Daemon{Socket: MustParseSocket("/run/milter.sock"), WriteTimeout: time.Minute}, Threshold: 5}
+			if err := Load(&cfg, Options{Name: "test", Args: tt.args, EnvPrefix: "TEST_", LookupEnv: env(tt.env)}); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !reflect.DeepEqual(cfg, tt.want) {
+				t.Errorf("Load() got %+v, want %+v", cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  interface{}
+		args []string
+		env  map[string]string
+		file string
+		err  string
+	}{
+		{"no pointer", testConfig{}, nil, nil, "", "pointer to a struct"},
+		{"unsupported type", &struct {
+			M map[string]string `config:"m"`
+		}{}, nil, nil, "", "unsupported type"},
+		{"duplicate", &struct {
+			A string `config:"a"`
+			B string `config:"a"`
+		}{}, nil, nil, "", "duplicate setting"},
+		{"unknown flag", &testConfig{}, []string{"-unknown"}, nil, "", "flag provided but not defined"},
+		{"invalid flag", &testConfig{}, []string{"-threshold", "high"}, nil, "", "flag -threshold"},
+		{"invalid env", &testConfig{}, nil, map[string]string{"TEST_READ_TIMEOUT": "10"}, "", "environment variable TEST_READ_TIMEOUT"},
+		{"invalid socket", &testConfig{}, []string{"-socket", "udp:127.0.0.1:25"}, nil, "", "unknown network"},
+		{"unknown setting", &testConfig{}, nil, nil, "threshold: 1\nthreshhold: 2\n", ":2: unknown setting \"threshhold\""},
+		{"invalid value", &testConfig{}, nil, nil, "actions: add-header, delete\n", ":1: actions: milterconfig: unknown action \"delete\""},
+		{"nested mapping", &testConfig{}, nil, nil, "socket:\n  network: unix\n", "indented lines"},
+		{"missing file", &testConfig{}, []string{"-config", "/does/not/exist.yml"}, nil, "", "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append(args, "-config", writeFile(t, tt.file))
+			}
+			if args == nil {
+				args = []string{}
+			}
+			err := Load(tt.cfg, Options{Name: "test", Args: args, EnvPrefix: "TEST_", LookupEnv: env(tt.env), Output: io.Discard})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Load() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoad_Help(t *testing.T) {
+	var usage bytes.Buffer
+	cfg := testConfig{Daemon: Daemon{Socket: MustParseSocket("unix:/run/milter.sock")}}
+	err := Load(&cfg, Options{Name: "test", Args: []string{"-h"}, LookupEnv: env(nil), Output: &usage})
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Load() error = %v, want flag.ErrHelp", err)
+	}
+	for _, s := range []string{"-socket", "(default unix:/run/milter.sock)", "spam score threshold", "-config"} {
+		if !strings.Contains(usage.String(), s) {
+			t.Errorf("usage %q does not contain %q", usage.String(), s)
+		}
+	}
+	if strings.Contains(usage.String(), "(default 0") {
+		t.Errorf("usage %q contains zero defaults", usage.String())
+	}
+}
+
+func TestLoad_NoConfigFlag(t *testing.T) {
+	cfg := testConfig{}
+	err := Load(&cfg, Options{Name: "test", Args: []string{"-config", "x.yml"}, ConfigFlag: "-", LookupEnv: env(nil), Output: io.Discard})
+	if err == nil {
+		t.Error("Load() accepted -config")
+	}
+}
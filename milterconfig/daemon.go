@@ -0,0 +1,186 @@
+package milterconfig
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/d--j/go-milter"
+)
+
+// Daemon are the settings that most milter daemons need. Embed it into your configuration struct and use [Load].
+type Daemon struct {
+	Socket       Socket        `config:"socket" usage:"milter socket, e.g. unix:/run/milter.sock, inet:10025@127.0.0.1 or tcp:127.0.0.1:10025"`
+	SocketMode   uint32        `config:"socket-mode" usage:"file mode of a unix socket, e.g. 0660 (0 keeps the default)"`
+	ReadTimeout  time.Duration `config:"read-timeout" usage:"read timeout of MTA connections (0 keeps the default)"`
+	WriteTimeout time.Duration `config:"write-timeout" usage:"write timeout of MTA connections (0 keeps the default)"`
+	Actions      Actions       `config:"actions" usage:"comma-separated message modifications the milter needs, e.g. add-header,change-body"`
+	// SpoolDir is the directory for the spooled message bodies of a mailfilter,
+	// pass it to [github.com/d--j/go-milter/mailfilter.WithTrxStore].
+	SpoolDir string `config:"spool-dir" usage:"directory for spooled message bodies (empty uses the temporary directory)"`
+}
+
+// ServerOptions returns the [milter.Option] values of the settings of d.
+// Append the options of your milter (e.g. [milter.WithMilter]) and create the [milter.Server] with them.
+func (d *Daemon) ServerOptions() []milter.Option {
+	var opts []milter.Option
+	if d.ReadTimeout > 0 {
+		opts = append(opts, milter.WithReadTimeout(d.ReadTimeout))
+	}
+	if d.WriteTimeout > 0 {
+		opts = append(opts, milter.WithWriteTimeout(d.WriteTimeout))
+	}
+	if d.Actions != 0 {
+		opts = append(opts, milter.WithActions(milter.OptAction(d.Actions)))
+	}
+	if d.SocketMode != 0 && d.Socket.Network == "unix" {
+		opts = append(opts, milter.WithUnixSocketPermissions(os.FileMode(d.SocketMode), -1, -1))
+	}
+	return opts
+}
+
+// ListenAndServe serves the MTA connections of the socket of d with s (see [milter.Server.ListenAndServe]).
+func (d *Daemon) ListenAndServe(s *milter.Server) error {
+	if d.Socket.Network == "" {
+		return fmt.Errorf("milterconfig: no socket configured")
+	}
+	return s.ListenAndServe(d.Socket.Network, d.Socket.Address)
+}
+
+// Socket is the listening address of a milter. It understands the socket notation of Sendmail
+// (unix:/path, local:/path, inet:port@host and inet6:port@host), of Postfix (inet:host:port),
+// network:address for the networks of [net.Listen] (e.g. tcp:127.0.0.1:10025) and a plain path of a unix socket.
+type Socket struct {
+	// Network is the network of [net.Listen], "unix", "tcp", "tcp4" or "tcp6".
+	Network string
+	// Address is the address of [net.Listen].
+	Address string
+}
+
+// ParseSocket parses the socket notation s. See [Socket].
+func ParseSocket(s string) (Socket, error) {
+	if strings.HasPrefix(s, "/") {
+		return Socket{Network: "unix", Address: s}, nil
+	}
+	network, address, ok := strings.Cut(s, ":")
+	if !ok || address == "" {
+		return Socket{}, fmt.Errorf("milterconfig: invalid socket %q", s)
+	}
+	switch network {
+	case "unix", "local":
+		return Socket{Network: "unix", Address: address}, nil
+	case "inet", "inet6":
+		tcp := "tcp4"
+		if network == "inet6" {
+			tcp = "tcp6"
+		}
+		port, host, ok := strings.Cut(address, "@")
+		if !ok {
+			// Postfix notation inet:host:port
+			return Socket{Network: tcp, Address: address}, nil
+		}
+		if port == "" {
+			return Socket{}, fmt.Errorf("milterconfig: invalid socket %q: expected %s:port@host", s, network)
+		}
+		if tcp == "tcp6" {
+			host = "[" + strings.TrimSuffix(strings.TrimPrefix(host, "["), "]") + "]"
+		}
+		return Socket{Network: tcp, Address: host + ":" + port}, nil
+	case "tcp", "tcp4", "tcp6":
+		return Socket{Network: network, Address: address}, nil
+	default:
+		return Socket{}, fmt.Errorf("milterconfig: invalid socket %q: unknown network %q", s, network)
+	}
+}
+
+// MustParseSocket is like [ParseSocket] but panics when s is invalid. Use it for defaults.
+func MustParseSocket(s string) Socket {
+	socket, err := ParseSocket(s)
+	if err != nil {
+		panic(err)
+	}
+	return socket
+}
+
+// String returns the network:address notation of s.
+func (s Socket) String() string {
+	if s.Network == "" {
+		return ""
+	}
+	return s.Network + ":" + s.Address
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (s Socket) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler].
+func (s *Socket) UnmarshalText(text []byte) error {
+	socket, err := ParseSocket(string(text))
+	if err != nil {
+		return err
+	}
+	*s = socket
+	return nil
+}
+
+// Actions is a [milter.OptAction] that can be configured with a comma-separated list of action names.
+type Actions milter.OptAction
+
+var actionNames = []struct {
+	name   string
+	action milter.OptAction
+}{
+	{"add-header", milter.OptAddHeader},
+	{"change-body", milter.OptChangeBody},
+	{"add-rcpt", milter.OptAddRcpt},
+	{"remove-rcpt", milter.OptRemoveRcpt},
+	{"change-header", milter.OptChangeHeader},
+	{"quarantine", milter.OptQuarantine},
+	{"change-from", milter.OptChangeFrom},
+	{"add-rcpt-with-args", milter.OptAddRcptWithArgs},
+	{"set-macros", milter.OptSetMacros},
+}
+
+// String returns the comma-separated action names of a.
+func (a Actions) String() string {
+	var names []string
+	for _, n := range actionNames {
+		if milter.OptAction(a)&n.action != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (a Actions) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler]. It parses a comma-separated list of action names
+// (add-header, change-body, add-rcpt, remove-rcpt, change-header, quarantine, change-from, add-rcpt-with-args and set-macros).
+func (a *Actions) UnmarshalText(text []byte) error {
+	var actions milter.OptAction
+	for _, name := range strings.Split(string(text), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, n := range actionNames {
+			if n.name == name {
+				actions |= n.action
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("milterconfig: unknown action %q", name)
+		}
+	}
+	*a = Actions(actions)
+	return nil
+}
@@ -0,0 +1,119 @@
+package milterconfig
+
+import (
+	"testing"
+	"time"
+
+	"github.com/d--j/go-milter"
+)
+
+func TestParseSocket(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Socket
+		wantErr bool
+	}{
+		{"/run/milter.sock", Socket{"unix", "/run/milter.sock"}, false},
+		{"unix:/run/milter.sock", Socket{"unix", "/run/milter.sock"}, false},
+		{"local:/run/milter.sock", Socket{"unix", "/run/milter.sock"}, false},
+		{"inet:10025@127.0.0.1", Socket{"tcp4", "127.0.0.1:10025"}, false},
+		{"inet:10025@", Socket{"tcp4", ":10025"}, false},
+		{"inet6:10025@::1", Socket{"tcp6", "[::1]:10025"}, false},
+		{"inet6:10025@[::1]", Socket{"tcp6", "[::1]:10025"}, false},
+		{"inet:127.0.0.1:10025", Socket{"tcp4", "127.0.0.1:10025"}, false},
+		{"tcp:localhost:10025", Socket{"tcp", "localhost:10025"}, false},
+		{"tcp6:[::1]:10025", Socket{"tcp6", "[::1]:10025"}, false},
+		{"", Socket{}, true},
+		{"unix:", Socket{}, true},
+		{"inet:@127.0.0.1", Socket{}, true},
+		{"udp:127.0.0.1:10025", Socket{}, true},
+		{"milter.sock", Socket{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSocket(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSocket() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSocket() got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMustParseSocket(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("MustParseSocket() did not panic")
+		}
+	}()
+	MustParseSocket("invalid")
+}
+
+func TestSocket_MarshalText(t *testing.T) {
+	var s Socket
+	if err := s.UnmarshalText([]byte("inet:10025@127.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	text, _ := s.MarshalText()
+	if string(text) != "tcp4:127.0.0.1:10025" {
+		t.Errorf("MarshalText() got %q", text)
+	}
+	var s2 Socket
+	if err := s2.UnmarshalText(text); err != nil || s2 != s {
+		t.Errorf("UnmarshalText() got %+v, %v", s2, err)
+	}
+	if (Socket{}).String() != "" {
+		t.Errorf("String() of empty Socket got %q", Socket{}.String())
+	}
+}
+
+func TestActions(t *testing.T) {
+	var a Actions
+	if err := a.UnmarshalText([]byte(" change-body, add-header,,quarantine ")); err != nil {
+		t.Fatal(err)
+	}
+	if want := Actions(milter.OptAddHeader | milter.OptChangeBody | milter.OptQuarantine); a != want {
+		t.Errorf("UnmarshalText() got %v, want %v", a, want)
+	}
+	text, _ := a.MarshalText()
+	if string(text) != "add-header,change-body,quarantine" {
+		t.Errorf("MarshalText() got %q", text)
+	}
+	if err := a.UnmarshalText([]byte("add-header,unknown")); err == nil {
+		t.Error("UnmarshalText() accepted an unknown action")
+	}
+	var all Actions
+	for _, n := range actionNames {
+		all |= Actions(n.action)
+	}
+	var parsed Actions
+	if err := parsed.UnmarshalText([]byte(all.String())); err != nil || parsed != all {
+		t.Errorf("round trip got %v, %v, want %v", parsed, err, all)
+	}
+}
+
+func TestDaemon_ServerOptions(t *testing.T) {
+	d := Daemon{}
+	if opts := d.ServerOptions(); len(opts) != 0 {
+		t.Errorf("ServerOptions() of empty Daemon got %d options", len(opts))
+	}
+	d = Daemon{Socket: MustParseSocket("inet:10025@127.0.0.1"), SocketMode: 0660, ReadTimeout: time.Second, WriteTimeout: time.Second, Actions: Actions(milter.OptAddHeader)}
+	if opts := d.ServerOptions(); len(opts) != 3 {
+		t.Errorf("ServerOptions() of tcp Daemon got %d options, want 3", len(opts))
+	}
+	d.Socket = MustParseSocket("unix:/run/milter.sock")
+	if opts := d.ServerOptions(); len(opts) != 4 {
+		t.Errorf("ServerOptions() of unix Daemon got %d options, want 4", len(opts))
+	}
+}
+
+func TestDaemon_ListenAndServe(t *testing.T) {
+	d := Daemon{}
+	s := milter.NewServer(milter.WithMilter(func() milter.Milter { return milter.NoOpMilter{} }))
+	defer s.Close()
+	if err := d.ListenAndServe(s); err == nil {
+		t.Error("ListenAndServe() without socket did not fail")
+	}
+}
@@ -0,0 +1,114 @@
+package milterconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fileEntry is a setting of a configuration file.
+type fileEntry struct {
+	line  int
+	key   string
+	value string
+}
+
+// loadFile applies the settings of the configuration file name to fields.
+func loadFile(name string, fields []field) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("milterconfig: %w", err)
+	}
+	defer f.Close()
+	entries, err := parseFile(f)
+	if err != nil {
+		return fmt.Errorf("milterconfig: %s: %w", name, err)
+	}
+	for _, e := range entries {
+		var target *field
+		for i := range fields {
+			if fields[i].name == e.key {
+				target = &fields[i]
+				break
+			}
+		}
+		if target == nil {
+			return fmt.Errorf("milterconfig: %s:%d: unknown setting %q", name, e.line, e.key)
+		}
+		if err = setValue(target.value, e.value); err != nil {
+			return fmt.Errorf("milterconfig: %s:%d: %s: %w", name, e.line, e.key, err)
+		}
+	}
+	return nil
+}
+
+// parseFile parses a configuration file. See [Load] for its format.
+func parseFile(r io.Reader) ([]fileEntry, error) {
+	var entries []fileEntry
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(stripComment(scanner.Text()), " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: indented lines are not supported", lineNo)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"name: value\"", lineNo)
+		}
+		value, err := unquote(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		entries = append(entries, fileEntry{line: lineNo, key: strings.TrimSpace(key), value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// stripComment removes a # comment from line. A # only starts a comment at the start of line or after whitespace,
+// and not inside a quoted value.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if (c == '\\' && quote == '"') || (c == '\'' && quote == '\'' && i+1 < len(line) && line[i+1] == '\'') {
+				// skip the escaped character
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i > 0 && strings.IndexByte(" \t:", line[i-1]) >= 0:
+			// only quoted values start a quote, not an apostrophe inside an unquoted value
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote returns the value of the unquoted, double-quoted (with Go escapes) or single-quoted (with doubled single quotes) value s.
+func unquote(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return strconv.Unquote(s)
+	}
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	if strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'") {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	return s, nil
+}
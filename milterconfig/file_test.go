@@ -0,0 +1,47 @@
+package milterconfig
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []fileEntry
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"values", "a: 1\nb: \"x # y\"\nc: it's\nd:\ne: 'x''y'\n", []fileEntry{{line: 1, key: "a", value: "1"}, {line: 2, key: "b", value: "x # y"}, {line: 3, key: "c", value: "it's"}, {line: 4, key: "d"}, {line: 5, key: "e", value: "x'y"}}, false},
+		{"comments", "# first\n\na: b#c # d\n", []fileEntry{{line: 3, key: "a", value: "b#c"}}, false},
+		{"list", "a: x, y\n", []fileEntry{{line: 1, key: "a", value: "x, y"}}, false},
+		{"indented", "a:\n  - x\n", nil, true},
+		{"no setting", "a\n", nil, true},
+		{"unterminated string", "a: \"b\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFile(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFile() got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_spoolDir(t *testing.T) {
+	file := writeFile(t, "spool-dir: \"/var/spool/my milter\"\n")
+	var cfg Daemon
+	if err := Load(&cfg, Options{Name: "test", Args: []string{"-config", file}, LookupEnv: env(nil), Output: io.Discard}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SpoolDir != "/var/spool/my milter" {
+		t.Errorf("SpoolDir = %q", cfg.SpoolDir)
+	}
+}
@@ -0,0 +1,45 @@
+package milterutil
+
+import "strings"
+
+// FoldHeaderValue folds the value of the header field name at whitespace so that no line of the header field
+// (including the "name: " prefix on the first line) is longer than maxLength characters.
+// The whitespace where a line got folded starts the continuation line.
+// Words that are longer than maxLength stay on their own (too long) line.
+//
+// The lines of the result are separated by LF (see [CrLfToLf]).
+// Values that already contain line breaks are considered folded by the caller and get returned unchanged.
+func FoldHeaderValue(name, value string, maxLength int) string {
+	if maxLength <= 0 || strings.ContainsAny(value, "\r\n") || len(name)+2+len(value) <= maxLength {
+		return value
+	}
+	var b strings.Builder
+	b.Grow(len(value) + len(value)/maxLength + 1)
+	lineLength := len(name) + 2
+	// wordsOnLine is false at the start of the value, we never fold directly after the "name: " prefix
+	wordsOnLine := false
+	for start := 0; start < len(value); {
+		// a segment is the whitespace before a word and the word itself
+		end := start
+		for end < len(value) && isFoldingWhitespace(value[end]) {
+			end++
+		}
+		for end < len(value) && !isFoldingWhitespace(value[end]) {
+			end++
+		}
+		segment := value[start:end]
+		if wordsOnLine && lineLength+len(segment) > maxLength && isFoldingWhitespace(segment[0]) {
+			b.WriteByte(lf)
+			lineLength = 0
+		}
+		b.WriteString(segment)
+		lineLength += len(segment)
+		wordsOnLine = true
+		start = end
+	}
+	return b.String()
+}
+
+func isFoldingWhitespace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
@@ -0,0 +1,33 @@
+package milterutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFoldHeaderValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		field     string
+		value     string
+		maxLength int
+		want      string
+	}{
+		{"short", "Subject", "test", 78, "test"},
+		{"disabled", "Subject", strings.Repeat("a ", 50), 0, strings.Repeat("a ", 50)},
+		{"fold", "Subject", "one two three four", 14, "one\n two three\n four"},
+		{"greedy", "X", "aa bb cc dd ee", 10, "aa bb\n cc dd ee"},
+		{"tabs", "X", "aa\tbb\tcc", 5, "aa\n\tbb\n\tcc"},
+		{"long word", "X", "aaaaaaaaaaaa bb", 8, "aaaaaaaaaaaa\n bb"},
+		{"leading whitespace", "X", "   aaaaaaaaaaaa", 8, "   aaaaaaaaaaaa"},
+		{"already folded", "X", "aa bb\n cc dd ee ff", 8, "aa bb\n cc dd ee ff"},
+		{"multiple spaces", "X", "aa   bb", 6, "aa\n   bb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FoldHeaderValue(tt.field, tt.value, tt.maxLength); got != tt.want {
+				t.Errorf("FoldHeaderValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,9 +3,11 @@ package milterutil
 import (
 	"errors"
 	"fmt"
+	"unicode"
 	"unicode/utf8"
 
 	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
 )
 
 const cr = '\r'
@@ -331,3 +333,67 @@ func (t *MaximumLineLengthTransformer) Reset() {
 }
 
 var _ transform.Transformer = &MaximumLineLengthTransformer{}
+
+// asciiReplacements are transliterations of characters that do not decompose into ASCII characters with NFKD.
+var asciiReplacements = map[rune]string{
+	'ß': "ss", 'Æ': "AE", 'æ': "ae", 'Ø': "O", 'ø': "o", 'Œ': "OE", 'œ': "oe",
+	'Ł': "L", 'ł': "l", 'Đ': "D", 'đ': "d", 'Þ': "Th", 'þ': "th", 'Ð': "D", 'ð': "d", 'ı': "i",
+	'‘': "'", '’': "'", '‚': "'", '“': "\"", '”': "\"", '„': "\"", '«': "\"", '»': "\"",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '−': "-", '€': "EUR", '•': "*",
+}
+
+// asciiTransformer replaces all non-ASCII characters of src. It expects NFKD normalized input.
+type asciiTransformer struct {
+	transform.NopResetter
+}
+
+func (t *asciiTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		c := src[nSrc]
+		if c < utf8.RuneSelf {
+			if nDst >= len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = c
+			nDst++
+			nSrc++
+			continue
+		}
+		if !atEOF && !utf8.FullRune(src[nSrc:]) {
+			return nDst, nSrc, transform.ErrShortSrc
+		}
+		r, size := utf8.DecodeRune(src[nSrc:])
+		var replacement string
+		if !unicode.Is(unicode.Mn, r) { // drop combining marks (accents)
+			if rep, ok := asciiReplacements[r]; ok {
+				replacement = rep
+			} else {
+				replacement = "?"
+			}
+		}
+		if nDst+len(replacement) > len(dst) {
+			return nDst, nSrc, transform.ErrShortDst
+		}
+		nDst += copy(dst[nDst:], replacement)
+		nSrc += size
+	}
+	return nDst, nSrc, nil
+}
+
+// NewASCIITransformer returns a [transform.Transformer] that transliterates src to ASCII.
+// Accents get removed (é becomes e), some common characters get transliterated (ß becomes ss, “ becomes ")
+// and all other non-ASCII characters get replaced with a question mark.
+//
+// You can use it to make text safe for protocols that only allow ASCII – like SMTP replies without SMTPUTF8.
+func NewASCIITransformer() transform.Transformer {
+	return transform.Chain(norm.NFKD, &asciiTransformer{})
+}
+
+// ToASCII transliterates s to ASCII with [NewASCIITransformer].
+func ToASCII(s string) string {
+	out, _, err := transform.String(NewASCIITransformer(), s)
+	if err != nil {
+		return s
+	}
+	return out
+}
@@ -145,6 +145,22 @@ func TestDoublePercentTransformer(t *testing.T) {
 	})
 }
 
+func TestASCIITransformer(t *testing.T) {
+	stuffing := strings.Repeat("1234567890", 4090/10)
+	t.Parallel()
+	doTransformerTest(t, NewASCIITransformer, nil, transformerTestCases{
+		{[]string{""}, ""},
+		{[]string{"plain ascii\r\n"}, "plain ascii\r\n"},
+		{[]string{"naïve café"}, "naive cafe"},
+		{[]string{"Grüße"}, "Grusse"},
+		{[]string{"“quoted” – text"}, "\"quoted\" - text"},
+		{[]string{"日本"}, "??"},
+		{[]string{"\xff"}, "?"},
+		{[]string{"n", "a\xcc", "\x88ve"}, "nave"},
+		{[]string{stuffing, "ßßßß"}, stuffing + "ssssssss"},
+	})
+}
+
 func TestSkipDoublePercentTransformer(t *testing.T) {
 	// transform.Transformer uses initial dst buffer size of 4096 bytes
 	stuffing := strings.Repeat("1234567890", 4090/10)
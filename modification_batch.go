@@ -0,0 +1,147 @@
+package milter
+
+import (
+	"fmt"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// BatchError is the error of [Modifier.Commit]. It holds all problems of the batch.
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("milter: modification batch: %s", strings.Join(msgs, "; "))
+}
+
+// modificationBatch holds the queued modifications of [Modifier.Begin].
+type modificationBatch struct {
+	// writePacket is the writePacket function of the Modifier before Begin
+	writePacket func(*wire.Message) error
+	queue       []*wire.Message
+}
+
+func (b *modificationBatch) add(msg *wire.Message) error {
+	// the data of msg might be a pooled buffer (e.g. the chunks of ReplaceBody), copy it
+	b.queue = append(b.queue, &wire.Message{Code: msg.Code, Data: append([]byte(nil), msg.Data...)})
+	return nil
+}
+
+// modificationRank returns the position of the modification msg in a committed batch.
+// The order is the same as the default order of the mailfilter package: envelope changes, header changes, body, quarantine.
+func modificationRank(msg *wire.Message) int {
+	switch wire.ModifyActCode(msg.Code) {
+	case wire.ActChangeFrom:
+		return 0
+	case wire.ActDelRcpt:
+		return 1
+	case wire.ActAddRcpt, wire.ActAddRcptPar:
+		return 2
+	case wire.ActChangeHeader, wire.ActInsertHeader:
+		return 3
+	case wire.ActAddHeader:
+		return 4
+	case wire.ActReplBody:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// validate returns the problems of the queued modifications of b.
+// Commit sorts the modifications, so their order is always valid. But some combinations are ambiguous
+// because the MTA only applies the last of them: more than one ChangeFrom or Quarantine call
+// and more than one ChangeHeader call for the same header field.
+func (b *modificationBatch) validate() []error {
+	var errs []error
+	changeFrom, quarantine := 0, 0
+	type headerField struct {
+		name  string
+		index uint32
+	}
+	var changedHeaders []headerField
+	headerChanges := make(map[headerField]int)
+	for _, msg := range b.queue {
+		switch wire.ModifyActCode(msg.Code) {
+		case wire.ActChangeFrom:
+			changeFrom++
+		case wire.ActQuarantine:
+			quarantine++
+		case wire.ActChangeHeader:
+			act, err := parseModifyAct(msg)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			field := headerField{name: textproto.CanonicalMIMEHeaderKey(act.HeaderName), index: act.HeaderIndex}
+			if headerChanges[field] == 0 {
+				changedHeaders = append(changedHeaders, field)
+			}
+			headerChanges[field]++
+		}
+	}
+	if changeFrom > 1 {
+		errs = append(errs, fmt.Errorf("%d ChangeFrom calls, the MTA would only use the last one", changeFrom))
+	}
+	if quarantine > 1 {
+		errs = append(errs, fmt.Errorf("%d Quarantine calls, the MTA would only use the last one", quarantine))
+	}
+	for _, field := range changedHeaders {
+		if n := headerChanges[field]; n > 1 {
+			errs = append(errs, fmt.Errorf("%d ChangeHeader calls for %s header %d, the MTA would only use the last one", n, field.name, field.index))
+		}
+	}
+	return errs
+}
+
+// Begin starts a batch of modifications. Until [Modifier.Commit] the modifications of m do not get sent to the MTA
+// but get queued. The modification methods still check whether the modification was negotiated.
+// Calling Begin while a batch is open does nothing.
+// When [Milter.EndOfMessage] returns with an open batch, its modifications get discarded and the [Server] logs a warning.
+//
+// Use a batch when the order of your modifications is not under your control (e.g. several independent checks modify the message),
+// since MTAs do not like all orders: e.g. Postfix does not allow other modifications between the chunks of [Modifier.ReplaceBody].
+func (m *Modifier) Begin() {
+	if m.batch != nil {
+		return
+	}
+	m.batch = &modificationBatch{writePacket: m.writePacket}
+	m.writePacket = m.batch.add
+}
+
+// Commit ends the batch of [Modifier.Begin] and sends its modifications to the MTA in a valid order:
+// [Modifier.ChangeFrom], [Modifier.DeleteRecipient], [Modifier.AddRecipient], [Modifier.ChangeHeader] and [Modifier.InsertHeader],
+// [Modifier.AddHeader], [Modifier.ReplaceBody] and finally [Modifier.Quarantine].
+// Modifications of the same kind keep their order, so body chunks stay contiguous and header indexes keep their meaning.
+//
+// When the batch is ambiguous Commit sends nothing and returns a [*BatchError] with all problems.
+// A batch is ambiguous when it calls [Modifier.ChangeFrom] or [Modifier.Quarantine] more than once
+// or calls [Modifier.ChangeHeader] more than once for the same header field. Commit without Begin does nothing.
+func (m *Modifier) Commit() error {
+	b := m.batch
+	if b == nil {
+		return nil
+	}
+	m.batch = nil
+	m.writePacket = b.writePacket
+	if errs := b.validate(); len(errs) > 0 {
+		return &BatchError{Errors: errs}
+	}
+	sort.SliceStable(b.queue, func(i, j int) bool {
+		return modificationRank(b.queue[i]) < modificationRank(b.queue[j])
+	})
+	for _, msg := range b.queue {
+		if err := m.writePacket(msg); err != nil {
+			return &BatchError{Errors: []error{err}}
+		}
+	}
+	return nil
+}
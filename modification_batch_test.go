@@ -0,0 +1,114 @@
+package milter
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+func TestModifier_Batch(t *testing.T) {
+	var codes []wire.ModifyActCode
+	writeErr := errors.New("write error")
+	var failWrite bool
+	m := NewTestModifier(nil, func(msg *wire.Message) error {
+		if failWrite {
+			return writeErr
+		}
+		codes = append(codes, wire.ModifyActCode(msg.Code))
+		return nil
+	}, nil, AllClientSupportedActionMasks, DataSize64K)
+
+	if err := m.Commit(); err != nil {
+		t.Fatalf("Commit() without Begin() = %v", err)
+	}
+	m.Begin()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(m.ReplaceBodyRawChunk([]byte("body 1")))
+	must(m.Quarantine("test"))
+	must(m.AddHeader("X-Test", "1"))
+	must(m.ReplaceBodyRawChunk([]byte("body 2")))
+	must(m.ChangeHeader(1, "Subject", "changed"))
+	must(m.AddRecipient("rcpt@example.com", ""))
+	must(m.DeleteRecipient("old@example.com"))
+	must(m.ChangeFrom("from@example.com", ""))
+	m.Begin()
+	if len(codes) != 0 {
+		t.Fatalf("Begin() did not queue the modifications: %v", codes)
+	}
+	must(m.Commit())
+	want := []wire.ModifyActCode{wire.ActChangeFrom, wire.ActDelRcpt, wire.ActAddRcpt, wire.ActChangeHeader, wire.ActAddHeader, wire.ActReplBody, wire.ActReplBody, wire.ActQuarantine}
+	if !reflect.DeepEqual(codes, want) {
+		t.Fatalf("Commit() sent %q, want %q", codes, want)
+	}
+
+	// invalid batch
+	codes = nil
+	m.Begin()
+	must(m.ChangeFrom("a@example.com", ""))
+	must(m.AddHeader("X-Test", "1"))
+	must(m.ChangeFrom("b@example.com", ""))
+	err := m.Commit()
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != 1 || !strings.Contains(err.Error(), "ChangeFrom") {
+		t.Fatalf("Commit() = %v", err)
+	}
+	if len(codes) != 0 {
+		t.Fatalf("invalid batch sent %q", codes)
+	}
+
+	// ambiguous header changes and quarantines
+	m.Begin()
+	must(m.ChangeHeader(1, "Subject", "a"))
+	must(m.ChangeHeader(2, "Subject", "b"))
+	must(m.ChangeHeader(1, "subject", "c"))
+	must(m.Quarantine("a"))
+	must(m.Quarantine("b"))
+	err = m.Commit()
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != 2 || !strings.Contains(err.Error(), "Subject header 1") || !strings.Contains(err.Error(), "Quarantine") {
+		t.Fatalf("Commit() = %v", err)
+	}
+	if len(codes) != 0 {
+		t.Fatalf("invalid batch sent %q", codes)
+	}
+
+	// write errors
+	m.Begin()
+	must(m.AddHeader("X-Test", "1"))
+	failWrite = true
+	if err := m.Commit(); !errors.As(err, &batchErr) || batchErr.Errors[0] != writeErr {
+		t.Fatalf("Commit() = %v", err)
+	}
+	// the batch is closed, modifications get written directly
+	if err := m.AddHeader("X-Test", "2"); err != writeErr {
+		t.Fatalf("AddHeader() after Commit() = %v", err)
+	}
+}
+
+func TestModifier_BatchReplaceBody(t *testing.T) {
+	var body []byte
+	m := NewTestModifier(nil, func(msg *wire.Message) error {
+		body = append(body, msg.Data...)
+		return nil
+	}, nil, AllClientSupportedActionMasks, DataSize64K)
+	// more than one chunk, ReplaceBody re-uses its buffer for every chunk
+	want := append(bytes.Repeat([]byte("a"), int(DataSize64K)), bytes.Repeat([]byte("b"), 100)...)
+	m.Begin()
+	if err := m.ReplaceBody(bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, want) {
+		t.Fatalf("Commit() sent a body of %d bytes that does not match the %d bytes of ReplaceBody()", len(body), len(want))
+	}
+}
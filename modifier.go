@@ -3,14 +3,19 @@ package milter
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/textproto"
+	"unicode/utf8"
 
 	"github.com/d--j/go-milter/internal/wire"
 	"github.com/d--j/go-milter/milterutil"
+	"golang.org/x/text/transform"
 )
 
 type ActionType int
@@ -194,10 +199,13 @@ func parseModifyAct(msg *wire.Message) (*ModifyAction, error) {
 			act.HeaderIndex = 1
 		}
 
-		msg.Data = msg.Data[4:]
 		fallthrough
 	case wire.ActAddHeader:
-		argv := bytes.Split(msg.Data, []byte{0x00})
+		data := msg.Data
+		if wire.ModifyActCode(msg.Code) != wire.ActAddHeader {
+			data = data[4:]
+		}
+		argv := bytes.Split(data, []byte{0x00})
 		if len(argv) != 3 {
 			return nil, fmt.Errorf("read modify action: wrong number of arguments %d for header action: %v", len(argv), argv)
 		}
@@ -218,10 +226,27 @@ func parseModifyAct(msg *wire.Message) (*ModifyAction, error) {
 // Besides [Modifier.Progress] they can only be called in the EndOfMessage callback.
 type Modifier struct {
 	Macros              Macros
+	ctx                 context.Context
+	conn                net.Conn
 	writeProgressPacket func(*wire.Message) error
 	writePacket         func(*wire.Message) error
 	actions             OptAction
 	maxDataSize         DataSize
+	connID, messageSeq  uint64
+	readOnly            bool
+	abortReason         AbortReason
+	// headerFoldLength is the maximum line length of header fields, 0 means no folding (see WithHeaderFolding)
+	headerFoldLength int
+	// session is the server session of the modifier, nil for modifiers created with NewTestModifier
+	session *serverSession
+	// batch holds the queued modifications between Begin and Commit
+	batch *modificationBatch
+	// modBytes counts the modifications that got sent to the MTA
+	modBytes *modificationBytes
+	// deferred are the handles of Defer that get closed when EndOfMessage returns
+	deferred []*DeferredModifier
+	// values are the values of SetMessageValue for modifiers without session
+	values map[interface{}]interface{}
 }
 
 func hasAngle(str string) bool {
@@ -303,14 +328,12 @@ func (m *Modifier) ReplaceBodyRawChunk(chunk []byte) error {
 // ReplaceBody reads from r and send its contents in the least amount of chunks to the MTA.
 //
 // This function does not do any CR LF line ending canonicalization or maximum line length enforcements.
-// If you need that you can use the various transform.Transformers of this package to wrap your reader.
-//
-//	t := transform.Chain(&milter.CrLfCanonicalizationTransformer{}, &milter.MaximumLineLengthTransformer{})
-//	wrappedR := transform.NewReader(r, t)
-//	m.ReplaceBody(wrappedR)
+// If you need that use [Modifier.ReplaceBodyWithOptions].
 //
 // This function tries to use as few calls to [Modifier.ReplaceBodyRawChunk] as possible.
 //
+// When r is empty the body of the message gets replaced with an empty body.
+//
 // You can call ReplaceBody multiple times. The MTA will combine all those calls into one message.
 //
 // You should do the ReplaceBody calls all in one go without intersecting it with other modification actions.
@@ -318,13 +341,52 @@ func (m *Modifier) ReplaceBodyRawChunk(chunk []byte) error {
 func (m *Modifier) ReplaceBody(r io.Reader) error {
 	scanner := milterutil.GetFixedBufferScanner(uint32(m.maxDataSize), r)
 	defer scanner.Close()
+	sent := false
 	for scanner.Scan() {
 		err := m.ReplaceBodyRawChunk(scanner.Bytes())
 		if err != nil {
 			return err
 		}
+		sent = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !sent {
+		// an empty r replaces the body with an empty body, the MTA needs one (empty) chunk for that
+		return m.ReplaceBodyRawChunk(nil)
 	}
-	return scanner.Err()
+	return nil
+}
+
+// ReplaceBodyOptions define how [Modifier.ReplaceBodyWithOptions] transforms the replacement body.
+type ReplaceBodyOptions struct {
+	// CanonicalizeLineEndings converts LF and bare CR line endings to CR LF line endings.
+	CanonicalizeLineEndings bool
+	// MaximumLineLength splits lines that are longer than MaximumLineLength bytes (not counting the line ending).
+	// 0 does not split lines. [milterutil.DefaultMaximumLineLength] is a safe value.
+	MaximumLineLength uint
+}
+
+// ReplaceBodyWithOptions is like [Modifier.ReplaceBody] but transforms the contents of r according to opts before sending them.
+// Use it when r does not already have CR LF line endings and lines of a safe length.
+//
+// You do not need to dot-stuff the body: the MTA takes care of SMTP transparency when it sends the message.
+func (m *Modifier) ReplaceBodyWithOptions(r io.Reader, opts ReplaceBodyOptions) error {
+	var transformers []transform.Transformer
+	if opts.MaximumLineLength > 0 {
+		if opts.MaximumLineLength < utf8.UTFMax {
+			return fmt.Errorf("milter: maximum line length %d is too small", opts.MaximumLineLength)
+		}
+		transformers = append(transformers, &milterutil.MaximumLineLengthTransformer{MaximumLength: opts.MaximumLineLength})
+	}
+	if opts.CanonicalizeLineEndings {
+		transformers = append(transformers, &milterutil.CrLfCanonicalizationTransformer{})
+	}
+	if len(transformers) > 0 {
+		r = transform.NewReader(r, transform.Chain(transformers...))
+	}
+	return m.ReplaceBody(r)
 }
 
 // Quarantine a message by giving a reason to hold it
@@ -351,7 +413,7 @@ func (m *Modifier) AddHeader(name, value string) error {
 	var buffer bytes.Buffer
 	buffer.WriteString(name)
 	buffer.WriteByte(0)
-	buffer.WriteString(milterutil.CrLfToLf(value))
+	buffer.WriteString(m.headerValue(name, value))
 	buffer.WriteByte(0)
 	return m.writePacket(newResponse(wire.Code(wire.ActAddHeader), buffer.Bytes()).Response())
 }
@@ -360,6 +422,7 @@ func (m *Modifier) AddHeader(name, value string) error {
 // The index is per canonical name and one-based. To delete a header pass an empty value.
 // If the index is bigger than there are headers with that name, then ChangeHeader will actually
 // add a new header at the end of the header list (With the same semantic as AddHeader).
+// Deleting a header may change the indexes of the following headers, see [MTAInfo.DeleteShiftsHeaderIndexes].
 func (m *Modifier) ChangeHeader(index int, name, value string) error {
 	if m.actions&OptChangeHeader == 0 {
 		return ErrModificationNotAllowed
@@ -370,7 +433,7 @@ func (m *Modifier) ChangeHeader(index int, name, value string) error {
 	}
 	buffer.WriteString(name)
 	buffer.WriteByte(0)
-	buffer.WriteString(milterutil.CrLfToLf(value))
+	buffer.WriteString(m.headerValue(name, value))
 	buffer.WriteByte(0)
 	return m.writePacket(newResponse(wire.Code(wire.ActChangeHeader), buffer.Bytes()).Response())
 }
@@ -392,11 +455,16 @@ func (m *Modifier) InsertHeader(index int, name, value string) error {
 	}
 	buffer.WriteString(name)
 	buffer.WriteByte(0)
-	buffer.WriteString(milterutil.CrLfToLf(value))
+	buffer.WriteString(m.headerValue(name, value))
 	buffer.WriteByte(0)
 	return m.writePacket(newResponse(wire.Code(wire.ActInsertHeader), buffer.Bytes()).Response())
 }
 
+// headerValue returns value how the header modifications of m send it to the MTA.
+func (m *Modifier) headerValue(name, value string) string {
+	return milterutil.FoldHeaderValue(name, milterutil.CrLfToLf(value), m.headerFoldLength)
+}
+
 // ChangeFrom replaces the FROM envelope header with value.
 // You can also define ESMTP arguments. But beware of the following Sendmail comment:
 //
@@ -431,26 +499,110 @@ func errorWriteReadOnly(m *wire.Message) error {
 	return fmt.Errorf("tried to send action %c in read-only state", m.Code)
 }
 
+// Context returns the context of the MTA connection.
+// It gets cancelled when the connection to the MTA ends, a write to the MTA fails, or [Server.Shutdown] gets called.
+// Long-running callbacks (e.g. virus scans in [Milter.EndOfMessage]) should stop when it is done.
+func (m *Modifier) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// ConnInfo describes the connection between the MTA and the milter (not the SMTP connection of the MTA).
+type ConnInfo struct {
+	// RemoteAddr is the address of the MTA.
+	RemoteAddr net.Addr
+	// LocalAddr is the address of the listener that accepted the connection of the MTA.
+	LocalAddr net.Addr
+	// TLS is the state of the TLS connection when the MTA connected via TLS (see [WithTLSConfig]), nil otherwise.
+	TLS *tls.ConnectionState
+}
+
+// ConnInfo returns information about the connection of the MTA to the milter.
+// Use it to distinguish multiple MTAs that use the same milter.
+// The returned [ConnInfo] is empty for modifiers created with [NewTestModifier].
+func (m *Modifier) ConnInfo() ConnInfo {
+	if m.conn == nil {
+		return ConnInfo{}
+	}
+	info := ConnInfo{RemoteAddr: m.conn.RemoteAddr(), LocalAddr: m.conn.LocalAddr()}
+	if tlsConn, ok := m.conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		info.TLS = &state
+	}
+	return info
+}
+
+// ModificationBytes returns the number of bytes of the header modifications ([Modifier.AddHeader], [Modifier.ChangeHeader]
+// and [Modifier.InsertHeader]) and of the body replacement that got sent to the MTA for the current message so far.
+// These are the same bytes that [SessionInfo.ModificationBytes] and [ServerStats] count.
+func (m *Modifier) ModificationBytes() (header, body int) {
+	if m.modBytes == nil {
+		return 0, 0
+	}
+	return m.modBytes.get()
+}
+
+// ConnID returns the ID of the MTA connection. The [Server] numbers its connections starting with 1.
+// Use it to correlate the log lines of all callbacks of a connection, the MTA might only send the queue ID
+// ([MacroQueueId]) late in the SMTP transaction or not at all.
+// ConnID returns 0 for modifiers created with [NewTestModifier].
+func (m *Modifier) ConnID() uint64 {
+	return m.connID
+}
+
+// MessageSeq returns the sequence number of the current message of the MTA connection.
+// The first MAIL FROM of a connection starts message 1, every further MAIL FROM increments the number.
+// MessageSeq returns 0 before the first MAIL FROM and for modifiers created with [NewTestModifier].
+// ConnID and MessageSeq together identify a message of a [Server].
+func (m *Modifier) MessageSeq() uint64 {
+	return m.messageSeq
+}
+
+// Truncated returns true when the [Server] did not pass the whole message to the [Milter] because the message
+// exceeded a limit of [WithMaxHeaderCount], [WithMaxHeaderBytes] or [WithMaxBodyBytes] and the [LimitPolicy] is [LimitTruncate].
+// Truncated returns false for modifiers created with [NewTestModifier].
+func (m *Modifier) Truncated() bool {
+	return m.session != nil && (m.session.headerTruncated || m.session.bodyTruncated)
+}
+
 // newModifier creates a new [Modifier] instance from s. If it is readOnly then all modification actions will throw an error.
 func newModifier(s *serverSession, readOnly bool) *Modifier {
-	writePacket := s.writePacket
+	writePacket := s.writeModification
 	if readOnly {
 		writePacket = errorWriteReadOnly
 	}
 	return &Modifier{
 		Macros:              &macroReader{macrosStages: s.macros},
+		ctx:                 s.ctx,
+		conn:                s.conn,
 		writePacket:         writePacket,
 		writeProgressPacket: s.writePacket,
 		actions:             s.actions,
 		maxDataSize:         s.maxDataSize,
+		connID:              s.id,
+		messageSeq:          s.messageSeq,
+		readOnly:            readOnly,
+		headerFoldLength:    s.server.options.headerFoldLength,
+		session:             s,
+		modBytes:            &s.modBytes,
 	}
 }
 
 // NewTestModifier is only exported for unit-tests. It can only be use internally since it uses the internal package [wire].
 func NewTestModifier(macros Macros, writePacket, writeProgress func(msg *wire.Message) error, actions OptAction, maxDataSize DataSize) *Modifier {
+	modBytes := &modificationBytes{}
 	return &Modifier{
-		Macros:              macros,
-		writePacket:         writePacket,
+		Macros: macros,
+		writePacket: func(msg *wire.Message) error {
+			if err := writePacket(msg); err != nil {
+				return err
+			}
+			modBytes.add(msg)
+			return nil
+		},
+		modBytes:            modBytes,
 		writeProgressPacket: writeProgress,
 		actions:             actions,
 		maxDataSize:         maxDataSize,
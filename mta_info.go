@@ -0,0 +1,91 @@
+package milter
+
+import (
+	"strings"
+)
+
+// MTAFlavor is the implementation of the MTA.
+type MTAFlavor int
+
+const (
+	// MTAUnknown is an MTA that did not identify itself or that this package does not know.
+	MTAUnknown MTAFlavor = iota
+	// MTASendmail is the sendmail MTA.
+	MTASendmail
+	// MTAPostfix is the Postfix MTA.
+	MTAPostfix
+)
+
+func (f MTAFlavor) String() string {
+	switch f {
+	case MTASendmail:
+		return "sendmail"
+	case MTAPostfix:
+		return "Postfix"
+	default:
+		return "unknown"
+	}
+}
+
+// MTAInfo describes the MTA that the [Milter] talks to. Use [Modifier.MTAInfo] to get it.
+type MTAInfo struct {
+	// Flavor is the implementation of the MTA.
+	Flavor MTAFlavor
+	// Version is the version of the MTA (e.g. "3.7.2" for Postfix 3.7.2) or the raw value of the
+	// [MacroMTAVersion] macro when Flavor is [MTAUnknown].
+	Version string
+	// ProtocolVersion is the milter protocol version that the MTA offered in the negotiation.
+	ProtocolVersion uint32
+	// Actions are the actions that the MTA offered in the negotiation.
+	Actions OptAction
+	// Protocol are the protocol options that the MTA offered in the negotiation.
+	Protocol OptProtocol
+}
+
+// DeleteShiftsHeaderIndexes returns true when deleting a header field with [Modifier.ChangeHeader] changes the
+// indexes of the following header fields of the same name. Postfix removes deleted header fields,
+// sendmail only marks them as deleted.
+func (i MTAInfo) DeleteShiftsHeaderIndexes() bool {
+	return i.Flavor == MTAPostfix
+}
+
+// ParseMTAVersion returns the MTA flavor and version of a [MacroMTAVersion] macro value.
+// Postfix sends its name and its version (e.g. "Postfix 3.7.2"), sendmail only its version (e.g. "8.17.1").
+// For other values it returns [MTAUnknown] and the trimmed value.
+func ParseMTAVersion(value string) (MTAFlavor, string) {
+	value = strings.TrimSpace(value)
+	fields := strings.Fields(value)
+	switch {
+	case len(fields) == 0:
+		return MTAUnknown, ""
+	case strings.EqualFold(fields[0], "postfix"):
+		if len(fields) > 1 {
+			return MTAPostfix, fields[1]
+		}
+		return MTAPostfix, ""
+	case len(fields) == 1 && strings.HasPrefix(value, "8.") && strings.Trim(value, "0123456789./") == "":
+		// sendmail 8, sometimes with the version of its configuration, e.g. 8.17.1/8.17.1
+		version, _, _ := strings.Cut(value, "/")
+		return MTASendmail, version
+	}
+	return MTAUnknown, value
+}
+
+// MTAInfo returns information about the MTA.
+// The flavor and version come from the [MacroMTAVersion] macro, so request it for [StageConnect] (see [WithMacroRequest])
+// if your MTA does not send it by default.
+// The negotiation fields are empty for modifiers created with [NewTestModifier].
+func (m *Modifier) MTAInfo() MTAInfo {
+	var info MTAInfo
+	if m.session != nil {
+		info = m.session.mtaOffer
+	}
+	if m.Macros != nil {
+		value, ok := m.Macros.GetEx(MacroMTAVersion)
+		if !ok {
+			value = m.Macros.Get("{" + MacroMTAVersion + "}")
+		}
+		info.Flavor, info.Version = ParseMTAVersion(value)
+	}
+	return info
+}
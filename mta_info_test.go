@@ -0,0 +1,72 @@
+package milter
+
+import (
+	"testing"
+)
+
+func TestParseMTAVersion(t *testing.T) {
+	tests := []struct {
+		value   string
+		flavor  MTAFlavor
+		version string
+	}{
+		{"", MTAUnknown, ""},
+		{"Postfix 3.7.2", MTAPostfix, "3.7.2"},
+		{"postfix", MTAPostfix, ""},
+		{"8.17.1", MTASendmail, "8.17.1"},
+		{" 8.15.2/8.15.2 ", MTASendmail, "8.15.2"},
+		{"Exim 4.96", MTAUnknown, "Exim 4.96"},
+		{"9.0", MTAUnknown, "9.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			flavor, version := ParseMTAVersion(tt.value)
+			if flavor != tt.flavor || version != tt.version {
+				t.Errorf("ParseMTAVersion() = %v, %q, want %v, %q", flavor, version, tt.flavor, tt.version)
+			}
+		})
+	}
+}
+
+func TestModifier_MTAInfo(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		macro  MacroName
+		value  string
+		flavor MTAFlavor
+	}{
+		{"postfix", MacroMTAVersion, "Postfix 3.7.2", MTAPostfix},
+		{"braces", "{v}", "8.17.1", MTASendmail},
+		{"none", MacroMTAFQDN, "mx.example.org", MTAUnknown},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var info MTAInfo
+			macros := NewMacroBag()
+			macros.Set(tt.macro, tt.value)
+			w := newServerClient(t, macros, []Option{WithMilter(func() Milter {
+				return &MockMilter{ConnResp: RespContinue, ConnMod: func(m *Modifier) {
+					info = m.MTAInfo()
+				}}
+			}), WithMacroRequest(StageConnect, []MacroName{MacroMTAVersion, "{v}"})}, []Option{WithProtocols(OptNoHelo)})
+			defer w.Cleanup()
+			act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			if info.Flavor != tt.flavor {
+				t.Errorf("MTAInfo().Flavor = %v, want %v", info.Flavor, tt.flavor)
+			}
+			if info.ProtocolVersion != MaxServerProtocolVersion || info.Protocol != OptNoHelo {
+				t.Errorf("MTAInfo() = %+v does not have the offered negotiation values", info)
+			}
+			if info.DeleteShiftsHeaderIndexes() != (tt.flavor == MTAPostfix) {
+				t.Errorf("DeleteShiftsHeaderIndexes() = %v", info.DeleteShiftsHeaderIndexes())
+			}
+		})
+	}
+	if info := NewTestModifier(nil, nil, nil, 0, 0).MTAInfo(); info != (MTAInfo{}) {
+		t.Errorf("MTAInfo() of test modifier = %+v", info)
+	}
+}
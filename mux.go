@@ -0,0 +1,215 @@
+package milter
+
+import (
+	"net"
+	"net/netip"
+)
+
+// MuxMatcher decides whether a connection of the MTA belongs to a [MuxRoute].
+// info describes the connection of the MTA to the milter, macros are the macros the MTA has sent so far.
+type MuxMatcher func(info ConnInfo, macros Macros) bool
+
+// MuxRoute is a route of [WithMux].
+type MuxRoute struct {
+	// Match decides whether a connection uses this route. A nil Match matches all connections.
+	Match MuxMatcher
+	// NewMilter creates the [Milter] for a connection of this route.
+	NewMilter func() Milter
+}
+
+// WithMux sets [Milter] backends of this [Server] that get selected per MTA connection.
+// This way one process can host several logically distinct milters, e.g. on different listeners (see [Server.ServeListeners])
+// or for different MTAs.
+//
+// The [Server] uses the first route whose Match function returns true. It decides with the first callback of the connection
+// (normally [Milter.Connect]), so the macros that the MTA sends with the connect event (e.g. [MacroDaemonName]) are available.
+// When no route matches, the connection gets handled by a [NoOpMilter]. Add a route without Match as the last route to set a default.
+//
+// All routes share the negotiation of the [Server]: use [WithAction], [WithProtocol] and [WithMacroRequest]
+// with everything that any of the routes needs.
+//
+// The [Milter] of a route can be a [MacroObserver] or a [MessageLifecycleMilter]. Macro packets that arrive before
+// the route got selected get passed to OnMacros right after the selection.
+// The other optional interfaces get hidden: the negotiation happens before the route gets selected,
+// so the [Server] cannot ask a [PartialMilter] which callbacks it implements. And a [ResettableMilter] does not get reset;
+// when the MTA re-uses its connection for another SMTP connection the [Server] calls [Milter.Cleanup]
+// and selects the route of the new SMTP connection.
+//
+// This option replaces a [WithMilter] or [WithDynamicMilter] that was used before it.
+//
+// This is a [Server] only [Option].
+func WithMux(routes ...MuxRoute) Option {
+	withMilter := WithMilter(func() Milter {
+		return &muxMilter{routes: routes}
+	})
+	return func(h *options) {
+		for _, route := range routes {
+			if route.NewMilter == nil {
+				panic("milter: WithMux needs a NewMilter function for every route")
+			}
+		}
+		withMilter(h)
+	}
+}
+
+// MatchListener matches connections that got accepted by the listener with the address addr (e.g. "127.0.0.1:10025" or "/run/milter.sock").
+func MatchListener(addr string) MuxMatcher {
+	return func(info ConnInfo, _ Macros) bool {
+		return info.LocalAddr != nil && info.LocalAddr.String() == addr
+	}
+}
+
+// MatchRemoteNetworks matches connections of MTAs whose IP address is in one of networks.
+func MatchRemoteNetworks(networks ...netip.Prefix) MuxMatcher {
+	return func(info ConnInfo, _ Macros) bool {
+		tcpAddr, ok := info.RemoteAddr.(*net.TCPAddr)
+		if !ok {
+			return false
+		}
+		ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+		if !ok {
+			return false
+		}
+		ip = ip.Unmap()
+		for _, network := range networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchMacro matches connections where the macro name has one of values, e.g. MatchMacro([MacroDaemonName], "submission").
+// You need to make sure that the MTA sends the macro before or with the first callback of the connection.
+func MatchMacro(name MacroName, values ...string) MuxMatcher {
+	return func(_ ConnInfo, macros Macros) bool {
+		if macros == nil {
+			return false
+		}
+		value := macros.Get(name)
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// muxMilter is the [Milter] of [WithMux]. It forwards all callbacks to the [Milter] of the matching route.
+type muxMilter struct {
+	routes  []MuxRoute
+	backend Milter
+	// macros are the macro packets that arrived before the backend got selected
+	macros []observedMacros
+}
+
+type observedMacros struct {
+	stage  MacroStage
+	macros map[MacroName]string
+}
+
+var _ MacroObserver = (*muxMilter)(nil)
+var _ MessageLifecycleMilter = (*muxMilter)(nil)
+
+// milter returns the backend of x and selects it with the information of m when there is none yet.
+func (x *muxMilter) milter(m *Modifier) Milter {
+	if x.backend != nil {
+		return x.backend
+	}
+	x.backend = x.selectRoute(m)
+	if observer, ok := x.backend.(MacroObserver); ok {
+		for _, observed := range x.macros {
+			observer.OnMacros(observed.stage, observed.macros)
+		}
+	}
+	x.macros = nil
+	return x.backend
+}
+
+// selectRoute creates the [Milter] of the first route that matches.
+func (x *muxMilter) selectRoute(m *Modifier) Milter {
+	info := m.ConnInfo()
+	for _, route := range x.routes {
+		if route.Match == nil || route.Match(info, m.Macros) {
+			return route.NewMilter()
+		}
+	}
+	return NoOpMilter{}
+}
+
+func (x *muxMilter) OnMacros(stage MacroStage, macros map[MacroName]string) {
+	if x.backend == nil {
+		x.macros = append(x.macros, observedMacros{stage: stage, macros: macros})
+		return
+	}
+	if observer, ok := x.backend.(MacroObserver); ok {
+		observer.OnMacros(stage, macros)
+	}
+}
+
+func (x *muxMilter) NewMessage(m *Modifier) error {
+	if lifecycle, ok := x.milter(m).(MessageLifecycleMilter); ok {
+		return lifecycle.NewMessage(m)
+	}
+	return nil
+}
+
+func (x *muxMilter) EndMessageCleanup() {
+	if lifecycle, ok := x.backend.(MessageLifecycleMilter); ok {
+		lifecycle.EndMessageCleanup()
+	}
+}
+
+func (x *muxMilter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	return x.milter(m).Connect(host, family, port, addr, m)
+}
+
+func (x *muxMilter) Helo(name string, m *Modifier) (*Response, error) {
+	return x.milter(m).Helo(name, m)
+}
+
+func (x *muxMilter) MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error) {
+	return x.milter(m).MailFrom(from, esmtpArgs, m)
+}
+
+func (x *muxMilter) RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error) {
+	return x.milter(m).RcptTo(rcptTo, esmtpArgs, m)
+}
+
+func (x *muxMilter) Data(m *Modifier) (*Response, error) {
+	return x.milter(m).Data(m)
+}
+
+func (x *muxMilter) Header(name string, value string, m *Modifier) (*Response, error) {
+	return x.milter(m).Header(name, value, m)
+}
+
+func (x *muxMilter) Headers(m *Modifier) (*Response, error) {
+	return x.milter(m).Headers(m)
+}
+
+func (x *muxMilter) BodyChunk(chunk []byte, m *Modifier) (*Response, error) {
+	return x.milter(m).BodyChunk(chunk, m)
+}
+
+func (x *muxMilter) EndOfMessage(m *Modifier) (*Response, error) {
+	return x.milter(m).EndOfMessage(m)
+}
+
+func (x *muxMilter) Abort(m *Modifier) error {
+	return x.milter(m).Abort(m)
+}
+
+func (x *muxMilter) Unknown(cmd string, m *Modifier) (*Response, error) {
+	return x.milter(m).Unknown(cmd, m)
+}
+
+func (x *muxMilter) Cleanup() {
+	if x.backend != nil {
+		x.backend.Cleanup()
+		x.backend = nil
+	}
+	x.macros = nil
+}
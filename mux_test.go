@@ -0,0 +1,140 @@
+package milter
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServer_WithMux(t *testing.T) {
+	t.Parallel()
+	routes := []MuxRoute{
+		{Match: MatchMacro(MacroDaemonName, "submission"), NewMilter: func() Milter {
+			return &MockMilter{ConnResp: RespReject}
+		}},
+		{NewMilter: func() Milter {
+			return &MockMilter{ConnResp: RespContinue}
+		}},
+	}
+	tests := []struct {
+		daemon string
+		want   ActionType
+	}{
+		{"submission", ActionReject},
+		{"smtpd", ActionContinue},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.daemon, func(t *testing.T) {
+			t.Parallel()
+			macros := NewMacroBag()
+			macros.Set(MacroDaemonName, tt.daemon)
+			w := newServerClient(t, macros, []Option{WithMux(routes...)}, nil)
+			defer w.Cleanup()
+			act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+			assertAction(t, act, err, tt.want)
+		})
+	}
+}
+
+func TestServer_WithMuxNoMatch(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMux(MuxRoute{Match: MatchMacro(MacroDaemonName, "submission"), NewMilter: func() Milter {
+		return &MockMilter{ConnResp: RespReject}
+	}})}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+}
+
+func TestWithMux_Panics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewServer did not panic")
+		}
+	}()
+	NewServer(WithMux(MuxRoute{}))
+}
+
+func TestMuxMatchers(t *testing.T) {
+	info := ConnInfo{
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000},
+		LocalAddr:  &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 10025},
+	}
+	macros := NewMacroBag()
+	macros.Set(MacroDaemonName, "smtpd")
+	tests := []struct {
+		name  string
+		match MuxMatcher
+		info  ConnInfo
+		want  bool
+	}{
+		{"listener", MatchListener("127.0.0.1:10025"), info, true},
+		{"other listener", MatchListener("127.0.0.1:10026"), info, false},
+		{"no listener", MatchListener("127.0.0.1:10025"), ConnInfo{}, false},
+		{"network", MatchRemoteNetworks(netip.MustParsePrefix("198.51.100.0/24"), netip.MustParsePrefix("192.0.2.0/24")), info, true},
+		{"other network", MatchRemoteNetworks(netip.MustParsePrefix("198.51.100.0/24")), info, false},
+		{"unix socket", MatchRemoteNetworks(netip.MustParsePrefix("0.0.0.0/0")), ConnInfo{RemoteAddr: &net.UnixAddr{Name: "@", Net: "unix"}}, false},
+		{"macro", MatchMacro(MacroDaemonName, "submission", "smtpd"), info, true},
+		{"other macro", MatchMacro(MacroDaemonName, "submission"), info, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.match(tt.info, macros); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if MatchMacro(MacroDaemonName, "smtpd")(info, nil) {
+		t.Error("MatchMacro() matched without macros")
+	}
+}
+
+// observingLifecycleMilter is a [MacroObserver] and a [MessageLifecycleMilter].
+type observingLifecycleMilter struct {
+	*lifecycleMilter
+}
+
+func (o *observingLifecycleMilter) OnMacros(stage MacroStage, macros map[MacroName]string) {
+	if daemon, ok := macros[MacroDaemonName]; ok {
+		o.record(fmt.Sprintf("macros %d %s", stage, daemon))
+	}
+}
+
+func TestServer_WithMuxOptionalInterfaces(t *testing.T) {
+	t.Parallel()
+	log := &lifecycleEvents{}
+	route := MuxRoute{NewMilter: func() Milter {
+		return &observingLifecycleMilter{&lifecycleMilter{MockMilter: &MockMilter{
+			ConnResp: RespContinue,
+			HeloResp: RespContinue,
+			MailResp: RespContinue,
+		}, log: log}}
+	}}
+	macros := NewMacroBag()
+	macros.Set(MacroDaemonName, "smtpd")
+	w := newServerClient(t, macros, []Option{WithMux(route), WithMacroRequest(StageConnect, []MacroName{MacroDaemonName}), WithMacroRequest(StageMail, []MacroName{MacroQueueId})}, nil)
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	macros.Set(MacroQueueId, "1")
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Cleanup()
+	want := []string{fmt.Sprintf("macros %d smtpd", StageConnect), "new 1", "abort", "end", "cleanup"}
+	deadline := time.Now().Add(time.Second)
+	for len(log.get()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// the Server might call Cleanup twice on QUIT, only look at the first one
+	if got := log.get(); len(got) < len(want) || !reflect.DeepEqual(got[:len(want)], want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
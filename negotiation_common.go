@@ -0,0 +1,106 @@
+package milter
+
+import "fmt"
+
+// NegotiationReport describes what [NegotiateCommon] had to drop to agree with the MTA.
+type NegotiationReport struct {
+	// MTAVersion and MilterVersion are the protocol versions that the MTA and the milter offered,
+	// Version is the negotiated protocol version.
+	MTAVersion, MilterVersion, Version uint32
+	// DroppedActions are the actions that the milter wanted but the MTA (or the negotiated version) does not support.
+	DroppedActions OptAction
+	// DroppedProtocol are the protocol options that the milter wanted but the MTA (or the negotiated version) does not support.
+	DroppedProtocol OptProtocol
+}
+
+// Downgraded returns true when the negotiation result differs from what the milter wanted.
+func (r NegotiationReport) Downgraded() bool {
+	return r.Version < r.MilterVersion || r.DroppedActions != 0 || r.DroppedProtocol != 0
+}
+
+func (r NegotiationReport) String() string {
+	return fmt.Sprintf("version %d (MTA %d, milter %d), dropped actions %032b, dropped protocol options %032b", r.Version, r.MTAVersion, r.MilterVersion, r.DroppedActions, r.DroppedProtocol)
+}
+
+// NegotiationReportFunc is the signature of a [WithCommonNegotiation] callback function.
+type NegotiationReportFunc func(report NegotiationReport)
+
+// protocolMaskForVersion returns the protocol options that the protocol version supports.
+func protocolMaskForVersion(version uint32) OptProtocol {
+	switch {
+	case version <= 2:
+		return allClientSupportedProtocolMasksV2
+	case version == 3:
+		return allClientSupportedProtocolMasksV3
+	case version < 6:
+		return allClientSupportedProtocolMasksV4
+	default:
+		return allClientSupportedProtocolMasks
+	}
+}
+
+// actionMaskForVersion returns the actions that the protocol version supports.
+func actionMaskForVersion(version uint32) OptAction {
+	if version < 6 {
+		return allClientSupportedActionMasksV2
+	}
+	return AllClientSupportedActionMasks
+}
+
+// NegotiateCommon negotiates the least common denominator of the capabilities of the MTA and the milter.
+// Its arguments and results are the same as the ones of a [NegotiationCallbackFunc],
+// so you can call it in your own negotiation callback and adjust its result.
+//
+// Instead of failing when the MTA does not offer everything the milter wants, NegotiateCommon
+// uses the lower protocol version of both and drops the actions and protocol options that the MTA does not offer
+// or the negotiated version does not support. report says what got dropped.
+// It only fails when the MTA uses a protocol version that this package does not support.
+//
+// Be aware that your [Milter] needs to cope with the reduced features:
+// modifications with a dropped action fail and the MTA sends events that a dropped OptNo* option would have suppressed.
+func NegotiateCommon(mtaVersion, milterVersion uint32, mtaActions, milterActions OptAction, mtaProtocol, milterProtocol OptProtocol, offeredDataSize DataSize) (version uint32, actions OptAction, protocol OptProtocol, maxDataSize DataSize, report NegotiationReport, err error) {
+	if mtaVersion < 2 || mtaVersion > MaxServerProtocolVersion {
+		return 0, 0, 0, 0, report, fmt.Errorf("milter: negotiate: unsupported protocol version: %d", mtaVersion)
+	}
+	version = milterVersion
+	if mtaVersion < version {
+		version = mtaVersion
+	}
+	actions = milterActions & mtaActions & actionMaskForVersion(version)
+	protocol = milterProtocol & mtaProtocol & protocolMaskForVersion(version)
+	report = NegotiationReport{
+		MTAVersion:      mtaVersion,
+		MilterVersion:   milterVersion,
+		Version:         version,
+		DroppedActions:  milterActions &^ actions,
+		DroppedProtocol: milterProtocol &^ protocol,
+	}
+	return version, actions, protocol, offeredDataSize, report, nil
+}
+
+// WithCommonNegotiation makes the [Server] negotiate the least common denominator with the MTA (see [NegotiateCommon])
+// instead of closing the connection when the MTA does not offer all actions and protocol options that the milter wants.
+// Use it when you prefer to work with reduced features over failing closed.
+//
+// When the negotiation had to drop something, report gets called with the [NegotiationReport].
+// If report is nil, a warning gets logged (see [WithLogger]).
+//
+// This option replaces a [WithNegotiationCallback] that was used before it.
+//
+// This is a [Server] only [Option].
+func WithCommonNegotiation(report NegotiationReportFunc) Option {
+	return func(h *options) {
+		h.negotiationCallback = func(mtaVersion, milterVersion uint32, mtaActions, milterActions OptAction, mtaProtocol, milterProtocol OptProtocol, offeredDataSize DataSize) (uint32, OptAction, OptProtocol, DataSize, error) {
+			version, actions, protocol, maxDataSize, r, err := NegotiateCommon(mtaVersion, milterVersion, mtaActions, milterActions, mtaProtocol, milterProtocol, offeredDataSize)
+			if err == nil && r.Downgraded() {
+				if report != nil {
+					report(r)
+				} else {
+					// h is the options of NewServer, its logger is set when the server negotiates
+					warn(h.logger, "negotiate: working with reduced features: %s", r)
+				}
+			}
+			return version, actions, protocol, maxDataSize, err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package milter
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestNegotiateCommon(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name                        string
+		mtaVersion, milterVersion   uint32
+		mtaActions, milterActions   OptAction
+		mtaProtocol, milterProtocol OptProtocol
+		version                     uint32
+		actions                     OptAction
+		protocol                    OptProtocol
+		report                      NegotiationReport
+		wantErr                     bool
+	}{
+		{"all offered", 6, 6, AllClientSupportedActionMasks, OptAddHeader, allClientSupportedProtocolMasks, OptNoConnect | OptSkip,
+			6, OptAddHeader, OptNoConnect | OptSkip, NegotiationReport{6, 6, 6, 0, 0}, false},
+		{"missing action", 6, 6, OptAddHeader, OptAddHeader | OptChangeBody, allClientSupportedProtocolMasks, OptNoConnect,
+			6, OptAddHeader, OptNoConnect, NegotiationReport{6, 6, 6, OptChangeBody, 0}, false},
+		{"missing protocol", 6, 6, AllClientSupportedActionMasks, OptAddHeader, OptNoConnect, OptNoConnect | OptNoBodyReply,
+			6, OptAddHeader, OptNoConnect, NegotiationReport{6, 6, 6, 0, OptNoBodyReply}, false},
+		{"older MTA", 2, 6, AllClientSupportedActionMasks, OptAddHeader | OptChangeFrom, allClientSupportedProtocolMasks, OptNoConnect | OptNoData | OptSkip,
+			2, OptAddHeader, OptNoConnect, NegotiationReport{2, 6, 2, OptChangeFrom, OptNoData | OptSkip}, false},
+		{"older milter", 6, 4, AllClientSupportedActionMasks, OptAddHeader, allClientSupportedProtocolMasks, OptNoData,
+			4, OptAddHeader, OptNoData, NegotiationReport{6, 4, 4, 0, 0}, false},
+		{"unsupported MTA", 1, 6, AllClientSupportedActionMasks, OptAddHeader, allClientSupportedProtocolMasks, 0,
+			0, 0, 0, NegotiationReport{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, actions, protocol, maxDataSize, report, err := NegotiateCommon(tt.mtaVersion, tt.milterVersion, tt.mtaActions, tt.milterActions, tt.mtaProtocol, tt.milterProtocol, DataSize256K)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NegotiateCommon() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if version != tt.version || actions != tt.actions || protocol != tt.protocol || maxDataSize != DataSize256K {
+				t.Errorf("NegotiateCommon() got %d, %032b, %032b, %d", version, actions, protocol, maxDataSize)
+			}
+			if report != tt.report {
+				t.Errorf("NegotiateCommon() got report %+v, want %+v", report, tt.report)
+			}
+			if report.Downgraded() != (tt.report.DroppedActions != 0 || tt.report.DroppedProtocol != 0 || tt.version < tt.milterVersion) {
+				t.Errorf("Downgraded() = %v", report.Downgraded())
+			}
+		})
+	}
+}
+
+func TestServer_WithCommonNegotiation(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var reports []NegotiationReport
+	var negotiated OptAction
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			ConnMod: func(m *Modifier) {
+				mu.Lock()
+				defer mu.Unlock()
+				negotiated = m.actions
+			},
+		}
+	}), WithActions(OptAddHeader | OptChangeBody), WithCommonNegotiation(func(report NegotiationReport) {
+		mu.Lock()
+		defer mu.Unlock()
+		reports = append(reports, report)
+	})}, []Option{WithActions(OptAddHeader)})
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reports) != 1 || reports[0].DroppedActions != OptChangeBody || !reports[0].Downgraded() {
+		t.Fatalf("got reports %+v", reports)
+	}
+	if negotiated != OptAddHeader {
+		t.Fatalf("negotiated actions %032b", negotiated)
+	}
+}
@@ -1,6 +1,10 @@
 package milter
 
 import (
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"os"
 	"time"
 )
 
@@ -8,10 +12,24 @@ import (
 // The parameters version, action, protocol and maxData are the negotiated values.
 type NewMilterFunc func(version uint32, action OptAction, protocol OptProtocol, maxData DataSize) Milter
 
+// MacroRequestsFunc is the signature of a [WithDynamicMacroRequests] function.
+// The parameters version, action and protocol are the negotiated values, remoteAddr is the address of the MTA.
+// It returns the macros the [Server] requests for each stage of this connection.
+// When it returns nil, the macros of [WithMacroRequest] get requested.
+type MacroRequestsFunc func(version uint32, action OptAction, protocol OptProtocol, remoteAddr net.Addr) map[MacroStage][]MacroName
+
+// MilterMiddleware is the signature of a [WithMilterMiddleware] function.
+// It gets the [Milter] instance of a connection and returns the [Milter] that the [Server] uses instead.
+type MilterMiddleware func(next Milter) Milter
+
 // NegotiationCallbackFunc is the signature of a [WithNegotiationCallback] function.
 // With this callback function you can override the negotiation process.
 type NegotiationCallbackFunc func(mtaVersion, milterVersion uint32, mtaActions, milterActions OptAction, mtaProtocol, milterProtocol OptProtocol, offeredDataSize DataSize) (version uint32, actions OptAction, protocol OptProtocol, maxDataSize DataSize, err error)
 
+// NegotiationWarningFunc is the signature of a [WithNegotiationTolerance] callback function.
+// unsupportedActions and unsupportedProtocol are the bits the milter requested but the [Client] did not offer.
+type NegotiationWarningFunc func(unsupportedActions OptAction, unsupportedProtocol OptProtocol)
+
 type options struct {
 	maxVersion                  uint32
 	actions                     OptAction
@@ -21,7 +39,49 @@ type options struct {
 	offeredMaxData, usedMaxData DataSize
 	macrosByStage               macroRequests
 	newMilter                   NewMilterFunc
+	dynamicMilter               bool
 	negotiationCallback         NegotiationCallbackFunc
+	pipelining                  bool
+	stageTimeouts               StageTimeouts
+	macroAutoFill               bool
+	negotiationTolerance        bool
+	negotiationWarning          NegotiationWarningFunc
+	autoChunking                bool
+	maxSessions                 int
+	sessionQueueTimeout         time.Duration
+	bodyPolicy                  BodyPolicy
+	failurePolicy               FailurePolicy
+	maxHeaderCount              int
+	maxHeaderBytes              int
+	maxBodyBytes                int64
+	limitPolicy                 LimitPolicy
+	maxBodyBuffer               int
+	rejectReply, tempFailReply  *Response
+	classifySession             SessionClassifier
+	sessionClasses              map[string]SessionClass
+	maxUnknownReplies           int
+	keepalive, idleTimeout      time.Duration
+	extensionReplyHandler       ExtensionReplyHandler
+	extensionCommandHandler     ExtensionCommandHandler
+	reconnectAttempts           int
+	timing                      TimingFunc
+	tlsConfig                   *tls.Config
+	logger                      Logger
+	maxConnections              int
+	serverTimeouts              ServerTimeouts
+	panicHandler                PanicHandler
+	overflowPolicy              OverflowPolicy
+	milterMiddlewares           []MilterMiddleware
+	dynamicMacroRequests        MacroRequestsFunc
+	maxConcurrentEOM            int
+	eomQueueTimeout             time.Duration
+	eomOverload                 *Response
+	unixSocketPermissions       *unixSocketPermissions
+	tarpit                      TarpitFunc
+	allowedNetworks             []netip.Prefix
+	autoProgressAfter           time.Duration
+	autoProgressInterval        time.Duration
+	headerFoldLength            int
 }
 
 // Option can be used to configure [Client] and [Server].
@@ -109,11 +169,44 @@ func WithWriteTimeout(timeout time.Duration) Option {
 	}
 }
 
+// StageTimeouts defines the read timeouts a [Client] uses when it waits for the reply of the milter to a specific command.
+// A zero value means that the read timeout of [WithReadTimeout] gets used for this command.
+type StageTimeouts struct {
+	Connect      time.Duration
+	Helo         time.Duration
+	MailFrom     time.Duration
+	RcptTo       time.Duration
+	Data         time.Duration
+	Header       time.Duration
+	EndOfHeader  time.Duration
+	BodyChunk    time.Duration
+	EndOfMessage time.Duration
+	Unknown      time.Duration
+}
+
+// WithStageTimeouts sets individual read timeouts for the replies to the commands of the milter protocol.
+// Milters like spam scanners often do most of their work at the end of the message and need far longer to
+// respond to [ClientSession.End] than to any other command. You can e.g. use
+//
+//	WithReadTimeout(10*time.Second), WithStageTimeouts(StageTimeouts{EndOfMessage: 5*time.Minute})
+//
+// to give the milter more time to reply at the end of the message.
+//
+// This is a [Client] only [Option].
+func WithStageTimeouts(timeouts StageTimeouts) Option {
+	return func(h *options) {
+		h.stageTimeouts = timeouts
+	}
+}
+
 // WithOfferedMaxData sets the [DataSize] that your MTA wants to offer to milters.
 // The milter needs to accept this offer in protocol negotiation for it to become effective.
 // This is just an indication to the milter that it can send bigger packages.
 // This library does not care what value was negotiated and always accept packages of up to 512 MB.
 //
+// You can use any size between [DataSize64K] and 512 MB. The protocol only knows [DataSize64K], [DataSize256K] and [DataSize1M],
+// so the [Client] offers the biggest of these sizes that is not bigger than offeredMaxData.
+//
 // This is a [Client] only [Option].
 func WithOfferedMaxData(offeredMaxData DataSize) Option {
 	return func(h *options) {
@@ -123,8 +216,10 @@ func WithOfferedMaxData(offeredMaxData DataSize) Option {
 
 // WithUsedMaxData sets the [DataSize] that your MTA or milter uses to send packages to the other party.
 // The default value is [DataSize64K] for maximum compatibility.
-// If you set this to 0 the [Client] will use the value of [WithOfferedMaxData] and the [Server] will use the dataSize that it
-// negotiated with the MTA.
+// You can use any size up to 512 MB.
+// If you set this to 0 the [Client] and the [Server] will use the dataSize that they negotiated with the other party.
+// A [ClientSession] then automatically splits body chunks that are bigger than the negotiated size,
+// so your MTA does not need to know the negotiated size.
 //
 // Setting the maximum used data size to something different might trigger the other party to an error.
 // MTAs like Postfix/sendmail and newer libmilter versions can handle bigger values without negotiation.
@@ -159,6 +254,18 @@ func WithMacroRequest(stage MacroStage, macros []MacroName) Option {
 	}
 }
 
+// WithDynamicMacroRequests sets the function that decides which macros the [Server] requests from the MTA
+// for each connection. Use it when the macros you need depend on the connection, e.g. on the MTA that connects.
+// The requested macros get sent in the negotiation reply. They override the macros of [WithMacroRequest] for this connection.
+// This function automatically sets the action [OptSetMacros].
+//
+// This is a [Server] only [Option].
+func WithDynamicMacroRequests(requests MacroRequestsFunc) Option {
+	return func(h *options) {
+		h.dynamicMacroRequests = requests
+	}
+}
+
 // WithMilter sets the [Milter] backend this [Server] uses.
 //
 // This is a [Server] only [Option].
@@ -167,6 +274,7 @@ func WithMilter(newMilter func() Milter) Option {
 		h.newMilter = func(uint32, OptAction, OptProtocol, DataSize) Milter {
 			return newMilter()
 		}
+		h.dynamicMilter = false
 	}
 }
 
@@ -178,6 +286,7 @@ func WithMilter(newMilter func() Milter) Option {
 func WithDynamicMilter(newMilter NewMilterFunc) Option {
 	return func(h *options) {
 		h.newMilter = newMilter
+		h.dynamicMilter = true
 	}
 }
 
@@ -192,3 +301,434 @@ func WithNegotiationCallback(negotiationCallback NegotiationCallbackFunc) Option
 		h.negotiationCallback = negotiationCallback
 	}
 }
+
+// WithPipelining enables the pipelined command mode of [ClientSession].
+//
+// When the milter negotiated [OptNoHeaderReply] (or [OptNoBodyReply]) it does not reply to header fields (or body chunks).
+// In pipelined mode [ClientSession.HeaderField] (or [ClientSession.BodyChunk]) then does not write each header field
+// (or body chunk) to the milter on its own but buffers it. The buffered commands get written to the milter
+// in one go at the next synchronization point: [ClientSession.HeaderEnd], [ClientSession.End], any other command
+// or when the buffer grows bigger than 64 KiB. Errors writing the buffered commands get returned by the synchronization point
+// and the synchronization point collects the decision of the milter about the buffered commands.
+// This saves a lot of system calls and network packets for header-heavy messages.
+// When the milter wants to reply to header fields and body chunks each command waits for its reply as usual.
+//
+// This is a [Client] only [Option].
+func WithPipelining() Option {
+	return func(h *options) {
+		h.pipelining = true
+	}
+}
+
+// WithBodyChunking instructs [ClientSession.BodyChunk] to split body chunks that are bigger than the data size
+// of [WithUsedMaxData] into multiple body chunks instead of returning an error.
+// The chunks get split after a line ending when possible.
+//
+// This is a [Client] only [Option].
+func WithBodyChunking() Option {
+	return func(h *options) {
+		h.autoChunking = true
+	}
+}
+
+// WithMacroAutoFill instructs the [Client] to derive the macros [MacroDaemonAddr], [MacroDaemonPort], [MacroIfAddr], [MacroIfName],
+// [MacroClientAddr], [MacroClientPort], [MacroTlsVersion], [MacroCipher], [MacroCipherBits], [MacroCertSubject] and [MacroCertIssuer]
+// from the SMTP connection that you set with [ClientSession.SetSMTPConn].
+// Values that you set in the [Macros] of the [ClientSession] take precedence over the derived values.
+//
+// This is a [Client] only [Option].
+func WithMacroAutoFill() Option {
+	return func(h *options) {
+		h.macroAutoFill = true
+	}
+}
+
+// WithNegotiationTolerance instructs the [Client] to not fail the protocol negotiation when the milter requests actions
+// or protocol options that the [Client] did not offer. The unsupported bits get masked out and warn gets called with them.
+// If warn is nil, a warning gets logged (see [WithLogger]).
+//
+// Be aware that the milter does not know that its request got masked. E.g. a milter that requested [OptNoHeaderReply]
+// will not send replies to header fields that the [Client] then waits for.
+// Only use this option when you know how the milters you connect to behave.
+//
+// This is a [Client] only [Option].
+func WithNegotiationTolerance(warn NegotiationWarningFunc) Option {
+	return func(h *options) {
+		h.negotiationTolerance = true
+		h.negotiationWarning = warn
+	}
+}
+
+// WithMaxConcurrentSessions limits the number of sessions that a [Client] has open at the same time to max.
+// Some milters collapse when they have to handle too many connections in parallel.
+//
+// When max sessions are open, [Client.Session] waits up to queueTimeout for another session to get closed.
+// If queueTimeout is 0 [Client.Session] does not wait and immediately fails with [ErrTooManySessions].
+// A [ClientSession] frees its slot when it gets closed or when it fails, so you need to call [ClientSession.Close].
+// [Client.Ping] also needs a free slot.
+//
+// This is a [Client] only [Option].
+func WithMaxConcurrentSessions(max int, queueTimeout time.Duration) Option {
+	return func(h *options) {
+		h.maxSessions = max
+		h.sessionQueueTimeout = queueTimeout
+	}
+}
+
+// WithBodyPolicy sets the [BodyPolicy] for NUL bytes and bare CR or LF characters in the message body.
+// The default is [BodyPassThrough].
+//
+// A [Client] applies the policy before it sends body chunks to the milter.
+// With [BodyReject] [ClientSession.BodyChunk] returns an error that wraps [ErrInvalidBody].
+//
+// A [Server] applies the policy before it passes body chunks to [Milter.BodyChunk].
+// With [BodyReject] the [Server] rejects the message without calling [Milter.EndOfMessage] (it calls [Milter.Abort] instead).
+func WithBodyPolicy(policy BodyPolicy) Option {
+	return func(h *options) {
+		h.bodyPolicy = policy
+	}
+}
+
+// WithOnError sets the [FailurePolicy] of the [Client].
+// When the connection to the milter breaks, the milter times out or sends invalid replies, the methods of [ClientSession]
+// do not return an error but the [Action] of the policy. All following calls of the session also return this [Action]
+// ([ClientSession.Abort] and [ClientSession.Reset] return nil), so your MTA can continue as if the milter replied.
+// Use [ClientSession.Err] to get the original error. Errors of the negotiation in [Client.Session] are still returned.
+// The default is [FailError].
+//
+// This is a [Client] only [Option].
+func WithOnError(policy FailurePolicy) Option {
+	return func(h *options) {
+		h.failurePolicy = policy
+	}
+}
+
+// WithMaxHeaderCount limits the number of header fields of a message to max.
+// When a message has more header fields the [Server] applies the [LimitPolicy] of [WithLimitPolicy].
+// By default, it temporarily rejects the message and does not pass the rest of the message to the [Milter]
+// ([Milter.Abort] gets called instead of [Milter.EndOfMessage]).
+// 0 means no limit, this is the default.
+//
+// This is a [Server] only [Option].
+func WithMaxHeaderCount(max int) Option {
+	return func(h *options) {
+		h.maxHeaderCount = max
+	}
+}
+
+// WithMaxHeaderBytes limits the combined size of the names and values of all header fields of a message to max bytes.
+// When the header of a message is bigger the [Server] applies the [LimitPolicy] of [WithLimitPolicy].
+// 0 means no limit, this is the default.
+//
+// This is a [Server] only [Option].
+func WithMaxHeaderBytes(max int) Option {
+	return func(h *options) {
+		h.maxHeaderBytes = max
+	}
+}
+
+// WithMaxBodyBytes limits the size of the body of a message to max bytes.
+// When the body of a message is bigger the [Server] applies the [LimitPolicy] of [WithLimitPolicy].
+// The limit applies to the body chunks after the [BodyPolicy] of [WithBodyPolicy].
+// 0 means no limit, this is the default.
+//
+// This is a [Server] only [Option].
+func WithMaxBodyBytes(max int64) Option {
+	return func(h *options) {
+		h.maxBodyBytes = max
+	}
+}
+
+// WithMaxBodyBuffer limits the number of body bytes that the [Server] holds in memory for one [Milter.BodyChunk] call to max.
+//
+// The [Server] handles the commands of a connection one after the other. It only reads the next body chunk from the
+// connection when [Milter.BodyChunk] returned, so a fast MTA waits for a slow [Milter] (TCP backpressure).
+// But a single body chunk can be as big as the MTA likes. With this option the [Server] reads a body chunk that is bigger than max
+// in pieces of max bytes and calls [Milter.BodyChunk] for each piece. When [Milter.BodyChunk] returns a response
+// that ends the body (e.g. [RespSkip] or [RespReject]) the rest of the chunk gets discarded without buffering it.
+// 0 means no limit, this is the default.
+//
+// This is a [Server] only [Option].
+func WithMaxBodyBuffer(max int) Option {
+	return func(h *options) {
+		h.maxBodyBuffer = max
+	}
+}
+
+// WithLimitPolicy sets the [LimitPolicy] for messages that exceed the limits of
+// [WithMaxHeaderCount], [WithMaxHeaderBytes] or [WithMaxBodyBytes]. The default is [LimitTempFail].
+//
+// This is a [Server] only [Option].
+func WithLimitPolicy(policy LimitPolicy) Option {
+	return func(h *options) {
+		h.limitPolicy = policy
+	}
+}
+
+// WithSessionClasses lets the [Server] sort connections into priority classes.
+// classify gets called with the connect macros of the MTA (e.g. [MacroDaemonName]) and returns the name of the [SessionClass] in classes.
+// Each class can have its own concurrency limit and read timeout. E.g. you can limit the number of inbound sessions
+// so that a flood of inbound connections does not starve the filtering of outgoing submissions.
+//
+// Connections get classified when the MTA sends the connect command, so you cannot use this option together with [OptNoConnect].
+// A connection keeps its class (and its slot) until it gets closed or the MTA starts a new connection with [ClientSession.Reset].
+//
+// This is a [Server] only [Option].
+func WithSessionClasses(classify SessionClassifier, classes map[string]SessionClass) Option {
+	return func(h *options) {
+		h.classifySession = classify
+		h.sessionClasses = classes
+	}
+}
+
+// WithPanicHandler sets the function that decides how the [Server] replies to a command when a callback of your [Milter] panicked.
+// The [Server] always recovers from these panics and logs them with their stack trace.
+// The [Milter] of the connection gets replaced with a new instance since its state is unknown after a panic.
+// Without a handler the [Server] replies with [RespTempFail].
+//
+// This is a [Server] only [Option].
+func WithPanicHandler(handler PanicHandler) Option {
+	return func(h *options) {
+		h.panicHandler = handler
+	}
+}
+
+// WithMilterMiddleware wraps every [Milter] instance that the [Server] creates with middleware, the same way
+// HTTP middleware wraps a [net/http.Handler]. Use it for cross-cutting concerns like logging, metrics or rate limiting.
+// The middleware usually embeds next and only overrides the callbacks it is interested in.
+//
+// You can use this option multiple times. The first middleware is the outermost one: it gets called first.
+//
+// This is a [Server] only [Option].
+func WithMilterMiddleware(middleware MilterMiddleware) Option {
+	return func(h *options) {
+		h.milterMiddlewares = append(h.milterMiddlewares, middleware)
+	}
+}
+
+// WithServerTimeouts sets the timeouts the [Server] uses when it waits for the MTA (see [ServerTimeouts]).
+// Without them a stalled MTA connection occupies a goroutine of the [Server] forever.
+// The ReadTimeout of a [SessionClass] overrides the Command and Idle timeouts.
+//
+// This is a [Server] only [Option].
+func WithServerTimeouts(timeouts ServerTimeouts) Option {
+	return func(h *options) {
+		h.serverTimeouts = timeouts
+	}
+}
+
+// WithMaxConnections limits the number of MTA connections that the [Server] handles at the same time to max.
+// policy defines what happens with connections over the limit (see [OverflowPolicy]).
+// The default is 0, there is no limit.
+//
+// You cannot use [OverflowTempFail] together with [OptNoConnect].
+//
+// This is a [Server] only [Option].
+func WithMaxConnections(max int, policy OverflowPolicy) Option {
+	return func(h *options) {
+		h.maxConnections = max
+		h.overflowPolicy = policy
+	}
+}
+
+// WithMaxConcurrentEndOfMessage limits the number of [Milter.EndOfMessage] callbacks that the [Server] runs at the same time to max,
+// independent of the number of connections. Use it when your end-of-message processing is CPU-heavy (e.g. content scanning),
+// so that a burst of messages does not overload your server.
+//
+// A message that does not get a slot waits up to queueTimeout for one (0 means that it does not wait at all).
+// When no slot got free in time, the [Server] aborts the message (see [Milter.Abort]) and replies with overload.
+// When overload is nil the [Server] replies with a 451 temporary failure. overload cannot be [RespContinue].
+// The default max is 0, there is no limit.
+//
+// This is a [Server] only [Option].
+func WithMaxConcurrentEndOfMessage(max int, queueTimeout time.Duration, overload *Response) Option {
+	return func(h *options) {
+		h.maxConcurrentEOM = max
+		h.eomQueueTimeout = queueTimeout
+		h.eomOverload = overload
+	}
+}
+
+// WithAutoProgress makes the [Server] send progress packets (see [Modifier.Progress]) to the MTA while your
+// [Milter.EndOfMessage] callback runs longer than after. It sends the first progress packet after after and then
+// one every interval (0 means every after). This prevents MTA-side milter timeouts during slow scans,
+// without having to call [Modifier.Progress] from your own goroutine.
+// The default after is 0, the [Server] does not send progress packets on its own.
+//
+// This is a [Server] only [Option].
+func WithAutoProgress(after, interval time.Duration) Option {
+	return func(h *options) {
+		h.autoProgressAfter = after
+		h.autoProgressInterval = interval
+	}
+}
+
+// WithHeaderFolding makes [Modifier.AddHeader], [Modifier.ChangeHeader] and [Modifier.InsertHeader] fold header values
+// at whitespace, so that no line of the header field is longer than maxLineLength characters
+// (see [milterutil.FoldHeaderValue]). maxLineLength 0 means 78 characters, the recommendation of RFC 5322.
+// Values that already contain line breaks do not get changed, so your [Milter] can still fold values on its own.
+// The default is to send header values as they are.
+//
+// This is a [Server] only [Option].
+func WithHeaderFolding(maxLineLength int) Option {
+	return func(h *options) {
+		if maxLineLength == 0 {
+			maxLineLength = 78
+		}
+		h.headerFoldLength = maxLineLength
+	}
+}
+
+// WithTarpit makes the [Server] delay its responses to suspicious peers (tarpitting).
+// decide gets called for every SMTP connection before [Milter.Connect] and returns the delay of every response
+// for this connection. Your [Milter] can change the delay later with [Modifier.Tarpit].
+//
+// The delay happens after the [Milter] callback returned, so slots of [WithMaxConcurrentEndOfMessage] are not held while waiting.
+// The connection also gives its slots of [WithMaxConnections] and [WithSessionClasses] to other connections while it waits
+// and waits for free slots again before it sends the response.
+// While the end-of-message response gets delayed the [Server] sends progress packets to the MTA.
+// Before other responses it cannot do that, so keep the delay below the command timeout of your MTA
+// (e.g. milter_command_timeout of Postfix, 30 seconds by default).
+//
+// This is a [Server] only [Option].
+func WithTarpit(decide TarpitFunc) Option {
+	return func(h *options) {
+		h.tarpit = decide
+	}
+}
+
+// WithAllowedNetworks makes the [Server] only accept TCP connections from MTAs with an IP address in one of the networks.
+// The [Server] closes connections from other addresses right after accepting them, before the protocol negotiation.
+// The milter protocol has no authentication, so you should use this option (or a firewall) when your [Server] listens on a TCP socket.
+// Connections without an IP address (e.g. unix sockets) are always allowed. When networks is empty all TCP connections get denied.
+//
+// This is a [Server] only [Option].
+func WithAllowedNetworks(networks []netip.Prefix) Option {
+	return func(h *options) {
+		h.allowedNetworks = append([]netip.Prefix{}, networks...)
+	}
+}
+
+// WithUnixSocketPermissions sets the permissions of the unix socket file that [Server.ListenAndServe] creates.
+// mode are the file permissions (e.g. 0660), uid and gid the numeric user and group ID of the file owner.
+// Use -1 for uid or gid to not change it. Without this option the socket file gets created with the default permissions.
+//
+// This is a [Server] only [Option].
+func WithUnixSocketPermissions(mode os.FileMode, uid, gid int) Option {
+	return func(h *options) {
+		h.unixSocketPermissions = &unixSocketPermissions{mode: mode, uid: uid, gid: gid}
+	}
+}
+
+// WithLenientReplies makes a [ClientSession] skip up to max milter replies with codes that this library does not know
+// (e.g. vendor extensions of a milter) instead of failing with an [ActionParseError].
+// Each skipped reply gets logged as warning (see [WithLogger]). The limit applies to the whole session.
+// The default is 0, every unknown reply is an error.
+//
+// This is a [Client] only [Option].
+func WithLenientReplies(max int) Option {
+	return func(h *options) {
+		h.maxUnknownReplies = max
+	}
+}
+
+// WithKeepalive makes idle [ClientSession] objects send a packet to the milter every interval
+// so that firewalls and NAT devices do not silently drop the connection of pooled sessions.
+// A session is idle when no method of it is running and it is not in an SMTP connection (it was just created or [ClientSession.Reset] got called).
+// The keepalive packet is an empty macro packet that the milter does not reply to.
+// The default is 0, no keepalive packets get sent.
+//
+// This is a [Client] only [Option].
+func WithKeepalive(interval time.Duration) Option {
+	return func(h *options) {
+		h.keepalive = interval
+	}
+}
+
+// WithIdleTimeout makes a [ClientSession] close itself when it was idle for longer than timeout (see [WithKeepalive]).
+// Use [ClientSession.Check] to find out whether a pooled session is still usable.
+// The default is 0, idle sessions do not get closed.
+//
+// This is a [Client] only [Option].
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(h *options) {
+		h.idleTimeout = timeout
+	}
+}
+
+// WithExtensionReplyHandler sets a handler for milter replies with codes that this library does not know.
+// Handled replies do not count towards the limit of [WithLenientReplies].
+// Use it together with [ClientSession.SendExtension] and [ClientSession.ExtensionRequest] to implement vendor-specific extensions of the milter protocol.
+//
+// This is a [Client] only [Option].
+func WithExtensionReplyHandler(handler ExtensionReplyHandler) Option {
+	return func(h *options) {
+		h.extensionReplyHandler = handler
+	}
+}
+
+// WithExtensionCommandHandler sets a handler for MTA commands with codes that this library does not know.
+// Without a handler the [Server] closes the connection when the MTA sends an unknown command.
+// Use it together with [NewExtensionResponse] and [Modifier.SendExtension] to implement vendor-specific extensions of the milter protocol.
+//
+// This is a [Server] only [Option].
+func WithExtensionCommandHandler(handler ExtensionCommandHandler) Option {
+	return func(h *options) {
+		h.extensionCommandHandler = handler
+	}
+}
+
+// WithTLSConfig makes the [Server] require TLS on all sockets it serves with [Server.Serve].
+// Set config.ClientAuth to e.g. [tls.RequireAndVerifyClientCert] to only accept MTAs with a valid client certificate.
+// config needs to contain a certificate – or use [Server.ServeTLS] to load it from files.
+// MTAs with built-in milter support do not speak TLS, so you need a TLS proxy on the MTA host or a [Client] that
+// uses a [tls.Dialer] (see [WithDialer]).
+//
+// The [Server] runs the TLS handshake right after accepting a connection (limited by [WithReadTimeout]).
+// It logs failed handshakes and counts them in [ListenerStats].
+//
+// This is a [Server] only [Option].
+func WithTLSConfig(config *tls.Config) Option {
+	return func(h *options) {
+		h.tlsConfig = config
+	}
+}
+
+// WithLogger sets the [Logger] that receives the warnings of the [Client] or [Server] (prefixed with "milter: warning: ").
+// The default is to use the package-level [LogWarning] function.
+// Use it when you run several clients or servers in one process that should log to different destinations.
+func WithLogger(logger Logger) Option {
+	return func(h *options) {
+		h.logger = logger
+	}
+}
+
+// WithReconnect makes a [ClientSession] re-establish the connection to the milter when the milter closed or reset it
+// (e.g. because the milter got restarted). The session connects to the milter again, negotiates the protocol options
+// and replays the commands of the current SMTP connection (Conn, Helo and, when in a message, Mail, Rcpt and DataStart)
+// with the current values of its [Macros]. Then it retries the failed command.
+// It tries this up to attempts times per command. The default is 0, the session does not reconnect.
+//
+// Only [ClientSession.Conn], [ClientSession.Helo], [ClientSession.Mail], [ClientSession.Rcpt], [ClientSession.DataStart]
+// and their WithMacros variants reconnect. A lost connection after DataStart still fails the message since the session does not keep
+// the header and body of the message.
+// WithReconnect cannot be used together with [WithPipelining].
+//
+// This is a [Client] only [Option].
+func WithReconnect(attempts int) Option {
+	return func(h *options) {
+		h.reconnectAttempts = attempts
+	}
+}
+
+// WithTimingCallback sets a function that a [ClientSession] calls after each command with the time the command took
+// and the [Action] of the milter. Use it to record per-milter and per-stage latencies to find slow milters.
+// The time includes the time the session waits for the reply of the milter, so with [WithPipelining]
+// the time of a synchronization point includes the time the milter needed for the buffered commands.
+//
+// This is a [Client] only [Option].
+func WithTimingCallback(callback TimingFunc) Option {
+	return func(h *options) {
+		h.timing = callback
+	}
+}
@@ -1,6 +1,9 @@
 package milter
 
 import (
+	"crypto/tls"
+	"io"
+	"log"
 	"net"
 	"reflect"
 	"testing"
@@ -159,3 +162,219 @@ func TestWithNegotiationCallback(t *testing.T) {
 		t.Fatalf("did not set the correct negotiationCallback")
 	}
 }
+
+func TestWithPipelining(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithPipelining()}, options{pipelining: true}},
+	})
+}
+
+func TestWithStageTimeouts(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithStageTimeouts(StageTimeouts{EndOfMessage: time.Minute})}, options{stageTimeouts: StageTimeouts{EndOfMessage: time.Minute}}},
+	})
+}
+
+func TestWithMacroAutoFill(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMacroAutoFill()}, options{macroAutoFill: true}},
+	})
+}
+
+func TestWithNegotiationTolerance(t *testing.T) {
+	opt := options{}
+	WithNegotiationTolerance(nil)(&opt)
+	if !opt.negotiationTolerance || opt.negotiationWarning != nil {
+		t.Fatalf("got %+v", opt)
+	}
+}
+
+func TestWithMaxConcurrentSessions(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxConcurrentSessions(4, time.Second)}, options{maxSessions: 4, sessionQueueTimeout: time.Second}},
+	})
+}
+
+func TestWithBodyChunking(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithBodyChunking()}, options{autoChunking: true}},
+	})
+}
+
+func TestWithBodyPolicy(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithBodyPolicy(BodyReject)}, options{bodyPolicy: BodyReject}},
+	})
+}
+
+func TestWithOnError(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithOnError(FailTempFail)}, options{failurePolicy: FailTempFail}},
+	})
+}
+
+func TestWithMaxHeaderCount(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxHeaderCount(100)}, options{maxHeaderCount: 100}},
+	})
+}
+
+func TestWithMaxHeaderBytes(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxHeaderBytes(1024)}, options{maxHeaderBytes: 1024}},
+	})
+}
+
+func TestWithMaxBodyBytes(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxBodyBytes(1 << 20)}, options{maxBodyBytes: 1 << 20}},
+	})
+}
+
+func TestWithMaxBodyBuffer(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxBodyBuffer(64 * 1024)}, options{maxBodyBuffer: 64 * 1024}},
+	})
+}
+
+func TestWithLimitPolicy(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithLimitPolicy(LimitTruncate)}, options{limitPolicy: LimitTruncate}},
+	})
+}
+
+func TestWithSessionClasses(t *testing.T) {
+	opt := options{}
+	classes := map[string]SessionClass{"inbound": {MaxConcurrent: 10}}
+	WithSessionClasses(func(macros Macros) string {
+		return macros.Get(MacroDaemonName)
+	}, classes)(&opt)
+	if opt.classifySession == nil || !reflect.DeepEqual(opt.sessionClasses, classes) {
+		t.Fatalf("got %+v", opt)
+	}
+}
+
+func TestWithLenientReplies(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithLenientReplies(3)}, options{maxUnknownReplies: 3}},
+	})
+}
+
+func TestWithKeepalive(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithKeepalive(time.Minute)}, options{keepalive: time.Minute}},
+	})
+}
+
+func TestWithIdleTimeout(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithIdleTimeout(time.Minute)}, options{idleTimeout: time.Minute}},
+	})
+}
+
+func TestWithTLSConfig(t *testing.T) {
+	config := &tls.Config{}
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithTLSConfig(config)}, options{tlsConfig: config}},
+	})
+}
+
+func TestWithLogger(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithLogger(logger)}, options{logger: logger}},
+	})
+}
+
+func TestWithMaxConnections(t *testing.T) {
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithMaxConnections(4, OverflowTempFail)}, options{maxConnections: 4, overflowPolicy: OverflowTempFail}},
+	})
+}
+
+func TestWithServerTimeouts(t *testing.T) {
+	timeouts := ServerTimeouts{Command: time.Second, Idle: time.Minute, Session: time.Hour}
+	testOptions(t, []optionsTestCase{
+		{"set", options{}, []Option{WithServerTimeouts(timeouts)}, options{serverTimeouts: timeouts}},
+	})
+}
+
+func TestWithPanicHandler(t *testing.T) {
+	opt := options{}
+	WithPanicHandler(func(recovered interface{}, m *Modifier) *Response {
+		return RespReject
+	})(&opt)
+	if opt.panicHandler == nil || opt.panicHandler(nil, nil) != RespReject {
+		t.Fatalf("did not set the correct panicHandler")
+	}
+}
+
+func TestWithMilterMiddleware(t *testing.T) {
+	opt := options{}
+	middleware := func(next Milter) Milter { return next }
+	WithMilterMiddleware(middleware)(&opt)
+	WithMilterMiddleware(middleware)(&opt)
+	if len(opt.milterMiddlewares) != 2 {
+		t.Fatalf("got %d middlewares, want 2", len(opt.milterMiddlewares))
+	}
+}
+
+func TestWithDynamicMacroRequests(t *testing.T) {
+	opt := options{}
+	WithDynamicMacroRequests(func(uint32, OptAction, OptProtocol, net.Addr) map[MacroStage][]MacroName {
+		return nil
+	})(&opt)
+	if opt.dynamicMacroRequests == nil {
+		t.Fatalf("did not set dynamicMacroRequests")
+	}
+}
+
+func TestWithMaxConcurrentEndOfMessage(t *testing.T) {
+	opt := options{}
+	WithMaxConcurrentEndOfMessage(4, time.Second, RespTempFail)(&opt)
+	if opt.maxConcurrentEOM != 4 || opt.eomQueueTimeout != time.Second || opt.eomOverload != RespTempFail {
+		t.Fatalf("unexpected options %+v", opt)
+	}
+}
+
+func TestWithTarpit(t *testing.T) {
+	opt := options{}
+	WithTarpit(func(string, string, uint16, string) time.Duration { return time.Second })(&opt)
+	if opt.tarpit == nil {
+		t.Fatalf("did not set tarpit")
+	}
+}
+
+func TestWithAllowedNetworks(t *testing.T) {
+	opt := options{}
+	WithAllowedNetworks(nil)(&opt)
+	if opt.allowedNetworks == nil || len(opt.allowedNetworks) != 0 {
+		t.Fatalf("unexpected allowedNetworks %v", opt.allowedNetworks)
+	}
+}
+
+func TestWithAutoProgress(t *testing.T) {
+	opt := options{}
+	WithAutoProgress(time.Second, 2*time.Second)(&opt)
+	if opt.autoProgressAfter != time.Second || opt.autoProgressInterval != 2*time.Second {
+		t.Fatalf("unexpected options %+v", opt)
+	}
+}
+
+func TestWithHeaderFolding(t *testing.T) {
+	opt := options{}
+	WithHeaderFolding(0)(&opt)
+	if opt.headerFoldLength != 78 {
+		t.Fatalf("unexpected headerFoldLength %d", opt.headerFoldLength)
+	}
+	WithHeaderFolding(998)(&opt)
+	if opt.headerFoldLength != 998 {
+		t.Fatalf("unexpected headerFoldLength %d", opt.headerFoldLength)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("NewServer() did not panic")
+		}
+	}()
+	NewServer(WithMilter(func() Milter { return NoOpMilter{} }), WithHeaderFolding(-1))
+}
@@ -0,0 +1,68 @@
+package milter
+
+import "sync/atomic"
+
+// OverflowPolicy defines what a [Server] does with new MTA connections when it already handles
+// the maximum number of connections of [WithMaxConnections].
+type OverflowPolicy int
+
+const (
+	// OverflowQueue makes the [Server] stop accepting connections until an active connection ends.
+	// The first connection over the limit waits without getting a reply to its negotiation, further connections
+	// wait in the listen backlog of the operating system (or time out at the MTA).
+	OverflowQueue OverflowPolicy = iota
+	// OverflowTempFail makes the [Server] accept the connection but temporarily reject every SMTP connection
+	// of it with a 421 reply. The connection does not call your [Milter] and does not count against the limit.
+	OverflowTempFail
+)
+
+// overflowResponse is the response to the connect command of connections that exceeded the limit of [WithMaxConnections].
+func overflowResponse() *Response {
+	return mustRejectWithCodeAndReason(421, "4.3.2 Too many concurrent connections, try again later")
+}
+
+// acquireConnection waits for a free connection slot of [WithMaxConnections] (or just checks for one with [OverflowTempFail]).
+// It returns ok == false when there is no free slot and closed == true when s got closed while waiting.
+func (s *Server) acquireConnection() (ok, closed bool) {
+	if s.connSlots == nil {
+		return true, false
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true, false
+	default:
+	}
+	atomic.AddUint64(&s.listenerStats.Overflows, 1)
+	if s.options.overflowPolicy == OverflowTempFail {
+		return false, false
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true, false
+	case <-s.done:
+		return false, true
+	}
+}
+
+// reacquireConnection waits for a free connection slot of [WithMaxConnections] for a connection that gave its slot away
+// (see [WithTarpit]). It returns false when done got closed or s got closed while waiting.
+func (s *Server) reacquireConnection(done <-chan struct{}) bool {
+	if s.connSlots == nil {
+		return true
+	}
+	select {
+	case s.connSlots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	case <-s.done:
+		return false
+	}
+}
+
+// releaseConnection frees a connection slot of [WithMaxConnections].
+func (s *Server) releaseConnection() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
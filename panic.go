@@ -0,0 +1,57 @@
+package milter
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// PanicHandler is the signature of a [WithPanicHandler] function.
+// recovered is the value the [Milter] callback panicked with, m is a [Modifier] for the current command.
+// The returned [Response] gets sent to the MTA. When it returns nil the [Server] closes the connection.
+type PanicHandler func(recovered interface{}, m *Modifier) *Response
+
+// defaultPanicHandler temporarily rejects the command that panicked.
+func defaultPanicHandler(_ interface{}, _ *Modifier) *Response {
+	return RespTempFail
+}
+
+// processSafe calls [serverSession.Process] and turns a panic of the backend into the response of the [PanicHandler].
+func (m *serverSession) processSafe(msg *wire.Message) (resp *Response, err error) {
+	code := msg.Code
+	defer func() {
+		if r := recover(); r != nil {
+			resp, err = m.recoverPanic(r, code)
+		}
+	}()
+	return m.Process(msg)
+}
+
+// recoverPanic logs the panic r of the backend and replaces the backend, since its state is unknown.
+func (m *serverSession) recoverPanic(r interface{}, code wire.Code) (*Response, error) {
+	m.logError("recovered from panic in milter callback: %v", fmt.Errorf("%v\n%s", r, debug.Stack()))
+	handler := m.server.options.panicHandler
+	if handler == nil {
+		handler = defaultPanicHandler
+	}
+	mod := newModifier(m, code != wire.CodeEOB)
+	resp := handler(r, mod)
+	mod.closeDeferred()
+	// the backend might panic again
+	func() {
+		defer func() { _ = recover() }()
+		m.endMessage()
+	}()
+	func() {
+		defer func() { _ = recover() }()
+		m.backend.Cleanup()
+	}()
+	m.backend = m.newBackend()
+	m.backendReplaced = true
+	m.resetMessage()
+	if resp == nil {
+		return nil, errCloseSession
+	}
+	return resp, nil
+}
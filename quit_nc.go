@@ -0,0 +1,36 @@
+package milter
+
+import "sync/atomic"
+
+// ResettableMilter is a [Milter] that can be reused for the next SMTP connection.
+//
+// An MTA that pools its milter connections sends SMFIC_QUIT_NC (see [ClientSession.Reset]) when an SMTP connection ended
+// and it wants to use the milter connection for another SMTP connection.
+// The [Server] then calls [Milter.Cleanup] and creates a new [Milter] with the [NewMilterFunc] of [WithMilter].
+// When the [Milter] implements ResettableMilter the [Server] calls Reset instead and keeps using it.
+type ResettableMilter interface {
+	Milter
+	// Reset prepares the milter for a new SMTP connection. It needs to discard all connection and message state,
+	// the MTA might have ended the previous SMTP connection in the middle of a message.
+	Reset()
+}
+
+// quitNewConn resets the per-connection state of m for the next SMTP connection of the MTA connection.
+func (m *serverSession) quitNewConn() {
+	m.endMessage()
+	if r, ok := m.backend.(ResettableMilter); ok {
+		r.Reset()
+	} else {
+		m.backend.Cleanup()
+		m.backend = m.newBackend()
+	}
+	m.macros.DelStageAndAbove(StageConnect)
+	m.macroPacket = false
+	m.resetMessage()
+	m.releaseClass()
+	m.tarpit = 0
+	atomic.AddUint64(&m.server.counters.connectionReuses, 1)
+	m.state.mu.Lock()
+	m.state.info.Reuses++
+	m.state.mu.Unlock()
+}
@@ -0,0 +1,35 @@
+package milter
+
+import (
+	"strconv"
+)
+
+// RejectedInfo describes why the MTA rejected a recipient. See [ClientSession.RcptRejected] and [RejectedRcpt].
+type RejectedInfo struct {
+	// Code is the SMTP code of the rejection, e.g. 550.
+	Code uint16
+	// Reason is the text of the rejection, e.g. "5.1.1 User unknown".
+	Reason string
+}
+
+// rejectedMailer is the value of [MacroRcptMailer] for rejected recipients.
+const rejectedMailer = "error"
+
+func (r RejectedInfo) macros() map[MacroName]string {
+	return map[MacroName]string{
+		MacroRcptMailer: rejectedMailer,
+		MacroRcptHost:   strconv.Itoa(int(r.Code)),
+		MacroRcptAddr:   r.Reason,
+	}
+}
+
+// RejectedRcpt checks if the recipient of a [Milter.RcptTo] call was already rejected by the MTA.
+// The MTA only sends rejected recipients when your milter negotiated [OptRcptRej].
+// Pass the [Modifier.Macros] of the RcptTo call as macros.
+func RejectedRcpt(macros Macros) (RejectedInfo, bool) {
+	if macros.Get(MacroRcptMailer) != rejectedMailer {
+		return RejectedInfo{}, false
+	}
+	code, _ := strconv.ParseUint(macros.Get(MacroRcptHost), 10, 16)
+	return RejectedInfo{Code: uint16(code), Reason: macros.Get(MacroRcptAddr)}, true
+}
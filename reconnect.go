@@ -0,0 +1,109 @@
+package milter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+// reconnector re-establishes the connection of a [ClientSession] for [WithReconnect].
+type reconnector struct {
+	client   *Client
+	attempts int
+	// commands are the successful commands of the current SMTP connection.
+	// The first connCommands of them are connection-level commands (Conn and Helo).
+	commands     []func() (*Action, error)
+	connCommands int
+}
+
+// forgetMessage removes the message-level commands. r can be nil.
+func (r *reconnector) forgetMessage() {
+	if r != nil {
+		r.commands = r.commands[:r.connCommands]
+	}
+}
+
+// forgetConnection removes all commands. r can be nil.
+func (r *reconnector) forgetConnection() {
+	if r != nil {
+		r.commands = nil
+		r.connCommands = 0
+	}
+}
+
+// connectionLost returns true when err indicates that the milter closed or reset the connection.
+func connectionLost(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// retriable runs command. When the connection to the milter got lost, it re-establishes the connection,
+// replays the commands of the current SMTP connection and runs command again.
+// connLevel is true for the connection-level commands Conn and Helo.
+func (s *ClientSession) retriable(connLevel bool, command func() (*Action, error)) (*Action, error) {
+	r := s.reconnect
+	if r == nil {
+		return command()
+	}
+	// errorOut forgets the macros, remember them for the replay
+	macros, smtpConn := s.macros, s.smtpConn
+	act, err := command()
+	for attempt := 0; err != nil && attempt < r.attempts && connectionLost(err); attempt++ {
+		if reErr := s.reestablish(macros, smtpConn); reErr != nil {
+			s.logWarning("could not re-establish milter session: %v", reErr)
+			s.milterErr = nil
+			return act, s.errorOut(err)
+		}
+		act, err = command()
+	}
+	if err == nil && act.Type == ActionContinue {
+		r.commands = append(r.commands, command)
+		if connLevel {
+			r.connCommands = len(r.commands)
+		}
+	}
+	return act, err
+}
+
+// reestablish connects to the milter again, negotiates the protocol options and replays the commands of the current SMTP connection.
+func (s *ClientSession) reestablish(macros Macros, smtpConn net.Conn) error {
+	r := s.reconnect
+	conn, err := r.client.dial(context.Background())
+	if err != nil {
+		return err
+	}
+	s.connMu.Lock()
+	if s.interrupted {
+		// Close got called while we were dialing
+		s.connMu.Unlock()
+		_ = conn.Close()
+		return net.ErrClosed
+	}
+	_ = s.conn.Close()
+	s.conn = conn
+	s.connMu.Unlock()
+	s.macros = macros
+	s.smtpConn = smtpConn
+	s.milterErr = nil
+	s.closedErr = nil
+	s.skip = false
+	s.skipUnknown = false
+	s.pipelined = nil
+	s.overridden = nil
+	if err := r.client.negotiate(s); err != nil {
+		return err
+	}
+	for _, command := range r.commands {
+		act, err := command()
+		if err != nil {
+			return err
+		}
+		if act.Type != ActionContinue {
+			return fmt.Errorf("milter: replay: milter did not accept the replayed command: %v", act.Type)
+		}
+	}
+	return nil
+}
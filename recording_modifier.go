@@ -0,0 +1,115 @@
+package milter
+
+import (
+	"sync"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// RecordingModifier is a [Modifier] for unit tests of [Milter] implementations.
+// It does not need a connection to an MTA: it records all modifications, [Modifier.Progress] calls and macro lookups
+// in memory, so that your tests can assert on them.
+//
+//	rec := milter.NewRecordingModifier(macros, milter.OptAddHeader)
+//	resp, err := myMilter.EndOfMessage(rec.Modifier)
+//	if !reflect.DeepEqual(rec.Actions(), []milter.ModifyAction{{Type: milter.ActionAddHeader, HeaderName: "X-Spam", HeaderValue: "no"}}) {
+//		t.Error(...)
+//	}
+//
+// A RecordingModifier is safe for concurrent use by multiple goroutines.
+type RecordingModifier struct {
+	*Modifier
+	mu       sync.Mutex
+	actions  []ModifyAction
+	lookups  []MacroName
+	progress int
+}
+
+// NewRecordingModifier returns a [RecordingModifier] that reads its macros from macros (nil means no macros)
+// and allows the modifications of actions. The maximum data size is [DataSize64K].
+func NewRecordingModifier(macros Macros, actions OptAction) *RecordingModifier {
+	if macros == nil {
+		macros = NewMacroBag()
+	}
+	r := &RecordingModifier{}
+	r.Modifier = &Modifier{
+		Macros:              &recordingMacros{macros: macros, recorder: r},
+		writePacket:         r.record,
+		writeProgressPacket: r.recordProgress,
+		actions:             actions,
+		maxDataSize:         DataSize64K,
+		modBytes:            &modificationBytes{},
+	}
+	return r
+}
+
+func (r *RecordingModifier) record(msg *wire.Message) error {
+	act, err := parseModifyAct(msg)
+	if err != nil {
+		return err
+	}
+	if act.Body != nil {
+		// the chunks of ReplaceBody share one buffer
+		act.Body = append([]byte(nil), act.Body...)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, *act)
+	r.modBytes.add(msg)
+	return nil
+}
+
+func (r *RecordingModifier) recordProgress(*wire.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.progress++
+	return nil
+}
+
+// Actions returns the modifications that were made so far, in the order of the calls.
+func (r *RecordingModifier) Actions() []ModifyAction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ModifyAction(nil), r.actions...)
+}
+
+// MacroLookups returns the names of the macros that were looked up so far, in the order of the lookups.
+func (r *RecordingModifier) MacroLookups() []MacroName {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]MacroName(nil), r.lookups...)
+}
+
+// ProgressCalls returns the number of [Modifier.Progress] calls so far.
+func (r *RecordingModifier) ProgressCalls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.progress
+}
+
+// Reset forgets all recorded modifications, progress calls and macro lookups.
+func (r *RecordingModifier) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = nil
+	r.lookups = nil
+	r.progress = 0
+}
+
+// recordingMacros records the lookups of [Macros] for a [RecordingModifier].
+type recordingMacros struct {
+	macros   Macros
+	recorder *RecordingModifier
+}
+
+func (m *recordingMacros) Get(name MacroName) string {
+	v, _ := m.GetEx(name)
+	return v
+}
+
+func (m *recordingMacros) GetEx(name MacroName) (value string, ok bool) {
+	m.recorder.mu.Lock()
+	m.recorder.lookups = append(m.recorder.lookups, name)
+	m.recorder.mu.Unlock()
+	return m.macros.GetEx(name)
+}
@@ -0,0 +1,61 @@
+package milter
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRecordingModifier(t *testing.T) {
+	macros := NewMacroBag()
+	macros.Set(MacroQueueId, "123")
+	rec := NewRecordingModifier(macros, OptAddHeader|OptQuarantine)
+	mm := &MockMilter{BodyResp: RespAccept, BodyMod: func(m *Modifier) {
+		_ = m.AddHeader("X-Queue-Id", m.Macros.Get(MacroQueueId))
+		_ = m.Progress()
+		_ = m.Quarantine("test")
+	}}
+	resp, err := mm.EndOfMessage(rec.Modifier)
+	if resp != RespAccept || err != nil {
+		t.Fatalf("EndOfMessage() = %v, %v", resp, err)
+	}
+	want := []ModifyAction{
+		{Type: ActionAddHeader, HeaderName: "X-Queue-Id", HeaderValue: "123"},
+		{Type: ActionQuarantine, Reason: "test"},
+	}
+	if got := rec.Actions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Actions() = %+v, want %+v", got, want)
+	}
+	if got := rec.MacroLookups(); !reflect.DeepEqual(got, []MacroName{MacroQueueId}) {
+		t.Errorf("MacroLookups() = %v", got)
+	}
+	if got := rec.ProgressCalls(); got != 1 {
+		t.Errorf("ProgressCalls() = %d, want 1", got)
+	}
+	if err := rec.ChangeFrom("from@example.com", ""); err != ErrModificationNotAllowed {
+		t.Errorf("ChangeFrom() = %v, want ErrModificationNotAllowed", err)
+	}
+	rec.Reset()
+	if len(rec.Actions()) != 0 || len(rec.MacroLookups()) != 0 || rec.ProgressCalls() != 0 {
+		t.Error("Reset() did not forget the recordings")
+	}
+	if NewRecordingModifier(nil, 0).Macros.Get(MacroQueueId) != "" {
+		t.Error("RecordingModifier without macros has macros")
+	}
+}
+
+func TestRecordingModifier_ReplaceBody(t *testing.T) {
+	rec := NewRecordingModifier(nil, OptChangeBody)
+	// more than one chunk, ReplaceBody re-uses its buffer for every chunk
+	want := append(bytes.Repeat([]byte("a"), int(DataSize64K)), bytes.Repeat([]byte("b"), 100)...)
+	if err := rec.ReplaceBody(bytes.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	actions := rec.Actions()
+	if len(actions) != 2 {
+		t.Fatalf("Actions() has %d actions, want 2", len(actions))
+	}
+	if got := append(actions[0].Body, actions[1].Body...); !bytes.Equal(got, want) {
+		t.Fatalf("Actions() recorded a body of %d bytes that does not match the %d bytes of ReplaceBody()", len(got), len(want))
+	}
+}
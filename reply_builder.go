@@ -0,0 +1,104 @@
+package milter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/d--j/go-milter/milterutil"
+)
+
+// ReplyBuilder builds a custom SMTP reply [*Response] step by step:
+//
+//	resp, err := milter.NewReplyBuilder(550).
+//		Enhanced("5.7.1").
+//		Line("Message rejected because of its content").
+//		Line("See https://example.com/policy").
+//		Build()
+//
+// It validates the SMTP code and the RFC 3463 enhanced status code, and puts the enhanced status code in front of
+// every line of the reply. The text gets processed like with [RejectWithCodeAndReason].
+// The first error of a ReplyBuilder gets returned by [ReplyBuilder.Build].
+type ReplyBuilder struct {
+	code     uint16
+	enhanced string
+	lines    []string
+	err      error
+}
+
+// NewReplyBuilder returns a [ReplyBuilder] for a reply with the SMTP code code.
+// code must be between 400 and 599, since the MTA only accepts temporary and permanent failures as custom reply.
+func NewReplyBuilder(code uint16) *ReplyBuilder {
+	b := &ReplyBuilder{code: code}
+	if code < 400 || code > 599 {
+		b.err = fmt.Errorf("milter: invalid code %d", code)
+	}
+	return b
+}
+
+// Enhanced sets the RFC 3463 enhanced status code (e.g. "5.7.1") of the reply.
+// Its class (the first number) must match the SMTP code: 4 for 4xx codes and 5 for 5xx codes.
+// Without Enhanced the reply does not have an enhanced status code.
+func (b *ReplyBuilder) Enhanced(status string) *ReplyBuilder {
+	if b.err != nil {
+		return b
+	}
+	if err := validateEnhancedStatus(b.code, status); err != nil {
+		b.err = err
+		return b
+	}
+	b.enhanced = status
+	return b
+}
+
+// validateEnhancedStatus checks that status is an RFC 3463 enhanced status code ("class.subject.detail") matching code.
+func validateEnhancedStatus(code uint16, status string) error {
+	parts := strings.Split(status, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("milter: invalid enhanced status code %q", status)
+	}
+	for i, part := range parts {
+		maxLength := 3
+		if i == 0 {
+			maxLength = 1
+		}
+		if len(part) == 0 || len(part) > maxLength {
+			return fmt.Errorf("milter: invalid enhanced status code %q", status)
+		}
+		if _, err := strconv.ParseUint(part, 10, 16); err != nil {
+			return fmt.Errorf("milter: invalid enhanced status code %q", status)
+		}
+	}
+	if parts[0] != strconv.Itoa(int(code/100)) {
+		return fmt.Errorf("milter: enhanced status code %q does not match code %d", status, code)
+	}
+	return nil
+}
+
+// Line adds text to the reply. Every line break in text starts a new line of the reply.
+func (b *ReplyBuilder) Line(text string) *ReplyBuilder {
+	if b.err != nil {
+		return b
+	}
+	b.lines = append(b.lines, strings.Split(strings.TrimRight(milterutil.CrLfToLf(text), "\n"), "\n")...)
+	return b
+}
+
+// Build returns the reply as [*Response] or the first error of b.
+func (b *ReplyBuilder) Build() (*Response, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	lines := b.lines
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	if b.enhanced != "" {
+		prefixed := make([]string, len(lines))
+		for i, line := range lines {
+			prefixed[i] = strings.TrimRight(b.enhanced+" "+line, " ")
+		}
+		lines = prefixed
+	}
+	return RejectWithCodeAndReason(b.code, strings.Join(lines, "\n"))
+}
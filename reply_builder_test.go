@@ -0,0 +1,43 @@
+package milter
+
+import (
+	"testing"
+)
+
+func TestReplyBuilder(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		builder *ReplyBuilder
+		want    string
+		wantErr bool
+	}{
+		{"code only", NewReplyBuilder(550), "550 ", false},
+		{"enhanced only", NewReplyBuilder(550).Enhanced("5.7.1"), "550 5.7.1", false},
+		{"single line", NewReplyBuilder(451).Enhanced("4.3.0").Line("try again"), "451 4.3.0 try again", false},
+		{"multi line", NewReplyBuilder(550).Enhanced("5.7.1").Line("rejected").Line("see\r\nhttps://example.com/%"), "550-5.7.1 rejected\r\n550-5.7.1 see\r\n550 5.7.1 https://example.com/%%", false},
+		{"without enhanced", NewReplyBuilder(550).Line("a\nb\n"), "550-a\r\n550 b", false},
+		{"empty line", NewReplyBuilder(550).Enhanced("5.7.1").Line("a\n\nb"), "550-5.7.1 a\r\n550-5.7.1\r\n550 5.7.1 b", false},
+		{"invalid code", NewReplyBuilder(250).Line("ok"), "", true},
+		{"class mismatch", NewReplyBuilder(550).Enhanced("4.7.1"), "", true},
+		{"invalid enhanced", NewReplyBuilder(550).Enhanced("5.7"), "", true},
+		{"invalid subject", NewReplyBuilder(550).Enhanced("5.1000.1"), "", true},
+		{"not a number", NewReplyBuilder(550).Enhanced("5.x.1"), "", true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			resp, err := tt.builder.Build()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Build() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := string(resp.data[:len(resp.data)-1]); got != tt.want {
+				t.Errorf("Build() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
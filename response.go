@@ -121,16 +121,23 @@ func newResponseStr(code wire.Code, data string) (*Response, error) {
 // smtpCode must be between 400 and 599, otherwise this method will return an error.
 //
 // The reason can contain new-lines. Line ending canonicalization is done automatically.
+// Non-ASCII characters in reason get transliterated to ASCII (see [milterutil.ToASCII]) since raw UTF-8 in SMTP replies
+// breaks some MTAs.
 // This function returns an error when the resulting SMTP text has a length of more than [DataSize64K] - 1
 func RejectWithCodeAndReason(smtpCode uint16, reason string) (*Response, error) {
 	if smtpCode < 400 || smtpCode > 599 {
 		return nil, fmt.Errorf("milter: invalid code %d", smtpCode)
 	}
-	if len(reason) > int(DataSize64K)-5 {
-		return nil, fmt.Errorf("milter: reason too long: %d > %d", len(reason), int(DataSize64K)-5)
+	// the transliteration changes the length of reason, so check the length afterwards
+	data, _, err := transform.String(milterutil.NewASCIITransformer(), reason)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > int(DataSize64K)-5 {
+		return nil, fmt.Errorf("milter: reason too long: %d > %d", len(data), int(DataSize64K)-5)
 	}
 	escapeAndNormalize := transform.Chain(&milterutil.DoublePercentTransformer{}, &milterutil.CrLfCanonicalizationTransformer{})
-	data, _, err := transform.String(escapeAndNormalize, strings.TrimRight(reason, "\r\n"))
+	data, _, err = transform.String(escapeAndNormalize, strings.TrimRight(data, "\r\n"))
 	if err != nil {
 		return nil, err
 	}
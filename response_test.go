@@ -30,6 +30,7 @@ func TestRejectWithCodeAndReason(t *testing.T) {
 		{"Newline3", args{400, "\r\n"}, "400 ", false},
 		{"Newline4", args{400, "\n\r"}, "400 ", false},
 		{"%", args{400, "%"}, "400 %%", false},
+		{"non-ASCII", args{400, "Grüße “Ωmega” – naïve"}, "400 Grusse \"?mega\" - naive", false},
 		{"null-bytes", args{400, "bogus\x00reason"}, "", true},
 		{"invalid-code1", args{200, ""}, "", true},
 		{"invalid-code2", args{999, ""}, "", true},
@@ -61,6 +62,17 @@ func TestRejectWithCodeAndReason(t *testing.T) {
 	}
 }
 
+func TestRejectWithCodeAndReason_TransliteratedLength(t *testing.T) {
+	t.Parallel()
+	// 66000 bytes of UTF-8 but only 33000 bytes of ASCII
+	if _, err := RejectWithCodeAndReason(400, strings.Repeat("ü", 33000)); err != nil {
+		t.Errorf("RejectWithCodeAndReason() error = %v", err)
+	}
+	if _, err := RejectWithCodeAndReason(400, strings.Repeat("ü", int(DataSize64K))); err == nil {
+		t.Errorf("RejectWithCodeAndReason() did not return an error")
+	}
+}
+
 func TestCustomResponseDefaultResponse(t *testing.T) {
 	tests := []struct {
 		name         string
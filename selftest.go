@@ -0,0 +1,112 @@
+package milter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// ErrSelfTestNotAccepted is returned (wrapped) by [Server.SelfTest] when the milter did not accept the harmless test message.
+var ErrSelfTestNotAccepted = errors.New("milter did not accept the test message")
+
+// SelfTestResult is the outcome of a [Server.SelfTest].
+type SelfTestResult struct {
+	// Action is the decision of the milter about the test message.
+	Action *Action
+	// ModifyActions are the modifications the milter requested at the end of the test message.
+	ModifyActions []ModifyAction
+}
+
+// SelfTest runs a canned test message through a [Milter] created by the [NewMilterFunc] of s and returns its decision.
+// It connects an in-process client to s over an in-memory connection, so it does not need a listening socket.
+// Call it before you call [Server.Serve] with your production sockets to make sure your milter works.
+//
+// The test message is a harmless message, so a sane milter accepts it (or lets it continue).
+// When the milter rejects, temporarily rejects or discards it, SelfTest returns the result together with an error wrapping [ErrSelfTestNotAccepted].
+// SelfTest also returns an error when the protocol negotiation fails, the milter returns an error or a reply that cannot be parsed,
+// or ctx is done before the test finished.
+// Sessions of SelfTest do not show up in [Server.Sessions] and [Server.ActiveSessions].
+func (s *Server) SelfTest(ctx context.Context) (*SelfTestResult, error) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	session := s.newSession(serverConn)
+	go func() {
+		defer serverConn.Close()
+		session.HandleMilterCommands()
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = clientConn.Close()
+		case <-done:
+		}
+	}()
+
+	result, err := selfTest(clientConn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("milter: self test: %w", ctx.Err())
+		}
+		return nil, fmt.Errorf("milter: self test: %w", err)
+	}
+	if act := result.Action; act.Type != ActionAccept && act.Type != ActionContinue {
+		if act.SMTPReply != "" {
+			return result, fmt.Errorf("milter: self test: %w: %s", ErrSelfTestNotAccepted, act.SMTPReply)
+		}
+		return result, fmt.Errorf("milter: self test: %w: discarded", ErrSelfTestNotAccepted)
+	}
+	return result, nil
+}
+
+// selfTest sends the test message over conn.
+func selfTest(conn net.Conn) (*SelfTestResult, error) {
+	macros := NewMacroBag()
+	macros.Set(MacroMTAFQDN, "localhost")
+	macros.Set(MacroDaemonName, "selftest")
+	macros.Set(MacroQueueId, "SELFTEST")
+	macros.Set(MacroMailAddr, "selftest@localhost")
+	macros.Set(MacroRcptAddr, "postmaster@localhost")
+	client := NewClient("pipe", "selftest", WithActions(AllClientSupportedActionMasks), WithBodyChunking())
+	session, err := client.session(conn, macros)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var hdr textproto.Header
+	hdr.Add("Message-ID", "<selftest@localhost>")
+	hdr.Add("Date", time.Now().Format(time.RFC1123Z))
+	hdr.Add("Subject", "Self test")
+	hdr.Add("To", "<postmaster@localhost>")
+	hdr.Add("From", "<selftest@localhost>")
+
+	steps := []func() (*Action, error){
+		func() (*Action, error) { return session.Conn("localhost", FamilyInet, 25, "127.0.0.1") },
+		func() (*Action, error) { return session.Helo("localhost") },
+		func() (*Action, error) { return session.Mail("selftest@localhost", "") },
+		func() (*Action, error) { return session.Rcpt("postmaster@localhost", "") },
+		session.DataStart,
+		func() (*Action, error) { return session.Header(hdr) },
+	}
+	for _, step := range steps {
+		act, err := step()
+		if err != nil {
+			return nil, err
+		}
+		if act.Type != ActionContinue {
+			return &SelfTestResult{Action: act}, nil
+		}
+	}
+	modifyActs, act, err := session.BodyReadFrom(strings.NewReader("This is a self test message.\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	return &SelfTestResult{Action: act, ModifyActions: modifyActs}, nil
+}
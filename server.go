@@ -1,8 +1,13 @@
 package milter
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,51 +17,72 @@ const MaxServerProtocolVersion uint32 = 6
 // ErrServerClosed is returned by the [Server]'s [Server.Serve] method after a call to [Server.Close].
 var ErrServerClosed = errors.New("milter: server closed")
 
-// Milter is an interface for milter callback handlers.
-type Milter interface {
+// ConnectMilter is the part of a [Milter] that handles the SMTP connection data.
+type ConnectMilter interface {
 	// Connect is called to provide SMTP connection data for incoming message.
 	// Suppress with OptNoConnect.
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoConnReply]) this response will be sent before closing the connection.
 	Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error)
+}
 
+// HeloMilter is the part of a [Milter] that handles HELO/EHLO.
+type HeloMilter interface {
 	// Helo is called to process any HELO/EHLO related filters. Suppress with [OptNoHelo].
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoHeloReply]) this response will be sent before closing the connection.
 	Helo(name string, m *Modifier) (*Response, error)
+}
 
+// MailFromMilter is the part of a [Milter] that handles the envelope sender.
+type MailFromMilter interface {
 	// MailFrom is called to process filters on envelope FROM address. Suppress with [OptNoMailFrom].
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoMailReply]) this response will be sent before closing the connection.
 	MailFrom(from string, esmtpArgs string, m *Modifier) (*Response, error)
+}
 
+// RcptToMilter is the part of a [Milter] that handles the envelope recipients.
+type RcptToMilter interface {
 	// RcptTo is called to process filters on envelope TO address. Suppress with [OptNoRcptTo].
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoRcptReply]) this response will be sent before closing the connection.
 	RcptTo(rcptTo string, esmtpArgs string, m *Modifier) (*Response, error)
+}
 
+// DataMilter is the part of a [Milter] that handles the DATA command.
+type DataMilter interface {
 	// Data is called at the beginning of the DATA command (after all RCPT TO commands). Suppress with [OptNoData].
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoDataReply]) this response will be sent before closing the connection.
 	Data(m *Modifier) (*Response, error)
+}
 
+// HeaderMilter is the part of a [Milter] that handles the header fields of the message.
+type HeaderMilter interface {
 	// Header is called once for each header in incoming message. Suppress with [OptNoHeaders].
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoHeaderReply]) this response will be sent before closing the connection.
 	Header(name string, value string, m *Modifier) (*Response, error)
+}
 
+// HeadersMilter is the part of a [Milter] that handles the end of the header.
+type HeadersMilter interface {
 	// Headers gets called when all message headers have been processed. Suppress with [OptNoEOH].
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoEOHReply]) this response will be sent before closing the connection.
 	Headers(m *Modifier) (*Response, error)
+}
 
+// BodyMilter is the part of a [Milter] that handles the body of the message.
+type BodyMilter interface {
 	// BodyChunk is called to process next message body chunk data (up to 64KB
 	// in size). Suppress with [OptNoBody]. If you return [RespSkip] the MTA will stop
 	// sending more body chunks. But older MTAs do not support this and in this case there are more calls to BodyChunk.
@@ -65,7 +91,10 @@ type Milter interface {
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoBodyReply]) this response will be sent before closing the connection.
 	BodyChunk(chunk []byte, m *Modifier) (*Response, error)
+}
 
+// EndOfMessageMilter is the part of a [Milter] that decides about the message and modifies it.
+type EndOfMessageMilter interface {
 	// EndOfMessage is called at the end of each message. All changes to message's
 	// content & attributes must be done here.
 	// The MTA can start over with another message in the same connection but that is handled in a new Milter instance.
@@ -73,25 +102,56 @@ type Milter interface {
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] this response will be sent before closing the connection.
 	EndOfMessage(m *Modifier) (*Response, error)
+}
 
+// AbortMilter is the part of a [Milter] that handles aborted messages.
+type AbortMilter interface {
 	// Abort is called if the current message has been aborted. All message data
 	// should be reset prior to the [Milter.MailFrom] callback. Connection data should be
 	// preserved. [Milter.Cleanup] is not called before or after Abort.
+	// [Modifier.AbortReason] tells why the message got aborted.
 	Abort(m *Modifier) error
+}
 
+// UnknownMilter is the part of a [Milter] that handles unknown SMTP commands.
+type UnknownMilter interface {
 	// Unknown is called when the MTA got an unknown command in the SMTP connection.
 	//
 	// If this method returns an error the error will be logged and the connection will be closed.
 	// If there is a [Response] (and we did not negotiate [OptNoUnknownReply]) this response will be sent before closing the connection.
 	Unknown(cmd string, m *Modifier) (*Response, error)
+}
 
+// CleanupMilter is the part of a [Milter] that releases its resources.
+type CleanupMilter interface {
 	// Cleanup always gets called when the [Milter] is about to be discarded.
 	// E.g. because the MTA closed the connection, one SMTP message was successful or there was an error.
 	// May be called more than once for a single [Milter].
 	Cleanup()
 }
 
+// Milter is an interface for milter callback handlers.
+//
+// Your filter does not need to implement all callbacks: embed [NoOpMilter] in your type,
+// or only implement the optional interfaces (e.g. [RcptToMilter] and [EndOfMessageMilter]) you need and
+// convert your type with [AsMilter]. The latter also lets the [Server] tell the MTA to not send the events you do not handle.
+type Milter interface {
+	ConnectMilter
+	HeloMilter
+	MailFromMilter
+	RcptToMilter
+	DataMilter
+	HeaderMilter
+	HeadersMilter
+	BodyMilter
+	EndOfMessageMilter
+	AbortMilter
+	UnknownMilter
+	CleanupMilter
+}
+
 // NoOpMilter is a dummy [Milter] implementation that does nothing.
+// Embed it in your own [Milter] type and only override the callbacks that you need.
 type NoOpMilter struct{}
 
 var _ Milter = NoOpMilter{}
@@ -143,11 +203,78 @@ func (NoOpMilter) Unknown(cmd string, m *Modifier) (*Response, error) {
 func (NoOpMilter) Cleanup() {
 }
 
+// ListenerStats holds counters of listener-level events of a [Server].
+// All counters are monotonically increasing for the lifetime of the [Server].
+type ListenerStats struct {
+	// TLSHandshakeFailures is the number of accepted connections that got closed because their TLS handshake failed
+	// (see [WithTLSConfig] and [Server.ServeTLS]). They are part of Accepted.
+	TLSHandshakeFailures uint64
+	// Accepted is the number of connections that got accepted.
+	Accepted uint64
+	// AcceptErrors is the number of errors the listeners returned when accepting a new connection.
+	// This includes temporary errors that got retried.
+	AcceptErrors uint64
+	// Overflows is the number of times the limit of [WithMaxConnections] was reached
+	// (connections that had to wait or got temporarily rejected).
+	Overflows uint64
+	// Denied is the number of connections that got closed because they did not come from one of the networks of [WithAllowedNetworks].
+	// They are not part of Accepted.
+	Denied uint64
+}
+
+// ServerState is the run-state of a [Server]. See [Server.State].
+type ServerState int
+
+const (
+	// ServerStopped means the server does not accept new connections and there are no active sessions.
+	ServerStopped ServerState = iota
+	// ServerServing means the server accepts new connections on at least one listener.
+	ServerServing
+	// ServerDraining means the server got closed but there are still active sessions.
+	ServerDraining
+)
+
+func (s ServerState) String() string {
+	switch s {
+	case ServerStopped:
+		return "stopped"
+	case ServerServing:
+		return "serving"
+	case ServerDraining:
+		return "draining"
+	default:
+		return fmt.Sprintf("ServerState(%d)", int(s))
+	}
+}
+
 // Server is a milter server.
 type Server struct {
-	options   options
+	// listenerStats and counters need to be the first fields to ensure 64-bit alignment for atomic operations
+	listenerStats ListenerStats
+	counters      serverCounters
+	options       options
+
+	mu        sync.Mutex
 	listeners []net.Listener
+	sessions  map[*serverSession]struct{}
 	closed    bool
+	classes   *sessionClasses
+
+	recentErrors recentErrors
+
+	// drain holds the options of Drain, drainDeadline is the end of DrainOptions.ConnectionDeadline
+	drain         *DrainOptions
+	drainDeadline time.Time
+
+	// connSlots limits the number of connections for WithMaxConnections, done gets closed by Close
+	connSlots chan struct{}
+	done      chan struct{}
+	// eomSlots limits the number of concurrent EndOfMessage callbacks for WithMaxConcurrentEndOfMessage
+	eomSlots chan struct{}
+
+	// ctx is the parent context of all sessions, cancel gets called by Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewServer creates a new milter server.
@@ -185,55 +312,391 @@ func NewServer(opts ...Option) *Server {
 	if options.offeredMaxData > 0 {
 		panic("milter: WithOfferedMaxData is a client only option")
 	}
-	if options.macrosByStage != nil {
+	if options.pipelining {
+		panic("milter: WithPipelining is a client only option")
+	}
+	if options.stageTimeouts != (StageTimeouts{}) {
+		panic("milter: WithStageTimeouts is a client only option")
+	}
+	if options.negotiationTolerance {
+		panic("milter: WithNegotiationTolerance is a client only option")
+	}
+	if options.macroAutoFill {
+		panic("milter: WithMacroAutoFill is a client only option")
+	}
+	if options.autoChunking {
+		panic("milter: WithBodyChunking is a client only option")
+	}
+	if options.maxSessions != 0 {
+		panic("milter: WithMaxConcurrentSessions is a client only option")
+	}
+	if options.failurePolicy != FailError {
+		panic("milter: WithOnError is a client only option")
+	}
+	if options.maxUnknownReplies != 0 {
+		panic("milter: WithLenientReplies is a client only option")
+	}
+	if options.extensionReplyHandler != nil {
+		panic("milter: WithExtensionReplyHandler is a client only option")
+	}
+	if options.timing != nil {
+		panic("milter: WithTimingCallback is a client only option")
+	}
+	if options.reconnectAttempts != 0 {
+		panic("milter: WithReconnect is a client only option")
+	}
+	if options.keepalive != 0 {
+		panic("milter: WithKeepalive is a client only option")
+	}
+	if options.idleTimeout != 0 {
+		panic("milter: WithIdleTimeout is a client only option")
+	}
+	if options.serverTimeouts.Command < 0 || options.serverTimeouts.Idle < 0 || options.serverTimeouts.Session < 0 {
+		panic("milter: WithServerTimeouts cannot have negative timeouts")
+	}
+	if options.maxConnections < 0 {
+		panic("milter: WithMaxConnections needs a positive maximum")
+	}
+	if options.maxConnections > 0 && options.overflowPolicy == OverflowTempFail && options.protocol&OptNoConnect != 0 {
+		panic("milter: WithMaxConnections with OverflowTempFail cannot be used with OptNoConnect")
+	}
+	if options.maxConcurrentEOM < 0 {
+		panic("milter: WithMaxConcurrentEndOfMessage needs a positive maximum")
+	}
+	if options.eomOverload != nil && options.eomOverload.Continue() {
+		panic("milter: WithMaxConcurrentEndOfMessage needs an overload response that ends the message")
+	}
+	if !validDefaultReply(options.rejectReply, '5') || !validDefaultReply(options.tempFailReply, '4') {
+		panic("milter: WithDefaultReplies needs a 5xx reject and a 4xx temporary failure reply")
+	}
+	if options.maxBodyBuffer < 0 {
+		panic("milter: WithMaxBodyBuffer needs a positive maximum")
+	}
+	if options.headerFoldLength < 0 {
+		panic("milter: WithHeaderFolding needs a non-negative line length")
+	}
+	for _, network := range options.allowedNetworks {
+		if !network.IsValid() {
+			panic("milter: WithAllowedNetworks got an invalid network")
+		}
+	}
+	if options.classifySession != nil && options.protocol&OptNoConnect != 0 {
+		panic("milter: WithSessionClasses cannot be used with OptNoConnect")
+	}
+	if options.macrosByStage != nil || options.dynamicMacroRequests != nil {
 		options.actions = options.actions | OptSetMacros
 	}
+	if middlewares := options.milterMiddlewares; len(middlewares) > 0 {
+		newMilter := options.newMilter
+		options.newMilter = func(version uint32, action OptAction, protocol OptProtocol, maxData DataSize) Milter {
+			backend := newMilter(version, action, protocol, maxData)
+			for i := len(middlewares) - 1; i >= 0; i-- {
+				backend = middlewares[i](backend)
+			}
+			return backend
+		}
+	}
 
-	return &Server{options: options}
+	server := &Server{options: options, sessions: make(map[*serverSession]struct{}), done: make(chan struct{})}
+	if options.maxConnections > 0 {
+		server.connSlots = make(chan struct{}, options.maxConnections)
+	}
+	if options.maxConcurrentEOM > 0 {
+		server.eomSlots = make(chan struct{}, options.maxConcurrentEOM)
+	}
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	if options.classifySession != nil {
+		server.classes = newSessionClasses(options.classifySession, options.sessionClasses)
+	}
+	return server
 }
 
 // Serve starts the server.
+// When [WithTLSConfig] was used, all connections of ln need to use TLS.
 func (s *Server) Serve(ln net.Listener) error {
-	s.listeners = append(s.listeners, ln)
-	defer func(ln net.Listener, len int) {
-		if s.listeners[len-1] != nil {
+	if s.options.tlsConfig != nil {
+		ln = tls.NewListener(ln, s.options.tlsConfig)
+	}
+	return s.serve(ln)
+}
+
+// ServeTLS starts the server and requires TLS on all connections of ln.
+// certFile and keyFile are the paths of the PEM encoded certificate (chain) and private key of the server.
+// They can be empty when the [tls.Config] of [WithTLSConfig] already contains a certificate.
+func (s *Server) ServeTLS(ln net.Listener, certFile, keyFile string) error {
+	var config *tls.Config
+	if s.options.tlsConfig != nil {
+		config = s.options.tlsConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		return errors.New("milter: ServeTLS needs a certificate")
+	}
+	return s.serve(tls.NewListener(ln, config))
+}
+
+// ServeListeners serves the MTA connections of all listeners with s (see [Server.Serve]),
+// e.g. a unix socket for the local MTA and a TCP socket for a remote one.
+//
+// All listeners share the statistics and the lifecycle of s: [Server.Close], [Server.Shutdown] and [Server.Drain]
+// stop all of them. When one listener fails, ServeListeners closes s (and thereby the other listeners) and
+// returns the error of the failed listener. Otherwise, it returns [ErrServerClosed] after s got closed.
+// Active sessions are not interrupted when ServeListeners returns.
+func (s *Server) ServeListeners(listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("milter: ServeListeners needs at least one listener")
+	}
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			err := s.Serve(ln)
+			// Serve does not close ln when s got closed before it started serving ln
 			_ = ln.Close()
-			s.listeners[len-1] = nil
+			errs <- err
+		}(ln)
+	}
+	firstErr := ErrServerClosed
+	for range listeners {
+		if err := <-errs; err != ErrServerClosed && firstErr == ErrServerClosed {
+			firstErr = err
+			_ = s.Close()
 		}
-	}(ln, len(s.listeners))
+	}
+	return firstErr
+}
+
+func (s *Server) serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, ln)
+	s.mu.Unlock()
+	defer s.removeListener(ln)
 
+	var tempDelay time.Duration // how long to sleep on accept failure
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			if s.closed {
+			if s.isClosed() {
 				return ErrServerClosed
 			}
+			atomic.AddUint64(&s.listenerStats.AcceptErrors, 1)
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				warn(s.options.logger, "accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			warn(s.options.logger, "accept error: %v", err)
 			return err
 		}
+		tempDelay = 0
+		if !s.allowedConn(conn.RemoteAddr()) {
+			atomic.AddUint64(&s.listenerStats.Denied, 1)
+			warn(s.options.logger, "closing connection of %s: address is not in the allowed networks", conn.RemoteAddr())
+			_ = conn.Close()
+			continue
+		}
+		atomic.AddUint64(&s.listenerStats.Accepted, 1)
+		ok, closed := s.acquireConnection()
+		if closed {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+
+		session := s.newSession(conn)
+		session.overflow = !ok
+		session.connSlot = ok
+		s.addSession(session)
+		s.beginDrain(session)
+		go func() {
+			defer s.removeSession(session)
+			session.HandleMilterCommands()
+			// the tarpit might have given the slot away (see serverSession.delayResponse)
+			if session.connSlot {
+				s.releaseConnection()
+			}
+		}()
+	}
+}
+
+// newSession creates the session for the MTA connection conn.
+func (s *Server) newSession(conn net.Conn) *serverSession {
+	session := &serverSession{
+		server:   s,
+		id:       atomic.AddUint64(&s.counters.sessions, 1),
+		version:  s.options.maxVersion,
+		actions:  s.options.actions,
+		protocol: s.options.protocol,
+		conn:     conn,
+		macros:   newMacroStages(),
+		body:     bodyFilter{policy: s.options.bodyPolicy},
+	}
+	session.ctx, session.cancel = context.WithCancel(s.ctx)
+	session.state.info = SessionInfo{
+		ConnID:       session.id,
+		RemoteAddr:   conn.RemoteAddr().String(),
+		Started:      time.Now(),
+		LastActivity: time.Now(),
+		Version:      session.version,
+		Actions:      session.actions,
+		Protocol:     session.protocol,
+	}
+	return session
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
 
-		session := serverSession{
-			server:   s,
-			version:  s.options.maxVersion,
-			actions:  s.options.actions,
-			protocol: s.options.protocol,
-			conn:     conn,
-			macros:   newMacroStages(),
+func (s *Server) removeListener(ln net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, l := range s.listeners {
+		if l == ln {
+			_ = ln.Close()
+			s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+			return
 		}
-		go session.HandleMilterCommands()
 	}
 }
 
+func (s *Server) addSession(session *serverSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session] = struct{}{}
+}
+
+func (s *Server) removeSession(session *serverSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, session)
+}
+
+// ListenerStats returns a snapshot of the listener-level event counters of s.
+// It is safe to call this method concurrently with [Server.Serve].
+func (s *Server) ListenerStats() ListenerStats {
+	return ListenerStats{
+		TLSHandshakeFailures: atomic.LoadUint64(&s.listenerStats.TLSHandshakeFailures),
+		Accepted:             atomic.LoadUint64(&s.listenerStats.Accepted),
+		AcceptErrors:         atomic.LoadUint64(&s.listenerStats.AcceptErrors),
+		Overflows:            atomic.LoadUint64(&s.listenerStats.Overflows),
+		Denied:               atomic.LoadUint64(&s.listenerStats.Denied),
+	}
+}
+
+// State returns the current run-state of s.
+// A [Server] that got closed with [Server.Close] or [Server.Shutdown] reports [ServerDraining] as long as there are active sessions.
+func (s *Server) State() ServerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !s.closed && len(s.listeners) > 0:
+		return ServerServing
+	case s.closed && len(s.sessions) > 0:
+		return ServerDraining
+	default:
+		return ServerStopped
+	}
+}
+
+// Addr returns the address of the first listener s is serving on or nil when s is not serving.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of all listeners s is serving on.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, ln := range s.listeners {
+		addrs = append(addrs, ln.Addr())
+	}
+	return addrs
+}
+
+// ActiveSessions returns the number of MTA connections that s currently handles.
+func (s *Server) ActiveSessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// Close closes all listeners of s. Active sessions are not interrupted. Use [Server.Shutdown] to wait for them.
 func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.closed {
 		return ErrServerClosed
 	}
 	s.closed = true
-	for _, ln := range s.listeners {
-		if ln != nil {
-			if err := ln.Close(); err != nil {
-				return err
-			}
+	close(s.done)
+	listeners := s.listeners
+	s.listeners = nil
+	var firstErr error
+	for _, ln := range listeners {
+		if err := ln.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// shutdownPollInterval is how often we check for active sessions in [Server.Shutdown].
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown closes all listeners of s and then waits until all active sessions ended or ctx is done.
+// While waiting the [Server.State] of s is [ServerDraining].
+// If ctx is done before all sessions ended, Shutdown returns the error of ctx.
+// Shutdown cancels the context of the [Modifier] objects of the active sessions (see [Modifier.Context]),
+// so that long-running callbacks can end early. Use [Server.Drain] to let in-flight messages finish.
+func (s *Server) Shutdown(ctx context.Context) error {
+	// tell the running callbacks to hurry up
+	s.cancel()
+	if err := s.Close(); err != nil && err != ErrServerClosed {
+		return err
+	}
+	return s.waitForSessions(ctx)
+}
+
+// waitForSessions waits until s has no active sessions or ctx is done.
+func (s *Server) waitForSessions(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.ActiveSessions() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
 		}
 	}
-	return nil
 }
@@ -2,7 +2,22 @@ package milter
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/d--j/go-milter/internal/wire"
 	"github.com/emersion/go-message/textproto"
@@ -113,3 +128,1426 @@ func TestServer_NoOpMilter(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+type tempErr struct{}
+
+func (tempErr) Error() string   { return "temporary accept error" }
+func (tempErr) Timeout() bool   { return false }
+func (tempErr) Temporary() bool { return true }
+
+type errListener struct {
+	net.Listener
+	errs []error
+}
+
+func (l *errListener) Accept() (net.Conn, error) {
+	err := l.errs[0]
+	l.errs = l.errs[1:]
+	return nil, err
+}
+
+func TestServer_ListenerStats(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	permanent := errors.New("permanent")
+	s := NewServer(WithMilter(func() Milter { return NoOpMilter{} }))
+	err = s.Serve(&errListener{Listener: ln, errs: []error{tempErr{}, tempErr{}, permanent}})
+	if err != permanent {
+		t.Fatalf("Serve() err = %v, want %v", err, permanent)
+	}
+	got := s.ListenerStats()
+	if got.AcceptErrors != 3 || got.Accepted != 0 {
+		t.Fatalf("ListenerStats() = %+v", got)
+	}
+}
+
+func TestServer_State(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return NoOpMilter{} })}, nil)
+	defer w.Cleanup()
+	// wait for the server to register the session
+	for i := 0; i < 100 && w.server.ActiveSessions() == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if got := w.server.State(); got != ServerServing {
+		t.Fatalf("State() = %v, want %v", got, ServerServing)
+	}
+	if got := w.server.Addr(); got == nil || got.String() != w.local.Addr().String() {
+		t.Fatalf("Addr() = %v, want %v", got, w.local.Addr())
+	}
+	if got := w.server.ActiveSessions(); got != 1 {
+		t.Fatalf("ActiveSessions() = %d, want 1", got)
+	}
+	if err := w.server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.server.State(); got != ServerDraining {
+		t.Fatalf("State() = %v, want %v", got, ServerDraining)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := w.server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := w.session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.server.State(); got != ServerStopped {
+		t.Fatalf("State() = %v, want %v", got, ServerStopped)
+	}
+	if got := w.server.Addrs(); len(got) != 0 {
+		t.Fatalf("Addrs() = %v, want none", got)
+	}
+}
+
+func TestServer_ServeListeners(t *testing.T) {
+	t.Parallel()
+	s := NewServer(WithMilter(func() Milter { return &MockMilter{ConnResp: RespContinue} }))
+	var listeners []net.Listener
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, ln)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ServeListeners(listeners...)
+	}()
+	for _, ln := range listeners {
+		session, err := NewClient("tcp", ln.Addr().String()).Session(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		act, err := session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		_ = session.Close()
+	}
+	if got := len(s.Addrs()); got != 2 {
+		t.Fatalf("len(Addrs()) = %d, want 2", got)
+	}
+	if got := s.Stats().Listener.Accepted; got != 2 {
+		t.Fatalf("Stats().Listener.Accepted = %d, want 2", got)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != ErrServerClosed {
+		t.Fatalf("ServeListeners() = %v, want %v", err, ErrServerClosed)
+	}
+}
+
+func TestServer_ServeListenersFailure(t *testing.T) {
+	t.Parallel()
+	ln1, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	permanent := errors.New("permanent")
+	s := NewServer(WithMilter(func() Milter { return NoOpMilter{} }))
+	if err := s.ServeListeners(ln1, &errListener{Listener: ln2, errs: []error{permanent}}); err != permanent {
+		t.Fatalf("ServeListeners() = %v, want %v", err, permanent)
+	}
+	if got := s.State(); got != ServerStopped {
+		t.Fatalf("State() = %v, want %v", got, ServerStopped)
+	}
+	if err := s.ServeListeners(); err == nil {
+		t.Fatal("ServeListeners() without listeners did not fail")
+	}
+}
+
+func TestServer_SelfTest(t *testing.T) {
+	t.Parallel()
+	t.Run("accept", func(t *testing.T) {
+		s := NewServer(WithMilter(func() Milter {
+			return NoOpMilter{}
+		}))
+		result, err := s.SelfTest(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Action.Type != ActionAccept || len(result.ModifyActions) != 0 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+		if s.ActiveSessions() != 0 {
+			t.Fatalf("ActiveSessions() = %d", s.ActiveSessions())
+		}
+	})
+	t.Run("reject at rcpt", func(t *testing.T) {
+		s := NewServer(WithMilter(func() Milter {
+			return &MockMilter{ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue, RcptResp: RespReject}
+		}))
+		result, err := s.SelfTest(context.Background())
+		if !errors.Is(err, ErrSelfTestNotAccepted) {
+			t.Fatalf("SelfTest() err = %v, want %v", err, ErrSelfTestNotAccepted)
+		}
+		if result == nil || result.Action.Type != ActionReject {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+	t.Run("discard", func(t *testing.T) {
+		s := NewServer(WithMilter(func() Milter {
+			return &MockMilter{
+				ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue, RcptResp: RespContinue, DataResp: RespContinue,
+				HdrResp: RespContinue, HdrsResp: RespContinue, BodyChunkResp: RespContinue, BodyResp: RespDiscard,
+			}
+		}))
+		result, err := s.SelfTest(context.Background())
+		if !errors.Is(err, ErrSelfTestNotAccepted) {
+			t.Fatalf("SelfTest() err = %v, want %v", err, ErrSelfTestNotAccepted)
+		}
+		if result == nil || result.Action.Type != ActionDiscard {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+	t.Run("continue", func(t *testing.T) {
+		s := NewServer(WithMilter(func() Milter {
+			return &MockMilter{
+				ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue, RcptResp: RespContinue, DataResp: RespContinue,
+				HdrResp: RespContinue, HdrsResp: RespContinue, BodyChunkResp: RespContinue, BodyResp: RespContinue,
+			}
+		}))
+		result, err := s.SelfTest(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Action.Type != ActionContinue {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+	t.Run("modifications", func(t *testing.T) {
+		s := NewServer(WithMilter(func() Milter {
+			return &MockMilter{
+				ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue, RcptResp: RespContinue, DataResp: RespContinue,
+				HdrResp: RespContinue, HdrsResp: RespContinue, BodyChunkResp: RespContinue, BodyResp: RespAccept,
+				BodyMod: func(m *Modifier) {
+					_ = m.AddHeader("X-Test", "1")
+				},
+			}
+		}), WithAction(OptAddHeader))
+		result, err := s.SelfTest(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Action.Type != ActionAccept || len(result.ModifyActions) != 1 || result.ModifyActions[0].Type != ActionAddHeader {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	})
+	t.Run("milter error", func(t *testing.T) {
+		s := NewServer(WithMilter(func() Milter {
+			return &MockMilter{ConnErr: errors.New("broken")}
+		}))
+		if _, err := s.SelfTest(context.Background()); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestServer_ShutdownCancelsContext(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			ConnMod: func(m *Modifier) {
+				close(started)
+				<-m.Context().Done()
+				close(cancelled)
+			},
+		}
+	})}, nil)
+	defer w.Cleanup()
+
+	go func() {
+		_, _ = w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		_ = w.server.Shutdown(ctx)
+	}()
+	select {
+	case <-cancelled:
+	case <-ctx.Done():
+		t.Fatal("context of the callback did not get cancelled")
+	}
+}
+
+func TestServer_Drain(t *testing.T) {
+	t.Parallel()
+	newMilter := WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp:      RespContinue,
+			HeloResp:      RespContinue,
+			MailResp:      RespContinue,
+			RcptResp:      RespContinue,
+			DataResp:      RespContinue,
+			HdrResp:       RespContinue,
+			HdrsResp:      RespContinue,
+			BodyChunkResp: RespContinue,
+			BodyResp:      RespAccept,
+		}
+	})
+	startMessage := func(t *testing.T, session *ClientSession) {
+		t.Helper()
+		act, err := session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = session.Helo("helo_host")
+		assertAction(t, act, err, ActionContinue)
+		act, err = session.Mail("from@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		act, err = session.Rcpt("to@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		hdr := textproto.Header{}
+		hdr.Add("Subject", "test")
+		act, err = session.Header(hdr)
+		assertAction(t, act, err, ActionContinue)
+	}
+	t.Run("in-flight", func(t *testing.T) {
+		w := newServerClient(t, nil, []Option{newMilter}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		second, err := w.client.Session(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer second.Close()
+		startMessage(t, second)
+
+		var mu sync.Mutex
+		shutdowns := 0
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- w.server.Drain(ctx, DrainOptions{OnShutdown: func(backend Milter) {
+				mu.Lock()
+				shutdowns++
+				mu.Unlock()
+			}})
+		}()
+		// the idle session ends right away
+		for i := 0; i < 100 && w.server.ActiveSessions() != 1; i++ {
+			time.Sleep(time.Millisecond * 10)
+		}
+		if n := w.server.ActiveSessions(); n != 1 {
+			t.Fatalf("ActiveSessions() = %d, want 1", n)
+		}
+		_, act, err = second.BodyReadFrom(bytes.NewReader([]byte("body")))
+		assertAction(t, act, err, ActionAccept)
+		if err := <-errCh; err != nil {
+			t.Fatalf("Drain() = %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if shutdowns != 2 {
+			t.Fatalf("OnShutdown got called %d times, want 2", shutdowns)
+		}
+	})
+	t.Run("deadline", func(t *testing.T) {
+		logger := &recordingLogger{}
+		w := newServerClient(t, nil, []Option{newMilter, WithLogger(logger)}, nil)
+		defer w.Cleanup()
+		startMessage(t, w.session)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := w.server.Drain(ctx, DrainOptions{ConnectionDeadline: 50 * time.Millisecond}); err != nil {
+			t.Fatalf("Drain() = %v", err)
+		}
+		if lines := logger.Lines(); len(lines) != 1 || lines[0] != "milter: warning: closing connection: server shutdown deadline exceeded" {
+			t.Fatalf("unexpected log lines %q", lines)
+		}
+	})
+}
+
+// testCertPool returns a pool with the self-signed certificate cert of testCertificate.
+func testCertPool(t *testing.T, cert tls.Certificate) *x509.CertPool {
+	t.Helper()
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return pool
+}
+
+func TestServer_TLS(t *testing.T) {
+	t.Parallel()
+	cert := testCertificate(t)
+	pool := testCertPool(t, cert)
+	var infoMu sync.Mutex
+	var info ConnInfo
+	serverOpts := []Option{WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue, ConnMod: func(m *Modifier) {
+			infoMu.Lock()
+			info = m.ConnInfo()
+			infoMu.Unlock()
+		}}
+	}), WithTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})}
+	t.Run("client certificate", func(t *testing.T) {
+		dialer := &tls.Dialer{Config: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}}
+		w := newServerClient(t, nil, serverOpts, []Option{WithDialer(dialer)})
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		infoMu.Lock()
+		defer infoMu.Unlock()
+		if info.TLS == nil || len(info.TLS.PeerCertificates) != 1 || info.LocalAddr.String() != w.local.Addr().String() {
+			t.Fatalf("unexpected ConnInfo %+v", info)
+		}
+	})
+	t.Run("no client certificate", func(t *testing.T) {
+		s := NewServer(serverOpts...)
+		defer s.Close()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_ = s.Serve(ln)
+		}()
+		dialer := &tls.Dialer{Config: &tls.Config{RootCAs: pool}}
+		client := NewClient("tcp", ln.Addr().String(), WithDialer(dialer))
+		if session, err := client.Session(nil); err == nil {
+			session.Close()
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("plain client", func(t *testing.T) {
+		s := NewServer(serverOpts...)
+		defer s.Close()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			_ = s.Serve(ln)
+		}()
+		client := NewClient("tcp", ln.Addr().String(), WithReadTimeout(time.Second))
+		if session, err := client.Session(nil); err == nil {
+			session.Close()
+			t.Fatal("expected an error")
+		}
+		for i := 0; i < 100 && s.ListenerStats().TLSHandshakeFailures == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if stats := s.ListenerStats(); stats.TLSHandshakeFailures != 1 || stats.Accepted != 1 {
+			t.Fatalf("unexpected listener stats %+v", stats)
+		}
+		if stats := s.Stats(); stats.NegotiationFailures != 0 {
+			t.Fatalf("handshake failure counted as negotiation failure: %+v", stats)
+		}
+	})
+}
+
+func TestServer_ServeTLS(t *testing.T) {
+	t.Parallel()
+	cert := testCertificate(t)
+	pool := testCertPool(t, cert)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	keyDer, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	}))
+	defer s.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ServeTLS(ln, "", ""); err == nil {
+		t.Fatal("expected an error without certificate")
+	}
+	go func() {
+		_ = s.ServeTLS(ln, certFile, keyFile)
+	}()
+	client := NewClient("tcp", ln.Addr().String(), WithDialer(&tls.Dialer{Config: &tls.Config{RootCAs: pool}}))
+	session, err := client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	act, err := session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+}
+
+func TestModifier_ReplaceBodyWithOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ReplaceBodyOptions
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"none", ReplaceBodyOptions{}, "a\nb\r", "a\nb\r", false},
+		{"line endings", ReplaceBodyOptions{CanonicalizeLineEndings: true}, "a\nb\rc\r\n", "a\r\nb\r\nc\r\n", false},
+		{"line length", ReplaceBodyOptions{MaximumLineLength: 8}, "abcdefghijkl\r\n", "abcde\r\nfghij\r\nkl\r\n", false},
+		{"both", ReplaceBodyOptions{CanonicalizeLineEndings: true, MaximumLineLength: 8}, "a\nabcdefghijkl", "a\r\nabcde\r\nfghij\r\nkl", false},
+		{"invalid line length", ReplaceBodyOptions{MaximumLineLength: 3}, "abc", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bytes.Buffer
+			m := NewTestModifier(nil, func(msg *wire.Message) error {
+				got.Write(msg.Data)
+				return nil
+			}, nil, OptChangeBody, DataSize64K)
+			err := m.ReplaceBodyWithOptions(strings.NewReader(tt.body), tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReplaceBodyWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.String() != tt.want {
+				t.Errorf("ReplaceBodyWithOptions() sent %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_WithHeaderFolding(t *testing.T) {
+	t.Parallel()
+	value := strings.Repeat("word ", 20) + "end"
+	mm := &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			_ = m.AddHeader("X-Long", value)
+			_ = m.ChangeHeader(1, "X-Short", "short")
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return mm }), WithAction(OptAddHeader | OptChangeHeader), WithHeaderFolding(0)}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	modifyActs, act, err := w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	want := []ModifyAction{
+		{Type: ActionAddHeader, HeaderName: "X-Long", HeaderValue: strings.Repeat("word ", 13) + "word\n word word word word word word end"},
+		{Type: ActionChangeHeader, HeaderIndex: 1, HeaderName: "X-Short", HeaderValue: "short"},
+	}
+	if !reflect.DeepEqual(modifyActs, want) {
+		t.Errorf("got modify actions %+v, want %+v", modifyActs, want)
+	}
+}
+
+func TestServer_UncommittedBatch(t *testing.T) {
+	t.Parallel()
+	mm := &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			_ = m.AddHeader("X-Direct", "1")
+			m.Begin()
+			_ = m.AddHeader("X-Batch", "1")
+			_ = m.ChangeFrom("from@example.com", "")
+		},
+	}
+	logger := &recordingLogger{}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter { return mm }), WithAction(OptAddHeader | OptChangeFrom), WithLogger(logger)}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.DataStart()
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.HeaderEnd()
+	assertAction(t, act, err, ActionContinue)
+	modifyActs, act, err := w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	want := []ModifyAction{{Type: ActionAddHeader, HeaderName: "X-Direct", HeaderValue: "1"}}
+	if !reflect.DeepEqual(modifyActs, want) {
+		t.Errorf("got modify actions %+v, want %+v", modifyActs, want)
+	}
+	lines := logger.Lines()
+	if len(lines) != 1 || lines[0] != "milter: warning: discarding 2 modifications of a batch that did not get committed" {
+		t.Fatalf("unexpected log lines %q", lines)
+	}
+}
+
+func TestModifier_ConnInfo(t *testing.T) {
+	t.Parallel()
+	infos := make(chan ConnInfo, 1)
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue, ConnMod: func(m *Modifier) {
+			infos <- m.ConnInfo()
+		}}
+	})}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	info := <-infos
+	if info.RemoteAddr == nil || info.LocalAddr.String() != w.local.Addr().String() || info.TLS != nil {
+		t.Fatalf("unexpected ConnInfo %+v", info)
+	}
+	if info := NewTestModifier(nil, nil, nil, 0, 0).ConnInfo(); info != (ConnInfo{}) {
+		t.Fatalf("unexpected ConnInfo %+v", info)
+	}
+}
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+func TestServer_WithLogger(t *testing.T) {
+	t.Parallel()
+	logger := &recordingLogger{}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	}), WithLogger(logger)}, nil)
+	defer w.Cleanup()
+	if err := w.session.SendExtension('Z', nil); err != nil {
+		t.Fatal(err)
+	}
+	// the server closes the connection because of the unknown command
+	_, _ = w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	lines := logger.Lines()
+	if len(lines) == 0 || lines[0] != "milter: warning: Unrecognized command code: Z" {
+		t.Fatalf("unexpected log lines %q", lines)
+	}
+}
+
+func TestServer_WithMaxConnections(t *testing.T) {
+	t.Parallel()
+	newMilter := WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	})
+	t.Run("tempfail", func(t *testing.T) {
+		w := newServerClient(t, nil, []Option{newMilter, WithMaxConnections(1, OverflowTempFail)}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+
+		second, err := w.client.Session(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer second.Close()
+		act, err = second.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionRejectWithCode)
+		if act.SMTPCode != 421 {
+			t.Fatalf("SMTPCode = %d, want 421", act.SMTPCode)
+		}
+		if stats := w.server.ListenerStats(); stats.Overflows != 1 {
+			t.Fatalf("Overflows = %d, want 1", stats.Overflows)
+		}
+	})
+	t.Run("queue", func(t *testing.T) {
+		w := newServerClient(t, nil, []Option{newMilter, WithMaxConnections(1, OverflowQueue)}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			second, err := w.client.Session(nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer second.Close()
+			act, err := second.Conn("host", FamilyInet, 25565, "172.0.0.1")
+			if err != nil || act.Type != ActionContinue {
+				t.Errorf("Conn() = %+v, %v", act, err)
+			}
+		}()
+		select {
+		case <-done:
+			t.Fatal("second connection did not wait for a free slot")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if err := w.session.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("second connection did not get a free slot")
+		}
+	})
+}
+
+func TestServer_WithServerTimeouts(t *testing.T) {
+	t.Parallel()
+	newMilter := WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue, RcptResp: RespContinue}
+	})
+	waitClosed := func(t *testing.T, s *Server) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for s.ActiveSessions() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("server did not close the stalled connection")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	t.Run("idle", func(t *testing.T) {
+		w := newServerClient(t, nil, []Option{newMilter, WithServerTimeouts(ServerTimeouts{Idle: 20 * time.Millisecond, Command: time.Hour})}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		waitClosed(t, w.server)
+	})
+	t.Run("command", func(t *testing.T) {
+		w := newServerClient(t, nil, []Option{newMilter, WithServerTimeouts(ServerTimeouts{Idle: time.Hour, Command: 20 * time.Millisecond})}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Helo("localhost")
+		assertAction(t, act, err, ActionContinue)
+		time.Sleep(40 * time.Millisecond)
+		if w.server.ActiveSessions() != 1 {
+			t.Fatal("idle connection got closed")
+		}
+		act, err = w.session.Mail("root@localhost", "")
+		assertAction(t, act, err, ActionContinue)
+		waitClosed(t, w.server)
+	})
+	t.Run("session", func(t *testing.T) {
+		w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+			return &MockMilter{ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue, RcptResp: RespContinue,
+				MailMod: func(m *Modifier) {
+					// the lifetime ends while the milter processes the command
+					time.Sleep(60 * time.Millisecond)
+				}}
+		}), WithServerTimeouts(ServerTimeouts{Session: 50 * time.Millisecond})}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Helo("localhost")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Mail("root@localhost", "")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Rcpt("root@localhost", "")
+		assertAction(t, act, err, ActionTempFail)
+		waitClosed(t, w.server)
+	})
+}
+
+func TestServer_PanicRecovery(t *testing.T) {
+	t.Parallel()
+	panicky := WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue,
+			MailMod: func(m *Modifier) {
+				panic("boom")
+			}}
+	})
+	t.Run("default", func(t *testing.T) {
+		w := newServerClient(t, nil, []Option{panicky, WithLogger(&recordingLogger{})}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Helo("localhost")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Mail("root@localhost", "")
+		assertAction(t, act, err, ActionTempFail)
+		if w.server.ActiveSessions() != 1 {
+			t.Fatal("connection got closed")
+		}
+	})
+	t.Run("handler", func(t *testing.T) {
+		var got interface{}
+		w := newServerClient(t, nil, []Option{panicky, WithLogger(&recordingLogger{}), WithPanicHandler(func(recovered interface{}, m *Modifier) *Response {
+			got = recovered
+			return RespReject
+		})}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Helo("localhost")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Mail("root@localhost", "")
+		assertAction(t, act, err, ActionReject)
+		if got != "boom" {
+			t.Fatalf("handler got %v", got)
+		}
+	})
+	t.Run("cleanup", func(t *testing.T) {
+		var backends, cleanups int32
+		w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+			first := atomic.AddInt32(&backends, 1) == 1
+			return &MockMilter{ConnResp: RespContinue, HeloResp: RespContinue, MailResp: RespContinue,
+				MailMod: func(m *Modifier) {
+					if first {
+						panic("boom")
+					}
+				},
+				OnClose: func() {
+					atomic.AddInt32(&cleanups, 1)
+				}}
+		}), WithLogger(&recordingLogger{})}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Helo("localhost")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Mail("root@localhost", "")
+		assertAction(t, act, err, ActionTempFail)
+		if err = w.session.Abort(nil); err != nil {
+			t.Fatal(err)
+		}
+		act, err = w.session.Mail("root@localhost", "")
+		assertAction(t, act, err, ActionContinue)
+		if got := atomic.LoadInt32(&cleanups); got != 1 {
+			t.Fatalf("Cleanup got called %d times, want 1", got)
+		}
+		if got := atomic.LoadInt32(&backends); got != 2 {
+			t.Fatalf("got %d backends, want 2", got)
+		}
+	})
+	t.Run("close", func(t *testing.T) {
+		w := newServerClient(t, nil, []Option{panicky, WithLogger(&recordingLogger{}), WithPanicHandler(func(recovered interface{}, m *Modifier) *Response {
+			return nil
+		})}, nil)
+		defer w.Cleanup()
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Helo("localhost")
+		assertAction(t, act, err, ActionContinue)
+		if _, err = w.session.Mail("root@localhost", ""); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestServer_Stats(t *testing.T) {
+	t.Parallel()
+	var headerBytes, bodyBytes int
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp:      RespContinue,
+			HeloResp:      RespContinue,
+			MailResp:      RespContinue,
+			RcptResp:      RespContinue,
+			DataResp:      RespContinue,
+			HdrResp:       RespContinue,
+			HdrsResp:      RespContinue,
+			BodyChunkResp: RespContinue,
+			BodyResp:      RespAccept,
+			BodyMod: func(m *Modifier) {
+				_ = m.AddHeader("X-Test", "1")
+				_ = m.ReplaceBody(bytes.NewReader([]byte("new body")))
+				headerBytes, bodyBytes = m.ModificationBytes()
+			},
+		}
+	}), WithActions(OptAddHeader | OptChangeBody)}, []Option{WithActions(OptAddHeader | OptChangeBody)})
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	if stats := w.server.Stats(); stats.ActiveConnections != 1 || stats.MessagesInProgress != 1 || stats.MessagesProcessed != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	hdr := textproto.Header{}
+	hdr.Add("Subject", "test")
+	act, err = w.session.Header(hdr)
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.BodyReadFrom(bytes.NewReader([]byte("body")))
+	assertAction(t, act, err, ActionAccept)
+	stats := w.server.Stats()
+	if stats.MessagesInProgress != 0 || stats.MessagesProcessed != 1 || stats.Accept != 1 || stats.Continue != 8 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.HeaderModificationBytes != uint64(len("X-Test\x001\x00")) || stats.BodyReplacementBytes != uint64(len("new body")) {
+		t.Fatalf("unexpected modification stats %+v", stats)
+	}
+	if headerBytes != len("X-Test\x001\x00") || bodyBytes != len("new body") {
+		t.Fatalf("ModificationBytes() = %d, %d", headerBytes, bodyBytes)
+	}
+	if sessions := w.server.Sessions(); len(sessions) != 1 || sessions[0].ModificationBytes != len("X-Test\x001\x00new body") {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+
+	// a broken negotiation
+	conn, err := net.Dial("tcp", w.local.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := wire.WritePacket(conn, &wire.Message{Code: wire.CodeOptNeg, Data: []byte{0, 0, 0, 6}}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && w.server.Stats().NegotiationFailures == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if stats := w.server.Stats(); stats.NegotiationFailures != 1 || stats.Listener.Accepted != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// tracingMilter is a middleware that records the Connect calls of its wrapped Milter.
+type tracingMilter struct {
+	Milter
+	name  string
+	mu    *sync.Mutex
+	calls *[]string
+}
+
+func (t *tracingMilter) Connect(host string, family string, port uint16, addr string, m *Modifier) (*Response, error) {
+	t.mu.Lock()
+	*t.calls = append(*t.calls, t.name)
+	t.mu.Unlock()
+	return t.Milter.Connect(host, family, port, addr, m)
+}
+
+func TestServer_WithMilterMiddleware(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var calls []string
+	trace := func(name string) MilterMiddleware {
+		return func(next Milter) Milter {
+			return &tracingMilter{Milter: next, name: name, mu: &mu, calls: &calls}
+		}
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue, ConnMod: func(m *Modifier) {
+			mu.Lock()
+			calls = append(calls, "milter")
+			mu.Unlock()
+		}}
+	}), WithMilterMiddleware(trace("outer")), WithMilterMiddleware(trace("inner"))}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(calls, []string{"outer", "inner", "milter"}) {
+		t.Fatalf("unexpected calls %q", calls)
+	}
+}
+
+func TestServer_WithDynamicMacroRequests(t *testing.T) {
+	t.Parallel()
+	var gotAddr net.Addr
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	}), WithMacroRequest(StageHelo, []MacroName{MacroCipher}), WithDynamicMacroRequests(func(version uint32, action OptAction, protocol OptProtocol, remoteAddr net.Addr) map[MacroStage][]MacroName {
+		gotAddr = remoteAddr
+		return map[MacroStage][]MacroName{StageHelo: {MacroTlsVersion, MacroCertSubject}}
+	})}, nil)
+	defer w.Cleanup()
+	if got := w.session.MacroRequests(StageHelo); !reflect.DeepEqual(got, []MacroName{MacroTlsVersion, MacroCertSubject}) {
+		t.Fatalf("MacroRequests(StageHelo) = %v", got)
+	}
+	if gotAddr == nil {
+		t.Fatal("remoteAddr is nil")
+	}
+}
+
+func TestServer_WithMaxConcurrentEndOfMessage(t *testing.T) {
+	t.Parallel()
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp:      RespContinue,
+			HeloResp:      RespContinue,
+			MailResp:      RespContinue,
+			RcptResp:      RespContinue,
+			DataResp:      RespContinue,
+			HdrResp:       RespContinue,
+			HdrsResp:      RespContinue,
+			BodyChunkResp: RespContinue,
+			BodyResp:      RespAccept,
+			BodyMod: func(m *Modifier) {
+				entered <- struct{}{}
+				<-release
+			},
+		}
+	}), WithMaxConcurrentEndOfMessage(1, 0, RespTempFail)}, nil)
+	defer w.Cleanup()
+	message := func(session *ClientSession) (*Action, error) {
+		for _, step := range []func() (*Action, error){
+			func() (*Action, error) { return session.Conn("host", FamilyInet, 25565, "172.0.0.1") },
+			func() (*Action, error) { return session.Helo("helo_host") },
+			func() (*Action, error) { return session.Mail("from@example.org", "") },
+			func() (*Action, error) { return session.Rcpt("to@example.org", "") },
+			func() (*Action, error) { return session.Header(textproto.Header{}) },
+		} {
+			if act, err := step(); err != nil || act.Type != ActionContinue {
+				return act, err
+			}
+		}
+		_, act, err := session.BodyReadFrom(bytes.NewReader([]byte("body")))
+		return act, err
+	}
+	type result struct {
+		act *Action
+		err error
+	}
+	first := make(chan result, 1)
+	go func() {
+		act, err := message(w.session)
+		first <- result{act, err}
+	}()
+	<-entered
+
+	second, err := w.client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	act, err := message(second)
+	assertAction(t, act, err, ActionTempFail)
+	if n := w.server.Stats().EndOfMessageOverloads; n != 1 {
+		t.Fatalf("EndOfMessageOverloads = %d, want 1", n)
+	}
+
+	close(release)
+	r := <-first
+	assertAction(t, r.act, r.err, ActionAccept)
+}
+
+func TestServer_WithTarpit(t *testing.T) {
+	t.Parallel()
+	const delay = 50 * time.Millisecond
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			HeloResp: RespContinue,
+			MailResp: RespContinue,
+			HeloMod: func(m *Modifier) {
+				m.Tarpit(0)
+			},
+		}
+	}), WithTarpit(func(host string, family string, port uint16, addr string) time.Duration {
+		if addr == "172.0.0.2" {
+			return delay
+		}
+		return 0
+	})}, nil)
+	defer w.Cleanup()
+	start := time.Now()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.2")
+	assertAction(t, act, err, ActionContinue)
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("Conn() took %v, want at least %v", elapsed, delay)
+	}
+	// the Milter disables the tarpit in its Helo callback
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	start = time.Now()
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Fatalf("Mail() took %v, want no delay", elapsed)
+	}
+}
+
+func TestServer_WithTarpitReleasesSlots(t *testing.T) {
+	t.Parallel()
+	const delay = 300 * time.Millisecond
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{ConnResp: RespContinue}
+	}), WithTarpit(func(host string, family string, port uint16, addr string) time.Duration {
+		if addr == "172.0.0.2" {
+			return delay
+		}
+		return 0
+	}), WithSessionClasses(func(Macros) string {
+		return "inbound"
+	}, map[string]SessionClass{
+		"inbound": {MaxConcurrent: 1},
+	})}, nil)
+	defer w.Cleanup()
+	type result struct {
+		act *Action
+		err error
+	}
+	tarpitted := make(chan result, 1)
+	go func() {
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.2")
+		tarpitted <- result{act, err}
+	}()
+	time.Sleep(delay / 3)
+
+	// the tarpitted connection does not hold the slot of its class while it waits
+	session2, err := w.client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	act, err := session2.Conn("host", FamilyInet, 25565, "172.0.0.3")
+	assertAction(t, act, err, ActionContinue)
+	select {
+	case <-tarpitted:
+		t.Fatal("tarpitted connection did not wait")
+	default:
+	}
+	// the tarpitted connection takes the slot back before it responds
+	_ = session2.Close()
+	r := <-tarpitted
+	assertAction(t, r.act, r.err, ActionContinue)
+}
+
+func TestServer_EmptyMessage(t *testing.T) {
+	t.Parallel()
+	mm := MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+		BodyMod: func(m *Modifier) {
+			_ = m.ReplaceBody(bytes.NewReader(nil))
+		},
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &mm
+	}), WithActions(OptChangeBody)}, []Option{WithActions(OptChangeBody)})
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	modifyActs, act, err := w.session.BodyReadFrom(bytes.NewReader(nil))
+	assertAction(t, act, err, ActionAccept)
+	if len(mm.Hdr) != 0 || len(mm.Chunks) != 0 {
+		t.Fatalf("milter got header %v and body chunks %v", mm.Hdr, mm.Chunks)
+	}
+	if len(modifyActs) != 1 || modifyActs[0].Type != ActionReplaceBody || len(modifyActs[0].Body) != 0 {
+		t.Fatalf("unexpected modifications %+v", modifyActs)
+	}
+	if stats := w.server.Stats(); stats.MessagesProcessed != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestServer_WithAutoProgress(t *testing.T) {
+	t.Parallel()
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp:      RespContinue,
+			HeloResp:      RespContinue,
+			MailResp:      RespContinue,
+			RcptResp:      RespContinue,
+			DataResp:      RespContinue,
+			HdrResp:       RespContinue,
+			HdrsResp:      RespContinue,
+			BodyChunkResp: RespContinue,
+			BodyResp:      RespAccept,
+			BodyMod: func(m *Modifier) {
+				// the client would time out without the progress packets of the server
+				time.Sleep(300 * time.Millisecond)
+				_ = m.AddHeader("X-Test", "1")
+			},
+		}
+	}), WithActions(OptAddHeader), WithAutoProgress(20*time.Millisecond, 20*time.Millisecond)},
+		[]Option{WithActions(OptAddHeader), WithStageTimeouts(StageTimeouts{EndOfMessage: 100 * time.Millisecond})})
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	modifyActs, act, err := w.session.BodyReadFrom(bytes.NewReader([]byte("body")))
+	assertAction(t, act, err, ActionAccept)
+	if len(modifyActs) != 1 || modifyActs[0].Type != ActionAddHeader {
+		t.Fatalf("unexpected modifications %+v", modifyActs)
+	}
+}
+
+func TestServer_ConnIDAndMessageSeq(t *testing.T) {
+	t.Parallel()
+	type ids struct{ conn, message uint64 }
+	var mu sync.Mutex
+	var got []ids
+	record := func(m *Modifier) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, ids{m.ConnID(), m.MessageSeq()})
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return &MockMilter{
+			ConnResp: RespContinue,
+			ConnMod:  record,
+			HeloResp: RespContinue,
+			MailResp: RespContinue,
+			MailMod:  record,
+			RcptResp: RespContinue,
+			RcptMod:  record,
+		}
+	})}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	for i := 0; i < 2; i++ {
+		act, err = w.session.Mail("from@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		act, err = w.session.Rcpt("to@example.org", "")
+		assertAction(t, act, err, ActionContinue)
+		if err := w.session.Abort(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	session, err := w.client.Session(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	act, err = session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	mu.Lock()
+	defer mu.Unlock()
+	want := []ids{{1, 0}, {1, 1}, {1, 1}, {1, 2}, {1, 2}, {2, 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got IDs %v, want %v", got, want)
+	}
+	infos := w.server.Sessions()
+	if len(infos) != 2 || infos[0].ConnID != 1 || infos[0].MessageSeq != 2 || infos[1].ConnID != 2 {
+		t.Fatalf("unexpected sessions %+v", infos)
+	}
+}
+
+type resettableMilter struct {
+	NoOpMilter
+	mu             sync.Mutex
+	helo           string
+	resets, cleans int
+}
+
+func (r *resettableMilter) Helo(name string, m *Modifier) (*Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.helo != "" {
+		return nil, fmt.Errorf("got helo %q, previous connection was not reset", name)
+	}
+	r.helo = name
+	return RespContinue, nil
+}
+
+func (r *resettableMilter) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.helo = ""
+	r.resets++
+}
+
+func (r *resettableMilter) Cleanup() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cleans++
+}
+
+func TestServer_QuitNewConn(t *testing.T) {
+	t.Parallel()
+	t.Run("new milter", func(t *testing.T) {
+		t.Parallel()
+		var mu sync.Mutex
+		created := 0
+		w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+			mu.Lock()
+			defer mu.Unlock()
+			created++
+			return NoOpMilter{}
+		})}, nil)
+		defer w.Cleanup()
+		for i := 0; i < 3; i++ {
+			act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo("helo_host")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Mail("from@example.org", "")
+			assertAction(t, act, err, ActionContinue)
+			if err := w.session.Reset(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// make sure the server processed the last reset
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		mu.Lock()
+		defer mu.Unlock()
+		if created != 4 {
+			t.Fatalf("server created %d milters, want 4", created)
+		}
+		if stats := w.server.Stats(); stats.ConnectionReuses != 3 || stats.MessagesInProgress != 0 {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+		if infos := w.server.Sessions(); len(infos) != 1 || infos[0].Reuses != 3 {
+			t.Fatalf("unexpected sessions %+v", infos)
+		}
+	})
+	t.Run("reset", func(t *testing.T) {
+		t.Parallel()
+		var mu sync.Mutex
+		var milters []*resettableMilter
+		w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+			mu.Lock()
+			defer mu.Unlock()
+			r := &resettableMilter{}
+			milters = append(milters, r)
+			return r
+		})}, nil)
+		defer w.Cleanup()
+		for i := 0; i < 2; i++ {
+			act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+			assertAction(t, act, err, ActionContinue)
+			act, err = w.session.Helo("helo_host")
+			assertAction(t, act, err, ActionContinue)
+			if err := w.session.Reset(nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+		assertAction(t, act, err, ActionContinue)
+		mu.Lock()
+		defer mu.Unlock()
+		if len(milters) != 1 {
+			t.Fatalf("server created %d milters, want 1", len(milters))
+		}
+		r := milters[0]
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.resets != 2 || r.cleans != 0 {
+			t.Fatalf("got %d resets and %d cleanups", r.resets, r.cleans)
+		}
+	})
+}
+
+func TestServer_WithMaxBodyBuffer(t *testing.T) {
+	t.Parallel()
+	mm := &MockMilter{
+		ConnResp:      RespContinue,
+		HeloResp:      RespContinue,
+		MailResp:      RespContinue,
+		RcptResp:      RespContinue,
+		DataResp:      RespContinue,
+		HdrResp:       RespContinue,
+		HdrsResp:      RespContinue,
+		BodyChunkResp: RespContinue,
+		BodyResp:      RespAccept,
+	}
+	w := newServerClient(t, nil, []Option{WithMilter(func() Milter {
+		return mm
+	}), WithMaxBodyBuffer(4)}, nil)
+	defer w.Cleanup()
+	act, err := w.session.Conn("host", FamilyInet, 25565, "172.0.0.1")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Helo("helo_host")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("0123456789"))
+	assertAction(t, act, err, ActionContinue)
+	_, act, err = w.session.End()
+	assertAction(t, act, err, ActionAccept)
+	if want := [][]byte{[]byte("0123"), []byte("4567"), []byte("89")}; !reflect.DeepEqual(mm.Chunks, want) {
+		t.Fatalf("milter received chunks %q, want %q", mm.Chunks, want)
+	}
+
+	// the rest of the chunk gets discarded when the milter rejects the message
+	mm.Chunks = nil
+	mm.BodyChunkResp = RespReject
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Rcpt("to@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.Header(textproto.Header{})
+	assertAction(t, act, err, ActionContinue)
+	act, err = w.session.BodyChunk([]byte("0123456789"))
+	assertAction(t, act, err, ActionReject)
+	if len(mm.Chunks) != 1 {
+		t.Fatalf("milter received chunks %q", mm.Chunks)
+	}
+	if err := w.session.Abort(nil); err != nil {
+		t.Fatal(err)
+	}
+	act, err = w.session.Mail("from@example.org", "")
+	assertAction(t, act, err, ActionContinue)
+}
@@ -0,0 +1,61 @@
+package milter
+
+import (
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// ServerTimeouts defines how long a [Server] waits for an MTA. A zero value disables the respective timeout.
+type ServerTimeouts struct {
+	// Command is the maximum time the [Server] waits for the next command of the MTA while a message is in progress
+	// (between the MAIL FROM command and the end or abort of the message).
+	Command time.Duration
+	// Idle is the maximum time the [Server] waits for the next command of the MTA when no message is in progress.
+	Idle time.Duration
+	// Session is the maximum lifetime of an MTA connection. The [Server] replies to the first command
+	// after this time with a temporary failure and closes the connection.
+	Session time.Duration
+}
+
+// expiredReadGrace is the time the [Server] waits for the next command after the session lifetime ran out.
+const expiredReadGrace = time.Second
+
+// nextReadTimeout returns the read timeout for the next command of the MTA.
+// It returns a negative value when the lifetime of the session is over.
+func (m *serverSession) nextReadTimeout() time.Duration {
+	timeout := m.readTimeout
+	if timeout == 0 {
+		if m.inMessage {
+			timeout = m.server.options.serverTimeouts.Command
+		} else {
+			timeout = m.server.options.serverTimeouts.Idle
+		}
+	}
+	if !m.deadline.IsZero() {
+		remaining := time.Until(m.deadline)
+		if remaining <= 0 {
+			return -1
+		}
+		if timeout == 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
+}
+
+// expired returns true when the lifetime of the session is over.
+func (m *serverSession) expired() bool {
+	return !m.deadline.IsZero() && !time.Now().Before(m.deadline)
+}
+
+// commandHasReply returns true when the MTA waits for a reply to the command code
+// (when the reply did not get disabled in the protocol negotiation).
+func commandHasReply(code wire.Code) bool {
+	switch code {
+	case wire.CodeConn, wire.CodeHelo, wire.CodeMail, wire.CodeRcpt, wire.CodeData, wire.CodeHeader, wire.CodeEOH,
+		wire.CodeBody, wire.CodeEOB, wire.CodeUnknown:
+		return true
+	}
+	return false
+}
@@ -2,12 +2,17 @@ package milter
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/d--j/go-milter/internal/wire"
 )
@@ -16,14 +21,67 @@ var errCloseSession = errors.New("stop current milter processing")
 
 // serverSession keeps session state during MTA communication
 type serverSession struct {
-	server      *Server
+	server *Server
+	// id is the connection ID of the session, messageSeq the sequence number of the current message (see Modifier.ConnID)
+	id          uint64
+	messageSeq  uint64
 	version     uint32
 	actions     OptAction
 	protocol    OptProtocol
 	maxDataSize DataSize
 	conn        net.Conn
+	writeMu     sync.Mutex
 	macros      *macrosStages
-	backend     Milter
+	// ctx is the context of the Modifier objects, cancel gets called when the connection ends
+	ctx     context.Context
+	cancel  context.CancelFunc
+	backend Milter
+	body    bodyFilter
+	// headerCount, headerBytes and bodyBytes measure the current message (see WithMaxHeaderCount, WithMaxBodyBytes)
+	headerCount, headerBytes int
+	bodyBytes                int64
+	// bodyRemaining is the number of bytes of the current body chunk that were not read yet (see WithMaxBodyBuffer)
+	bodyRemaining uint32
+	// headerTruncated and bodyTruncated are true when the LimitTruncate policy dropped a part of the current message
+	headerTruncated, bodyTruncated bool
+	// class is the name of the SessionClass of this connection, classified is true when it holds a slot of this class
+	class      string
+	classified bool
+	// overflow is true when the connection exceeded the limit of WithMaxConnections
+	overflow bool
+	// connSlot is true when the connection holds a slot of WithMaxConnections
+	connSlot bool
+	// backendReplaced is true when recoverPanic already cleaned up and replaced the backend of the current command
+	backendReplaced bool
+	// modBytes counts the modification bytes of the current message
+	modBytes modificationBytes
+	// inMessage is true between the MAIL FROM command and the end or abort of the message.
+	// Only the session goroutine changes it, with drain.mu locked.
+	inMessage bool
+	// messageLifecycle is the backend whose NewMessage started the current message (see MessageLifecycleMilter)
+	messageLifecycle MessageLifecycleMilter
+	// messageValues are the values of Modifier.SetMessageValue for the current message
+	messageValues map[interface{}]interface{}
+	// deadline is the end of the lifetime of the session (see ServerTimeouts.Session)
+	deadline time.Time
+	// readTimeout is the ReadTimeout of the SessionClass
+	readTimeout time.Duration
+	// macroPacket is true when the last command was a macro packet for the stage macroStage
+	macroPacket bool
+	macroStage  MacroStage
+	// tarpit is the delay of the responses of the current SMTP connection (see WithTarpit)
+	tarpit time.Duration
+	// rejected is the response for the current message when the server itself rejected it
+	// (e.g. because of the BodyReject policy). The backend does not get the rest of the message.
+	rejected *Response
+	// headerOccurrences counts the header fields of the current message (see Modifier.ChangeHeaderValue)
+	headerOccurrences headerOccurrences
+	// mtaOffer holds what the MTA offered in the negotiation (see Modifier.MTAInfo)
+	mtaOffer MTAInfo
+	// state gets read by Server.Sessions
+	state sessionState
+	// drain gets changed by Server.Drain
+	drain sessionDrain
 }
 
 // readPacket reads incoming milter packet
@@ -31,9 +89,56 @@ func (m *serverSession) readPacket() (*wire.Message, error) {
 	return wire.ReadPacket(m.conn, 0)
 }
 
+// readPacketInto reads incoming milter packet into msg, reusing its memory
+func (m *serverSession) readPacketInto(msg *wire.Message) error {
+	if err := m.setReadDeadline(); err != nil {
+		return err
+	}
+	var err error
+	m.bodyRemaining, err = wire.ReadPacketIntoLimit(m.conn, msg, 0, uint32(m.server.options.maxBodyBuffer))
+	return err
+}
+
+// setReadDeadline sets the read deadline of the connection for the next read.
+// It returns errDraining when the server drains and the session is not in a message.
+func (m *serverSession) setReadDeadline() error {
+	timeout := m.nextReadTimeout()
+	if timeout < 0 {
+		// the session lifetime ran out while we processed the last command,
+		// give the MTA a short time to send the next command, so that we can temp-fail it
+		timeout = expiredReadGrace
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	// Server.Drain changes the read deadline concurrently, hold the lock while we set it
+	m.drain.mu.Lock()
+	if m.drain.active {
+		if !m.inMessage {
+			m.drain.mu.Unlock()
+			return errDraining
+		}
+		if !m.drain.deadline.IsZero() && (deadline.IsZero() || m.drain.deadline.Before(deadline)) {
+			deadline = m.drain.deadline
+		}
+	}
+	_ = m.conn.SetReadDeadline(deadline)
+	m.drain.mu.Unlock()
+	return nil
+}
+
 // writePacket sends a milter response packet to socket stream
 func (m *serverSession) writePacket(msg *wire.Message) error {
-	return wire.WritePacket(m.conn, msg, 0)
+	// progress packets can get sent concurrently to the other packets (see WithAutoProgress)
+	m.writeMu.Lock()
+	err := wire.WritePacket(m.conn, msg, 0)
+	m.writeMu.Unlock()
+	if err != nil {
+		// the connection is broken, tell the callbacks to stop
+		m.cancel()
+	}
+	return err
 }
 
 func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milterActions OptAction, milterProtocol OptProtocol, callback NegotiationCallbackFunc, macroRequests macroRequests, usedMaxData DataSize) (*Response, error) {
@@ -53,6 +158,7 @@ func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milte
 		offeredMaxDataSize = DataSize256K
 	}
 	mtaProtoMask = mtaProtoMask & (^OptProtocol(optInternal))
+	m.mtaOffer = MTAInfo{ProtocolVersion: mtaVersion, Actions: mtaActionMask, Protocol: mtaProtoMask}
 
 	var err error
 	var maxDataSize DataSize
@@ -78,6 +184,15 @@ func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milte
 	if m.version < 2 || m.version > MaxServerProtocolVersion {
 		return nil, fmt.Errorf("milter: negotiate: unsupported protocol version: %d", m.version)
 	}
+	if m.server != nil && m.backend == nil && !m.server.options.dynamicMilter {
+		// the Milter does not depend on the negotiation, the negotiation might depend on the Milter (see PartialMilter).
+		// Create it only now that the negotiation succeeded, a failed negotiation must not create and clean up a Milter.
+		m.backend = m.newBackend()
+	}
+	// only use the options of a PartialMilter that the MTA offers, they are an optimization and not a requirement
+	if m.server != nil && m.backend != nil {
+		m.protocol |= m.server.options.autoProtocol(m.backend) & mtaProtoMask & protocolMaskForVersion(m.version)
+	}
 	if maxDataSize != DataSize64K && maxDataSize != DataSize256K && maxDataSize != DataSize1M {
 		maxDataSize = DataSize64K
 	}
@@ -88,12 +203,7 @@ func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milte
 
 	// TODO: activate skip response according to m.version
 
-	sizeMask := uint32(0)
-	if maxDataSize == DataSize256K {
-		sizeMask = optMds256K
-	} else if maxDataSize == DataSize1M {
-		sizeMask = optMds1M
-	}
+	sizeMask := maxDataSize.protocolFlag()
 
 	// prepare response data
 	var buffer bytes.Buffer
@@ -103,6 +213,7 @@ func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milte
 		}
 	}
 	// send the macros we want to have in the response
+	macroRequests = m.dynamicMacroRequests(macroRequests)
 	if macroRequests != nil && mtaActionMask&OptSetMacros != 0 {
 		for st := 0; st < int(StageEndMarker) && st < len(macroRequests); st++ {
 			if macroRequests[st] != nil && len(macroRequests[st]) > 0 {
@@ -114,18 +225,44 @@ func (m *serverSession) negotiate(msg *wire.Message, milterVersion uint32, milte
 			}
 		}
 	} else if macroRequests != nil {
-		LogWarning("milter could not send the needed macros since MTA does not support this")
+		m.logWarning("milter could not send the needed macros since MTA does not support this")
 	}
 	// build negotiation response
 	return newResponse(wire.CodeOptNeg, buffer.Bytes()), nil
 }
 
+// dynamicMacroRequests returns the macro requests of [WithDynamicMacroRequests] for this connection
+// or requests when this option was not used.
+func (m *serverSession) dynamicMacroRequests(requests macroRequests) macroRequests {
+	if m.server == nil || m.server.options.dynamicMacroRequests == nil {
+		return requests
+	}
+	var remoteAddr net.Addr
+	if m.conn != nil {
+		remoteAddr = m.conn.RemoteAddr()
+	}
+	byStage := m.server.options.dynamicMacroRequests(m.version, m.actions, m.protocol, remoteAddr)
+	if byStage == nil {
+		return requests
+	}
+	dynamic := make(macroRequests, StageEndMarker)
+	for stage, names := range byStage {
+		if stage < StageEndMarker {
+			dynamic[stage] = names
+		}
+	}
+	return dynamic
+}
+
 func (m *serverSession) newBackend() Milter {
 	return m.server.options.newMilter(m.version, m.actions, m.protocol, m.maxDataSize)
 }
 
 // Process processes incoming milter commands
 func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
+	if msg.Code != wire.CodeMacro {
+		m.macroPacket = false
+	}
 	switch msg.Code {
 	case wire.CodeOptNeg:
 		return nil, fmt.Errorf("milter: negotiate: can only be called once in a connection")
@@ -188,6 +325,15 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 		default:
 			return nil, fmt.Errorf("milter: conn: unexpected protocol family: %c", protocolFamily)
 		}
+		if decide := m.server.options.tarpit; decide != nil {
+			m.tarpit = decide(hostname, family, port, address)
+		}
+		if m.overflow {
+			return overflowResponse(), nil
+		}
+		if resp := m.classify(); resp != nil {
+			return resp, nil
+		}
 		// run handler and return
 		return m.backend.Connect(
 			hostname,
@@ -209,6 +355,17 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 			return nil, fmt.Errorf("milter: mail: unexpected data size: %d", len(msg.Data))
 		}
 		m.macros.DelStageAndAbove(StageRcpt)
+		m.resetMessage()
+		m.setInMessage(true)
+		m.messageSeq++
+		m.modBytes.reset()
+		m.state.mu.Lock()
+		m.state.info.ModificationBytes = 0
+		m.state.info.MessageSeq = m.messageSeq
+		m.state.mu.Unlock()
+		if err := m.startMessage(); err != nil {
+			return nil, fmt.Errorf("milter: new message: %w", err)
+		}
 		from := wire.ReadCString(msg.Data)
 		msg.Data = msg.Data[len(from)+1:]
 
@@ -243,6 +400,16 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 		if len(headerData) != 2 {
 			return nil, fmt.Errorf("milter: header: unexpected number of strings: %d", len(headerData))
 		}
+		m.headerOccurrences.add(headerData[0])
+		pass := m.rejected == nil && m.countHeader(headerData[0], headerData[1])
+		if m.rejected != nil {
+			m.macros.DelStageAndAbove(StageEndMarker)
+			return m.rejected, nil
+		}
+		if !pass {
+			m.macros.DelStageAndAbove(StageEndMarker)
+			return RespContinue, nil
+		}
 		// call and return milter handler
 		resp, err := m.backend.Header(headerData[0], headerData[1], newModifier(m, true))
 		m.macros.DelStageAndAbove(StageEndMarker)
@@ -250,15 +417,33 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 
 	case wire.CodeEOH:
 		m.macros.DelStageAndAbove(StageEOM)
+		if m.rejected != nil {
+			return m.rejected, nil
+		}
 		return m.backend.Headers(newModifier(m, true))
 
 	case wire.CodeBody:
-		resp, err := m.backend.BodyChunk(msg.Data, newModifier(m, true))
+		resp, err := m.bodyChunk(msg.Data)
+		if err == nil && m.bodyRemaining > 0 {
+			resp, err = m.bodyPieces(resp)
+		}
 		m.macros.DelStageAndAbove(StageEndMarker)
 		return resp, err
 
 	case wire.CodeEOB:
-		return m.backend.EndOfMessage(newModifier(m, false))
+		if err := m.body.finish(); err != nil && m.rejected == nil {
+			m.reject(invalidBodyResponse, err)
+		}
+		if m.rejected != nil {
+			resp := m.rejected
+			m.resetMessage()
+			atomic.AddUint64(&m.server.counters.messagesProcessed, 1)
+			return resp, m.abort(AbortRejected)
+		}
+		resp, err := m.endOfMessage()
+		m.resetMessage()
+		atomic.AddUint64(&m.server.counters.messagesProcessed, 1)
+		return resp, err
 
 	case wire.CodeUnknown:
 		cmd := wire.ReadCString(msg.Data)
@@ -290,96 +475,231 @@ func (m *serverSession) Process(msg *wire.Message) (*Response, error) {
 		case wire.CodeUnknown, wire.CodeHeader, wire.CodeAbort, wire.CodeBody:
 			stage = StageEndMarker // this stage gets cleared after the command
 		default:
-			LogWarning("MTA sent macro for %c. we cannot handle this so we ignore it", code)
+			m.logWarning("MTA sent macro for %c. we cannot handle this so we ignore it", code)
 			return nil, nil
 		}
-		m.macros.DelStageAndAbove(stage)
+		// some MTAs split big macro sets into multiple packets, merge consecutive packets of the same stage
+		merge := m.macroPacket && m.macroStage == stage
+		if !merge {
+			m.macros.DelStageAndAbove(stage)
+		}
 		// convert data to Go strings
 		data := wire.DecodeCStrings(msg.Data[1:])
-		if len(data) != 0 {
-			if len(data)%2 == 1 {
-				data = append(data, "")
+		if len(data)%2 == 1 {
+			data = append(data, "")
+		}
+		if merge {
+			for i := 0; i < len(data); i += 2 {
+				m.macros.SetMacro(stage, data[i], data[i+1])
 			}
+		} else if len(data) != 0 {
 			m.macros.SetStage(stage, data...)
 		}
+		m.macroPacket = true
+		m.macroStage = stage
+		m.observeMacros(stage, data)
 		// do not send response
 		return nil, nil
 
 	case wire.CodeAbort:
 		// abort current message and start over
-		err := m.backend.Abort(newModifier(m, true))
+		err := m.abort(AbortMTA)
 		m.macros.DelStageAndAbove(StageHelo)
+		m.resetMessage()
 		return nil, err
 
 	case wire.CodeQuitNewConn:
 		// abort current connection and start over
-		m.backend.Cleanup()
-		m.macros.DelStageAndAbove(StageConnect)
-		m.backend = m.newBackend()
+		m.quitNewConn()
 		// do not send response
 		return nil, nil
 
 	case wire.CodeQuit:
+		m.abortUnfinished()
+		m.endMessage()
 		m.backend.Cleanup()
 		// client requested session close
 		return nil, errCloseSession
 
 	default:
+		if handler := m.server.options.extensionCommandHandler; handler != nil {
+			resp, err := handler(byte(msg.Code), msg.Data, m.backend)
+			if err != nil {
+				return nil, fmt.Errorf("milter: extension command %q: %w", msg.Code, err)
+			}
+			return resp, nil
+		}
 		// print error and close session
-		LogWarning("Unrecognized command code: %c", msg.Code)
+		m.logWarning("Unrecognized command code: %c", msg.Code)
 		return nil, errCloseSession
 	}
 }
 
+// bodyChunk passes the body chunk to the backend.
+func (m *serverSession) bodyChunk(data []byte) (*Response, error) {
+	if m.rejected != nil {
+		return m.rejected, nil
+	}
+	chunk, err := m.body.apply(data)
+	if err != nil {
+		m.reject(invalidBodyResponse, err)
+		return m.rejected, nil
+	}
+	if chunk = m.countBody(chunk); m.rejected != nil {
+		return m.rejected, nil
+	}
+	if m.bodyTruncated && len(chunk) == 0 {
+		return m.truncatedBodyResponse(), nil
+	}
+	return m.backend.BodyChunk(chunk, newModifier(m, true))
+}
+
+// resetMessage resets the per-message state of m for the next message.
+func (m *serverSession) resetMessage() {
+	m.endMessage()
+	m.messageValues = nil
+	m.body.reset()
+	m.headerCount = 0
+	m.headerBytes = 0
+	m.bodyBytes = 0
+	m.headerTruncated = false
+	m.bodyTruncated = false
+	m.headerOccurrences.reset()
+	m.rejected = nil
+	m.setInMessage(false)
+}
+
+// reject makes the server reject the current message with the response of newResp.
+// err is the reason that gets logged.
+func (m *serverSession) reject(newResp func() *Response, err error) {
+	m.logWarning("rejecting message: %v", err)
+	m.rejected = newResp()
+}
+
+// invalidBodyResponse is the response for a body that violated the [BodyReject] policy.
+func invalidBodyResponse() *Response {
+	return mustRejectWithCodeAndReason(554, "5.6.0 Message body contains NUL bytes or bare CR or LF characters")
+}
+
+func mustRejectWithCodeAndReason(smtpCode uint16, reason string) *Response {
+	resp, err := RejectWithCodeAndReason(smtpCode, reason)
+	if err != nil {
+		panic(err)
+	}
+	return resp
+}
+
+// handshake runs the TLS handshake of a connection of [WithTLSConfig] or [Server.ServeTLS] before the negotiation,
+// so that a failed handshake gets counted and logged as such. It returns false when the handshake failed.
+func (m *serverSession) handshake() bool {
+	tlsConn, ok := m.conn.(*tls.Conn)
+	if !ok {
+		return true
+	}
+	ctx := m.ctx
+	if timeout := m.server.options.readTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		atomic.AddUint64(&m.server.listenerStats.TLSHandshakeFailures, 1)
+		m.logWarning("closing connection of %s: TLS handshake failed: %v", m.conn.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
+
 // HandleMilterCommands processes all milter commands in the same connection
 func (m *serverSession) HandleMilterCommands() {
 	defer func() {
+		m.abortUnfinished()
+		m.endMessage()
+		m.endDrain()
+		m.cancel()
 		if m.backend != nil {
 			m.backend.Cleanup()
 		}
+		m.releaseClass()
+		m.setInMessage(false)
 		if m.conn != nil {
 			if err := m.conn.Close(); err != nil && err != io.EOF {
-				LogWarning("Error closing connection: %v", err)
+				m.logWarning("Error closing connection: %v", err)
 			}
 		}
 	}()
 
+	if !m.handshake() {
+		return
+	}
+
 	// first do the negotiation
 	msg, err := m.readPacket()
 	if err != nil {
-		if err != io.EOF {
-			LogWarning("Error reading milter command: %v", err)
+		if draining, _ := m.draining(); err != io.EOF && !draining {
+			m.logError("Error reading milter command: %v", err)
 		}
 		return
 	}
+	m.touch(msg.Code)
 	resp, err := m.negotiate(msg, m.server.options.maxVersion, m.server.options.actions, m.server.options.protocol, m.server.options.negotiationCallback, m.server.options.macrosByStage, 0)
 	if err != nil {
-		LogWarning("Error negotiating: %v", err)
+		m.logError("Error negotiating: %v", err)
+		atomic.AddUint64(&m.server.counters.negotiationFailures, 1)
 		return
 	}
-	m.backend = m.newBackend()
+	m.syncInfo()
+	if m.backend == nil {
+		m.backend = m.newBackend()
+	}
 	if err = m.writePacket(resp.Response()); err != nil {
-		LogWarning("Error writing packet: %v", err)
+		m.logError("Error writing packet: %v", err)
 		return
 	}
 
+	if d := m.server.options.serverTimeouts.Session; d > 0 {
+		m.deadline = time.Now().Add(d)
+	}
+
 	// now we can process the events
+	msg.Reset()
 	for {
-		msg, err := m.readPacket()
-		if err != nil {
-			if err != io.EOF {
-				LogWarning("Error reading milter command: %v", err)
+		if msg.Code == wire.CodeBody {
+			// the backend may have kept the body chunk, do not overwrite it with the next packet
+			msg.Data = nil
+		}
+		if err := m.readPacketInto(msg); err != nil {
+			if draining, deadlineExceeded := m.draining(); draining {
+				if deadlineExceeded {
+					m.logWarning("closing connection: server shutdown deadline exceeded")
+				}
+			} else if m.expired() {
+				m.logWarning("closing connection: maximum session lifetime of %v exceeded", m.server.options.serverTimeouts.Session)
+			} else if err != io.EOF {
+				m.logError("Error reading milter command: %v", err)
+			}
+			return
+		}
+		m.touch(msg.Code)
+
+		if m.expired() && msg.Code != wire.CodeMacro {
+			m.logWarning("closing connection: maximum session lifetime of %v exceeded", m.server.options.serverTimeouts.Session)
+			if commandHasReply(msg.Code) && !m.skipResponse(msg.Code) {
+				_ = m.writeResponse(RespTempFail)
 			}
 			return
 		}
 
-		resp, err := m.Process(msg)
+		// Process may re-slice the data of the message, give it a copy so that msg keeps the whole buffer
+		cmd := *msg
+		m.backendReplaced = false
+		resp, err := m.processSafe(&cmd)
 		if err != nil {
 			if err != errCloseSession {
 				// log error condition
-				LogWarning("Error performing milter command: %v", err)
+				m.logError("Error performing milter command: %v", err)
 				if resp != nil && !m.skipResponse(msg.Code) {
-					_ = m.writePacket(resp.Response())
+					_ = m.writeResponse(resp)
 				}
 			}
 			return
@@ -390,16 +710,23 @@ func (m *serverSession) HandleMilterCommands() {
 			continue
 		}
 
+		if !m.delayResponse(msg.Code) {
+			return
+		}
+
 		// send back response message
-		if err = m.writePacket(resp.Response()); err != nil {
-			LogWarning("Error writing packet: %v", err)
+		if err = m.writeResponse(resp); err != nil {
+			m.logError("Error writing packet: %v", err)
 			return
 		}
 
 		if !resp.Continue() {
-			m.backend.Cleanup()
-			// prepare backend for next message
-			m.backend = m.newBackend()
+			if !m.backendReplaced {
+				m.endMessage()
+				m.backend.Cleanup()
+				// prepare backend for next message
+				m.backend = m.newBackend()
+			}
 			m.macros.DelStageAndAbove(StageMail)
 		}
 	}
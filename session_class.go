@@ -0,0 +1,127 @@
+package milter
+
+import (
+	"time"
+)
+
+// SessionClass configures a priority class of [Server] sessions. See [WithSessionClasses].
+type SessionClass struct {
+	// MaxConcurrent limits the number of sessions of this class that the [Server] handles at the same time.
+	// 0 means no limit.
+	MaxConcurrent int
+	// QueueTimeout is the time a session waits for a free slot when MaxConcurrent sessions of this class are active.
+	// When no slot got free in this time the [Server] temporarily rejects the connection.
+	// 0 means that the connection gets rejected immediately.
+	QueueTimeout time.Duration
+	// ReadTimeout is the maximum time the [Server] waits for the next command of the MTA.
+	// 0 means no timeout.
+	ReadTimeout time.Duration
+}
+
+// SessionClassifier is the signature of a [WithSessionClasses] function.
+// It gets called with the macros that the MTA sent for the connect stage (e.g. [MacroDaemonName])
+// before [Milter.Connect] gets called and returns the name of the [SessionClass] of this connection.
+// Connections with a name that is not configured are not limited.
+type SessionClassifier func(macros Macros) string
+
+// sessionClasses holds the concurrency pools of the session classes of a [Server].
+type sessionClasses struct {
+	classify SessionClassifier
+	classes  map[string]SessionClass
+	slots    map[string]chan struct{}
+}
+
+func newSessionClasses(classify SessionClassifier, classes map[string]SessionClass) *sessionClasses {
+	c := &sessionClasses{
+		classify: classify,
+		classes:  make(map[string]SessionClass, len(classes)),
+		slots:    make(map[string]chan struct{}),
+	}
+	for name, class := range classes {
+		if class.MaxConcurrent < 0 {
+			panic("milter: SessionClass.MaxConcurrent cannot be negative")
+		}
+		c.classes[name] = class
+		if class.MaxConcurrent > 0 {
+			c.slots[name] = make(chan struct{}, class.MaxConcurrent)
+		}
+	}
+	return c
+}
+
+// acquire waits for a free slot of the class name. It returns false when no slot got free in time.
+func (c *sessionClasses) acquire(name string) bool {
+	slots := c.slots[name]
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	timeout := c.classes[name].QueueTimeout
+	if timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// reacquire waits for a free slot of the class name for a session that gave its slot away (see [WithTarpit]).
+// Unlike acquire it does not time out. It returns false when done got closed while waiting.
+func (c *sessionClasses) reacquire(name string, done <-chan struct{}) bool {
+	slots := c.slots[name]
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// release frees a slot of the class name.
+func (c *sessionClasses) release(name string) {
+	if slots := c.slots[name]; slots != nil {
+		<-slots
+	}
+}
+
+// classify determines the class of m and acquires a slot for it.
+// It returns a response when the connection needs to be rejected because there is no free slot.
+func (m *serverSession) classify() *Response {
+	classes := m.server.classes
+	if classes == nil || m.classified {
+		return nil
+	}
+	name := classes.classify(&macroReader{macrosStages: m.macros})
+	if !classes.acquire(name) {
+		m.logWarning("rejecting connection: too many concurrent sessions of class %q", name)
+		return mustRejectWithCodeAndReason(421, "4.3.2 Too many concurrent sessions, try again later")
+	}
+	m.class = name
+	m.classified = true
+	m.readTimeout = classes.classes[name].ReadTimeout
+	m.syncInfo()
+	return nil
+}
+
+// releaseClass frees the slot of the class of m.
+func (m *serverSession) releaseClass() {
+	if m.classified {
+		m.server.classes.release(m.class)
+		m.classified = false
+		m.class = ""
+		m.readTimeout = 0
+		m.syncInfo()
+	}
+}
@@ -549,3 +549,33 @@ func Test_milterSession_Process(t *testing.T) {
 		})
 	}
 }
+
+func Test_milterSession_ProcessSplitMacros(t *testing.T) {
+	t.Parallel()
+	m := &serverSession{
+		server:  NewServer(WithMilter(func() Milter { return &processTestMilter{} })),
+		version: MaxServerProtocolVersion,
+		macros:  newMacroStages(),
+		backend: &processTestMilter{},
+	}
+	process := func(code wire.Code, data []byte) {
+		t.Helper()
+		if _, err := m.Process(&wire.Message{Code: code, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(name MacroName) string {
+		value, _ := m.macros.GetMacroEx(name)
+		return value
+	}
+	process(wire.CodeMacro, []byte("Ha\x001\x00"))
+	process(wire.CodeMacro, []byte("Hb\x002\x00"))
+	if a, b := get("a"), get("b"); a != "1" || b != "2" {
+		t.Fatalf("split macros did not get merged: a=%q b=%q", a, b)
+	}
+	process(wire.CodeHelo, []byte("host\x00"))
+	process(wire.CodeMacro, []byte("Hc\x003\x00"))
+	if a, c := get("a"), get("c"); a != "" || c != "3" {
+		t.Fatalf("macros of the next command did not replace the old ones: a=%q c=%q", a, c)
+	}
+}
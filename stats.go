@@ -0,0 +1,191 @@
+package milter
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// ServerStats is a runtime snapshot of a [Server]. See [Server.Stats].
+//
+// ActiveConnections and MessagesInProgress are gauges, all other fields are counters
+// that monotonically increase for the lifetime of the [Server].
+type ServerStats struct {
+	// ActiveConnections is the number of MTA connections that the [Server] currently handles.
+	ActiveConnections int
+	// MessagesInProgress is the number of messages that started (MAIL FROM) but did not end yet.
+	MessagesInProgress int
+	// MessagesProcessed is the number of messages that the [Server] handled up to the end of the message.
+	MessagesProcessed uint64
+	// Accept, Continue, Discard, Reject, TempFail, ReplyCode and Skip count the responses
+	// that the [Server] sent to the MTA by their action.
+	// ReplyCode counts the custom SMTP replies of [RejectWithCodeAndReason].
+	Accept    uint64
+	Continue  uint64
+	Discard   uint64
+	Reject    uint64
+	TempFail  uint64
+	ReplyCode uint64
+	Skip      uint64
+	// HeaderModificationBytes is the number of bytes of header fields that the [Milter] added, changed or inserted.
+	HeaderModificationBytes uint64
+	// BodyReplacementBytes is the number of bytes of replaced message bodies.
+	BodyReplacementBytes uint64
+	// EndOfMessageOverloads is the number of messages that got rejected because of [WithMaxConcurrentEndOfMessage].
+	EndOfMessageOverloads uint64
+	// NegotiationFailures is the number of connections that ended because the option negotiation with the MTA failed.
+	NegotiationFailures uint64
+	// ConnectionReuses is the number of times an MTA reused a connection for another SMTP connection (SMFIC_QUIT_NC).
+	ConnectionReuses uint64
+	// Listener holds the listener-level counters, see [Server.ListenerStats].
+	Listener ListenerStats
+}
+
+// serverCounters holds the counters of a [Server] that its sessions update atomically.
+// It must only contain 64-bit fields to keep them 64-bit aligned.
+type serverCounters struct {
+	messagesInProgress  int64
+	messagesProcessed   uint64
+	accept              uint64
+	cont                uint64
+	discard             uint64
+	reject              uint64
+	tempFail            uint64
+	replyCode           uint64
+	skip                uint64
+	headerModBytes      uint64
+	bodyModBytes        uint64
+	eomOverloads        uint64
+	negotiationFailures uint64
+	connectionReuses    uint64
+	// sessions is the ID of the last session (see Modifier.ConnID)
+	sessions uint64
+}
+
+// countResponse records that the server sent resp to the MTA.
+func (c *serverCounters) countResponse(resp *Response) {
+	var counter *uint64
+	switch wire.ActionCode(resp.code) {
+	case wire.ActAccept:
+		counter = &c.accept
+	case wire.ActContinue:
+		counter = &c.cont
+	case wire.ActDiscard:
+		counter = &c.discard
+	case wire.ActReject:
+		counter = &c.reject
+	case wire.ActTempFail:
+		counter = &c.tempFail
+	case wire.ActReplyCode:
+		counter = &c.replyCode
+	case wire.ActSkip:
+		counter = &c.skip
+	default:
+		return
+	}
+	atomic.AddUint64(counter, 1)
+}
+
+// Stats returns a runtime snapshot of s that you can export into your monitoring system.
+// It is safe to call this method concurrently with [Server.Serve].
+func (s *Server) Stats() ServerStats {
+	c := &s.counters
+	return ServerStats{
+		ActiveConnections:       s.ActiveSessions(),
+		MessagesInProgress:      int(atomic.LoadInt64(&c.messagesInProgress)),
+		MessagesProcessed:       atomic.LoadUint64(&c.messagesProcessed),
+		Accept:                  atomic.LoadUint64(&c.accept),
+		Continue:                atomic.LoadUint64(&c.cont),
+		Discard:                 atomic.LoadUint64(&c.discard),
+		Reject:                  atomic.LoadUint64(&c.reject),
+		TempFail:                atomic.LoadUint64(&c.tempFail),
+		ReplyCode:               atomic.LoadUint64(&c.replyCode),
+		Skip:                    atomic.LoadUint64(&c.skip),
+		HeaderModificationBytes: atomic.LoadUint64(&c.headerModBytes),
+		BodyReplacementBytes:    atomic.LoadUint64(&c.bodyModBytes),
+		EndOfMessageOverloads:   atomic.LoadUint64(&c.eomOverloads),
+		NegotiationFailures:     atomic.LoadUint64(&c.negotiationFailures),
+		ConnectionReuses:        atomic.LoadUint64(&c.connectionReuses),
+		Listener:                s.ListenerStats(),
+	}
+}
+
+// setInMessage sets m.inMessage and keeps the MessagesInProgress gauge of the server in sync.
+func (m *serverSession) setInMessage(inMessage bool) {
+	if m.inMessage == inMessage {
+		return
+	}
+	m.drain.mu.Lock()
+	m.inMessage = inMessage
+	m.drain.mu.Unlock()
+	if inMessage {
+		atomic.AddInt64(&m.server.counters.messagesInProgress, 1)
+	} else {
+		atomic.AddInt64(&m.server.counters.messagesInProgress, -1)
+	}
+}
+
+// writeResponse sends resp (or its replacement of WithDefaultReplies) to the MTA and counts it.
+func (m *serverSession) writeResponse(resp *Response) error {
+	resp = m.defaultReply(resp)
+	err := m.writePacket(resp.Response())
+	if err == nil {
+		m.server.counters.countResponse(resp)
+	}
+	return err
+}
+
+// writeModification sends the modification msg to the MTA and accounts its size.
+func (m *serverSession) writeModification(msg *wire.Message) error {
+	err := m.writePacket(msg)
+	if err != nil {
+		return err
+	}
+	header, ok := m.modBytes.add(msg)
+	if !ok {
+		return nil
+	}
+	counter := &m.server.counters.bodyModBytes
+	if header {
+		counter = &m.server.counters.headerModBytes
+	}
+	atomic.AddUint64(counter, uint64(len(msg.Data)))
+	m.state.mu.Lock()
+	m.state.info.ModificationBytes += len(msg.Data)
+	m.state.mu.Unlock()
+	return nil
+}
+
+// modificationBytes counts the bytes of the header and body modifications of the current message (see [Modifier.ModificationBytes]).
+type modificationBytes struct {
+	mu           sync.Mutex
+	header, body int
+}
+
+// add accounts the modification msg. ok is false when msg is neither a header nor a body modification.
+func (c *modificationBytes) add(msg *wire.Message) (header, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch wire.ModifyActCode(msg.Code) {
+	case wire.ActAddHeader, wire.ActChangeHeader, wire.ActInsertHeader:
+		c.header += len(msg.Data)
+		return true, true
+	case wire.ActReplBody:
+		c.body += len(msg.Data)
+		return false, true
+	}
+	return false, false
+}
+
+func (c *modificationBytes) get() (header, body int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.header, c.body
+}
+
+func (c *modificationBytes) reset() {
+	c.mu.Lock()
+	c.header, c.body = 0, 0
+	c.mu.Unlock()
+}
@@ -0,0 +1,85 @@
+package milter
+
+import (
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// TarpitFunc decides whether the [Server] slows down the SMTP connection with the remote host host (see [Milter.Connect] for the arguments).
+// It returns the delay of every response for this connection, 0 means no delay.
+type TarpitFunc func(host string, family string, port uint16, addr string) time.Duration
+
+// tarpitProgressInterval is how often the [Server] sends progress packets while it delays an end-of-message response.
+const tarpitProgressInterval = 10 * time.Second
+
+// Tarpit delays all following responses of the current SMTP connection by delay (0 disables the delay).
+// Use it to slow down suspicious peers. See [WithTarpit] for details.
+// Tarpit does nothing for modifiers created with [NewTestModifier].
+func (m *Modifier) Tarpit(delay time.Duration) {
+	if m.session != nil {
+		m.session.tarpit = delay
+	}
+}
+
+// delayResponse waits for the tarpit delay of m before the response to the command code gets sent.
+// While it waits m does not hold its slots of [WithMaxConnections] and [WithSessionClasses], so that tarpitted connections
+// do not keep other connections from getting served. It takes the slots back before it returns.
+// While it delays the end-of-message response it sends progress packets to the MTA.
+// It returns false when the connection of m ended while waiting.
+func (m *serverSession) delayResponse(code wire.Code) bool {
+	if m.tarpit <= 0 {
+		return true
+	}
+	connSlot, classified := m.connSlot, m.classified
+	if connSlot {
+		m.server.releaseConnection()
+		m.connSlot = false
+	}
+	if classified {
+		m.server.classes.release(m.class)
+		m.classified = false
+	}
+	if !m.waitTarpit(code) {
+		return false
+	}
+	// take the slots in the same order as a new connection, so that tarpitted connections cannot deadlock each other
+	if connSlot {
+		if !m.server.reacquireConnection(m.ctx.Done()) {
+			return false
+		}
+		m.connSlot = true
+	}
+	if classified {
+		if !m.server.classes.reacquire(m.class, m.ctx.Done()) {
+			return false
+		}
+		m.classified = true
+	}
+	return true
+}
+
+// waitTarpit waits for the tarpit delay of m. It returns false when the connection of m ended while waiting.
+func (m *serverSession) waitTarpit(code wire.Code) bool {
+	timer := time.NewTimer(m.tarpit)
+	defer timer.Stop()
+	var progress <-chan time.Time
+	if code == wire.CodeEOB {
+		ticker := time.NewTicker(tarpitProgressInterval)
+		defer ticker.Stop()
+		progress = ticker.C
+	}
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-progress:
+			if err := m.writePacket(respProgress.Response()); err != nil {
+				m.logError("Error writing packet: %v", err)
+				return false
+			}
+		case <-m.ctx.Done():
+			return false
+		}
+	}
+}
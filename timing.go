@@ -0,0 +1,42 @@
+package milter
+
+import (
+	"time"
+
+	"github.com/d--j/go-milter/internal/wire"
+)
+
+// TimingFunc is the signature of a [WithTimingCallback] function.
+// cmd is the name of the command (e.g. "connect", "rcpt" or "end of message"), d is the time the command took
+// and act is the [Action] the command returned (nil when the command failed or does not return an action).
+type TimingFunc func(cmd string, d time.Duration, act *Action)
+
+// timed returns a function that reports the time since start to the [TimingFunc] of s and returns its arguments.
+func (s *ClientSession) timed(code wire.Code, start time.Time) func(*Action, error) (*Action, error) {
+	return func(act *Action, err error) (*Action, error) {
+		if s.timing != nil {
+			s.timing(commandName(code), time.Since(start), act)
+		}
+		return act, err
+	}
+}
+
+// timedEnd is timed for commands that also return modification actions.
+func (s *ClientSession) timedEnd(code wire.Code, start time.Time) func([]ModifyAction, *Action, error) ([]ModifyAction, *Action, error) {
+	return func(modifyActs []ModifyAction, act *Action, err error) ([]ModifyAction, *Action, error) {
+		if s.timing != nil {
+			s.timing(commandName(code), time.Since(start), act)
+		}
+		return modifyActs, act, err
+	}
+}
+
+// timedErr is timed for commands that only return an error.
+func (s *ClientSession) timedErr(code wire.Code, start time.Time) func(error) error {
+	return func(err error) error {
+		if s.timing != nil {
+			s.timing(commandName(code), time.Since(start), nil)
+		}
+		return err
+	}
+}